@@ -0,0 +1,443 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Operator tool for a fleet of workers, replaces testing/*.py
+
+const usage = `Usage: chessctl [flags] <command> [args]
+
+Commands:
+  list [prefix]                      workers registered in the catalog
+  status <worker|prefix>...          state, load and engine of each worker
+  drain <worker|prefix>...           finish current jobs, deregister and send clients away
+  exit <worker|prefix>...            drain, stop the engine and shut workers down
+  restart <worker|prefix>...         restart the engine process of idle workers
+  set-option <worker> <name> [value] pin a UCI option on a worker
+  smoke <worker>                     run new_game, new_pos and parse_moves against a worker
+
+Flags:
+`
+
+var (
+	catalogFlag = flag.String("catalog", fmt.Sprintf("%s:%d", common.CatalogAddr, common.CatalogPort), "catalog server host:port")
+	jsonFlag    = flag.Bool("json", false, "print JSON instead of a table")
+	tokenFlag   = flag.String("token", os.Getenv(common.EnvAdminToken), "admin token, defaults to $"+common.EnvAdminToken)
+	timeoutFlag = flag.Duration("timeout", 10*time.Second, "time allowed for each worker to answer")
+
+	// where tables and JSON are printed
+	stdout io.Writer = os.Stdout
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	host, portStr, err := net.SplitHostPort(*catalogFlag)
+	if err != nil {
+		log.Fatal("Invalid -catalog address: ", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		log.Fatal("Invalid -catalog port: ", err)
+	}
+	common.CatalogAddr = host
+	common.CatalogPort = port
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "list":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		err = list(prefix)
+	case "status":
+		err = status(args)
+	case common.AdminDrain, common.AdminExit, common.AdminRestart:
+		err = command(cmd, args)
+	case "set-option":
+		if len(args) < 2 {
+			log.Fatal("Usage: chessctl set-option <worker> <name> [value]")
+		}
+		err = setOption(args[0], args[1:])
+	case "smoke":
+		if len(args) != 1 {
+			log.Fatal("Usage: chessctl smoke <worker>")
+		}
+		err = smoke(args[0])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// prints the rows as JSON or as a table with the given header
+func output(rows interface{}, header string, lines []string) {
+	if *jsonFlag {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
+
+// finds the workers named by args, an exact name wins over a prefix match
+func resolve(args []string) ([]catalog.Entry, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no workers given")
+	}
+	entries, err := catalog.Query(common.CatalogAddr, common.CatalogPort)
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []catalog.Entry
+	seen := map[string]bool{}
+	for _, arg := range args {
+		matches := catalog.Workers(entries, arg)
+		if e, ok := catalog.Find(entries, arg); ok {
+			matches = []catalog.Entry{e}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no workers in the catalog match %q", arg)
+		}
+		for _, e := range matches {
+			if !seen[e.Project] {
+				seen[e.Project] = true
+				workers = append(workers, e)
+			}
+		}
+	}
+	return workers, nil
+}
+
+// opens a connection to a worker, using TLS when CHESS_TLS_* is set
+func dial(e catalog.Entry) (*common.Conn, error) {
+	dialer := &net.Dialer{Timeout: *timeoutFlag}
+	var conn net.Conn
+	var err error
+	if cfg := common.TLSConfigFromEnv(); cfg.Enabled() {
+		tlsConfig, cfgErr := cfg.ClientConfig(e.Project)
+		if cfgErr != nil {
+			return nil, cfgErr
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.HostPort(), tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", e.HostPort())
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(*timeoutFlag))
+	return common.NewConn(conn), nil
+}
+
+// sends one request and waits for the reply, error replies become errors
+func request(conn *common.Conn, m common.Message) (common.Message, error) {
+	err := conn.Send(m)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(*common.Error); ok {
+		return nil, errors.New(e.Reason)
+	}
+	return reply, nil
+}
+
+// runs an admin command on a single worker
+func admin(e catalog.Entry, command string, args ...string) (*common.AdminReply, error) {
+	conn, err := dial(e)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	reply, err := request(conn, &common.Admin{Type: common.TypeAdmin, Token: *tokenFlag, Command: command, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	r, ok := reply.(*common.AdminReply)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s reply", reply.MessageType())
+	}
+	return r, nil
+}
+
+func list(prefix string) error {
+	entries, err := catalog.Query(common.CatalogAddr, common.CatalogPort)
+	if err != nil {
+		return err
+	}
+	workers := catalog.Workers(entries, prefix)
+
+	var lines []string
+	for _, e := range workers {
+		var labels []string
+		for k, v := range e.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		busy := "idle"
+		if e.Busy {
+			busy = "busy"
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%d\t%d\t%.2f\t%s\t%s", e.Project, e.HostPort(), e.Owner,
+			time.Since(e.LastHeard()).Round(time.Second), e.Engine, e.Threads, e.Hash, e.Load, busy, strings.Join(labels, ",")))
+	}
+	output(workers, "NAME\tADDRESS\tOWNER\tLAST HEARD\tENGINE\tTHREADS\tHASH\tLOAD\tSTATE\tLABELS", lines)
+	return nil
+}
+
+type statusRow struct {
+	Name    string               `json:"name"`
+	Address string               `json:"address"`
+	Status  *common.WorkerStatus `json:"status,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+func status(args []string) error {
+	workers, err := resolve(args)
+	if err != nil {
+		return err
+	}
+
+	var rows []statusRow
+	var lines []string
+	for _, e := range workers {
+		row := statusRow{Name: e.Project, Address: e.HostPort()}
+		reply, err := admin(e, common.AdminStatus)
+		if err != nil {
+			row.Error = err.Error()
+			lines = append(lines, fmt.Sprintf("%s\t%s\tunreachable\t\t\t\t\t%s", row.Name, row.Address, row.Error))
+		} else {
+			row.Status = reply.Status
+			s := reply.Status
+			state := "ready"
+			if s.Draining {
+				state = "draining"
+			} else if s.Searching {
+				state = "searching"
+			} else if s.SessionActive {
+				state = "in session"
+			}
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s", row.Name, row.Address, state,
+				s.Sessions, s.Jobs, (time.Duration(s.UptimeSeconds)*time.Second).String(), s.Engine,
+				strings.Join(s.EngineOptions, ", ")))
+		}
+		rows = append(rows, row)
+	}
+	output(rows, "NAME\tADDRESS\tSTATE\tSESSIONS\tJOBS\tUPTIME\tENGINE\tOPTIONS", lines)
+	return nil
+}
+
+type commandRow struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// runs the same admin command on every worker and reports each outcome
+func command(cmd string, args []string) error {
+	workers, err := resolve(args)
+	if err != nil {
+		return err
+	}
+
+	var rows []commandRow
+	failed := false
+	for _, e := range workers {
+		rows = append(rows, runCommand(e, cmd))
+		failed = failed || !rows[len(rows)-1].Ok
+	}
+	printCommands(rows)
+	if failed {
+		return errors.New(fmt.Sprint(cmd, " failed on some workers"))
+	}
+	return nil
+}
+
+func setOption(name string, option []string) error {
+	workers, err := resolve([]string{name})
+	if err != nil {
+		return err
+	}
+	row := runCommand(workers[0], common.AdminSetEngineOption, option...)
+	printCommands([]commandRow{row})
+	if !row.Ok {
+		return errors.New("set-option failed")
+	}
+	return nil
+}
+
+func runCommand(e catalog.Entry, cmd string, args ...string) commandRow {
+	row := commandRow{Name: e.Project, Command: cmd}
+	reply, err := admin(e, cmd, args...)
+	if err != nil {
+		row.Message = err.Error()
+	} else {
+		row.Ok = true
+		row.Message = reply.Message
+	}
+	return row
+}
+
+func printCommands(rows []commandRow) {
+	var lines []string
+	for _, r := range rows {
+		result := "ok"
+		if !r.Ok {
+			result = "FAILED"
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s", r.Name, r.Command, result, r.Message))
+	}
+	output(rows, "NAME\tCOMMAND\tRESULT\tMESSAGE", lines)
+}
+
+type smokeStep struct {
+	Step     string        `json:"step"`
+	Ok       bool          `json:"ok"`
+	Duration time.Duration `json:"duration_ns"`
+	Detail   string        `json:"detail"`
+}
+
+// Plays the start of a turn against a worker the way the client does
+func smoke(name string) error {
+	workers, err := resolve([]string{name})
+	if err != nil {
+		return err
+	}
+	e := workers[0]
+
+	var steps []smokeStep
+	record := func(step string, start time.Time, detail string, err error) bool {
+		s := smokeStep{Step: step, Ok: err == nil, Duration: time.Since(start), Detail: detail}
+		if err != nil {
+			s.Detail = err.Error()
+		}
+		steps = append(steps, s)
+		return err == nil
+	}
+	defer func() {
+		var lines []string
+		for _, s := range steps {
+			result := "ok"
+			if !s.Ok {
+				result = "FAILED"
+			}
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s", s.Step, result, s.Duration.Round(time.Microsecond), s.Detail))
+		}
+		output(steps, "STEP\tRESULT\tTIME\tDETAIL", lines)
+	}()
+
+	start := time.Now()
+	conn, err := dial(e)
+	if !record("connect", start, e.HostPort(), err) {
+		return errors.New("smoke test failed")
+	}
+	defer conn.Close()
+
+	start = time.Now()
+	err = conn.Negotiate(common.Codecs)
+	if !record("hello", start, conn.Codec().Name(), err) {
+		return errors.New("smoke test failed")
+	}
+
+	game := chess.NewGame(chess.UseNotation(chess.UCINotation{}))
+	start = time.Now()
+	reply, err := request(conn, &common.NewGame{Type: common.TypeNewGame, Position: game.FEN(), PosId: 0})
+	if !record(common.TypeNewGame, start, "ready_ok", expectReady(reply, err, 0)) {
+		return errors.New("smoke test failed")
+	}
+
+	game.MoveStr("e2e4")
+	start = time.Now()
+	reply, err = request(conn, &common.NewPos{Type: common.TypeNewPos, Position: game.FEN(), PosId: 1})
+	if !record(common.TypeNewPos, start, "ready_ok", expectReady(reply, err, 1)) {
+		return errors.New("smoke test failed")
+	}
+
+	var moves []string
+	for _, move := range game.ValidMoves() {
+		moves = append(moves, move.String())
+	}
+	start = time.Now()
+	reply, err = request(conn, &common.ParseMoves{
+		Type:     common.TypeParseMoves,
+		Position: game.FEN(),
+		PosId:    1,
+		Moves:    moves,
+		DueTime:  time.Now().Add(time.Second),
+		JobId:    0,
+	})
+	detail := ""
+	if err == nil {
+		results, ok := reply.(*common.Results)
+		if !ok {
+			err = fmt.Errorf("expected results, got %s", reply.MessageType())
+		} else if game.MoveStr(results.BestMove) != nil {
+			err = fmt.Errorf("illegal best move %s", results.BestMove)
+		} else {
+			detail = fmt.Sprintf("best %s score %d mate %d nodes %d", results.BestMove, results.Score, results.Mate, results.Nodes)
+		}
+	}
+	if !record(common.TypeParseMoves, start, detail, err) {
+		return errors.New("smoke test failed")
+	}
+
+	conn.Send(&common.Stop{Type: common.TypeStop})
+	return nil
+}
+
+func expectReady(reply common.Message, err error, posId int) error {
+	if err != nil {
+		return err
+	}
+	ready, ok := reply.(*common.ReadyOk)
+	if !ok {
+		return fmt.Errorf("expected ready_ok, got %s", reply.MessageType())
+	}
+	if ready.PosId != posId {
+		return fmt.Errorf("ready_ok for pos_id %d, expected %d", ready.PosId, posId)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+const token = "secret"
+
+// runs a command with JSON output and decodes what it printed into rows
+func run(t *testing.T, rows interface{}, cmd func() error) error {
+	t.Helper()
+	var out bytes.Buffer
+	oldStdout, oldJSON := stdout, *jsonFlag
+	stdout, *jsonFlag = &out, true
+	defer func() { stdout, *jsonFlag = oldStdout, oldJSON }()
+	err := cmd()
+	if out.Len() > 0 {
+		if jerr := json.Unmarshal(out.Bytes(), rows); jerr != nil {
+			t.Fatalf("output isn't JSON: %v: %s", jerr, out.String())
+		}
+	}
+	return err
+}
+
+// the clustertest catalog stands in for the real one
+func startFleet(t *testing.T) *clustertest.Cluster {
+	t.Helper()
+	c := clustertest.Start(t, clustertest.Options{NoClient: true})
+	for _, w := range c.Workers {
+		w.SetAdminToken(token)
+	}
+	old := *tokenFlag
+	*tokenFlag = token
+	t.Cleanup(func() { *tokenFlag = old })
+	return c
+}
+
+func TestList(t *testing.T) {
+	startFleet(t)
+	var entries []catalog.Entry
+	if err := run(t, &entries, func() error { return list("clustertest") }); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Project != "clustertest-00" || entries[1].Project != "clustertest-01" {
+		t.Fatalf("want both workers in order, got %+v", entries)
+	}
+	entries = nil
+	if err := run(t, &entries, func() error { return list("nobody") }); err != nil || len(entries) != 0 {
+		t.Fatalf("want no workers, got %+v: %v", entries, err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	startFleet(t)
+	var rows []statusRow
+	if err := run(t, &rows, func() error { return status([]string{"clustertest"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("want a row per worker, got %+v", rows)
+	}
+	for _, r := range rows {
+		if r.Error != "" || r.Status == nil || r.Status.Name != r.Name || r.Status.Draining {
+			t.Fatalf("want %s ready, got %+v", r.Name, r)
+		}
+	}
+	if err := run(t, &rows, func() error { return status([]string{"nobody"}) }); err == nil {
+		t.Fatal("status of a worker that isn't in the catalog")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := startFleet(t)
+
+	// a bad token fails the command and leaves the worker be
+	*tokenFlag = "wrong"
+	var rows []commandRow
+	err := run(t, &rows, func() error { return command(common.AdminDrain, []string{"clustertest-01"}) })
+	if err == nil || len(rows) != 1 || rows[0].Ok || !strings.Contains(rows[0].Message, "token") {
+		t.Fatalf("want the drain refused, got %+v: %v", rows, err)
+	}
+	*tokenFlag = token
+
+	rows = nil
+	err = run(t, &rows, func() error { return command(common.AdminDrain, []string{"clustertest-01"}) })
+	if err != nil || len(rows) != 1 || !rows[0].Ok || rows[0].Name != "clustertest-01" {
+		t.Fatalf("want clustertest-01 drained, got %+v: %v", rows, err)
+	}
+	if !c.Worker("clustertest-01").Status().Draining || c.Worker("clustertest-00").Status().Draining {
+		t.Fatal("want only clustertest-01 draining")
+	}
+	err = c.Catalog.WaitGone(common.Wait, "clustertest-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []catalog.Entry
+	if err := run(t, &entries, func() error { return list("clustertest") }); err != nil || len(entries) != 1 || entries[0].Project != "clustertest-00" {
+		t.Fatalf("want only clustertest-00 listed, got %+v: %v", entries, err)
+	}
+}
@@ -1,108 +1,236 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"strconv"
 	"time"
 
 	"github.com/notnil/chess"
-	"github.com/notnil/chess/uci"
 
 	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/config"
+	"github.com/rpnahm/distsys-chess-engine/pkg/play"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+	"github.com/rpnahm/distsys-chess-engine/pkg/trace"
 )
 
 // *** UPDATES NEEDED ***
 
-// re-print board after client and server move
-// Endgame handling (print out final board state and result)
-
 // Later on: game select and such
 
 func main() {
 	fmt.Println("Hello from Client Main")
-	// handle command line input
-	if len(os.Args) != 4 {
-		log.Fatal("Usage: ./server <BaseServerName> <num servers> <turntime>")
+	// settings come from -config, CHESS_* variables and flags, see pkg/config
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ./client [flags] [<BaseServerName> <num servers> <turntime(ms)>]")
+		fs.PrintDefaults()
 	}
-	turntime, err := strconv.Atoi(os.Args[3])
+	cfg, args, err := config.Load(config.RoleClient, fs, os.Args[1:])
 	if err != nil {
-		log.Fatal("invalid turn time")
+		log.Fatal(err)
 	}
-	numservers, err := strconv.Atoi(os.Args[2])
-	if err != nil {
-		log.Fatal("invalid turn time")
+	// positional arguments from before the config system still work
+	if len(args) != 0 && len(args) != 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(args) == 3 {
+		turntime, err := strconv.Atoi(args[2])
+		if err != nil {
+			log.Fatal("invalid turn time")
+		}
+		numservers, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal("invalid number of servers")
+		}
+		cfg.Workers.Base = args[0]
+		cfg.Workers.Count = numservers
+		cfg.Client.TurnTime.Duration = time.Duration(turntime) * time.Millisecond
 	}
-	eng := client.Init(os.Args[1], numservers, time.Duration(turntime)*time.Millisecond, 50*time.Millisecond)
-	//eng.Game.UseNotation = *chess.NewGame()
-	err = eng.ConnectAll()
+	err = cfg.Validate(config.RoleClient)
 	if err != nil {
-		log.Fatal("Unable to connect to all servers: ", err)
+		log.Fatal(err)
 	}
+	cfg.Apply()
 
-	err = eng.NewGame(*eng.Game.Position(), []uci.CmdSetOption{})
+	logger, err := cfg.Logger()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for eng.Game.Outcome() == chess.NoOutcome {
-		reader := bufio.NewReader(os.Stdin)
-		// select a random move
-
-		//add error handling
-
-		//clear board before printing game state to avoid stacking boards
-		cmd := exec.Command("clear")
-		cmd.Stdout = os.Stdout
-		cmd.Run()
-
-		fmt.Println(eng.Game.Position().Board().Draw())
-		for {
-			fmt.Printf("Enter a valid move:")
-			move, _ := reader.ReadString('\n')
-			move = move[:len(move)-1]
-			err = eng.Game.MoveStr(move)
-			if err != nil {
-				fmt.Println("Invalid move\nValid Moves:")
-				moves := eng.Game.ValidMoves()
-				for i := 0; i < len(moves); i++ {
-					fmt.Printf("%s\n", moves[i])
-				}
-				continue
-			} else {
-				break
-			}
+	eng := client.New(cfg.Selector(), cfg.Client.TurnTime.Duration, cfg.Client.LatencyBuffer.Duration)
+	eng.SetLogger(logger)
+	// injected network faults, for resilience testing only
+	faults, err := cfg.Faults()
+	if err != nil {
+		log.Fatal("Unable to load fault scenario: ", err)
+	}
+	if faults != nil {
+		eng.InjectFaults(faults)
+		logger.Warn("injecting network faults", "scenario", cfg.Network.Faults)
+	}
+
+	// mutual TLS is enabled when the tls files are set
+	if tlsConfig := cfg.TLSConfig(); tlsConfig.Enabled() {
+		err = eng.UseTLS(tlsConfig)
+		if err != nil {
+			log.Fatal("Unable to enable TLS: ", err)
 		}
+	}
 
-		cmd = exec.Command("clear")
-		cmd.Stdout = os.Stdout
-		cmd.Run()
+	// output files, closed once the engine has shut down
+	var closers []io.Closer
 
-		fmt.Println(eng.Game.Position().Board().Draw())
+	// per-turn telemetry as JSON Lines
+	if cfg.Client.TelemetryFile != "" {
+		tf, err := os.Create(cfg.Client.TelemetryFile)
+		if err != nil {
+			log.Fatal("Unable to open telemetry file: ", err)
+		}
+		closers = append(closers, tf)
+		eng.AddObserver(client.NewJSONLObserver(tf))
+	}
 
-		eng.Run()
+	// OTLP JSON spans for every turn
+	if cfg.Client.TraceFile != "" {
+		exp, err := trace.CreateFileExporter(cfg.Client.TraceFile)
+		if err != nil {
+			log.Fatal("Unable to open trace file: ", err)
+		}
+		closers = append(closers, exp)
+		tracer := trace.NewTracer("chess-client", exp)
+		tracer.OnError(func(err error) { logger.Warn("unable to export spans", common.LogErr, err) })
+		eng.SetTracer(tracer)
 	}
 
-	cmd := exec.Command("clear")
-	cmd.Stdout = os.Stdout
-	cmd.Run()
+	// every protocol message, for cmd/replay
+	if cfg.Client.RecordFile != "" {
+		rec, err := recording.Create(cfg.Client.RecordFile, recording.RoleClient, "chess-client")
+		if err != nil {
+			log.Fatal("Unable to open record file: ", err)
+		}
+		closers = append(closers, rec)
+		eng.SetRecorder(rec)
+	}
 
-	fmt.Println(eng.Game.Position().Board().Draw())
+	ctx := context.Background()
+	err = eng.Connect(ctx)
+	if err != nil {
+		log.Fatal("Unable to connect to all servers: ", err)
+	}
 
-	fmt.Println(eng.Game.Outcome())
-	if eng.Game.Outcome() == "WhiteWon" {
-		fmt.Println("Checkmate. You Won!")
-	} else if eng.Game.Outcome() == "BlackWon" {
-		fmt.Println("Checkmate. You Lost.")
-	} else if eng.Game.Outcome() == "Draw" {
-		fmt.Println("Stalemate. You Tied!")
+	// pick up workers joining and leaving mid-game
+	stopWatching := func() {}
+	if cfg.Workers.Refresh.Duration > 0 {
+		stopWatching = eng.WatchCatalog(cfg.Workers.Refresh.Duration)
 	}
 
-	fmt.Println("Game Ended")
+	err = eng.NewGame(ctx, cfg.UCIOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Println("Success")
+	color, err := play.ParseColor(cfg.Client.Color)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the game is kept here, the cluster only answers searches
+	session := play.New(eng, os.Stdin, os.Stdout)
+	session.SetColor(color)
+	session.SetFlipped(cfg.Client.Flip)
+	session.SetClear(play.Terminal(os.Stdout))
+	if cfg.Client.Resume != "" {
+		game, err := resume(cfg.Client.Resume)
+		if err != nil {
+			log.Fatal("Unable to resume: ", err)
+		}
+		session.Resume(game)
+	}
+
+	err = session.Play(ctx)
+	// saved even when unfinished, so it can be resumed
+	if cfg.Client.PGNFile != "" {
+		g := session.Record()
+		names := [2]string{playerName(), clusterName(cfg)}
+		controls := [2]tournament.TimeControl{{}, {MoveTime: cfg.Client.TurnTime}}
+		workers := [2]int{0, len(eng.Workers())}
+		g.WhiteName, g.BlackName = names[g.White], names[g.Black]
+		g.TimeControl = [2]tournament.TimeControl{controls[g.White], controls[g.Black]}
+		g.Workers = [2]int{workers[g.White], workers[g.Black]}
+		serr := savePGN(cfg.Client.PGNFile, g)
+		if serr != nil {
+			logger.Warn("unable to save the game", common.LogErr, serr)
+		} else {
+			fmt.Println("Game saved to", cfg.Client.PGNFile)
+		}
+	}
+	// log.Fatal would skip the shutdown and leave the files unflushed
+	if err != nil {
+		log.Print(err)
+	}
+	stopWatching()
 	eng.Shutdown()
+	for _, c := range closers {
+		cerr := c.Close()
+		if cerr != nil {
+			logger.Warn("unable to close output file", common.LogErr, cerr)
+		}
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// the last game or position in path, played out
+func resume(path string) (*chess.Game, error) {
+	games, err := tournament.LoadOpenings(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	game, err := games[len(games)-1].Game()
+	if err != nil {
+		return nil, err
+	}
+	if game.Outcome() != chess.NoOutcome {
+		return nil, fmt.Errorf("%s: the game is already over", path)
+	}
+	return game, nil
+}
+
+// appends g to the PGN file at path
+func savePGN(path string, g tournament.GameResult) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = tournament.WritePGN(f, "distsys-chess client game", tournament.Openings{}, g)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// the person at the keyboard, for the PGN tags
+func playerName() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "player"
+}
+
+// the cluster, for the PGN tags
+func clusterName(cfg config.Config) string {
+	if cfg.Workers.Base != "" {
+		return cfg.Workers.Base
+	}
+	return "cluster"
 }
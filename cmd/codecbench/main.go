@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Compares the wire codecs on the messages sent during a turn.
+// Encode/decode numbers come from testing.Benchmark and include validation,
+// which is also timed on its own. Turn overhead is measured as a
+// parse_moves -> results round trip over loopback TCP against an echo
+// worker that does no searching.
+
+func main() {
+	turns := flag.Int("turns", 2000, "number of round trips per codec for the turn benchmark")
+	flag.Parse()
+
+	// a middlegame position with a realistic number of moves to split
+	fen, err := chess.FEN("r1bq1rk1/pp2bppp/2n1pn2/3p4/2PP4/2N1PN2/PP2BPPP/R2QKB1R w KQ - 0 8")
+	if err != nil {
+		log.Fatal(err)
+	}
+	game := chess.NewGame(fen, chess.UseNotation(chess.UCINotation{}))
+	var moves []string
+	for _, move := range game.ValidMoves() {
+		moves = append(moves, move.String())
+	}
+
+	messages := []common.Message{
+		&common.NewGame{Type: common.TypeNewGame, Options: []string{"Threads 4", "Hash 1024"}, Position: game.FEN(), PosId: 12},
+		&common.NewPos{Type: common.TypeNewPos, Position: game.FEN(), PosId: 13},
+		&common.ReadyOk{Type: common.TypeReadyOk, PosId: 13},
+		&common.ParseMoves{Type: common.TypeParseMoves, Position: game.FEN(), PosId: 13, Moves: moves, DueTime: time.Now(), JobId: 52},
+		&common.Results{Type: common.TypeResults, JobId: 52, BestMove: "c4d5", Score: 37, Nodes: 1834221},
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "codec\tmessage\tbytes\tencode ns/op\tdecode ns/op\tvalidate ns/op\tallocs/op\t")
+	for _, name := range common.CodecNames() {
+		codec, _ := common.LookupCodec(name)
+		for _, m := range messages {
+			frame, err := codec.Encode(m)
+			if err != nil {
+				log.Fatal(name, " unable to encode ", m.MessageType(), ": ", err)
+			}
+			_, payload, _ := codec.Split(frame)
+
+			enc := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					codec.Encode(m)
+				}
+			})
+			dec := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					codec.Decode(payload)
+				}
+			})
+			validate := testing.Benchmark(func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					m.Validate()
+				}
+			})
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t\n", name, m.MessageType(), len(frame),
+				enc.NsPerOp(), dec.NsPerOp(), validate.NsPerOp(), enc.AllocsPerOp()+dec.AllocsPerOp())
+		}
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "codec\tturns\tp50\tp90\tp99\tmean\t")
+	for _, name := range common.CodecNames() {
+		latencies, err := roundTrips(name, messages[3].(*common.ParseMoves), *turns)
+		if err != nil {
+			log.Fatal(name, " turn benchmark failed: ", err)
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var total time.Duration
+		for _, l := range latencies {
+			total += l
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t\n", name, len(latencies),
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+			total/time.Duration(len(latencies)))
+	}
+	w.Flush()
+}
+
+// Times n parse_moves -> results exchanges using the given codec
+func roundTrips(codec string, job *common.ParseMoves, n int) ([]time.Duration, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	go echoWorker(ln)
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	conn := common.NewConn(raw)
+	defer conn.Close()
+	err = conn.Negotiate([]string{codec})
+	if err != nil {
+		return nil, err
+	}
+
+	latencies := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		job.JobId = i
+		start := time.Now()
+		err = conn.Send(job)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := conn.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := reply.(*common.Results); !ok || r.JobId != i {
+			return nil, fmt.Errorf("unexpected reply %v", reply)
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	return latencies, nil
+}
+
+// Answers every parse_moves with a canned results message
+func echoWorker(ln net.Listener) {
+	raw, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	conn := common.NewConn(raw)
+	defer conn.Close()
+
+	for {
+		request, err := conn.Recv()
+		if err != nil {
+			return
+		}
+		switch m := request.(type) {
+		case *common.Hello:
+			conn.Accept(m)
+		case *common.ParseMoves:
+			conn.Send(&common.Results{Type: common.TypeResults, JobId: m.JobId, BestMove: m.Moves[0], Score: 12, Nodes: 100000})
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+// Scripted UCI engine for tests, a drop in for bin/stockfish.
+// The script comes from $FAKEENGINE_SCRIPT, a JSON file or the JSON itself,
+// see pkg/fakeengine for the fields. Without one the first legal move is
+// played straight away.
+func main() {
+	var script fakeengine.Script
+	if value := os.Getenv(fakeengine.EnvScript); value != "" {
+		var err error
+		script, err = fakeengine.LoadScript(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err := fakeengine.Serve(script, os.Stdin, os.Stdout)
+	if err != nil {
+		// a scripted crash exits like a real engine dying
+		log.Fatal(err)
+	}
+}
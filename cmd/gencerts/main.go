@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tlstest"
+)
+
+// Writes a throwaway CA plus worker and client certificates for local testing
+func main() {
+	if len(os.Args) < 3 {
+		log.Fatal("Usage: ./gencerts <outputDir> <workerName>...")
+	}
+
+	configs, err := tlstest.WriteFixtures(os.Args[1], os.Args[2:]...)
+	if err != nil {
+		log.Fatal("Unable to write certificates: ", err)
+	}
+
+	var names []string
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := configs[name]
+		fmt.Printf("# %s\n%s=%s %s=%s %s=%s\n", name,
+			common.EnvTLSCert, cfg.CertFile, common.EnvTLSKey, cfg.KeyFile, common.EnvTLSCA, cfg.CAFile)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+)
+
+// Plays back recordings made with -record, see pkg/recording
+
+const usage = `Usage: replay [flags] <command> [args]
+
+Commands:
+  show <recording>                   print the recording as a timeline
+  worker <recording> <host:port>     re-drive a worker with the client's side of the recording
+  client <recording>                 mock the recorded workers for a client, until interrupted
+
+Flags:
+`
+
+var (
+	peerFlag   = flag.String("peer", "", "worker: replay only the connections with this peer")
+	speedFlag  = flag.Float64("speed", 1, "playback speed, 2 is twice as fast as recorded")
+	strictFlag = flag.Bool("strict", false, "compare every field of messages, not just their type and ids")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r, err := recording.Load(flag.Arg(1))
+	if err != nil {
+		log.Fatal("Unable to load recording: ", err)
+	}
+	opts := recording.Options{Speed: *speedFlag, Strict: *strictFlag, Peer: *peerFlag}
+
+	// interrupts stop playback and still print the report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch flag.Arg(0) {
+	case "show":
+		show(r)
+	case "worker":
+		if flag.NArg() != 3 {
+			log.Fatal("Usage: replay worker <recording> <host:port>")
+		}
+		report, err := recording.Replay(ctx, r, flag.Arg(2), opts)
+		summarize(report)
+		if err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		if len(report.Mismatches) > 0 {
+			os.Exit(1)
+		}
+	case "client":
+		mock, err := recording.NewMock(r, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var addrs []string
+		for _, name := range mock.Workers() {
+			log.Printf("Mocking %s at %s", name, mock.Addr(name))
+			addrs = append(addrs, mock.Addr(name))
+		}
+		fmt.Printf("-workers %s\n", strings.Join(addrs, ","))
+		<-ctx.Done()
+		mock.Close()
+		report := mock.Report()
+		summarize(report)
+		if len(report.Mismatches) > 0 {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// prints every message with its offset from the start of the recording
+func show(r *recording.Recording) {
+	fmt.Printf("%s recording by %s, started %s\n", r.Role, r.Name, r.Start.Format("2006-01-02 15:04:05.000"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AT\tCONN\tPEER\tMESSAGE")
+	peers := map[int]string{}
+	for _, e := range r.Events {
+		at := e.At.Sub(r.Start).Round(100 * time.Microsecond)
+		switch e.Kind {
+		case recording.KindOpen:
+			peers[e.Conn] = e.Peer
+			fmt.Fprintf(w, "%s\t%d\t%s\topened\n", at, e.Conn, e.Peer)
+		case recording.KindSend, recording.KindRecv:
+			// arrows point the way the message went, from the recorder's side
+			dir := "->"
+			if e.Kind == recording.KindRecv {
+				dir = "<-"
+			}
+			message := string(e.Message)
+			if e.Message == nil {
+				message = fmt.Sprintf("(undecodable frame, %d bytes)", len(e.Raw))
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s %s\t%s\n", at, e.Conn, dir, peers[e.Conn], message)
+		}
+	}
+	w.Flush()
+}
+
+func summarize(report recording.Report) {
+	fmt.Printf("%d connections, %d messages sent, %d received, %d mismatches\n",
+		report.Conns, report.Sent, report.Received, len(report.Mismatches))
+	for _, m := range report.Mismatches {
+		fmt.Println("  " + m.String())
+	}
+}
@@ -1,28 +1,133 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/config"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
 	"github.com/rpnahm/distsys-chess-engine/pkg/server"
 )
 
 func main() {
-	fmt.Println("Hello from the server Executable")
+	// settings come from -config, CHESS_* variables and flags, see pkg/config
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ./server [flags] [serverName [workerConfigFile]]")
+		fs.PrintDefaults()
+	}
+	cfg, args, err := config.Load(config.RoleWorker, fs, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	// positional arguments from before the config system still work
+	if len(args) > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(args) >= 1 {
+		cfg.Worker.Name = args[0]
+	}
+	err = cfg.Validate(config.RoleWorker)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Apply()
 
-	// handle command line input
-	if len(os.Args) != 2 {
-		log.Fatal("Usage: ./server <serverName>")
+	logger, err := cfg.Logger()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// start the engine and server
-	worker := server.Startup()
-	worker.SetName(os.Args[1])
+	worker, err := server.StartupEngine(cfg.Worker.EnginePath)
+	if err != nil {
+		log.Fatal("Unable to start worker: ", err)
+	}
+	worker.SetName(cfg.Worker.Name)
+	worker.SetLogger(logger)
+	worker.SetShutdownGrace(cfg.Worker.ShutdownGrace.Duration)
+	worker.SetLabels(cfg.Worker.Labels)
+	if cfg.Worker.Capacity > 0 {
+		worker.SetCapacity(cfg.Worker.Capacity)
+	}
+
+	// admin commands are only accepted with this token
+	worker.SetAdminToken(cfg.Worker.AdminToken)
+	err = worker.SetEngineOptions(cfg.Worker.EngineOptions)
+	if err != nil {
+		log.Fatal("Unable to set engine options: ", err)
+	}
+	worker.SetConfigPath(cfg.Path)
+	if len(args) == 2 {
+		err := worker.LoadConfig(args[1])
+		if err != nil {
+			log.Fatal("Unable to load config: ", err)
+		}
+	}
+
+	// injected network faults, for resilience testing only
+	faults, err := cfg.Faults()
+	if err != nil {
+		log.Fatal("Unable to load fault scenario: ", err)
+	}
+	if faults != nil {
+		worker.InjectFaults(faults)
+		logger.Warn("injecting network faults", "scenario", cfg.Network.Faults)
+	}
+
+	// mutual TLS is enabled when the tls files are set
+	if tlsConfig := cfg.TLSConfig(); tlsConfig.Enabled() {
+		err := worker.UseTLS(tlsConfig)
+		if err != nil {
+			log.Fatal("Unable to enable TLS: ", err)
+		}
+	}
+
+	// every protocol message, for cmd/replay
+	if cfg.Worker.RecordFile != "" {
+		rec, err := recording.Create(cfg.Worker.RecordFile, recording.RoleWorker, cfg.Worker.Name)
+		if err != nil {
+			log.Fatal("Unable to open record file: ", err)
+		}
+		defer rec.Close()
+		worker.SetRecorder(rec)
+	}
+
+	// optional /healthz, /status and /metrics listener
+	if cfg.Worker.StatusAddr != "" {
+		bound, err := worker.ListenHTTP(cfg.Worker.StatusAddr)
+		if err != nil {
+			log.Fatal("Unable to start status endpoint: ", err)
+		}
+		logger.Info("serving status", "address", bound.String())
+	}
 
 	// Run a separate thread that communicates with the nameserver
-	go worker.CatalogMessage("rnahm")
+	go func() {
+		err := worker.CatalogMessage(cfg.Catalog.Owner)
+		if err != nil {
+			log.Fatal("Unable to advertise to catalog: ", err)
+		}
+	}()
+
+	// SIGTERM and SIGINT drain and deregister before exiting, a second signal exits at once
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		signal.Reset(syscall.SIGTERM, os.Interrupt)
+		logger.Info("received signal", "signal", sig.String())
+		worker.Shutdown(cfg.Worker.ShutdownGrace.Duration)
+	}()
 
+	logger.Info("worker running", common.LogWorker, cfg.Worker.Name)
 	worker.Run()
+	logger.Info("worker stopped", common.LogWorker, cfg.Worker.Name)
 
 }
@@ -1,155 +1,335 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/notnil/chess"
-	"github.com/notnil/chess/uci"
 	"github.com/rpnahm/distsys-chess-engine/pkg/client"
 	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/config"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+	"github.com/rpnahm/distsys-chess-engine/pkg/trace"
 )
 
 func main() {
-	// handle command line input
-	if len(os.Args) != 6 {
-		log.Fatal("Usage: ./server <BaseServerName> <numServers> <turnTime(ms)> <numGames> <threads>")
+	// settings come from -config, CHESS_* variables and flags, see pkg/config
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ./test [flags] [<BaseServerName> <numServers> <turnTime(ms)> <numGames> <threads> [codecs]]")
+		fmt.Fprintln(fs.Output(), "Plays the cluster against a local engine, or the tournament given with -tournament.")
+		fs.PrintDefaults()
 	}
-	nServers, err := strconv.Atoi(os.Args[2])
+	cfg, args, err := config.Load(config.RoleTest, fs, os.Args[1:])
 	if err != nil {
-		log.Fatal("Must use integer for numServers", err)
+		log.Fatal(err)
 	}
-	t, err := strconv.Atoi(os.Args[3])
-	if err != nil {
-		log.Fatal("Must use integer for turn time", err)
+	// positional arguments from before the config system still work
+	if len(args) != 0 && len(args) != 5 && len(args) != 6 {
+		fs.Usage()
+		os.Exit(2)
 	}
-	turnTime := time.Duration(t) * time.Millisecond
-	nGames, err := strconv.Atoi(os.Args[4])
-	if err != nil {
-		log.Fatal("Must use integer for nGames", err)
+	if len(args) >= 5 {
+		nServers, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal("Must use integer for numServers", err)
+		}
+		t, err := strconv.Atoi(args[2])
+		if err != nil {
+			log.Fatal("Must use integer for turn time", err)
+		}
+		nGames, err := strconv.Atoi(args[3])
+		if err != nil {
+			log.Fatal("Must use integer for nGames", err)
+		}
+		nThreads, err := strconv.Atoi(args[4])
+		if err != nil {
+			log.Fatal("Must use integer for nThreads", err)
+		}
+		cfg.Workers.Base = args[0]
+		cfg.Workers.Count = nServers
+		cfg.Client.TurnTime.Duration = time.Duration(t) * time.Millisecond
+		cfg.Test.Games = nGames
+		cfg.Test.Threads = nThreads
 	}
-	nThreads, err := strconv.Atoi(os.Args[5])
-	if err != nil {
-		log.Fatal("Must use integer for nThreads", err)
+	// optional comma separated list of wire formats to offer the workers
+	if len(args) == 6 {
+		cfg.Client.Codecs = strings.Split(args[5], ",")
 	}
-
-	// Start up engines
-	fmt.Println("Starting up engines")
-	client := client.Init(os.Args[1], nServers, turnTime, 50*time.Millisecond)
-
-	localEng, err := uci.New("bin/stockfish")
+	err = cfg.Validate(config.RoleTest)
 	if err != nil {
-		log.Fatal("Unable to start local stockfish")
+		log.Fatal(err)
 	}
+	cfg.Apply()
 
-	defer localEng.Close()
-
-	// Connecting to all servers
-	fmt.Println("Connecting to all servers")
-	err = client.ConnectAll()
+	logger, err := cfg.Logger()
 	if err != nil {
-		log.Fatal("Unable to connect to all servers", err)
+		log.Fatal(err)
 	}
-	defer client.Shutdown()
 
-	// tracking information
-	systemWins, systemDraws, systemLosses := 0, 0, 0
-	fd, err := os.OpenFile(fmt.Sprintf("%s-%d-%d-%d.log", os.Args[1], nServers, t, nThreads), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Fatal("Unable to open log file", err)
+	// a tournament file, or the original cluster against local stockfish match
+	var spec *tournament.Spec
+	if cfg.Test.Tournament != "" {
+		spec, err = tournament.Load(cfg.Test.Tournament)
+		if err != nil {
+			log.Fatal("Unable to load tournament: ", err)
+		}
+	} else {
+		spec = legacySpec(cfg)
+	}
+	if cfg.Test.PGNFile != "" {
+		spec.PGN = cfg.Test.PGNFile
+	}
+	if cfg.Test.Openings != "" {
+		spec.Openings.File = cfg.Test.Openings
+	}
+	if cfg.Test.Order != "" {
+		spec.Openings.Order = cfg.Test.Order
+	}
+	if cfg.Test.Seed != 0 {
+		spec.Openings.Seed = cfg.Test.Seed
+	}
+	if cfg.Test.Summary != "" {
+		spec.Summary = cfg.Test.Summary
+	}
+	adjudication(&spec.Adjudication, cfg.Test.Adjudication)
+	if t := cfg.Test.SPRT; t.Enabled() {
+		spec.SPRT = &tournament.SPRT{Elo0: t.Elo0, Elo1: t.Elo1, Alpha: t.Alpha, Beta: t.Beta}
 	}
-	defer fd.Close()
-	fd.WriteString("ServerNodesProcessed, ClientNodesProcessed\n")
-
-	var results common.Results
-
-	fmt.Println("All servers connected")
-	// games loop
-	for game := 0; game < nGames; game++ {
-		//setup each game
-		// options
-		var options []uci.CmdSetOption
-		options = append(options, uci.CmdSetOption{Name: "Threads", Value: fmt.Sprint(nThreads)})
-		options = append(options, uci.CmdSetOption{Name: "Hash", Value: fmt.Sprint((10240 * nThreads))})
 
-		err = localEng.Run(uci.CmdUCI, uci.CmdIsReady, uci.CmdUCINewGame)
+	// per-turn telemetry as JSON Lines
+	var telemetry client.Observer
+	if cfg.Client.TelemetryFile != "" {
+		tf, err := os.Create(cfg.Client.TelemetryFile)
 		if err != nil {
-			log.Fatal("Unable configure local stockfish", err)
-		}
-		var cmds []uci.Cmd
-		for _, c := range options {
-			cmds = append(cmds, c)
+			log.Fatal("Unable to open telemetry file: ", err)
 		}
-		err = localEng.Run(cmds...)
+		defer tf.Close()
+		telemetry = client.NewJSONLObserver(tf)
+	}
+
+	// OTLP JSON spans for every turn
+	var tracer *trace.Tracer
+	if cfg.Client.TraceFile != "" {
+		exp, err := trace.CreateFileExporter(cfg.Client.TraceFile)
 		if err != nil {
-			log.Fatal("Unable to run setoptions on local engine", err)
+			log.Fatal("Unable to open trace file: ", err)
 		}
+		defer exp.Close()
+		tracer = trace.NewTracer("chess-client", exp)
+		tracer.OnError(func(err error) { logger.Warn("unable to export spans", common.LogErr, err) })
+	}
 
-		fmt.Println("Remotely creating a newgame")
-		client.Game = *chess.NewGame(chess.UseNotation(chess.UCINotation{}))
-
-		err = client.NewGame(*client.Game.Position(), options)
+	// every protocol message, for cmd/replay
+	var recorder *recording.Recorder
+	if cfg.Client.RecordFile != "" {
+		recorder, err = recording.Create(cfg.Client.RecordFile, recording.RoleClient, "chess-test")
 		if err != nil {
-			log.Fatal("Unable to start newgames on servers", err)
+			log.Fatal("Unable to open record file: ", err)
 		}
+		defer recorder.Close()
+	}
 
-		fmt.Println("Entering Game Loop")
-		// game loop
-		for client.Game.Outcome() == chess.NoOutcome {
-			// Local Move
-			if game%2 == 0 {
+	// injected network faults, for resilience testing only
+	faults, err := cfg.Faults()
+	if err != nil {
+		log.Fatal("Unable to load fault scenario: ", err)
+	}
+	if faults != nil {
+		logger.Warn("injecting network faults", "scenario", cfg.Network.Faults)
+	}
+	tlsConfig := cfg.TLSConfig()
 
-				cmdPos := uci.CmdPosition{Position: client.Game.Position()}
-				cmdGo := uci.CmdGo{MoveTime: turnTime}
-				localEng.Run(cmdPos, cmdGo)
+	// every cluster in the tournament gets a client set up like cmd/client's
+	newClient := func(e tournament.Engine) (*client.Client, error) {
+		c := client.New(e.Workers.Selector(), cfg.Client.TurnTime.Duration, cfg.Client.LatencyBuffer.Duration)
+		c.SetLogger(logger.With("engine", e.Name))
+		c.SetTracer(tracer)
+		if telemetry != nil {
+			c.AddObserver(telemetry)
+		}
+		if recorder != nil {
+			c.SetRecorder(recorder)
+		}
+		if faults != nil {
+			c.InjectFaults(faults)
+		}
+		// mutual TLS is enabled when the tls files are set
+		if tlsConfig.Enabled() {
+			err := c.UseTLS(tlsConfig)
+			if err != nil {
+				return nil, fmt.Errorf("unable to enable TLS: %w", err)
+			}
+		}
+		return c, nil
+	}
 
-				move := localEng.SearchResults().BestMove
-				client.Game.Move(move)
+	runner, err := tournament.New(spec, tournament.DefaultFactory(newClient, cfg.Workers.Refresh.Duration))
+	if err != nil {
+		log.Fatal(err)
+	}
+	runner.SetLogger(logger)
+	runner.OnGame(func(g tournament.GameResult) {
+		fmt.Printf("Game %d: %s - %s %s, %s after %d moves\n", g.Number, g.WhiteName, g.BlackName, g.Result, g.Reason, (len(g.Moves)+1)/2)
+	})
 
-				if client.Game.Outcome() != chess.NoOutcome {
-					break
-				}
+	// an interrupt stops the tournament and still prints the standings
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Playing %d games of a %s between %d engines\n", len(spec.Schedule()), spec.Format, len(spec.Engines))
+	// enough to play the same openings in the same order again
+	if o := spec.Openings; o.File != "" {
+		fmt.Printf("Openings: %d from %s", len(spec.OpeningSuite()), o.File)
+		if o.Order == tournament.OrderRandom {
+			fmt.Printf(", shuffled with -seed %d", o.Seed)
+		}
+		fmt.Println()
+	}
+	results, err := runner.Run(ctx)
+	standings(results)
+	matches(results)
+	// the original match also kept its node counts and record in a log file
+	if cfg.Test.Tournament == "" {
+		legacyLog(legacyStem(cfg)+".log", results)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
 
-				// server move
-				results, _ = client.Run()
-			} else {
-				// server move
-				results, _ = client.Run()
+// files of the original match are named <base>-<numServers>-<turnTime(ms)>-<threads>
+func legacyStem(cfg config.Config) string {
+	name := cfg.Workers.Base
+	if name == "" {
+		name = "cluster"
+	}
+	return fmt.Sprintf("%s-%d-%d-%d", name, cfg.Workers.Count, cfg.Client.TurnTime.Milliseconds(), cfg.Test.Threads)
+}
 
-				if client.Game.Outcome() != chess.NoOutcome {
-					break
-				}
+// the original match: the cluster against local stockfish, alternating
+// colors, with the same Threads and Hash on both sides
+func legacySpec(cfg config.Config) *tournament.Spec {
+	name := cfg.Workers.Base
+	if name == "" {
+		name = "cluster"
+	}
+	threads := cfg.Test.Threads
+	options := append(append([]string{}, cfg.Client.EngineOptions...), fmt.Sprint("Threads ", threads), fmt.Sprint("Hash ", 10240*threads))
+	turnTime := cfg.Client.TurnTime.Duration
+	return &tournament.Spec{
+		Format: tournament.FormatGauntlet,
+		Games:  cfg.Test.Games,
+		Engines: []tournament.Engine{
+			{Name: name, Kind: tournament.KindCluster, Workers: cfg.Workers, Options: options},
+			{Name: "stockfish", Kind: tournament.KindUCI, Path: cfg.Test.EnginePath, Options: options},
+		},
+		TimeControl: tournament.TimeControl{MoveTime: config.Duration{Duration: turnTime}},
+		PGN:         legacyStem(cfg) + ".pgn",
+	}
+}
 
-				cmdPos := uci.CmdPosition{Position: client.Game.Position()}
-				cmdGo := uci.CmdGo{MoveTime: turnTime}
-				localEng.Run(cmdPos, cmdGo)
+// writes the original match's log: the cluster's and the local engine's
+// nodes for every pair of moves, then the cluster's W-D-L record, which is
+// printed as well
+func legacyLog(path string, results *tournament.Results) {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatal("Unable to open log file", err)
+	}
+	defer fd.Close()
+	fd.WriteString("ServerNodesProcessed, ClientNodesProcessed\n")
 
-				move := localEng.SearchResults().BestMove
-				client.Game.Move(move)
+	// the cluster is engine 0
+	wins, draws, losses := 0, 0, 0
+	for _, g := range results.Games {
+		var nodes [2]int
+		played := 0
+		turn := g.Start.Turn()
+		for _, m := range g.Moves {
+			mover := g.White
+			if turn == chess.Black {
+				mover = g.Black
+			}
+			turn = turn.Other()
+			if m.Book {
+				continue
+			}
+			nodes[mover] = m.Nodes
+			played++
+			if played%2 == 0 {
+				fd.WriteString(fmt.Sprintf("%d, %d\n", nodes[0], nodes[1]))
 			}
-			fd.WriteString(fmt.Sprintf("%d, %d\n", results.Nodes, localEng.SearchResults().Info.Nodes))
+		}
+		switch {
+		case g.Result == tournament.Draw:
+			draws++
+		case g.Score(0) == 1:
+			wins++
+		case g.Result != tournament.Ongoing:
+			losses++
+		}
+	}
+	record := fmt.Sprintf("Distributed Chess Record against Local Stockfish:\n%d-%d-%d\n", wins, draws, losses)
+	fd.WriteString(record)
+	fmt.Print("\n" + record)
+}
 
+// the config's adjudication rules win over the tournament's
+func adjudication(a *tournament.Adjudication, c config.Adjudication) {
+	for _, rule := range []struct {
+		set   *int
+		value int
+	}{
+		{&a.MaxMoves, c.MaxMoves}, {&a.ResignScore, c.ResignScore}, {&a.ResignMoves, c.ResignMoves},
+		{&a.DrawScore, c.DrawScore}, {&a.DrawMoves, c.DrawMoves}, {&a.DrawAfter, c.DrawAfter},
+		{&a.TablebasePieces, c.TablebasePieces},
+	} {
+		if rule.value != 0 {
+			*rule.set = rule.value
 		}
+	}
+	if c.TablebaseURL != "" {
+		a.TablebaseURL = c.TablebaseURL
+	}
+}
 
-		log.Println(client.Game.Outcome(), client.Game.Method(), len(client.Game.MoveHistory()), "moves")
-		if client.Game.Outcome() == chess.Draw {
-			systemDraws++
-		} else if game%2 == 0 {
-			if client.Game.Outcome() == chess.WhiteWon {
-				systemLosses++
-			} else {
-				systemWins++
-			}
-		} else {
-			if client.Game.Outcome() == chess.BlackWon {
-				systemWins++
-			} else {
-				systemLosses++
+// prints the table of results
+func standings(results *tournament.Results) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENGINE\tGAMES\tWINS\tDRAWS\tLOSSES\tPOINTS")
+	for _, s := range results.Standings() {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f\n", s.Engine, s.Games, s.Wins, s.Draws, s.Losses, s.Points)
+	}
+	w.Flush()
+}
+
+// prints each match's statistics from the first engine's side
+func matches(results *tournament.Results) {
+	for _, m := range results.Matches() {
+		fmt.Printf("\n%s vs %s: %d-%d-%d (W-D-L), score %.1f%%\n", m.Engine, m.Opponent, m.Wins, m.Draws, m.Losses, m.Score*100)
+		fmt.Printf("  Elo %s [%s, %s] at %.0f%%, LOS %.1f%%\n", m.Elo.Diff, m.Elo.Low, m.Elo.High, tournament.Confidence*100, m.LOS*100)
+		if m.Pairs() > 0 {
+			fmt.Printf("  Pentanomial %v over %d pairs\n", m.Pentanomial, m.Pairs())
+		}
+		if t := m.SPRT; t != nil {
+			result := "continue"
+			if t.Result != tournament.SPRTContinue {
+				result = "accepted " + t.Result
 			}
+			fmt.Printf("  SPRT [%v, %v] LLR %.2f (%.2f, %.2f) %s\n", t.Elo0, t.Elo1, t.LLR, t.Lower, t.Upper, result)
 		}
 	}
-	fd.WriteString(fmt.Sprintf("Distributed Chess Record against Local Stockfish:\n%d-%d-%d\n", systemWins, systemDraws, systemLosses))
+	if results.Stopped != "" {
+		fmt.Printf("\nStopped after %d of %d games: %s\n", len(results.Games), results.Scheduled, results.Stopped)
+	}
 }
@@ -1,10 +1,14 @@
 module github.com/rpnahm/distsys-chess-engine
 
-go 1.20
+go 1.21
 
 require (
 	github.com/cloudwego/netpoll v0.6.5
 	github.com/notnil/chess v1.10.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 )
 
-require github.com/bytedance/gopkg v0.1.0 // indirect
+require (
+	github.com/bytedance/gopkg v0.1.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
@@ -0,0 +1,130 @@
+// Package catalog reads worker registrations from the ND catalog server.
+// Workers announce themselves over UDP, and the catalog serves every
+// announcement it has heard recently as a JSON list at /query.json.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WorkerType is the "type" workers register under
+const WorkerType = "chess-worker"
+
+// Entry is a single registration in query.json
+type Entry struct {
+	Type          string  `json:"type"`
+	Owner         string  `json:"owner"`
+	Project       string  `json:"project"` // the worker's name
+	Address       string  `json:"address"`
+	Port          int     `json:"port"`
+	LastHeardFrom float64 `json:"lastheardfrom"` // unix seconds
+
+	// advertised by workers for client side selection
+	Labels   map[string]string `json:"labels,omitempty"`
+	Capacity int               `json:"capacity,omitempty"` // search threads the worker offers
+	Engine   string            `json:"engine,omitempty"`   // engine name and version
+	Net      string            `json:"net,omitempty"`      // NNUE file, named after its hash
+	Threads  int               `json:"threads,omitempty"`
+	Hash     int               `json:"hash,omitempty"` // MB
+	CPU      string            `json:"cpu,omitempty"`
+	Protocol int               `json:"protocol,omitempty"` // 0 for workers that predate it
+	Load     float64           `json:"load"`               // 1 minute load average per CPU
+	Busy     bool              `json:"busy"`               // a client session is active
+	Draining bool              `json:"draining"`           // leaving, takes no new sessions
+}
+
+// HasLabels reports whether the entry carries every key value pair in labels
+func (e Entry) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// HostPort is the address to dial the worker on
+func (e Entry) HostPort() string {
+	return net.JoinHostPort(e.Address, fmt.Sprint(e.Port))
+}
+
+// LastHeard converts LastHeardFrom into a time
+func (e Entry) LastHeard() time.Time {
+	return time.Unix(int64(e.LastHeardFrom), 0)
+}
+
+// how long to wait on the catalog before giving up
+var Timeout = 10 * time.Second
+
+// Query fetches every registration the catalog at host:port knows about
+func Query(host string, port int) ([]Entry, error) {
+	return QueryContext(context.Background(), host, port)
+}
+
+// QueryContext is Query, giving up early when ctx ends
+func QueryContext(ctx context.Context, host string, port int) ([]Entry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/query.json", net.JoinHostPort(host, fmt.Sprint(port))), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := http.Client{Timeout: Timeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact catalog server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("catalog server returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog response: %w", err)
+	}
+
+	var entries []Entry
+	err = json.Unmarshal(body, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode catalog response: %w", err)
+	}
+	return entries, nil
+}
+
+// Workers keeps the newest registration of every chess worker whose name
+// starts with prefix, sorted by name
+func Workers(entries []Entry, prefix string) []Entry {
+	newest := map[string]Entry{}
+	for _, e := range entries {
+		if e.Type != WorkerType || !strings.HasPrefix(e.Project, prefix) {
+			continue
+		}
+		if old, ok := newest[e.Project]; !ok || old.LastHeardFrom < e.LastHeardFrom {
+			newest[e.Project] = e
+		}
+	}
+
+	var workers []Entry
+	for _, e := range newest {
+		workers = append(workers, e)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Project < workers[j].Project })
+	return workers
+}
+
+// Find returns the newest registration for the worker with exactly this name
+func Find(entries []Entry, name string) (Entry, bool) {
+	for _, e := range Workers(entries, name) {
+		if e.Project == name {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
@@ -0,0 +1,151 @@
+package catalog_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+)
+
+// a worker registration heard from at lastHeard
+func worker(name string, port int, lastHeard float64) catalog.Entry {
+	return catalog.Entry{Type: catalog.WorkerType, Project: name, Address: "10.0.0.1", Port: port, LastHeardFrom: lastHeard}
+}
+
+var entries = []catalog.Entry{
+	worker("w10", 9010, 100),
+	worker("w1", 9001, 100),
+	// an older registration of w1 on another port
+	worker("w1", 9999, 50),
+	worker("w2", 9002, 100),
+	// a newer one of w2 after a restart
+	worker("w2", 9102, 150),
+	worker("other", 9100, 100),
+	{Type: "catalog", Project: "w3", Address: "10.0.0.1", Port: 9003, LastHeardFrom: 100},
+}
+
+func TestWorkers(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"every worker", "", []string{"other@9100", "w1@9001", "w10@9010", "w2@9102"}},
+		{"prefix", "w", []string{"w1@9001", "w10@9010", "w2@9102"}},
+		{"prefix is a name", "w1", []string{"w1@9001", "w10@9010"}},
+		{"other types left out", "w3", nil},
+		{"nothing", "x", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range catalog.Workers(entries, tt.prefix) {
+				got = append(got, e.Project+"@"+strconv.Itoa(e.Port))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	e, ok := catalog.Find(entries, "w1")
+	if !ok || e.Port != 9001 {
+		t.Fatalf("want the newest w1, got %+v %v", e, ok)
+	}
+	if e.HostPort() != "10.0.0.1:9001" {
+		t.Fatalf("unexpected address %s", e.HostPort())
+	}
+	// w1 is a prefix of w10, but not its name
+	if e, ok := catalog.Find([]catalog.Entry{worker("w10", 9010, 100)}, "w1"); ok {
+		t.Fatalf("w1 found %+v", e)
+	}
+	if _, ok := catalog.Find(entries, "w3"); ok {
+		t.Fatal("found a registration that isn't a worker")
+	}
+}
+
+// a catalog answering every query with status and body
+func serve(t *testing.T, status int, body string) (string, int) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, p
+}
+
+func TestQuery(t *testing.T) {
+	host, port := serve(t, http.StatusOK, `[{"type":"chess-worker","project":"w1","address":"10.0.0.1","port":9001,"lastheardfrom":100.5}]`)
+	got, err := catalog.Query(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []catalog.Entry{{Type: catalog.WorkerType, Project: "w1", Address: "10.0.0.1", Port: 9001, LastHeardFrom: 100.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"status", http.StatusServiceUnavailable, "", "catalog server returned 503 Service Unavailable"},
+		{"bad json", http.StatusOK, `[{"project":`, "unable to decode catalog response"},
+		{"not a list", http.StatusOK, `{"project":"w1"}`, "unable to decode catalog response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := serve(t, tt.status, tt.body)
+			_, err := catalog.QueryContext(context.Background(), host, port)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("want an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// nothing listening
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	_, err = catalog.Query("127.0.0.1", ln.Addr().(*net.TCPAddr).Port)
+	if err == nil || !strings.Contains(err.Error(), "unable to contact catalog server") {
+		t.Fatalf("want the catalog unreachable, got %v", err)
+	}
+
+	// a cancelled query gives up
+	host, port := serve(t, http.StatusOK, "[]")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = catalog.QueryContext(ctx, host, port)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want the query cancelled, got %v", err)
+	}
+}
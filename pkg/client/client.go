@@ -1,39 +1,69 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net"
-	"net/http"
+	"sync"
 	"time"
 
 	"github.com/notnil/chess"
 	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
 	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/faultnet"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+	"github.com/rpnahm/distsys-chess-engine/pkg/trace"
 )
 
-// struct to store all client data
+// Client spreads searches over a set of workers. It keeps no game of its
+// own, callers pass the position to every Search and apply the move.
+// The setters are meant to be called before Connect, everything after is
+// safe for concurrent use, operations on the workers take turns.
 type Client struct {
-	baseServerName string
-	numServers     int
-	Game           chess.Game
-	conns          []server
-	posId          int
-	jobId          int
-	TurnTime       time.Duration
-	latencyBuff    time.Duration
+	selector    Selector
+	conns       []server
+	posId       int
+	jobId       int
+	turnTime    time.Duration
+	latencyBuff time.Duration
+	tls         *common.TLSConfig
+	faults      *faultnet.Scenario
+	recorder    *recording.Recorder
+	turn        int
+	observers   []Observer
+	logger      *slog.Logger
+	tracer      *trace.Tracer
+
+	// new_game options and the last position searched, replayed to workers that join mid-game
+	options  []string
+	position string
+	inGame   bool
+
+	// held by every operation that talks to workers, a channel so waiting can be cancelled
+	busy chan struct{}
+
+	// guarded by mu
+	mu      sync.Mutex
+	latest  []catalog.Entry // newest catalog poll not yet applied
+	workers []string        // connected workers as of the last operation
 }
 
-// Stores connection information about each server
+// Stores connection information about each server, conn is nil while disconnected
 type server struct {
-	name  string
-	conn  net.Conn
-	jobId int
+	name    string
+	address string
+	direct  bool // address was given explicitly rather than looked up in the catalog
+	conn    *common.Conn
+	jobId   int
 }
 
+// returned when a worker announces it is draining or shutting down
+var errLeaving = errors.New("worker is leaving")
+
 type newError struct {
 	Code    int
 	Message string
@@ -43,302 +73,414 @@ func (e *newError) Error() string {
 	return fmt.Sprintf("Code %d, Error: %s\n", e.Code, e.Message)
 }
 
-// intialize the Client struct for operations
+// intialize the Client struct for operations on <baseServer>-00 to <baseServer>-<numServers-1>
 func Init(baseServer string, numServers int, turnTime time.Duration, latency time.Duration) *Client {
-	c := &Client{baseServerName: baseServer, numServers: numServers, posId: 0, jobId: 0}
+	return New(NamedWorkers(baseServer, numServers), turnTime, latency)
+}
 
-	c.TurnTime = turnTime
-	c.latencyBuff = latency
-	c.Game = *chess.NewGame(chess.UseNotation(chess.UCINotation{}))
-	for i := 0; i < c.numServers; i++ {
-		name := fmt.Sprintf("%s-%02d", c.baseServerName, i)
-		s := server{name: name, conn: nil, jobId: 0}
-		c.conns = append(c.conns, s)
+// intialize the Client struct for the workers picked by selector.
+// turnTime is used by searches that don't set Limits.MoveTime, latency is
+// the part of every turn reserved for results to travel back.
+func New(selector Selector, turnTime time.Duration, latency time.Duration) *Client {
+	return &Client{
+		selector:    selector,
+		turnTime:    turnTime,
+		latencyBuff: latency,
+		position:    chess.StartingPosition().String(),
+		logger:      slog.Default(),
+		busy:        make(chan struct{}, 1),
 	}
-	return c
 }
 
-// Closes all connections
-func (c *Client) Shutdown() {
-	// stop message
-	o := common.Stop{Type: "stop"}
-	data, _ := json.Marshal(o)
+// Set the logger used for connection and turn events
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
 
-	for _, server := range c.conns {
-		server.conn.Write(data)
-		server.conn.Close()
-	}
+// Record a trace for every turn, workers' spans are exported alongside the client's
+func (c *Client) SetTracer(tracer *trace.Tracer) {
+	c.tracer = tracer
 }
 
-// connects a single server
-func (c *Client) Connect(serverNum int) error {
-	// get response from server
-	resp, err := http.Get(fmt.Sprintf("http://%s:%d/query.json", common.CatalogAddr, common.CatalogPort))
+// Use mutual TLS for every worker connection made after this call
+func (c *Client) UseTLS(cfg common.TLSConfig) error {
+	// load once now so bad paths are reported before connecting
+	_, err := cfg.ClientConfig("")
 	if err != nil {
-		log.Fatal("Unable to contact catalog server", serverNum, err)
+		return err
 	}
-	defer resp.Body.Close()
+	c.tls = &cfg
+	return nil
+}
 
-	// parse the input
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal("Unable to parse body of catalog", err)
-		return err
+// Inject the scenario's faults into every worker connection made after this
+// call, for resilience testing. Faults sit below TLS, on the raw bytes.
+func (c *Client) InjectFaults(s *faultnet.Scenario) {
+	c.faults = s
+}
+
+// Record every message exchanged with workers on connections made after this call
+func (c *Client) SetRecorder(r *recording.Recorder) {
+	c.recorder = r
+}
+
+// waits for exclusive use of the workers, or for ctx to end
+func (c *Client) acquire(ctx context.Context) error {
+	select {
+	case c.busy <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	var results []map[string]interface{}
-	err = json.Unmarshal(body, &results)
-	if err != nil {
-		log.Println("Unable to decode json", err)
-		return err
+// publishes the connected workers and lets the next operation in
+func (c *Client) release() {
+	var names []string
+	for _, i := range c.live() {
+		names = append(names, c.conns[i].name)
 	}
+	c.mu.Lock()
+	c.workers = names
+	c.mu.Unlock()
+	<-c.busy
+}
 
-	// Now we have the input in result we should
-	// iterate over it now to find our server
-	newTime := 0.0
-	var newServerInfo map[string]interface{}
-	for _, value := range results {
-		if value["type"] == "chess-worker" {
-			if value["project"] == c.conns[serverNum].name && newTime < value["lastheardfrom"].(float64) {
-				newServerInfo = value
-				newTime = value["lastheardfrom"].(float64)
-			}
+// Closes all connections, waiting for a search in progress to finish
+func (c *Client) Shutdown() {
+	c.acquire(context.Background())
+	defer c.release()
+	c.closeAll()
+}
+
+func (c *Client) closeAll() {
+	// stop message
+	o := common.Stop{Type: common.TypeStop}
+
+	for _, i := range c.live() {
+		c.conns[i].conn.SetWriteDeadline(time.Now().Add(common.Wait))
+		c.conns[i].conn.Send(&o)
+		c.conns[i].conn.Close()
+		c.conns[i].conn = nil
+	}
+}
+
+// Names of the workers connected as of the last operation
+func (c *Client) Workers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.workers...)
+}
+
+// indexes of the connected servers
+func (c *Client) live() []int {
+	var live []int
+	for i, server := range c.conns {
+		if server.conn != nil {
+			live = append(live, i)
 		}
 	}
+	return live
+}
 
-	// set the conn values to the correct state, and return
-	c.conns[serverNum].conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", newServerInfo["address"], int(newServerInfo["port"].(float64))))
-	if err != nil {
+// disconnects a server, it stays in the list so it can be reconnected
+func (c *Client) drop(serverNum int) {
+	if c.conns[serverNum].conn != nil {
+		c.conns[serverNum].conn.Close()
 		c.conns[serverNum].conn = nil
-		log.Println("Unable to connect to server: ", newServerInfo)
-		return err
 	}
-	return nil
+	c.logger.Warn("dropped worker", common.LogWorker, c.conns[serverNum].name)
 }
 
-// Connect to all servers
-func (c *Client) ConnectAll() error {
-	for i := 0; i < c.numServers; i++ {
-		err := http.ErrAbortHandler
-		tried := false
-		for err != nil {
-			err = c.Connect(i)
-			if err != nil {
-				if tried {
-					return err
-				}
-				time.Sleep(common.Wait)
-				tried = true
-			}
-		}
+// the earlier of ctx's deadline and timeout from now
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
 	}
-	return nil
+	return d
 }
 
-// sets up the newgame on all machines
-func (c *Client) NewGame(position chess.Position, options []uci.CmdSetOption) error {
-	o := common.NewGame{
-		Type:     "new_game",
-		Position: position.String(),
-		PosId:    c.posId,
+// reads one message, giving up at the deadline or as soon as ctx ends
+func recv(ctx context.Context, conn *common.Conn, until time.Time) (common.Message, error) {
+	conn.SetReadDeadline(until)
+	defer conn.SetReadDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	m, err := conn.Recv()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
+	return m, err
+}
 
-	// add options to the message
-	var opts []string
-	for _, option := range options {
-		opts = append(opts, fmt.Sprintf("%s %s", option.Name, option.Value))
+// reconnects a single server, looking its address up in the catalog again
+func (c *Client) reconnect(ctx context.Context, serverNum int) error {
+	s := &c.conns[serverNum]
+	if !s.direct {
+		// get response from server
+		entries, err := catalog.QueryContext(ctx, common.CatalogAddr, common.CatalogPort)
+		if err != nil {
+			c.logger.Warn("unable to query catalog", common.LogWorker, s.name, common.LogErr, err)
+			return err
+		}
+
+		// Now we have the input in result we should
+		// iterate over it now to find our server
+		newServerInfo, ok := catalog.Find(entries, s.name)
+		if !ok {
+			return &newError{Code: 2, Message: fmt.Sprint("server not found in catalog: ", s.name)}
+		}
+		s.address = newServerInfo.HostPort()
+	}
+	return c.open(ctx, serverNum)
+}
+
+// dials a server at its known address and negotiates the wire format
+func (c *Client) open(ctx context.Context, serverNum int) error {
+	s := &c.conns[serverNum]
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
 	}
 
-	o.Options = opts
+	// workers given by address are expected to present a certificate for their host
+	tlsName := s.name
+	if s.direct {
+		tlsName, _, _ = net.SplitHostPort(s.address)
+	}
 
-	// Send to all clients and get their responses one at a time
-	oData, err := json.Marshal(o)
+	// set the conn values to the correct state, and return
+	ctx, cancel := context.WithDeadline(ctx, deadline(ctx, common.Wait))
+	defer cancel()
+	conn, err := c.dial(ctx, s.address, s.name, tlsName)
 	if err != nil {
+		c.logger.Warn("unable to connect to server", common.LogWorker, s.name, "address", s.address, common.LogErr, err)
 		return err
 	}
-	err = c.sendAll(oData)
+	s.conn = common.NewConn(conn)
+	if c.recorder != nil {
+		c.recorder.Attach(s.conn, s.name)
+	}
+
+	// agree on a wire format before anything else is sent
+	d, _ := ctx.Deadline()
+	s.conn.SetDeadline(d)
+	err = s.conn.Negotiate(common.Codecs)
 	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		c.logger.Warn("unable to negotiate codec", common.LogWorker, s.name, common.LogErr, err)
 		return err
 	}
+	s.conn.SetDeadline(time.Time{})
 	return nil
 }
 
-// Sends the same message to all clients expects ReadyOk
-func (c *Client) sendAll(data []byte) error {
-	buf := make([]byte, common.BufSize)
-	var response map[string]interface{}
+// opens a connection to a worker, through the fault scenario and over TLS if configured
+func (c *Client) dial(ctx context.Context, address string, name string, tlsName string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if c.faults != nil {
+		dialer := &faultnet.Dialer{Scenario: c.faults, Worker: name}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil || c.tls == nil {
+		return conn, err
+	}
 
-	for i, server := range c.conns {
-		// loop forever until sent
-		for {
-			for {
-				_, err := server.conn.Write(data)
-				if err != nil {
-					log.Println("Unable to send data to server:", i, err, "retrying")
-					time.Sleep(common.Wait)
-					server.conn.Close()
-					c.Connect(i)
-				} else {
-					break
-				}
-			}
+	tlsConfig, err := c.tls.ClientConfig(tlsName)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
 
-			// Now get readyok
-			n, err := server.conn.Read(buf)
-			if err != nil {
-				log.Println("Unable to recieve readyok from server", err)
-				server.conn.Close()
-				c.Connect(i)
-			} else {
-				// decode json
-				err = json.Unmarshal(buf[:n], &response)
-				// bad json gets a return
-				if err != nil {
-					return err
-				}
-				// server error gets return
-				if response["type"] == "error" {
-					return &newError{Code: 1, Message: response["reason"].(string)}
-				} else if response["type"] == "ready_ok" && int(response["pos_id"].(float64)) == c.posId { // ready_ok continues
-					break
-				} else { // random message gets a resend
-					continue
-				}
+// Connect to every worker the selector picks, workers that can't be reached
+// after one retry are skipped. Only fails when none can be reached.
+// Connecting again starts over with a fresh set of workers.
+func (c *Client) Connect(ctx context.Context) error {
+	err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.release()
+
+	var entries []catalog.Entry
+	if c.selector.needsCatalog() {
+		entries, err = catalog.QueryContext(ctx, common.CatalogAddr, common.CatalogPort)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.closeAll()
+	c.conns = nil
+	c.inGame = false
+	for _, t := range c.selector.resolve(entries) {
+		c.conns = append(c.conns, server{name: t.name, address: t.address, direct: t.direct})
+	}
+
+	for i, server := range c.conns {
+		if server.address == "" {
+			c.logger.Warn("server not found in catalog", common.LogWorker, server.name)
+			continue
+		}
+		err := c.open(ctx, i)
+		if err != nil && ctx.Err() == nil {
+			select {
+			case <-time.After(common.Wait):
+			case <-ctx.Done():
 			}
+			err = c.reconnect(ctx, i)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			c.logger.Warn("skipping unreachable worker", common.LogWorker, server.name, common.LogErr, err)
 		}
 	}
+
+	if len(c.live()) == 0 {
+		return &newError{Code: 2, Message: fmt.Sprint("no reachable workers for ", c.selector)}
+	}
+	c.logger.Info("connected to workers", "workers", c.names(), "selected", len(c.conns))
 	return nil
 }
 
-// updates the postition of all clients
-func (c *Client) NewPos(position chess.Position) error {
-	c.posId++
-	o := common.NewPos{
-		Type:     "new_pos",
-		Position: position.String(),
-		PosId:    c.posId,
+// names of the connected servers, for logging while the client is held
+func (c *Client) names() []string {
+	var names []string
+	for _, i := range c.live() {
+		names = append(names, c.conns[i].name)
 	}
+	return names
+}
 
-	// marshall the json
-	data, err := json.Marshal(o)
+// NewGame resets the workers' engines with the given options, which are
+// also sent to workers that join later. Positions come with each Search.
+func (c *Client) NewGame(ctx context.Context, options []uci.CmdSetOption) error {
+	err := c.acquire(ctx)
 	if err != nil {
 		return err
 	}
+	defer c.release()
 
-	// send the messages and expect a readyok
-	err = c.sendAll(data)
-	if err != nil {
-		return err
+	// add options to the message
+	var opts []string
+	for _, option := range options {
+		opts = append(opts, fmt.Sprintf("%s %s", option.Name, option.Value))
 	}
+	c.options = opts
+	c.position = chess.StartingPosition().String()
+	c.inGame = true
 
-	return nil
-}
-
-// Main function that handles server operations
-// Parses the current position, and returns the best move
-func (c *Client) Run() (common.Results, error) {
-	// build generic message
-	// calculate duetime because it is the same for all servers
-	dueTime := time.Now().Add(c.TurnTime - c.latencyBuff)
-	base := common.ParseMoves{
-		Type:     "parse_moves",
-		Position: c.Game.FEN(),
+	o := common.NewGame{
+		Type:     common.TypeNewGame,
+		Position: c.position,
+		Options:  opts,
 		PosId:    c.posId,
-		DueTime:  dueTime,
 	}
+	// Send to all clients and get their responses one at a time
+	return c.sendAll(ctx, &o)
+}
 
-	// create an array of messages for all servers
-	var messages []common.ParseMoves
-	for i := 0; i < c.numServers; i++ {
-		base.JobId = c.jobId + i
-		messages = append(messages, base)
+// sends one message, reconnecting once on failure. A server that still
+// can't be sent to is dropped.
+func (c *Client) send(ctx context.Context, serverNum int, m common.Message) error {
+	c.conns[serverNum].conn.SetWriteDeadline(deadline(ctx, common.Wait))
+	err := c.conns[serverNum].conn.Send(m)
+	var decodeErr *common.DecodeError
+	if err == nil || errors.As(err, &decodeErr) {
+		return err
 	}
-
-	// Get the list of possible moves
-	moves := c.Game.ValidMoves()
-	assignments := len(moves)
-	// Assign the moves to the servers
-	for i, move := range moves {
-		messages[i%c.numServers].Moves = append(messages[i%c.numServers].Moves, move.String())
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-
-	// Iterate over servers and build + send their messages while splitting up moves and incrementing jobid's
-	// figure out how many messages there actually are
-	if assignments > c.numServers {
-		assignments = c.numServers
+	c.logger.Warn("unable to send data to server, reconnecting", common.LogWorker, c.conns[serverNum].name, common.LogPosId, c.posId, common.LogErr, err)
+	err = c.reconnect(ctx, serverNum)
+	if err == nil {
+		c.conns[serverNum].conn.SetWriteDeadline(deadline(ctx, common.Wait))
+		err = c.conns[serverNum].conn.Send(m)
 	}
-	for i, server := range c.conns {
-		// break if the index is greater or equal to assignments
-		if i >= assignments {
-			break
-		}
-		// marshall and send the data
-		data, err := json.Marshal(messages[i])
-		if err != nil {
-			log.Fatal("Unable to encode parse_moves json", err)
-		}
-
-		_, err = server.conn.Write(data)
-		if err != nil {
-			err = c.Connect(i)
-			if err != nil {
-				return common.Results{}, err
-			}
-		}
+	if err != nil {
+		c.drop(serverNum)
 	}
+	return err
+}
 
-	var results []common.Results
-	var result common.Results
-	// listen for results message, combine them into some sort of datastructure and pick based off of score/mate
-	// sleep until the deadline
-	time.Sleep(time.Until(dueTime.Add(c.latencyBuff)))
-	// Now perform all read results with very short blocking
-	buf := make([]byte, common.BufSize)
+// Sends the same message to all connected servers, expects ReadyOk.
+// Servers that stop responding are dropped, it fails if none are left.
+func (c *Client) sendAll(ctx context.Context, m common.Message) error {
+	for _, i := range c.live() {
+		for attempt := 0; attempt < 2; attempt++ {
+			err := c.send(ctx, i, m)
+			var decodeErr *common.DecodeError
+			if errors.As(err, &decodeErr) || ctx.Err() != nil {
+				return err
+			} else if err != nil {
+				break
+			}
 
-	for i, server := range c.conns {
-		if i > assignments {
+			// Now get readyok, bad frames get a return
+			response, err := c.waitReady(ctx, c.conns[i])
+			if errors.As(err, &decodeErr) || ctx.Err() != nil {
+				return err
+			} else if errors.Is(err, errLeaving) {
+				c.logger.Info("worker left", common.LogWorker, c.conns[i].name, common.LogErr, err)
+				c.drop(i)
+				break
+			} else if err != nil {
+				c.logger.Warn("unable to receive ready_ok from server", common.LogWorker, c.conns[i].name, common.LogPosId, c.posId, common.LogErr, err)
+				c.drop(i)
+				if attempt == 0 && c.reconnect(ctx, i) == nil {
+					continue
+				}
+				break
+			}
+			// server error gets return
+			if m, ok := response.(*common.Error); ok {
+				return &newError{Code: 1, Message: m.Reason}
+			}
 			break
 		}
+	}
+	if len(c.live()) == 0 {
+		return &newError{Code: 2, Message: "no workers left"}
+	}
+	return nil
+}
 
-		server.conn.SetReadDeadline(time.Now().Add(100 * time.Microsecond))
-		// ignore errors, just skip
-		n, err := server.conn.Read(buf)
+// reads until the ready_ok for the current position or an error, skipping late results.
+// A worker gets common.Wait to answer.
+func (c *Client) waitReady(ctx context.Context, server server) (common.Message, error) {
+	until := deadline(ctx, common.Wait)
+	for {
+		response, err := recv(ctx, server.conn, until)
 		if err != nil {
-			log.Println(err)
-		}
-		if n != 0 {
-			json.Unmarshal(buf[:n], &result)
-			results = append(results, result)
+			return nil, err
 		}
-		server.conn.SetReadDeadline(time.Time{})
-	}
-
-	// ouput results struct to handle testing
-	// Apply the chosen move to the game
-	// if results are empty
-	if len(results) == 0 {
-		// Choose a random move
-		move := moves[rand.Intn(len(moves))]
-		log.Println("No input from servers, choosing random move")
-		c.Game.Move(move)
-		return common.Results{}, nil
-	}
-	output := results[0]
-	// loop through results
-	for _, result := range results {
-		// update nodes visited
-		output.Nodes += result.Nodes
-
-		// select best_move
-		if output.Score < result.Score {
-			output.BestMove = result.BestMove
-			output.Score = result.Score
-			output.Mate = result.Mate
+		switch m := response.(type) {
+		case *common.Error:
+			return m, nil
+		case *common.Leaving:
+			return nil, fmt.Errorf("%w: %s", errLeaving, m.Reason)
+		case *common.ReadyOk:
+			if m.PosId == c.posId {
+				return m, nil
+			}
 		}
+		c.logger.Debug("skipping message", common.LogWorker, server.name, common.LogType, response.MessageType())
 	}
-
-	// apply the move
-	c.Game.MoveStr(output.BestMove)
-	// Update position at all of the servers
-	return output, nil
 }
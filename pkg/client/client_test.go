@@ -0,0 +1,208 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+// starts a cluster and a new game on it
+func start(t *testing.T, opts clustertest.Options) *clustertest.Cluster {
+	t.Helper()
+	c := clustertest.Start(t, opts)
+	err := c.Client.NewGame(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSearchPicksBestScore(t *testing.T) {
+	script := fakeengine.Script{
+		Moves:  []string{"d2d4", "e2e4"},
+		Scores: map[string]int{"d2d4": 80, "e2e4": 50},
+		Nodes:  100,
+	}
+	c := start(t, clustertest.Options{Workers: 3, Script: script})
+
+	result, err := c.Client.Search(context.Background(), chess.StartingPosition(), nil, client.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Move.String() != "d2d4" || result.Score != 80 || result.Source != client.SourceWorker {
+		t.Fatalf("want d2d4 at 80 from a worker, got %s at %d from %s", result.Move, result.Score, result.Source)
+	}
+	if len(result.PV) == 0 || result.PV[0].String() != "d2d4" {
+		t.Fatalf("want the PV to start with d2d4, got %v", result.PV)
+	}
+	// every worker searched some of the twenty moves
+	if result.Nodes != 300 {
+		t.Fatalf("want nodes summed over 3 workers, got %d", result.Nodes)
+	}
+}
+
+func TestSearchPrefersMate(t *testing.T) {
+	c := start(t, clustertest.Options{Workers: 3, Scripts: []fakeengine.Script{
+		{Mate: 3},
+		{Score: 80},
+		{Mate: -2},
+	}})
+
+	result, err := c.Client.Search(context.Background(), chess.StartingPosition(), nil, client.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Mate != 3 || result.Worker != c.Names[0] {
+		t.Fatalf("want the mate in 3 from %s, got mate %d score %d from %s", c.Names[0], result.Mate, result.Score, result.Worker)
+	}
+}
+
+func TestCentipawns(t *testing.T) {
+	// best first
+	ranked := []client.SearchResult{{Mate: 1}, {Mate: 3}, {Score: 80}, {Score: -500}, {Mate: -5}, {Mate: -1}}
+	for i := 1; i < len(ranked); i++ {
+		if ranked[i-1].Centipawns() <= ranked[i].Centipawns() {
+			t.Fatalf("want %+v ranked above %+v", ranked[i-1], ranked[i])
+		}
+	}
+}
+
+func TestSearchRestrictedMoves(t *testing.T) {
+	c := start(t, clustertest.Options{Script: fakeengine.Script{Moves: []string{"e2e4"}}})
+
+	pos := chess.StartingPosition()
+	var only []*chess.Move
+	for _, m := range pos.ValidMoves() {
+		if m.String() == "g1f3" || m.String() == "b1c3" {
+			only = append(only, m)
+		}
+	}
+	result, err := c.Client.Search(context.Background(), pos, nil, client.Limits{Moves: only})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Move.String() != "g1f3" && result.Move.String() != "b1c3" {
+		t.Fatalf("searched outside the allowed moves, got %s", result.Move)
+	}
+}
+
+func TestSearchRandomWhenWorkersAreSlow(t *testing.T) {
+	c := start(t, clustertest.Options{Script: fakeengine.Script{Delay: time.Minute}})
+
+	result, err := c.Client.Search(context.Background(), chess.StartingPosition(), nil, client.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Source != client.SourceRandom {
+		t.Fatalf("want a random move, got %s from %s", result.Move, result.Source)
+	}
+}
+
+func TestSearchDeadline(t *testing.T) {
+	c := start(t, clustertest.Options{Script: fakeengine.Script{Delay: time.Minute}, TurnTime: 2 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	result, err := c.Client.Search(ctx, chess.StartingPosition(), nil, client.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the context's deadline wins over the turn time
+	if took := time.Since(begin); took > time.Second {
+		t.Fatalf("search ignored the deadline, took %s", took)
+	}
+	if result.Move == nil {
+		t.Fatal("no move returned")
+	}
+}
+
+func TestSearchCancel(t *testing.T) {
+	c := start(t, clustertest.Options{Script: fakeengine.Script{Delay: time.Minute}, TurnTime: 2 * time.Second})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := c.Client.Search(ctx, chess.StartingPosition(), nil, client.Limits{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+
+	// the client is still usable afterwards
+	_, err = c.Client.Search(context.Background(), chess.StartingPosition(), nil, client.Limits{MoveTime: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchNotEnoughTime(t *testing.T) {
+	c := start(t, clustertest.Options{})
+
+	_, err := c.Client.Search(context.Background(), chess.StartingPosition(), nil, client.Limits{MoveTime: 10 * time.Millisecond})
+	if err == nil {
+		t.Fatal("want an error for a turn shorter than the latency buffer")
+	}
+}
+
+func TestWorkerLeavesMidGame(t *testing.T) {
+	c := start(t, clustertest.Options{Workers: 3})
+
+	c.Worker("clustertest-01").Drain()
+	game := chess.NewGame()
+	for i := 0; i < 2; i++ {
+		result, err := c.Client.Search(context.Background(), game.Position(), game.Positions()[:len(game.Positions())-1], client.Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		game.Move(result.Move)
+	}
+	for _, name := range c.Client.Workers() {
+		if name == "clustertest-01" {
+			t.Fatalf("drained worker still in use: %v", c.Client.Workers())
+		}
+	}
+	if len(c.Client.Workers()) != 2 {
+		t.Fatalf("want the other two workers, got %v", c.Client.Workers())
+	}
+}
+
+// both sides are played by the cluster, white gets scholar's mate while
+// black shuffles its a pawn
+func TestFullGame(t *testing.T) {
+	moves := []string{"e2e4", "f1c4", "d1h5", "h5f7", "a7a6", "a6a5", "a5a4"}
+	scores := map[string]int{}
+	for _, m := range moves {
+		scores[m] = 100
+	}
+	c := start(t, clustertest.Options{Workers: 3, Script: fakeengine.Script{Moves: moves, Scores: scores}})
+
+	game := chess.NewGame()
+	for game.Outcome() == chess.NoOutcome {
+		if len(game.Moves()) > len(moves) {
+			t.Fatalf("game went off script: %s", game)
+		}
+		positions := game.Positions()
+		result, err := c.Client.Search(context.Background(), game.Position(), positions[:len(positions)-1], client.Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Source != client.SourceWorker {
+			t.Fatalf("move %d was random", len(game.Moves())+1)
+		}
+		err = game.Move(result.Move)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if game.Outcome() != chess.WhiteWon || game.Method() != chess.Checkmate {
+		t.Fatalf("want white to win by checkmate, got %s by %s", game.Outcome(), game.Method())
+	}
+	if len(game.Moves()) != 7 {
+		t.Fatalf("want a seven ply game, got %s", game)
+	}
+}
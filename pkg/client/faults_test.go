@@ -0,0 +1,94 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/faultnet"
+)
+
+// searches take half of a 200ms turn, so a game runs through a fault's window
+var paced = fakeengine.Script{Delay: 100 * time.Millisecond}
+
+// plays plies moves, failing unless every move is legal and on time
+func playOnTime(t *testing.T, c *clustertest.Cluster, plies int, turnTime time.Duration) (sources map[string]int) {
+	t.Helper()
+	sources = map[string]int{}
+	game := chess.NewGame()
+	for i := 0; i < plies && game.Outcome() == chess.NoOutcome; i++ {
+		positions := game.Positions()
+		begin := time.Now()
+		result, err := c.Client.Search(context.Background(), game.Position(), positions[:len(positions)-1], client.Limits{})
+		if err != nil {
+			t.Fatalf("ply %d: %v", i+1, err)
+		}
+		// a little slack for scheduling on a busy machine
+		if took := time.Since(begin); took > turnTime+100*time.Millisecond {
+			t.Fatalf("ply %d took %s with a %s turn", i+1, took, turnTime)
+		}
+		err = game.Move(result.Move)
+		if err != nil {
+			t.Fatalf("ply %d: illegal move %s: %v", i+1, result.Move, err)
+		}
+		sources[result.Source]++
+	}
+	return sources
+}
+
+func TestSearchUnderFaults(t *testing.T) {
+	const turnTime = 300 * time.Millisecond
+	faults := &faultnet.Scenario{Seed: 42, Rules: []faultnet.Rule{
+		// results from 00 always miss the turn they were meant for
+		{Worker: "clustertest-00", Direction: faultnet.Recv, Latency: 400 * time.Millisecond},
+		// half of the jobs sent to 01 vanish
+		{Worker: "clustertest-01", Direction: faultnet.Send, Drop: 0.5},
+		// 02 is slow and jittery but in time
+		{Worker: "clustertest-02", Latency: 30 * time.Millisecond, Jitter: 40 * time.Millisecond, Bandwidth: 64 * 1024},
+	}}
+	c := start(t, clustertest.Options{Workers: 3, TurnTime: turnTime, Faults: faults})
+
+	sources := playOnTime(t, c, 12, turnTime)
+	if sources[client.SourceWorker] == 0 {
+		t.Fatalf("no move came from a worker: %v", sources)
+	}
+	stats := faults.Stats()
+	if stats.Delayed == 0 || stats.Dropped == 0 {
+		t.Fatalf("faults weren't injected: %+v", stats)
+	}
+}
+
+func TestSearchThroughPartition(t *testing.T) {
+	const turnTime = 200 * time.Millisecond
+	faults := &faultnet.Scenario{Rules: []faultnet.Rule{
+		{From: 300 * time.Millisecond, Until: 900 * time.Millisecond, Partition: true},
+	}}
+	c := start(t, clustertest.Options{Workers: 2, TurnTime: turnTime, Script: paced, Faults: faults})
+	faults.Start()
+
+	sources := playOnTime(t, c, 8, turnTime)
+	if sources[client.SourceRandom] == 0 {
+		t.Fatalf("every move came from a worker during the partition: %v", sources)
+	}
+}
+
+func TestSearchAfterDisconnect(t *testing.T) {
+	const turnTime = 200 * time.Millisecond
+	faults := &faultnet.Scenario{Rules: []faultnet.Rule{
+		{Worker: "clustertest-01", From: 250 * time.Millisecond, Disconnect: true},
+	}}
+	c := start(t, clustertest.Options{Workers: 2, TurnTime: turnTime, Script: paced, Faults: faults})
+	faults.Start()
+
+	sources := playOnTime(t, c, 6, turnTime)
+	if sources[client.SourceWorker] == 0 {
+		t.Fatalf("the remaining worker never answered: %v", sources)
+	}
+	if faults.Stats().Disconnected != 1 {
+		t.Fatalf("want one disconnect, got %+v", faults.Stats())
+	}
+}
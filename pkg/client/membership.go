@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// WatchCatalog polls the catalog every interval so workers can join and
+// leave mid-game. Changes are applied at the start of the next Search, new
+// workers get the current new_game options and position before any work.
+// Call the returned function to stop polling.
+func (c *Client) WatchCatalog(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			entries, err := catalog.Query(common.CatalogAddr, common.CatalogPort)
+			if err != nil {
+				c.logger.Warn("unable to poll catalog", common.LogErr, err)
+				continue
+			}
+			c.mu.Lock()
+			c.latest = entries
+			c.mu.Unlock()
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Refresh queries the catalog now and applies any membership changes
+func (c *Client) Refresh(ctx context.Context) (joined []string, left []string, err error) {
+	entries, err := catalog.QueryContext(ctx, common.CatalogAddr, common.CatalogPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = c.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.release()
+	joined, left = c.applyMembership(ctx, entries)
+	return joined, left, nil
+}
+
+// applies the newest poll from WatchCatalog, if there is one
+func (c *Client) syncMembership(ctx context.Context) (joined []string, left []string) {
+	c.mu.Lock()
+	entries := c.latest
+	c.latest = nil
+	c.mu.Unlock()
+	if entries == nil {
+		return nil, nil
+	}
+	return c.applyMembership(ctx, entries)
+}
+
+// reconciles conns with what the selector picks from entries.
+// Workers missing from the catalog are dropped, named ones stay listed so
+// they can rejoin. New workers and workers that came back (possibly on a new
+// port) are connected and brought up to the current game.
+func (c *Client) applyMembership(ctx context.Context, entries []catalog.Entry) (joined []string, left []string) {
+	wanted := map[string]target{}
+	var order []string
+	for _, t := range c.selector.resolve(entries) {
+		wanted[t.name] = t
+		order = append(order, t.name)
+	}
+
+	// drop departed workers
+	var kept []server
+	for i, s := range c.conns {
+		t, ok := wanted[s.name]
+		if !ok || t.address == "" {
+			if s.conn != nil {
+				c.drop(i)
+				left = append(left, s.name)
+			}
+			if ok {
+				kept = append(kept, c.conns[i])
+			}
+			continue
+		}
+		kept = append(kept, c.conns[i])
+	}
+	c.conns = kept
+
+	// connect new and returning workers
+	for _, name := range order {
+		t := wanted[name]
+		if t.address == "" {
+			continue
+		}
+		i := c.index(name)
+		if i < 0 {
+			c.conns = append(c.conns, server{name: t.name, address: t.address, direct: t.direct})
+			i = len(c.conns) - 1
+		} else if c.conns[i].conn != nil && c.conns[i].address == t.address {
+			continue
+		}
+		c.conns[i].address = t.address
+		err := c.join(ctx, i)
+		if err != nil {
+			c.logger.Warn("worker unable to join", common.LogWorker, name, common.LogErr, err)
+			continue
+		}
+		c.logger.Info("worker joined", common.LogWorker, name, "address", t.address)
+		joined = append(joined, name)
+	}
+	return joined, left
+}
+
+// position in conns of the named worker, -1 when absent
+func (c *Client) index(name string) int {
+	for i, s := range c.conns {
+		if s.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// connects a worker and, once a game is going, sends it the current position
+func (c *Client) join(ctx context.Context, serverNum int) error {
+	err := c.open(ctx, serverNum)
+	if err != nil {
+		return err
+	}
+	if !c.inGame {
+		return nil
+	}
+
+	o := common.NewGame{
+		Type:     common.TypeNewGame,
+		Position: c.position,
+		Options:  c.options,
+		PosId:    c.posId,
+	}
+	// a worker that never answers mustn't stall the turn
+	c.conns[serverNum].conn.SetWriteDeadline(deadline(ctx, common.Wait))
+	err = c.conns[serverNum].conn.Send(&o)
+	if err == nil {
+		var response common.Message
+		response, err = c.waitReady(ctx, c.conns[serverNum])
+		if m, ok := response.(*common.Error); ok {
+			err = &newError{Code: 1, Message: m.Reason}
+		}
+	}
+	if err != nil {
+		c.drop(serverNum)
+		return err
+	}
+	return nil
+}
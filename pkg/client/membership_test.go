@@ -0,0 +1,130 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+)
+
+// a client taking every clustertest worker in the catalog, in a game
+func watching(t *testing.T, c *clustertest.Cluster, options ...uci.CmdSetOption) *client.Client {
+	t.Helper()
+	cl := c.NewClient(client.Selector{Prefix: "clustertest"})
+	err := cl.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.NewGame(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+// searches game's position and plays the result
+func searchAndMove(t *testing.T, cl *client.Client, game *chess.Game) {
+	t.Helper()
+	positions := game.Positions()
+	result, err := cl.Search(context.Background(), game.Position(), positions[:len(positions)-1], client.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = game.Move(result.Move)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkerJoinsMidGame(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, NoClient: true})
+	cl := watching(t, c, uci.CmdSetOption{Name: "Threads", Value: "2"})
+	game := chess.NewGame()
+	searchAndMove(t, cl, game)
+	joinedAt := game.Positions()[0].String()
+
+	var buf bytes.Buffer
+	rec := recording.NewRecorder(&buf, recording.RoleWorker, "clustertest-01")
+	c.AddWorker("clustertest-01", fakeengine.Script{}).SetRecorder(rec)
+	joined, left, err := cl.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(joined) != 1 || joined[0] != "clustertest-01" || len(left) != 0 {
+		t.Fatalf("want clustertest-01 to join, got joined %v left %v", joined, left)
+	}
+	searchAndMove(t, cl, game)
+	cl.Shutdown()
+	rec.Close()
+
+	r, err := recording.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := r.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("want one session on the new worker, got %d", len(conns))
+	}
+	// the client's stop ends the session, before that the worker sees the game
+	// it joined and then its share of the next turn
+	var received []common.Message
+	for _, e := range conns[0].Events {
+		if !r.FromClient(e) || e.Type() == common.TypeHello || e.Type() == common.TypeStop {
+			continue
+		}
+		m, err := e.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, m)
+	}
+	if len(received) != 2 {
+		t.Fatalf("want new_game then parse_moves, got %+v", received)
+	}
+	newGame, ok := received[0].(*common.NewGame)
+	if !ok || newGame.Position != joinedAt || newGame.PosId != 1 || len(newGame.Options) != 1 || newGame.Options[0] != "Threads 2" {
+		t.Fatalf("want new_game with Threads 2 at pos_id 1 in %s, got %+v", joinedAt, received[0])
+	}
+	job, ok := received[1].(*common.ParseMoves)
+	if !ok || job.PosId != 2 || job.Position != game.Positions()[1].String() || len(job.Moves) == 0 {
+		t.Fatalf("want moves to search at pos_id 2, got %+v", received[1])
+	}
+	if threads := c.Engine("clustertest-01").Values()["Threads"]; threads != "2" {
+		t.Fatalf("want the joining worker's engine at Threads 2, got %q", threads)
+	}
+}
+
+func TestWorkerLeavesCatalog(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 3, NoClient: true})
+	cl := watching(t, c)
+	game := chess.NewGame()
+	searchAndMove(t, cl, game)
+
+	c.Catalog.Remove("clustertest-01")
+	joined, left, err := cl.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(joined) != 0 || len(left) != 1 || left[0] != "clustertest-01" {
+		t.Fatalf("want clustertest-01 to leave, got joined %v left %v", joined, left)
+	}
+	jobs := c.Worker("clustertest-01").Status().Jobs
+	for i := 0; i < 2; i++ {
+		searchAndMove(t, cl, game)
+	}
+	if got := c.Worker("clustertest-01").Status().Jobs; got != jobs {
+		t.Fatalf("want no jobs for a worker gone from the catalog, it ran %d more", got-jobs)
+	}
+	for _, name := range cl.Workers() {
+		if name == "clustertest-01" {
+			t.Fatalf("departed worker still in use: %v", cl.Workers())
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/trace"
+)
+
+// Limits bounds a single search
+type Limits struct {
+	MoveTime time.Duration // time for the whole turn, 0 for the client's turn time
+	Moves    []*chess.Move // only consider these moves, nil for every valid move
+}
+
+// SearchResult is the move picked for a position and where it came from
+type SearchResult struct {
+	Move   *chess.Move // always one of the position's valid moves
+	Source string      // SourceWorker or SourceRandom
+	Worker string      // worker whose result was picked
+	Score  int
+	Mate   int
+	Depth  int
+	Nodes  int           // summed over every worker that answered
+	PV     []*chess.Move // the chosen worker's principal variation from position, may be cut short or empty
+}
+
+// MateScore stands in for a mate in Centipawns, beyond any centipawn score
+const MateScore = 100000
+
+// Centipawns is the result's score from the searching side with mates
+// ranked above every centipawn score, a shorter mate higher
+func (r SearchResult) Centipawns() int {
+	return centipawns(r.Score, r.Mate)
+}
+
+func centipawns(score int, mate int) int {
+	switch {
+	case mate > 0:
+		return MateScore - mate
+	case mate < 0:
+		return -MateScore - mate
+	}
+	return score
+}
+
+// Search splits the moves of position between the workers and returns the
+// best result that arrives in time. history holds the positions before it,
+// oldest first, so the engines can see repetitions. It may be nil.
+//
+// The turn lasts limits.MoveTime, or less when ctx has an earlier deadline,
+// and results that arrive before it ends are used. With no results a random
+// move is picked. Cancelling ctx abandons the search and returns its error.
+func (c *Client) Search(ctx context.Context, position *chess.Position, history []*chess.Position, limits Limits) (SearchResult, error) {
+	// positions cache their moves on first use, so they're only looked at one search at a time
+	err := c.acquire(ctx)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	defer c.release()
+
+	moves := limits.Moves
+	if moves == nil {
+		moves = position.ValidMoves()
+	}
+	if len(moves) == 0 {
+		return SearchResult{}, errors.New("no moves to search")
+	}
+	start, played, err := replay(position, history)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	moveTime := limits.MoveTime
+	if moveTime == 0 {
+		moveTime = c.turnTime
+	}
+
+	// workers that joined or left since the last turn
+	joined, left := c.syncMembership(ctx)
+	if len(c.live()) == 0 {
+		return SearchResult{}, &newError{Code: 2, Message: "no workers left"}
+	}
+
+	// calculate duetime because it is the same for all servers
+	begin := time.Now()
+	end := deadline(ctx, moveTime)
+	dueTime := end.Add(-c.latencyBuff)
+	if !dueTime.After(begin) {
+		return SearchResult{}, fmt.Errorf("%s is not enough time to search with a %s latency buffer", end.Sub(begin), c.latencyBuff)
+	}
+	// every search is a new position to the workers
+	c.posId++
+	c.position = position.String()
+
+	turn := c.tracer.Start("turn", trace.Context{})
+	turn.SetAttr("turn", c.turn)
+	turn.SetAttr("pos_id", c.posId)
+	defer turn.End()
+	partition := c.tracer.Start("partition", turn.Context())
+	base := common.ParseMoves{
+		Type:     common.TypeParseMoves,
+		Position: c.position,
+		PosId:    c.posId,
+		DueTime:  dueTime,
+	}
+	if len(played) > 0 {
+		base.Start = start.String()
+		base.History = played
+	}
+
+	// create an array of messages for all connected servers
+	live := c.live()
+	var messages []common.ParseMoves
+	for i := range live {
+		base.JobId = c.jobId + i
+		messages = append(messages, base)
+	}
+	// job ids are unique per turn so late results can be told apart
+	c.jobId += len(live)
+
+	// Assign the moves to the servers
+	for i, move := range moves {
+		messages[i%len(live)].Moves = append(messages[i%len(live)].Moves, move.String())
+	}
+
+	// figure out how many messages there actually are
+	assignments := len(moves)
+	if assignments > len(live) {
+		assignments = len(live)
+	}
+	partition.SetAttr("moves", len(moves))
+	partition.SetAttr("workers", assignments)
+	partition.End()
+	sent := make([]time.Time, assignments)
+	failed := make([]error, assignments)
+	for i := 0; i < assignments; i++ {
+		server := c.conns[live[i]]
+		// workers hang their spans off the send span
+		send := c.tracer.Start("send", turn.Context())
+		send.SetAttr("worker", server.name)
+		send.SetAttr("job_id", messages[i].JobId)
+		messages[i].TraceId = send.Context().TraceId
+		messages[i].SpanId = send.Context().SpanId
+		// encode and send the data, a worker that's gone just misses this turn
+		err := c.send(ctx, live[i], &messages[i])
+		sent[i] = time.Now()
+		send.EndAt(sent[i])
+		var decodeErr *common.DecodeError
+		if errors.As(err, &decodeErr) {
+			return SearchResult{}, err
+		} else if errors.Is(err, context.Canceled) {
+			return SearchResult{}, err
+		}
+		failed[i] = err
+	}
+
+	// every assigned server is read concurrently until the turn ends so arrival times can be recorded
+	report := TurnReport{
+		Turn:     c.turn,
+		PosId:    c.posId,
+		Position: base.Position,
+		Start:    begin,
+		DueTime:  dueTime,
+		Workers:  make([]WorkerReport, assignments),
+		Joined:   joined,
+		Left:     left,
+	}
+	c.turn++
+	// a little slack past the turn, unless the turn ends at the caller's deadline
+	readDeadline := end
+	if ctxDeadline, ok := ctx.Deadline(); !ok || end.Before(ctxDeadline) {
+		readDeadline = end.Add(100 * time.Microsecond)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < assignments; i++ {
+		report.Workers[i] = WorkerReport{Name: c.conns[live[i]].name, JobId: messages[i].JobId, Moves: messages[i].Moves}
+		if failed[i] != nil {
+			report.Workers[i].Error = failed[i].Error()
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.collect(ctx, c.conns[live[i]], &report.Workers[i], readDeadline, dueTime)
+		}(i)
+	}
+	wg.Wait()
+	// running out of time still uses what arrived, cancelling doesn't
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return SearchResult{}, ctx.Err()
+	}
+	c.traceResults(turn.Context(), report.Workers, sent)
+
+	// workers that announced they are leaving get no more work
+	for i, w := range report.Workers {
+		if w.leaving {
+			c.drop(live[i])
+			report.Left = append(report.Left, w.Name)
+		}
+	}
+
+	aggregate := c.tracer.Start("aggregate", turn.Context())
+	defer aggregate.End()
+	var result SearchResult
+	chosen := -1
+	for i, w := range report.Workers {
+		if !w.Received {
+			report.Missing = append(report.Missing, w.Name)
+			continue
+		}
+		// update nodes visited
+		result.Nodes += w.Nodes
+		move := find(moves, w.BestMove)
+		if move == nil {
+			c.logger.Warn("worker returned a move it wasn't given", common.LogWorker, w.Name, common.LogJobId, w.JobId, "move", w.BestMove)
+			continue
+		}
+		// select best_move
+		if chosen < 0 || result.Centipawns() < centipawns(w.Score, w.Mate) {
+			result.Move = move
+			result.Score = w.Score
+			result.Mate = w.Mate
+			result.Depth = w.Depth
+			chosen = i
+		}
+	}
+	report.TotalNodes = result.Nodes
+
+	if chosen < 0 {
+		// Choose a random move
+		result.Move = moves[rand.Intn(len(moves))]
+		result.Source = SourceRandom
+		c.logger.Warn("no input from servers, choosing random move", common.LogPosId, c.posId, "move", result.Move.String())
+		report.Chosen = ChosenMove{Move: result.Move.String(), Source: SourceRandom}
+	} else {
+		result.Source = SourceWorker
+		result.Worker = report.Workers[chosen].Name
+		result.PV = variation(position, report.Workers[chosen].result.PV)
+		report.Chosen = ChosenMove{
+			Move:   result.Move.String(),
+			Source: SourceWorker,
+			Worker: result.Worker,
+			JobId:  report.Workers[chosen].JobId,
+			Score:  result.Score,
+			Mate:   result.Mate,
+			Depth:  result.Depth,
+		}
+		aggregate.SetAttr("move", result.Move.String())
+	}
+	aggregate.SetAttr("source", result.Source)
+	aggregate.End()
+	c.notify(report)
+	return result, nil
+}
+
+// the move in moves written as s in UCI notation, nil if there is none
+func find(moves []*chess.Move, s string) *chess.Move {
+	for _, move := range moves {
+		if move.String() == s {
+			return move
+		}
+	}
+	return nil
+}
+
+// the moves of pv played out from position, up to the first that isn't legal
+func variation(position *chess.Position, pv []string) []*chess.Move {
+	var moves []*chess.Move
+	for _, s := range pv {
+		move := find(position.ValidMoves(), s)
+		if move == nil {
+			break
+		}
+		moves = append(moves, move)
+		position = position.Update(move)
+	}
+	return moves
+}
+
+// works out the moves that lead through history to position, returning the
+// first position and the moves in UCI notation
+func replay(position *chess.Position, history []*chess.Position) (*chess.Position, []string, error) {
+	// callers may pass the positions of a game including the current one
+	if n := len(history); n > 0 && history[n-1].Hash() == position.Hash() {
+		history = history[:n-1]
+	}
+	if len(history) == 0 {
+		return position, nil, nil
+	}
+	var played []string
+	positions := append(append([]*chess.Position(nil), history...), position)
+	for i := 1; i < len(positions); i++ {
+		prev, next := positions[i-1], positions[i]
+		var found *chess.Move
+		for _, move := range prev.ValidMoves() {
+			if prev.Update(move).Hash() == next.Hash() {
+				found = move
+				break
+			}
+		}
+		if found == nil {
+			return nil, nil, fmt.Errorf("history position %d doesn't lead to the next one", i-1)
+		}
+		played = append(played, found.String())
+	}
+	return history[0], played, nil
+}
+
+// reads from one server until its results show up or the deadline passes, skipping stale frames
+func (c *Client) collect(ctx context.Context, server server, w *WorkerReport, readDeadline time.Time, dueTime time.Time) {
+	for {
+		response, err := recv(ctx, server.conn, readDeadline)
+		var decodeErr *common.DecodeError
+		if errors.As(err, &decodeErr) {
+			c.logger.Warn("unable to decode response", common.LogWorker, server.name, common.LogJobId, w.JobId, common.LogErr, err)
+			continue
+		} else if err != nil {
+			// ignore errors, just skip
+			c.logger.Info("no results from server", common.LogWorker, server.name, common.LogJobId, w.JobId, common.LogErr, err)
+			w.Error = err.Error()
+			return
+		}
+		switch m := response.(type) {
+		case *common.Results:
+			if m.JobId == w.JobId {
+				w.Received = true
+				w.arrived = time.Now()
+				w.Arrival = w.arrived.Sub(dueTime)
+				w.BestMove = m.BestMove
+				w.Score = m.Score
+				w.Mate = m.Mate
+				w.Depth = m.Depth
+				w.Nodes = m.Nodes
+				w.result = *m
+				return
+			}
+		case *common.Error:
+			w.Error = m.Reason
+		case *common.Leaving:
+			w.Error = fmt.Sprintf("%s: %s", errLeaving, m.Reason)
+			w.leaving = true
+			return
+		}
+		c.logger.Debug("skipping message", common.LogWorker, server.name, common.LogJobId, w.JobId, common.LogType, response.MessageType())
+	}
+}
+
+// exports the workers' spans and a result_return span for each result that arrived,
+// running from the end of the worker's job to arrival, or from the send when the
+// worker's clock puts its job end outside that window
+func (c *Client) traceResults(turn trace.Context, workers []WorkerReport, sent []time.Time) {
+	if c.tracer == nil {
+		return
+	}
+	for i, w := range workers {
+		if !w.Received {
+			continue
+		}
+		c.tracer.Import(w.result.Spans)
+		start := sent[i]
+		// the worker's job span is always first
+		if len(w.result.Spans) > 0 {
+			end := w.result.Spans[0].End
+			if end.After(start) && end.Before(w.arrived) {
+				start = end
+			}
+		}
+		ret := c.tracer.StartAt("result_return", turn, start)
+		ret.SetAttr("worker", w.Name)
+		ret.SetAttr("job_id", w.JobId)
+		ret.EndAt(w.arrived)
+	}
+}
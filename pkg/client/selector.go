@@ -0,0 +1,191 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+)
+
+// Ways to order catalog workers before Max is applied
+const (
+	RankName     = "name"     // alphabetical, the default
+	RankLoad     = "load"     // least loaded first, idle before busy
+	RankCapacity = "capacity" // most advertised capacity first
+	RankThreads  = "threads"  // most engine threads first
+	RankHash     = "hash"     // largest hash table first
+)
+
+// Selector decides which workers a client uses.
+// Explicit Workers are used as given, otherwise every catalog worker that
+// matches the filters is a candidate, ordered by Rank.
+type Selector struct {
+	Workers     []string          // catalog names, or host:port addresses dialed directly
+	Prefix      string            // catalog workers whose name starts with this
+	Labels      map[string]string // catalog workers must carry all of these labels
+	MinCapacity int               // catalog workers must offer at least this many threads
+	Engine      string            // engine name must contain this, case insensitive
+	Net         string            // NNUE file name must contain this
+	MinThreads  int
+	MinHash     int     // MB
+	Protocol    int     // exact protocol version, 0 for any
+	MaxLoad     float64 // highest load per CPU, 0 for no limit
+	Rank        string  // one of the Rank constants, empty for RankName
+	Max         int     // use at most this many workers, 0 for no limit
+}
+
+// Ranks lists the accepted Rank values
+func Ranks() []string {
+	return []string{RankName, RankLoad, RankCapacity, RankThreads, RankHash}
+}
+
+// NamedWorkers selects <base>-00 to <base>-<n-1>, the original naming scheme
+func NamedWorkers(base string, n int) Selector {
+	var s Selector
+	for i := 0; i < n; i++ {
+		s.Workers = append(s.Workers, fmt.Sprintf("%s-%02d", base, i))
+	}
+	return s
+}
+
+// a worker picked by the selector, address is empty until resolved through the catalog
+type target struct {
+	name    string
+	address string
+	direct  bool // address came from the selector, not the catalog
+}
+
+// host:port entries are dialed directly, anything else is a catalog name
+func isAddress(worker string) bool {
+	_, _, err := net.SplitHostPort(worker)
+	return err == nil
+}
+
+// needsCatalog reports whether resolving requires a catalog query
+func (s Selector) needsCatalog() bool {
+	if len(s.Workers) == 0 {
+		return true
+	}
+	for _, w := range s.Workers {
+		if !isAddress(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve turns the selector into targets using the catalog entries.
+// Named workers missing from the catalog or draining are kept with no
+// address so they can be reported, filtered catalog workers only include
+// matches.
+func (s Selector) resolve(entries []catalog.Entry) []target {
+	var targets []target
+	if len(s.Workers) > 0 {
+		for _, w := range s.Workers {
+			if isAddress(w) {
+				targets = append(targets, target{name: w, address: w, direct: true})
+				continue
+			}
+			// draining workers are left out like missing ones
+			t := target{name: w}
+			if e, ok := catalog.Find(entries, w); ok && !e.Draining {
+				t.address = e.HostPort()
+			}
+			targets = append(targets, t)
+		}
+	} else {
+		var matched []catalog.Entry
+		for _, e := range catalog.Workers(entries, s.Prefix) {
+			if s.matches(e) {
+				matched = append(matched, e)
+			}
+		}
+		s.rank(matched)
+		for _, e := range matched {
+			targets = append(targets, target{name: e.Project, address: e.HostPort()})
+		}
+	}
+	if s.Max > 0 && len(targets) > s.Max {
+		targets = targets[:s.Max]
+	}
+	return targets
+}
+
+// matches applies the filters to a catalog entry
+func (s Selector) matches(e catalog.Entry) bool {
+	switch {
+	case e.Draining:
+		return false
+	case !e.HasLabels(s.Labels):
+		return false
+	case e.Capacity < s.MinCapacity, e.Threads < s.MinThreads, e.Hash < s.MinHash:
+		return false
+	case s.Engine != "" && !strings.Contains(strings.ToLower(e.Engine), strings.ToLower(s.Engine)):
+		return false
+	case s.Net != "" && !strings.Contains(e.Net, s.Net):
+		return false
+	case s.Protocol != 0 && e.Protocol != s.Protocol:
+		return false
+	case s.MaxLoad > 0 && e.Load > s.MaxLoad:
+		return false
+	}
+	return true
+}
+
+// rank orders entries already sorted by name, ties keep name order
+func (s Selector) rank(entries []catalog.Entry) {
+	var less func(a, b catalog.Entry) bool
+	switch s.Rank {
+	case RankLoad:
+		less = func(a, b catalog.Entry) bool {
+			if a.Busy != b.Busy {
+				return !a.Busy
+			}
+			return a.Load < b.Load
+		}
+	case RankCapacity:
+		less = func(a, b catalog.Entry) bool { return a.Capacity > b.Capacity }
+	case RankThreads:
+		less = func(a, b catalog.Entry) bool { return a.Threads > b.Threads }
+	case RankHash:
+		less = func(a, b catalog.Entry) bool { return a.Hash > b.Hash }
+	default:
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+}
+
+// String describes the selector for error messages
+func (s Selector) String() string {
+	if len(s.Workers) > 0 {
+		return strings.Join(s.Workers, ",")
+	}
+	desc := fmt.Sprintf("prefix %q", s.Prefix)
+	if len(s.Labels) > 0 {
+		desc += fmt.Sprintf(" labels %v", s.Labels)
+	}
+	if s.MinCapacity > 0 {
+		desc += fmt.Sprintf(" capacity >= %d", s.MinCapacity)
+	}
+	if s.Engine != "" {
+		desc += fmt.Sprintf(" engine %q", s.Engine)
+	}
+	if s.Net != "" {
+		desc += fmt.Sprintf(" net %q", s.Net)
+	}
+	if s.MinThreads > 0 {
+		desc += fmt.Sprintf(" threads >= %d", s.MinThreads)
+	}
+	if s.MinHash > 0 {
+		desc += fmt.Sprintf(" hash >= %d", s.MinHash)
+	}
+	if s.Protocol > 0 {
+		desc += fmt.Sprintf(" protocol %d", s.Protocol)
+	}
+	if s.MaxLoad > 0 {
+		desc += fmt.Sprintf(" load <= %g", s.MaxLoad)
+	}
+	return desc
+}
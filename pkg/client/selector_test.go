@@ -0,0 +1,182 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+)
+
+// a catalog registration for name on port
+func entry(name string, port int, set func(*catalog.Entry)) catalog.Entry {
+	e := catalog.Entry{Type: catalog.WorkerType, Project: name, Address: "10.0.0.1", Port: port, LastHeardFrom: 100}
+	if set != nil {
+		set(&e)
+	}
+	return e
+}
+
+var entries = []catalog.Entry{
+	entry("w-02", 9002, func(e *catalog.Entry) { e.Capacity = 8; e.Labels = map[string]string{"gpu": "yes", "site": "b"} }),
+	entry("w-00", 9000, func(e *catalog.Entry) { e.Capacity = 4; e.Labels = map[string]string{"site": "a"} }),
+	entry("w-01", 9001, func(e *catalog.Entry) { e.Capacity = 2; e.Labels = map[string]string{"site": "a"} }),
+	entry("other-00", 9100, nil),
+	// an older registration of w-01 on another port
+	entry("w-01", 9999, func(e *catalog.Entry) { e.LastHeardFrom = 50 }),
+	{Type: "not-a-worker", Project: "w-03", Address: "10.0.0.1", Port: 9003},
+}
+
+// the names and addresses the selector resolves to
+func resolved(s Selector) []string {
+	var got []string
+	for _, t := range s.resolve(entries) {
+		got = append(got, t.name+"@"+t.address)
+	}
+	return got
+}
+
+func TestSelectorResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector Selector
+		want     []string
+	}{
+		{"every worker", Selector{}, []string{"other-00@10.0.0.1:9100", "w-00@10.0.0.1:9000", "w-01@10.0.0.1:9001", "w-02@10.0.0.1:9002"}},
+		{"list", Selector{Workers: []string{"w-02", "w-00"}}, []string{"w-02@10.0.0.1:9002", "w-00@10.0.0.1:9000"}},
+		{"list keeps missing names", Selector{Workers: []string{"w-00", "w-03"}}, []string{"w-00@10.0.0.1:9000", "w-03@"}},
+		{"list with an address", Selector{Workers: []string{"127.0.0.1:7000", "w-01"}}, []string{"127.0.0.1:7000@127.0.0.1:7000", "w-01@10.0.0.1:9001"}},
+		{"named workers", NamedWorkers("w", 2), []string{"w-00@10.0.0.1:9000", "w-01@10.0.0.1:9001"}},
+		{"prefix", Selector{Prefix: "w-"}, []string{"w-00@10.0.0.1:9000", "w-01@10.0.0.1:9001", "w-02@10.0.0.1:9002"}},
+		{"prefix matches nothing", Selector{Prefix: "x"}, nil},
+		{"label", Selector{Labels: map[string]string{"site": "a"}}, []string{"w-00@10.0.0.1:9000", "w-01@10.0.0.1:9001"}},
+		{"every label", Selector{Labels: map[string]string{"site": "b", "gpu": "yes"}}, []string{"w-02@10.0.0.1:9002"}},
+		{"label value differs", Selector{Labels: map[string]string{"site": "c"}}, nil},
+		{"capacity", Selector{MinCapacity: 4}, []string{"w-00@10.0.0.1:9000", "w-02@10.0.0.1:9002"}},
+		{"max", Selector{Prefix: "w-", Max: 2}, []string{"w-00@10.0.0.1:9000", "w-01@10.0.0.1:9001"}},
+		{"max cuts a list", Selector{Workers: []string{"w-02", "w-01", "w-00"}, Max: 1}, []string{"w-02@10.0.0.1:9002"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolved(tt.selector); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSelectorNeedsCatalog(t *testing.T) {
+	tests := []struct {
+		selector Selector
+		want     bool
+	}{
+		{Selector{}, true},
+		{Selector{Prefix: "w-"}, true},
+		{Selector{Workers: []string{"127.0.0.1:7000", "w-00"}}, true},
+		{Selector{Workers: []string{"127.0.0.1:7000", "[::1]:7001"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.selector.needsCatalog(); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.selector, tt.want, got)
+		}
+	}
+}
+
+// workers advertising engine and host details
+var advertised = []catalog.Entry{
+	entry("a", 1, func(e *catalog.Entry) {
+		e.Engine, e.Net, e.Threads, e.Hash, e.Protocol, e.Load, e.Capacity = "Stockfish 16", "nn-5af11540bbfe.nnue", 4, 256, 2, 0.9, 4
+	}),
+	entry("b", 2, func(e *catalog.Entry) {
+		e.Engine, e.Net, e.Threads, e.Hash, e.Protocol, e.Load, e.Capacity = "Stockfish 15", "nn-ad9b42354671.nnue", 8, 64, 2, 0.2, 8
+		e.Busy = true
+	}),
+	entry("c", 3, func(e *catalog.Entry) {
+		e.Engine, e.Threads, e.Hash, e.Load, e.Capacity = "Komodo", 2, 1024, 0.5, 2
+	}),
+	entry("d", 4, func(e *catalog.Entry) {
+		e.Engine, e.Threads, e.Hash, e.Protocol, e.Load, e.Capacity = "Stockfish 16", 16, 2048, 2, 0.1, 16
+		e.Draining = true
+	}),
+}
+
+// the names of the advertised workers the selector picks, in order
+func picked(s Selector) []string {
+	var got []string
+	for _, t := range s.resolve(advertised) {
+		got = append(got, t.name)
+	}
+	return got
+}
+
+func TestSelectorFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector Selector
+		want     []string
+	}{
+		{"draining left out", Selector{}, []string{"a", "b", "c"}},
+		{"engine", Selector{Engine: "stockfish"}, []string{"a", "b"}},
+		{"engine version", Selector{Engine: "Stockfish 16"}, []string{"a"}},
+		{"net", Selector{Net: "5af11540"}, []string{"a"}},
+		{"min threads", Selector{MinThreads: 4}, []string{"a", "b"}},
+		{"min hash", Selector{MinHash: 256}, []string{"a", "c"}},
+		{"protocol", Selector{Protocol: 2}, []string{"a", "b"}},
+		{"protocol before it was advertised", Selector{Protocol: 1}, nil},
+		{"max load", Selector{MaxLoad: 0.5}, []string{"b", "c"}},
+		{"combined", Selector{Engine: "stockfish", MinThreads: 8, MaxLoad: 0.5}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := picked(tt.selector); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// a named worker that's draining is reported like a missing one
+	targets := Selector{Workers: []string{"d", "a"}}.resolve(advertised)
+	if len(targets) != 2 || targets[0].address != "" || targets[1].address == "" {
+		t.Fatalf("want d without an address and a with one, got %+v", targets)
+	}
+}
+
+func TestSelectorRank(t *testing.T) {
+	tests := []struct {
+		rank string
+		want []string
+	}{
+		{"", []string{"a", "b", "c"}},
+		{RankName, []string{"a", "b", "c"}},
+		// idle before busy, then least loaded
+		{RankLoad, []string{"c", "a", "b"}},
+		{RankCapacity, []string{"b", "a", "c"}},
+		{RankThreads, []string{"b", "a", "c"}},
+		{RankHash, []string{"c", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rank, func(t *testing.T) {
+			if got := picked(Selector{Rank: tt.rank}); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// max applies after ranking
+	if got := picked(Selector{Rank: RankHash, Max: 2}); !reflect.DeepEqual(got, []string{"c", "a"}) {
+		t.Fatalf("want the two largest hash tables, got %q", got)
+	}
+
+	// ties keep name order
+	tied := []catalog.Entry{
+		entry("y", 1, func(e *catalog.Entry) { e.Capacity = 4 }),
+		entry("z", 2, func(e *catalog.Entry) { e.Capacity = 8 }),
+		entry("x", 3, func(e *catalog.Entry) { e.Capacity = 4 }),
+	}
+	var got []string
+	for _, t := range (Selector{Rank: RankCapacity}).resolve(tied) {
+		got = append(got, t.name)
+	}
+	if !reflect.DeepEqual(got, []string{"z", "x", "y"}) {
+		t.Fatalf("want z then x and y by name, got %q", got)
+	}
+}
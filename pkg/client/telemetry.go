@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Where the chosen move came from
+const (
+	SourceWorker = "worker" // best score among the workers' results
+	SourceRandom = "random" // no results arrived in time
+)
+
+// TurnReport describes everything that happened during one call to Search
+type TurnReport struct {
+	Turn       int            `json:"turn"`
+	PosId      int            `json:"pos_id"`
+	Position   string         `json:"position"`
+	Start      time.Time      `json:"start"`
+	DueTime    time.Time      `json:"due_time"`
+	Workers    []WorkerReport `json:"workers"`
+	Missing    []string       `json:"missing,omitempty"` // workers that were assigned moves but sent nothing usable
+	Joined     []string       `json:"joined,omitempty"`  // workers added before this turn
+	Left       []string       `json:"left,omitempty"`    // workers dropped before this turn
+	TotalNodes int            `json:"total_nodes"`
+	Chosen     ChosenMove     `json:"chosen"`
+}
+
+// WorkerReport is one worker's share of a turn
+type WorkerReport struct {
+	Name     string   `json:"name"`
+	JobId    int      `json:"job_id"`
+	Moves    []string `json:"moves"` // moves assigned to the worker
+	Received bool     `json:"received"`
+	BestMove string   `json:"best_move,omitempty"`
+	Score    int      `json:"score"`
+	Mate     int      `json:"mate"`
+	Depth    int      `json:"depth"`
+	Nodes    int      `json:"nodes"`
+	// Arrival is when the results arrived relative to the due time, negative is early
+	Arrival time.Duration `json:"arrival_ns"`
+	Error   string        `json:"error,omitempty"`
+
+	result  common.Results
+	arrived time.Time
+	leaving bool
+}
+
+// ChosenMove records the provenance of the move that was played
+type ChosenMove struct {
+	Move   string `json:"move"`
+	Source string `json:"source"`
+	Worker string `json:"worker,omitempty"`
+	JobId  int    `json:"job_id"`
+	Score  int    `json:"score"`
+	Mate   int    `json:"mate"`
+	Depth  int    `json:"depth"`
+}
+
+// Observer is told about every turn once its move has been picked
+type Observer interface {
+	OnTurn(report TurnReport)
+}
+
+// ObserverFunc adapts a function to the Observer interface
+type ObserverFunc func(report TurnReport)
+
+func (f ObserverFunc) OnTurn(report TurnReport) {
+	f(report)
+}
+
+// Register an observer, observers are called in order on the goroutine running Search
+func (c *Client) AddObserver(o Observer) {
+	c.observers = append(c.observers, o)
+}
+
+func (c *Client) notify(report TurnReport) {
+	for _, o := range c.observers {
+		o.OnTurn(report)
+	}
+}
+
+// JSONLObserver writes each report as a single JSON line
+type JSONLObserver struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONLObserver(out io.Writer) *JSONLObserver {
+	return &JSONLObserver{enc: json.NewEncoder(out)}
+}
+
+func (o *JSONLObserver) OnTurn(report TurnReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err == nil {
+		o.err = o.enc.Encode(report)
+	}
+}
+
+// Err returns the first write error, reports after it are dropped
+func (o *JSONLObserver) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
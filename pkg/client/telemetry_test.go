@@ -0,0 +1,87 @@
+package client_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+func TestTurnReportSlowWorker(t *testing.T) {
+	fast := fakeengine.Script{Moves: []string{"e2e4", "d2d4"}, Scores: map[string]int{"e2e4": 90, "d2d4": 40}, Nodes: 100}
+	c := start(t, clustertest.Options{Workers: 3, Scripts: []fakeengine.Script{fast, fast, {Delay: time.Minute}}})
+	var out bytes.Buffer
+	var reports []client.TurnReport
+	c.Client.AddObserver(client.NewJSONLObserver(&out))
+	c.Client.AddObserver(client.ObserverFunc(func(r client.TurnReport) { reports = append(reports, r) }))
+
+	game := chess.NewGame()
+	for i := 0; i < 2; i++ {
+		positions := game.Positions()
+		result, err := c.Client.Search(context.Background(), game.Position(), positions[:len(positions)-1], client.Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		game.Move(result.Move)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("want a report per turn, got %d", len(reports))
+	}
+
+	report := reports[0]
+	if len(report.Missing) != 1 || report.Missing[0] != "clustertest-02" {
+		t.Fatalf("want the slow worker missing, got %v", report.Missing)
+	}
+	var best *client.WorkerReport
+	for i, w := range report.Workers {
+		if w.Name == "clustertest-02" {
+			if w.Received {
+				t.Fatalf("the slow worker answered: %+v", w)
+			}
+			continue
+		}
+		if !w.Received || w.Arrival >= 0 {
+			t.Fatalf("want %s's results before the due time, got %+v", w.Name, w)
+		}
+		if best == nil || w.Score > best.Score {
+			best = &report.Workers[i]
+		}
+	}
+	chosen := report.Chosen
+	if chosen.Source != client.SourceWorker || chosen.Worker != best.Name || chosen.JobId != best.JobId ||
+		chosen.Move != best.BestMove || chosen.Score != best.Score {
+		t.Fatalf("want %s's %s at %d from job %d, got %+v", best.Name, best.BestMove, best.Score, best.JobId, chosen)
+	}
+
+	// one JSON object per turn, the same report the observers saw
+	scanner := bufio.NewScanner(&out)
+	var lines int
+	for scanner.Scan() {
+		var decoded client.TurnReport
+		err := json.Unmarshal(scanner.Bytes(), &decoded)
+		if err != nil {
+			t.Fatalf("line %d isn't a turn report: %v", lines+1, err)
+		}
+		if lines >= len(reports) {
+			t.Fatalf("more lines than turns: %s", scanner.Text())
+		}
+		want, _ := json.Marshal(reports[lines])
+		if again, _ := json.Marshal(decoded); !bytes.Equal(again, want) {
+			t.Fatalf("line %d: want %s, got %s", lines+1, want, again)
+		}
+		if decoded.Turn != reports[0].Turn+lines {
+			t.Fatalf("line %d is turn %d", lines+1, decoded.Turn)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Fatalf("want a line per turn, got %d", lines)
+	}
+}
@@ -0,0 +1,219 @@
+// Package clustertest runs a whole cluster inside one process for tests: a
+// stand-in for the catalog server, workers on loopback driving fake engines
+// and a client connected to them.
+//
+// Workers and clients find the catalog through common.CatalogAddr and
+// common.CatalogPort, so a cluster points those at itself while it runs.
+// Tests that start clusters must not run in parallel.
+package clustertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+)
+
+// Catalog speaks the catalog server's protocol on loopback. Updates arrive
+// as JSON over UDP and everything heard within each update's lifetime is
+// served as a list at /query.json, on the same port number over HTTP.
+type Catalog struct {
+	udp  net.PacketConn
+	ln   net.Listener
+	srv  *http.Server
+	port int
+	done chan struct{}
+
+	mu      sync.Mutex
+	records map[string]record
+	updates int
+}
+
+// an entry and when it expires
+type record struct {
+	entry   catalog.Entry
+	expires time.Time
+}
+
+// an update as workers send it
+type update struct {
+	catalog.Entry
+	Lifetime int `json:"lifetime"` // seconds
+}
+
+// how long entries without a lifetime are kept, the real catalog's default
+const defaultLifetime = 15 * time.Minute
+
+// NewCatalog starts a catalog on a free loopback port
+func NewCatalog() (*Catalog, error) {
+	c := &Catalog{records: map[string]record{}, done: make(chan struct{})}
+
+	// UDP and HTTP share a port number, retry until both halves are free
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		c.ln, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		c.port = c.ln.Addr().(*net.TCPAddr).Port
+		c.udp, err = net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(c.port)))
+		if err == nil {
+			break
+		}
+		c.ln.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no free port for the catalog: %w", err)
+	}
+
+	c.srv = &http.Server{Handler: http.HandlerFunc(c.serveQuery)}
+	go c.srv.Serve(c.ln)
+	go c.listen()
+	return c, nil
+}
+
+// Addr is the host to reach the catalog on
+func (c *Catalog) Addr() string {
+	return "127.0.0.1"
+}
+
+// Port is the catalog's UDP and HTTP port
+func (c *Catalog) Port() int {
+	return c.port
+}
+
+// collects updates until Close
+func (c *Catalog) listen() {
+	defer close(c.done)
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := c.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var u update
+		if json.Unmarshal(buf[:n], &u) != nil {
+			continue
+		}
+		u.Address = from.(*net.UDPAddr).IP.String()
+		lifetime := defaultLifetime
+		if u.Lifetime > 0 {
+			lifetime = time.Duration(u.Lifetime) * time.Second
+		}
+		c.Add(u.Entry, lifetime)
+	}
+}
+
+// Add registers an entry as if it had been sent over UDP, replacing any
+// earlier one for the same name, address and port
+func (c *Catalog) Add(e catalog.Entry, lifetime time.Duration) {
+	now := time.Now()
+	e.LastHeardFrom = float64(now.UnixNano()) / 1e9
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records[fmt.Sprintf("%s/%s", e.Project, e.HostPort())] = record{entry: e, expires: now.Add(lifetime)}
+	c.updates++
+}
+
+// Remove forgets every entry for the named worker
+func (c *Catalog) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, r := range c.records {
+		if r.entry.Project == name {
+			delete(c.records, key)
+		}
+	}
+}
+
+// Entries returns every entry that hasn't expired, sorted by name
+func (c *Catalog) Entries() []catalog.Entry {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var entries []catalog.Entry
+	for key, r := range c.records {
+		if now.After(r.expires) {
+			delete(c.records, key)
+			continue
+		}
+		entries = append(entries, r.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Project < entries[j].Project })
+	return entries
+}
+
+// Updates counts the updates received so far
+func (c *Catalog) Updates() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updates
+}
+
+// WaitFor blocks until every named worker is listed, or the timeout passes
+func (c *Catalog) WaitFor(timeout time.Duration, names ...string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		missing := c.missing(names)
+		if len(missing) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("workers never registered with the catalog: %v", missing)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// WaitGone blocks until none of the named workers are listed, or the timeout passes
+func (c *Catalog) WaitGone(timeout time.Duration, names ...string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if len(c.missing(names)) == len(names) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("workers still registered with the catalog")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// the names with no live entry
+func (c *Catalog) missing(names []string) []string {
+	entries := c.Entries()
+	var missing []string
+	for _, name := range names {
+		if _, ok := catalog.Find(entries, name); !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func (c *Catalog) serveQuery(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/query.json" {
+		http.NotFound(w, r)
+		return
+	}
+	entries := c.Entries()
+	if entries == nil {
+		entries = []catalog.Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Close stops both listeners
+func (c *Catalog) Close() error {
+	err := c.udp.Close()
+	<-c.done
+	return errors.Join(err, c.srv.Close())
+}
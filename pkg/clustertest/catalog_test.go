@@ -0,0 +1,53 @@
+package clustertest
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+)
+
+func TestCatalogUpdatesAndQuery(t *testing.T) {
+	c, err := NewCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("udp", net.JoinHostPort(c.Addr(), strconv.Itoa(c.Port())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"type":"chess-worker","project":"worker-a","port":9000}`))
+	conn.Write([]byte(`{"type":"chess-worker","project":"worker-b","port":9001,"lifetime":1}`))
+	err = c.WaitFor(time.Second, "worker-a", "worker-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := catalog.Query(c.Addr(), c.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok := catalog.Find(entries, "worker-a")
+	if !ok || a.Address != "127.0.0.1" || a.Port != 9000 {
+		t.Fatalf("want worker-a at 127.0.0.1:9000, got %+v", entries)
+	}
+
+	// worker-b's one second lifetime runs out, worker-a stays
+	err = c.WaitGone(3*time.Second, "worker-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := catalog.Find(c.Entries(), "worker-a"); !ok {
+		t.Fatal("worker-a expired early")
+	}
+
+	c.Remove("worker-a")
+	if len(c.Entries()) != 0 {
+		t.Fatalf("want no entries after Remove, got %+v", c.Entries())
+	}
+}
@@ -0,0 +1,254 @@
+package clustertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/faultnet"
+	"github.com/rpnahm/distsys-chess-engine/pkg/server"
+)
+
+// Options configure a cluster, the zero value gives two workers and short turns
+type Options struct {
+	Workers  int                 // number of workers, 2 when zero
+	Prefix   string              // workers are <Prefix>-NN, "clustertest" when empty
+	Script   fakeengine.Script   // engine script for every worker
+	Scripts  []fakeengine.Script // per worker scripts, these win over Script
+	TurnTime time.Duration       // client turn time, 300ms when zero
+	Latency  time.Duration       // client latency buffer, 50ms when zero
+	NoClient bool                // skip starting the client
+	Faults   *faultnet.Scenario  // injected into the client's connections, nil for none
+	Logger   *slog.Logger        // for workers and the client, discarded when nil
+}
+
+// Cluster is a running catalog, its workers and a client connected to them
+type Cluster struct {
+	Catalog *Catalog
+	Workers []*server.Worker
+	Names   []string
+	Client  *client.Client // nil with Options.NoClient
+
+	t       testing.TB
+	opts    Options
+	mu      sync.Mutex
+	engines map[string]*fakeengine.Engine
+}
+
+// Start brings a cluster up and tears it down when the test ends.
+// It fails the test if anything can't be started.
+func Start(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	if opts.Workers == 0 {
+		opts.Workers = 2
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "clustertest"
+	}
+	if opts.TurnTime == 0 {
+		opts.TurnTime = 300 * time.Millisecond
+	}
+	if opts.Latency == 0 {
+		opts.Latency = 50 * time.Millisecond
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	cat, err := NewCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldAddr, oldPort := common.CatalogAddr, common.CatalogPort
+	common.CatalogAddr, common.CatalogPort = cat.Addr(), cat.Port()
+	c := &Cluster{Catalog: cat, t: t, opts: opts, engines: map[string]*fakeengine.Engine{}}
+	t.Cleanup(func() {
+		c.Close()
+		common.CatalogAddr, common.CatalogPort = oldAddr, oldPort
+	})
+
+	for i := 0; i < opts.Workers; i++ {
+		script := opts.Script
+		if i < len(opts.Scripts) {
+			script = opts.Scripts[i]
+		}
+		c.AddWorker(fmt.Sprintf("%s-%02d", opts.Prefix, i), script)
+	}
+	if opts.NoClient {
+		return c
+	}
+
+	c.Client = c.NewClient(client.NamedWorkers(opts.Prefix, opts.Workers))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*common.Wait)
+	defer cancel()
+	err = c.Client.Connect(ctx)
+	if err != nil {
+		t.Fatal("unable to connect the client: ", err)
+	}
+	return c
+}
+
+// AddWorker starts another worker playing script and waits for it to register
+func (c *Cluster) AddWorker(name string, script fakeengine.Script) *server.Worker {
+	c.t.Helper()
+	w, err := server.StartupWith(func() (server.Engine, error) {
+		e := fakeengine.New(script)
+		c.mu.Lock()
+		c.engines[name] = e
+		c.mu.Unlock()
+		return e, nil
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	w.SetName(name)
+	w.SetLogger(c.opts.Logger)
+	go w.Run()
+	go w.CatalogMessage("clustertest")
+
+	c.mu.Lock()
+	c.Workers = append(c.Workers, w)
+	c.Names = append(c.Names, name)
+	c.mu.Unlock()
+
+	err = c.Catalog.WaitFor(common.Wait, name)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return w
+}
+
+// NewClient returns an unconnected client for the workers picked by selector,
+// with the cluster's turn time, logger and faults
+func (c *Cluster) NewClient(selector client.Selector) *client.Client {
+	cl := client.New(selector, c.opts.TurnTime, c.opts.Latency)
+	cl.SetLogger(c.opts.Logger)
+	if c.opts.Faults != nil {
+		cl.InjectFaults(c.opts.Faults)
+	}
+	c.t.Cleanup(cl.Shutdown)
+	return cl
+}
+
+// Worker returns the named worker, nil if there isn't one
+func (c *Cluster) Worker(name string) *server.Worker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, n := range c.Names {
+		if n == name {
+			return c.Workers[i]
+		}
+	}
+	return nil
+}
+
+// Engine returns the fake engine the named worker is running
+func (c *Cluster) Engine(name string) *fakeengine.Engine {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.engines[name]
+}
+
+// Addr is the loopback address of the named worker
+func (c *Cluster) Addr(name string) string {
+	w := c.Worker(name)
+	if w == nil {
+		return ""
+	}
+	return net.JoinHostPort("127.0.0.1", w.Port())
+}
+
+// Dial opens a raw protocol connection to the named worker, closed when the test ends.
+// Reads time out after common.Wait so a silent worker fails the test instead of hanging it.
+func (c *Cluster) Dial(name string) *Conn {
+	c.t.Helper()
+	raw, err := net.DialTimeout("tcp", c.Addr(name), common.Wait)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	conn := &Conn{Conn: common.NewConn(raw), t: c.t}
+	c.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// a grace of 0 would wait on jobs in flight, tearing a test down cancels them
+const teardownGrace = time.Millisecond
+
+// Close shuts the client, the workers and the catalog down. It runs on its
+// own when the test ends, calling it earlier is fine.
+func (c *Cluster) Close() {
+	if c.Client != nil {
+		c.Client.Shutdown()
+	}
+	c.mu.Lock()
+	workers := c.Workers
+	c.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *server.Worker) {
+			defer wg.Done()
+			w.Shutdown(teardownGrace)
+		}(w)
+	}
+	wg.Wait()
+	c.Catalog.Close()
+}
+
+// Conn is a protocol connection that fails the test on errors
+type Conn struct {
+	*common.Conn
+	t testing.TB
+}
+
+// Send sends m, failing the test if it can't
+func (c *Conn) Send(m common.Message) {
+	c.t.Helper()
+	c.SetWriteDeadline(time.Now().Add(common.Wait))
+	err := c.Conn.Send(m)
+	if err != nil {
+		c.t.Fatal("send: ", err)
+	}
+}
+
+// SendUnchecked writes m as json without validating it first, for testing
+// how workers answer requests the codec would refuse to send
+func (c *Conn) SendUnchecked(m common.Message) {
+	c.t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		c.t.Fatal("send: ", err)
+	}
+	c.SetWriteDeadline(time.Now().Add(common.Wait))
+	_, err = c.Write(data)
+	if err != nil {
+		c.t.Fatal("send: ", err)
+	}
+}
+
+// Recv reads the next message, failing the test if none arrives in common.Wait
+func (c *Conn) Recv() common.Message {
+	c.t.Helper()
+	c.SetReadDeadline(time.Now().Add(common.Wait))
+	m, err := c.Conn.Recv()
+	if err != nil {
+		c.t.Fatal("recv: ", err)
+	}
+	return m
+}
+
+// Request sends m and returns the reply
+func (c *Conn) Request(m common.Message) common.Message {
+	c.t.Helper()
+	c.Send(m)
+	return c.Recv()
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+	The codec turns raw frames into concrete message structs.
+	Every frame is first read as an envelope to find its type, then the
+	registry supplies the struct to decode into along with the fields
+	that must be present. Callers switch on the returned Message instead
+	of digging through map[string]interface{} values.
+*/
+
+// Sentinel errors wrapped by DecodeError, test for them with errors.Is
+var (
+	ErrMalformed    = errors.New("malformed frame")
+	ErrUnknownType  = errors.New("unknown message type")
+	ErrMissingField = errors.New("missing required field")
+	ErrInvalidField = errors.New("invalid field")
+)
+
+// DecodeError describes why a frame could not be turned into a message
+type DecodeError struct {
+	Type  string // message type, empty if it could not be read
+	Field string // offending field, empty if the error is not about one field
+	Err   error  // one of the sentinel errors above
+	Msg   string // extra detail
+}
+
+func (e *DecodeError) Error() string {
+	s := e.Err.Error()
+	if e.Type != "" {
+		s = fmt.Sprintf("%s: %s", e.Type, s)
+	}
+	if e.Field != "" {
+		s = fmt.Sprintf("%s %q", s, e.Field)
+	}
+	if e.Msg != "" {
+		s = fmt.Sprintf("%s: %s", s, e.Msg)
+	}
+	return s
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// builds the error returned by Validate methods
+func fieldError(msgType string, field string, msg string) error {
+	return &DecodeError{Type: msgType, Field: field, Err: ErrInvalidField, Msg: msg}
+}
+
+// registry entry for a single message type
+type registration struct {
+	factory  func() Message
+	required []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]registration{}
+)
+
+func init() {
+	Register(TypeError, func() Message { return &Error{} }, "reason")
+	Register(TypeNewGame, func() Message { return &NewGame{} }, "position", "pos_id")
+	Register(TypeReadyOk, func() Message { return &ReadyOk{} }, "pos_id")
+	Register(TypeParseMoves, func() Message { return &ParseMoves{} }, "position", "pos_id", "moves", "due_time", "job_id")
+	Register(TypeWorking, func() Message { return &Working{} }, "pos_id", "job_id")
+	Register(TypeResults, func() Message { return &Results{} }, "job_id", "best_move")
+	Register(TypeNewPos, func() Message { return &NewPos{} }, "position", "pos_id")
+	Register(TypeStop, func() Message { return &Stop{} })
+	Register(TypeExit, func() Message { return &Exit{} })
+	Register(TypeHello, func() Message { return &Hello{} }, "codecs")
+	Register(TypeAdmin, func() Message { return &Admin{} }, "token", "command")
+	Register(TypeAdminReply, func() Message { return &AdminReply{} }, "command")
+	Register(TypeLeaving, func() Message { return &Leaving{} })
+}
+
+// Register adds a message type to the codec. The factory must return a
+// pointer to a fresh struct, and required lists the json keys that must
+// be present in every frame of this type. Registering a type twice replaces it.
+func Register(msgType string, factory func() Message, required ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[msgType] = registration{factory: factory, required: required}
+}
+
+// finds the registration for a message type and checks the required fields
+// are present, has reports whether a key exists in the raw frame
+func lookup(msgType string, has func(string) bool) (registration, error) {
+	registryMu.RLock()
+	reg, ok := registry[msgType]
+	registryMu.RUnlock()
+	if !ok {
+		return registration{}, &DecodeError{Type: msgType, Err: ErrUnknownType}
+	}
+
+	for _, field := range reg.required {
+		if !has(field) {
+			return registration{}, &DecodeError{Type: msgType, Field: field, Err: ErrMissingField}
+		}
+	}
+	return reg, nil
+}
+
+// Decode reads a single JSON frame and returns its concrete message
+func Decode(data []byte) (Message, error) {
+	return JSON.Decode(data)
+}
+
+// Encode validates a message and turns it into a JSON frame
+func Encode(m Message) ([]byte, error) {
+	return JSON.Encode(m)
+}
@@ -0,0 +1,268 @@
+package common_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+var start = chess.StartingPosition().String()
+
+// the error must be a *DecodeError wrapping want
+func decodeError(t *testing.T, err error, want error) *common.DecodeError {
+	t.Helper()
+	var de *common.DecodeError
+	if !errors.As(err, &de) || !errors.Is(err, want) {
+		t.Fatalf("want a DecodeError wrapping %q, got %v", want, err)
+	}
+	return de
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  error
+		typ   string
+		field string
+	}{
+		{"not json", `{"type":`, common.ErrMalformed, "", ""},
+		{"no type", `{"pos_id":0}`, common.ErrMissingField, "", "type"},
+		{"type not a string", `{"type":7}`, common.ErrInvalidField, "", "type"},
+		{"unknown type", `{"type":"resign"}`, common.ErrUnknownType, "resign", ""},
+		{"missing field", `{"type":"new_game","position":"` + start + `"}`, common.ErrMissingField, "new_game", "pos_id"},
+		{"wrong type", `{"type":"new_game","position":42,"pos_id":0}`, common.ErrInvalidField, "new_game", "position"},
+		{"negative id", `{"type":"ready_ok","pos_id":-1}`, common.ErrInvalidField, "ready_ok", "pos_id"},
+		{"bad fen", `{"type":"new_pos","position":"not a fen","pos_id":1}`, common.ErrInvalidField, "new_pos", "position"},
+		{"bad new_game fen", `{"type":"new_game","position":"not a fen","pos_id":0}`, common.ErrInvalidField, "new_game", "position"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := common.Decode([]byte(tt.frame))
+			de := decodeError(t, err, tt.want)
+			if de.Type != tt.typ || de.Field != tt.field {
+				t.Fatalf("want type %q and field %q, got %q and %q: %v", tt.typ, tt.field, de.Type, de.Field, err)
+			}
+		})
+	}
+}
+
+func TestEncodeValidates(t *testing.T) {
+	for _, codec := range []common.Codec{common.JSON, common.Msgpack} {
+		_, err := codec.Encode(&common.ParseMoves{Type: common.TypeParseMoves, Position: "not a fen", Moves: []string{"e2e4"}, DueTime: time.Now()})
+		de := decodeError(t, err, common.ErrInvalidField)
+		if de.Field != "position" {
+			t.Fatalf("%s: want the position refused, got %v", codec.Name(), err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	due := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	messages := []common.Message{
+		&common.NewGame{Type: common.TypeNewGame, Position: start, PosId: 3, Options: []string{"Threads 2"}},
+		&common.ParseMoves{Type: common.TypeParseMoves, Position: start, PosId: 3, JobId: 9, Moves: []string{"e2e4", "d2d4"}, DueTime: due, Start: start, History: []string{"e2e4"}},
+		&common.Results{Type: common.TypeResults, JobId: 9, BestMove: "e2e4", Score: -35, Mate: 2, Depth: 12, Nodes: 1 << 40, PV: []string{"e2e4", "e7e5"}},
+		&common.Error{Type: common.TypeError, Reason: "no"},
+	}
+	for _, codec := range []common.Codec{common.JSON, common.Msgpack} {
+		for _, m := range messages {
+			frame, err := codec.Encode(m)
+			if err != nil {
+				t.Fatalf("%s: %v", codec.Name(), err)
+			}
+			advance, body, err := codec.Split(frame)
+			if err != nil || advance != len(frame) {
+				t.Fatalf("%s: want the whole frame split off, got %d of %d: %v", codec.Name(), advance, len(frame), err)
+			}
+			got, err := codec.Decode(body)
+			if err != nil {
+				t.Fatalf("%s: %v", codec.Name(), err)
+			}
+			// times come back in the local zone
+			if p, ok := got.(*common.ParseMoves); ok {
+				if !p.DueTime.Equal(due) {
+					t.Fatalf("%s: want due time %s, got %s", codec.Name(), due, p.DueTime)
+				}
+				p.DueTime = due
+			}
+			if !reflect.DeepEqual(got, m) {
+				t.Fatalf("%s: want %#v, got %#v", codec.Name(), m, got)
+			}
+		}
+	}
+}
+
+func TestMsgpackSplit(t *testing.T) {
+	first, err := common.Msgpack.Encode(&common.ReadyOk{Type: common.TypeReadyOk, PosId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := common.Msgpack.Encode(&common.ReadyOk{Type: common.TypeReadyOk, PosId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.BigEndian.Uint32(first); int(size) != len(first)-4 {
+		t.Fatalf("want a length prefix of %d, got %d", len(first)-4, size)
+	}
+
+	// nothing until the first frame is complete
+	stream := append(append([]byte{}, first...), second...)
+	for n := 0; n < len(first); n++ {
+		advance, frame, err := common.Msgpack.Split(stream[:n])
+		if advance != 0 || frame != nil || err != nil {
+			t.Fatalf("split %d bytes of %d: got %d, %v, %v", n, len(first), advance, frame, err)
+		}
+	}
+	advance, frame, err := common.Msgpack.Split(stream)
+	if err != nil || advance != len(first) || !bytes.Equal(frame, first[4:]) {
+		t.Fatalf("want the first frame, got %d bytes: %v", advance, err)
+	}
+	_, frame, err = common.Msgpack.Split(stream[advance:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := common.Msgpack.Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready, ok := m.(*common.ReadyOk); !ok || ready.PosId != 2 {
+		t.Fatalf("want the second frame, got %#v", m)
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(common.MaxFrameSize+1))
+	_, _, err := common.Msgpack.Split(header)
+	decodeError(t, err, common.ErrMalformed)
+
+	// a json object that never ends
+	open := []byte(`{"type":"error","reason":"` + strings.Repeat("x", common.MaxFrameSize))
+	_, _, err = common.JSON.Split(open)
+	decodeError(t, err, common.ErrMalformed)
+}
+
+func TestRecvSurvivesBadFrame(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		server.Write([]byte(`{"type":"ready_ok"}`))
+		server.Write([]byte(`{"type":"ready_ok","pos_id":4}`))
+	}()
+	conn := common.NewConn(client)
+	_, err := conn.Recv()
+	de := decodeError(t, err, common.ErrMissingField)
+	if de.Field != "pos_id" {
+		t.Fatalf("want pos_id missing, got %v", err)
+	}
+	m, err := conn.Recv()
+	if ready, ok := m.(*common.ReadyOk); err != nil || !ok || ready.PosId != 4 {
+		t.Fatalf("want the next frame, got %#v: %v", m, err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	// the worker answers the hello with reply, or accepts it and sends
+	// ready_ok when nil. done has the worker's error.
+	negotiate := func(offer []string, reply common.Message) (*common.Conn, chan error) {
+		t.Helper()
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+		done := make(chan error, 1)
+		go func() {
+			worker := common.NewConn(server)
+			m, err := worker.Recv()
+			if err != nil {
+				done <- err
+				return
+			}
+			if reply != nil {
+				done <- worker.Send(reply)
+				return
+			}
+			err = worker.Accept(m.(*common.Hello))
+			if err == nil {
+				err = worker.Send(&common.ReadyOk{Type: common.TypeReadyOk, PosId: 1})
+			}
+			done <- err
+		}()
+		conn := common.NewConn(client)
+		err := conn.Negotiate(offer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, done
+	}
+
+	conn, done := negotiate([]string{common.CodecMsgpack, common.CodecJSON}, nil)
+	if conn.Codec() != common.Msgpack {
+		t.Fatalf("want msgpack, got %s", conn.Codec().Name())
+	}
+	if m, err := conn.Recv(); err != nil || m.MessageType() != common.TypeReadyOk {
+		t.Fatalf("want ready_ok in msgpack, got %v: %v", m, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// workers from before negotiation reject the hello
+	conn, done = negotiate([]string{common.CodecMsgpack}, &common.Error{Type: common.TypeError, Reason: "Unexpected message type: hello"})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if conn.Codec() != common.JSON {
+		t.Fatalf("want json after an error, got %s", conn.Codec().Name())
+	}
+
+	// offering json alone sends nothing
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if err := common.NewConn(client).Negotiate([]string{common.CodecJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if err := common.NewConn(client).Negotiate([]string{"xml"}); err == nil {
+		t.Fatal("offered an unknown codec")
+	}
+}
+
+// encode and decode both validate, so the position checks are part of each
+func BenchmarkCodec(b *testing.B) {
+	job := &common.ParseMoves{Type: common.TypeParseMoves, Position: start, PosId: 13, JobId: 52, DueTime: time.Now(),
+		Moves: []string{"a2a3", "a2a4", "b2b3", "b2b4", "c2c3", "c2c4", "d2d3", "d2d4", "e2e3", "e2e4"}}
+	for _, codec := range []common.Codec{common.JSON, common.Msgpack} {
+		frame, err := codec.Encode(job)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, body, _ := codec.Split(frame)
+		b.Run(codec.Name()+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				codec.Encode(job)
+			}
+		})
+		b.Run(codec.Name()+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				codec.Decode(body)
+			}
+		})
+	}
+	b.Run("validate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			job.Validate()
+		}
+	})
+}
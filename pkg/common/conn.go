@@ -0,0 +1,135 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Conn sends and receives whole messages over a stream connection.
+// Partial frames survive read deadlines, so a Recv that times out can
+// simply be retried later.
+type Conn struct {
+	net.Conn
+	codec   Codec
+	pending []byte
+	buf     []byte
+	tap     Tap
+}
+
+// Tap sees every message a Conn sends or receives, with the frame as it was
+// on the wire. m is nil for received frames that couldn't be decoded. frame
+// is only valid during the call.
+type Tap func(sent bool, m Message, frame []byte)
+
+// NewConn wraps a connection, all connections start out speaking json
+func NewConn(c net.Conn) *Conn {
+	return &Conn{Conn: c, codec: JSON, buf: make([]byte, BufSize)}
+}
+
+// Codec returns the wire format currently in use
+func (c *Conn) Codec() Codec {
+	return c.codec
+}
+
+// SetTap has tap called for every message from now on, nil removes it.
+// Set it before the connection is shared.
+func (c *Conn) SetTap(tap Tap) {
+	c.tap = tap
+}
+
+// Send encodes and writes a single message
+func (c *Conn) Send(m Message) error {
+	data, err := c.codec.Encode(m)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(data)
+	if err == nil && c.tap != nil {
+		c.tap(true, m, data)
+	}
+	return err
+}
+
+// Recv blocks until a full message arrives or the read fails.
+// Frames that cannot be decoded are consumed and returned as a *DecodeError.
+func (c *Conn) Recv() (Message, error) {
+	for {
+		advance, frame, err := c.codec.Split(c.pending)
+		if err != nil {
+			// framing is lost, drop everything buffered to resync
+			c.pending = c.pending[:0]
+			return nil, err
+		}
+		if frame != nil {
+			m, err := c.codec.Decode(frame)
+			if c.tap != nil {
+				c.tap(false, m, frame)
+			}
+			c.pending = c.pending[advance:]
+			return m, err
+		}
+
+		n, err := c.Read(c.buf)
+		c.pending = append(c.pending, c.buf[:n]...)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Negotiate is run by the client right after connecting. It offers the
+// given codecs in order of preference and switches to the one the worker
+// picks. Offering only json skips the exchange entirely.
+func (c *Conn) Negotiate(names []string) error {
+	if len(names) == 0 || (len(names) == 1 && names[0] == CodecJSON) {
+		return nil
+	}
+	for _, name := range names {
+		if _, ok := LookupCodec(name); !ok {
+			return fmt.Errorf("unknown codec %q", name)
+		}
+	}
+
+	err := c.Send(&Hello{Type: TypeHello, Codecs: names})
+	if err != nil {
+		return err
+	}
+	reply, err := c.Recv()
+	if err != nil {
+		return err
+	}
+
+	switch m := reply.(type) {
+	case *Hello:
+		codec, ok := LookupCodec(m.Codecs[0])
+		if !ok {
+			return fmt.Errorf("worker picked unknown codec %q", m.Codecs[0])
+		}
+		c.codec = codec
+		return nil
+	case *Error:
+		// workers that predate negotiation reject the hello, stay on json
+		return nil
+	default:
+		return errors.New(fmt.Sprint("unexpected reply to hello: ", reply.MessageType()))
+	}
+}
+
+// Accept is run by the worker when a hello arrives. It picks the first
+// offered codec it supports, replies in json and switches over.
+func (c *Conn) Accept(hello *Hello) error {
+	for _, name := range hello.Codecs {
+		codec, ok := LookupCodec(name)
+		if !ok {
+			continue
+		}
+		err := c.Send(&Hello{Type: TypeHello, Codecs: []string{name}})
+		if err != nil {
+			return err
+		}
+		c.codec = codec
+		return nil
+	}
+	return fmt.Errorf("no supported codec in %v", hello.Codecs)
+}
@@ -4,8 +4,26 @@ import "time"
 
 var BufSize = 1024
 
+// largest frame a Conn will buffer before giving up on it
+var MaxFrameSize = 1 << 20
+
+// wire formats the client offers to workers, in order of preference
+var Codecs = []string{CodecJSON}
+
 // global variables for catalog addresses and such
 var CatalogAddr = "catalog.cse.nd.edu"
 var CatalogPort = 9097
 
 var Wait = 2 * time.Second
+
+// environment variable holding the shared secret for admin commands
+const EnvAdminToken = "CHESS_ADMIN_TOKEN"
+
+// environment variable with the address for a worker's HTTP status endpoint
+const EnvStatusAddr = "CHESS_STATUS_ADDR"
+
+// environment variable naming a JSON Lines file for per-turn client telemetry
+const EnvTelemetryFile = "CHESS_TELEMETRY"
+
+// environment variable naming a file for OTLP JSON trace spans
+const EnvTraceFile = "CHESS_TRACE_FILE"
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// environment variables read by LoggerFromEnv
+const (
+	EnvLogLevel  = "CHESS_LOG_LEVEL"  // debug, info, warn or error
+	EnvLogFormat = "CHESS_LOG_FORMAT" // text or json
+)
+
+// Field names shared by client and worker log lines
+const (
+	LogWorker = "worker"
+	LogPosId  = "pos_id"
+	LogJobId  = "job_id"
+	LogType   = "type"
+	LogErr    = "err"
+)
+
+// NewLogger builds a logger writing to out at the given level ("" means info)
+// in either "text" or "json" format ("" means text)
+func NewLogger(out io.Writer, level string, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		err := lvl.UnmarshalText([]byte(level))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(out, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+}
+
+// LoggerFromEnv builds a stderr logger configured by CHESS_LOG_LEVEL and CHESS_LOG_FORMAT
+func LoggerFromEnv() (*slog.Logger, error) {
+	return NewLogger(os.Stderr, os.Getenv(EnvLogLevel), os.Getenv(EnvLogFormat))
+}
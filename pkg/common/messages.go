@@ -1,6 +1,10 @@
 package common
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 /*
 	This file will contain different json formats
@@ -9,14 +13,60 @@ import "time"
 
 	Notices:
 		All position messages must be in FEN notation (notnils/chess )
+		Every message type is registered with the codec in codec.go
 */
 
+// Version of the message protocol, advertised to the catalog so clients can
+// skip workers they can't talk to. Bump it on incompatible message changes.
+const ProtocolVersion = 1
+
+// Message type strings as they appear in the "type" field
+const (
+	TypeError      = "error"
+	TypeNewGame    = "new_game"
+	TypeReadyOk    = "ready_ok"
+	TypeParseMoves = "parse_moves"
+	TypeWorking    = "working"
+	TypeResults    = "results"
+	TypeNewPos     = "new_pos"
+	TypeStop       = "stop"
+	TypeExit       = "exit"
+	TypeHello      = "hello"
+	TypeAdmin      = "admin"
+	TypeAdminReply = "admin_reply"
+	TypeLeaving    = "leaving"
+)
+
+// Commands accepted in admin messages
+const (
+	AdminExit            = "exit"              // shut the worker down
+	AdminDrain           = "drain"             // deregister, finish the current job and send the client away
+	AdminReloadConfig    = "reload-config"     // re-read the worker's config file
+	AdminSetEngineOption = "set-engine-option" // args: "name value", pinned for future games
+	AdminStatus          = "status"            // report a WorkerStatus
+	AdminRestart         = "restart"           // replace the engine process, refused during a session
+)
+
+// Message is implemented by every struct that can be sent over the wire
+type Message interface {
+	// MessageType returns the value expected in the "type" field
+	MessageType() string
+	// Validate checks the semantic contents of a decoded message
+	Validate() error
+}
+
 // Error message: An error message to let the client know that the previous operation failed for some reason
 type Error struct {
 	Type   string `json:"type"`
 	Reason string `json:"reason"`
 }
 
+func (m *Error) MessageType() string { return TypeError }
+
+func (m *Error) Validate() error {
+	return checkType(m.Type, TypeError)
+}
+
 // NewGame message: for signalling a newgame to the server
 type NewGame struct {
 	Type     string   `json:"type"`
@@ -25,12 +75,33 @@ type NewGame struct {
 	PosId    int      `json:"pos_id"`
 }
 
+func (m *NewGame) MessageType() string { return TypeNewGame }
+
+func (m *NewGame) Validate() error {
+	if err := checkType(m.Type, TypeNewGame); err != nil {
+		return err
+	}
+	if err := checkFEN(TypeNewGame, "position", m.Position); err != nil {
+		return err
+	}
+	return checkId(TypeNewGame, "pos_id", m.PosId)
+}
+
 // ReadyOk message: server confirming the position
 type ReadyOk struct {
 	Type  string `json:"type"`
 	PosId int    `json:"pos_id"`
 }
 
+func (m *ReadyOk) MessageType() string { return TypeReadyOk }
+
+func (m *ReadyOk) Validate() error {
+	if err := checkType(m.Type, TypeReadyOk); err != nil {
+		return err
+	}
+	return checkId(TypeReadyOk, "pos_id", m.PosId)
+}
+
 // ParseMoves message: Sends all necessary data to the server to look at moves
 type ParseMoves struct {
 	Type     string    `json:"type"`
@@ -39,6 +110,41 @@ type ParseMoves struct {
 	Moves    []string  `json:"moves"`
 	DueTime  time.Time `json:"due_time"`
 	JobId    int       `json:"job_id"`
+	TraceId  string    `json:"trace_id,omitempty"` // set when the client is tracing
+	SpanId   string    `json:"span_id,omitempty"`  // client span the worker's spans hang off
+	// optional game so far, the moves lead from start to position and let the
+	// engine see repetitions
+	Start   string   `json:"start,omitempty"`
+	History []string `json:"history,omitempty"`
+}
+
+func (m *ParseMoves) MessageType() string { return TypeParseMoves }
+
+func (m *ParseMoves) Validate() error {
+	if err := checkType(m.Type, TypeParseMoves); err != nil {
+		return err
+	}
+	if err := checkFEN(TypeParseMoves, "position", m.Position); err != nil {
+		return err
+	}
+	if len(m.Moves) == 0 {
+		return fieldError(TypeParseMoves, "moves", "must contain at least one move")
+	}
+	if m.DueTime.IsZero() {
+		return fieldError(TypeParseMoves, "due_time", "must be set")
+	}
+	if len(m.History) > 0 && m.Start == "" {
+		return fieldError(TypeParseMoves, "start", "must be set along with history")
+	}
+	if m.Start != "" {
+		if err := checkFEN(TypeParseMoves, "start", m.Start); err != nil {
+			return err
+		}
+	}
+	if err := checkId(TypeParseMoves, "pos_id", m.PosId); err != nil {
+		return err
+	}
+	return checkId(TypeParseMoves, "job_id", m.JobId)
 }
 
 // Working message: Acknowledges that the server is working
@@ -48,14 +154,41 @@ type Working struct {
 	JobId int    `json:"job_id"`
 }
 
+func (m *Working) MessageType() string { return TypeWorking }
+
+func (m *Working) Validate() error {
+	if err := checkType(m.Type, TypeWorking); err != nil {
+		return err
+	}
+	if err := checkId(TypeWorking, "pos_id", m.PosId); err != nil {
+		return err
+	}
+	return checkId(TypeWorking, "job_id", m.JobId)
+}
+
 // Results message: Returns the results of the search (ideally by the response time)
 type Results struct {
-	Type     string `json:"type"`
-	JobId    int    `json:"job_id"`
-	BestMove string `json:"best_move"`
-	Score    int    `json:"score"`
-	Mate     int    `json:"mate"`
-	Nodes    int    `json:"nodes"`
+	Type     string   `json:"type"`
+	JobId    int      `json:"job_id"`
+	BestMove string   `json:"best_move"`
+	Score    int      `json:"score"`
+	Mate     int      `json:"mate"`
+	Depth    int      `json:"depth"`
+	Nodes    int      `json:"nodes"`
+	PV       []string `json:"pv,omitempty"`    // principal variation in UCI notation, best_move first
+	Spans    []Span   `json:"spans,omitempty"` // worker spans, only when parse_moves carried a trace_id
+}
+
+func (m *Results) MessageType() string { return TypeResults }
+
+func (m *Results) Validate() error {
+	if err := checkType(m.Type, TypeResults); err != nil {
+		return err
+	}
+	if m.BestMove == "" {
+		return fieldError(TypeResults, "best_move", "must not be empty")
+	}
+	return checkId(TypeResults, "job_id", m.JobId)
 }
 
 // NewPosition message: updates the position of the board
@@ -65,8 +198,161 @@ type NewPos struct {
 	PosId    int    `json:"pos_id"`
 }
 
+func (m *NewPos) MessageType() string { return TypeNewPos }
+
+func (m *NewPos) Validate() error {
+	if err := checkType(m.Type, TypeNewPos); err != nil {
+		return err
+	}
+	if err := checkFEN(TypeNewPos, "position", m.Position); err != nil {
+		return err
+	}
+	return checkId(TypeNewPos, "pos_id", m.PosId)
+}
+
 // Stop message: Signals to the server to close the connection
-// Can either contain type: stop or stop: exit depending on the need
 type Stop struct {
 	Type string `json:"type"`
 }
+
+func (m *Stop) MessageType() string { return TypeStop }
+
+func (m *Stop) Validate() error {
+	return checkType(m.Type, TypeStop)
+}
+
+// Leaving message: sent by a worker that is draining or shutting down, right
+// before it closes the connection. Clients should drop the worker.
+type Leaving struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+func (m *Leaving) MessageType() string { return TypeLeaving }
+
+func (m *Leaving) Validate() error {
+	return checkType(m.Type, TypeLeaving)
+}
+
+// Exit message: Signals to the server to shut down entirely
+type Exit struct {
+	Type string `json:"type"`
+}
+
+func (m *Exit) MessageType() string { return TypeExit }
+
+func (m *Exit) Validate() error {
+	return checkType(m.Type, TypeExit)
+}
+
+// Hello message: negotiates the wire format for the rest of the connection
+// The client lists the codecs it supports, the worker answers with the one it chose
+type Hello struct {
+	Type   string   `json:"type"`
+	Codecs []string `json:"codecs"`
+}
+
+func (m *Hello) MessageType() string { return TypeHello }
+
+func (m *Hello) Validate() error {
+	if err := checkType(m.Type, TypeHello); err != nil {
+		return err
+	}
+	if len(m.Codecs) == 0 {
+		return fieldError(TypeHello, "codecs", "must list at least one codec")
+	}
+	return nil
+}
+
+// Admin message: an operator command, only run when the token matches the worker's
+type Admin struct {
+	Type    string   `json:"type"`
+	Token   string   `json:"token"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+func (m *Admin) MessageType() string { return TypeAdmin }
+
+func (m *Admin) Validate() error {
+	if err := checkType(m.Type, TypeAdmin); err != nil {
+		return err
+	}
+	if m.Command == "" {
+		return fieldError(TypeAdmin, "command", "must not be empty")
+	}
+	return nil
+}
+
+// AdminReply message: the outcome of a successful admin command
+type AdminReply struct {
+	Type    string        `json:"type"`
+	Command string        `json:"command"`
+	Message string        `json:"message,omitempty"`
+	Status  *WorkerStatus `json:"status,omitempty"`
+}
+
+func (m *AdminReply) MessageType() string { return TypeAdminReply }
+
+func (m *AdminReply) Validate() error {
+	return checkType(m.Type, TypeAdminReply)
+}
+
+// WorkerStatus is a snapshot of a worker returned by the status command
+type WorkerStatus struct {
+	Name          string     `json:"name"`
+	UptimeSeconds float64    `json:"uptime_seconds"`
+	Draining      bool       `json:"draining"`
+	SessionActive bool       `json:"session_active"`
+	Client        string     `json:"client,omitempty"` // address of the session's client
+	Searching     bool       `json:"searching"`
+	JobDueTime    *time.Time `json:"job_due_time,omitempty"` // deadline of the job being searched
+	PosId         int        `json:"pos_id"`
+	JobId         int        `json:"job_id"`
+	Sessions      int        `json:"sessions"`
+	Jobs          int        `json:"jobs"`
+	Engine        string     `json:"engine"`
+	EngineState   string     `json:"engine_state"` // idle, searching or stopped
+	EngineOptions []string   `json:"engine_options"`
+}
+
+// Span is a finished tracing span as it travels between processes
+type Span struct {
+	TraceId  string            `json:"trace_id"`
+	SpanId   string            `json:"span_id"`
+	ParentId string            `json:"parent_id,omitempty"`
+	Name     string            `json:"name"`
+	Service  string            `json:"service"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+}
+
+// checks that the type field matches the struct it was decoded into
+func checkType(got string, want string) error {
+	if got != want {
+		return fieldError(want, "type", fmt.Sprintf("expected %q, got %q", want, got))
+	}
+	return nil
+}
+
+// ids are counters and can never be negative
+func checkId(msgType string, field string, id int) error {
+	if id < 0 {
+		return fieldError(msgType, field, fmt.Sprintf("must not be negative, got %d", id))
+	}
+	return nil
+}
+
+// positions must look like FEN strings, this runs on every encode and decode
+// so it only checks the shape. The worker parses the position when it sets it up.
+func checkFEN(msgType string, field string, fen string) error {
+	if fen == "" {
+		return fieldError(msgType, field, "must not be empty")
+	}
+	parts := strings.Split(strings.TrimSpace(fen), " ")
+	if len(parts) != 6 || strings.Count(parts[0], "/") != 7 {
+		return fieldError(msgType, field, "not a FEN position, want 8 ranks and 6 fields")
+	}
+	return nil
+}
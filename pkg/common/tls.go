@@ -0,0 +1,97 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig holds the files needed for mutual TLS between clients and workers.
+// Both sides present a certificate signed by the CA in CAFile and verify the peer against it.
+type TLSConfig struct {
+	CertFile string // PEM certificate presented to the peer
+	KeyFile  string // PEM private key for CertFile
+	CAFile   string // PEM bundle of CAs trusted to sign peer certificates
+	// ServerName overrides the name a client expects in worker certificates.
+	// When empty the client expects the worker's catalog name.
+	ServerName string
+}
+
+// environment variables read by TLSConfigFromEnv
+const (
+	EnvTLSCert       = "CHESS_TLS_CERT"
+	EnvTLSKey        = "CHESS_TLS_KEY"
+	EnvTLSCA         = "CHESS_TLS_CA"
+	EnvTLSServerName = "CHESS_TLS_SERVER_NAME"
+)
+
+// TLSConfigFromEnv reads the TLS file paths from the environment
+func TLSConfigFromEnv() TLSConfig {
+	return TLSConfig{
+		CertFile:   os.Getenv(EnvTLSCert),
+		KeyFile:    os.Getenv(EnvTLSKey),
+		CAFile:     os.Getenv(EnvTLSCA),
+		ServerName: os.Getenv(EnvTLSServerName),
+	}
+}
+
+// Enabled reports whether any TLS setting was given
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.CAFile != ""
+}
+
+// ServerConfig builds the listener side config, client certificates are required
+func (t TLSConfig) ServerConfig() (*tls.Config, error) {
+	cert, pool, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig builds the dialing side config for a worker with the given name
+func (t TLSConfig) ClientConfig(workerName string) (*tls.Config, error) {
+	cert, pool, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	serverName := t.ServerName
+	if serverName == "" {
+		serverName = workerName
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// reads the key pair and CA pool, all three files are required
+func (t TLSConfig) load() (tls.Certificate, *x509.CertPool, error) {
+	if t.CertFile == "" || t.KeyFile == "" || t.CAFile == "" {
+		return tls.Certificate{}, nil, errors.New("tls: cert, key and CA files must all be set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("tls: unable to load key pair: %w", err)
+	}
+
+	caData, err := os.ReadFile(t.CAFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("tls: unable to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return tls.Certificate{}, nil, fmt.Errorf("tls: no certificates found in %s", t.CAFile)
+	}
+	return cert, pool, nil
+}
@@ -0,0 +1,138 @@
+package common_test
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tlstest"
+)
+
+// listens with cfg's server side, handshakes are answered with a ready_ok
+func listenTLS(t *testing.T, cfg common.TLSConfig) string {
+	t.Helper()
+	serverConfig, err := cfg.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(common.Wait))
+			if conn.(*tls.Conn).Handshake() == nil {
+				common.NewConn(conn).Send(&common.ReadyOk{Type: common.TypeReadyOk})
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dials addr with cfg, the error is whatever stopped the ready_ok arriving
+func dialTLS(addr string, cfg *tls.Config) error {
+	raw, err := tls.DialWithDialer(&net.Dialer{Timeout: common.Wait}, "tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(common.Wait))
+	_, err = common.NewConn(raw).Recv()
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	configs, err := tlstest.WriteFixtures(filepath.Join(t.TempDir(), "good"), "w0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same names signed by an unrelated CA
+	others, err := tlstest.WriteFixtures(filepath.Join(t.TempDir(), "other"), "w0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listenTLS(t, configs["w0"])
+	good, other := configs["client"], others["client"]
+
+	clientConfig := func(cfg common.TLSConfig, name string) *tls.Config {
+		t.Helper()
+		c, err := cfg.ClientConfig(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	err = dialTLS(addr, clientConfig(good, "w0"))
+	if err != nil {
+		t.Fatalf("a client with a good certificate was refused: %v", err)
+	}
+	noCert := clientConfig(good, "w0")
+	noCert.Certificates = nil
+	if dialTLS(addr, noCert) == nil {
+		t.Fatal("a client without a certificate was accepted")
+	}
+	foreign := clientConfig(other, "w0")
+	foreign.RootCAs = clientConfig(good, "w0").RootCAs
+	if dialTLS(addr, foreign) == nil {
+		t.Fatal("a client with a certificate from another CA was accepted")
+	}
+	// clients check the worker too
+	if dialTLS(addr, clientConfig(other, "w0")) == nil {
+		t.Fatal("a client trusted a worker from another CA")
+	}
+	if dialTLS(addr, clientConfig(good, "w1")) == nil {
+		t.Fatal("a client accepted a certificate for another worker")
+	}
+	good.ServerName = "w0"
+	if err := dialTLS(addr, clientConfig(good, "w1")); err != nil {
+		t.Fatalf("ServerName didn't override the worker name: %v", err)
+	}
+}
+
+func TestTLSConfigFiles(t *testing.T) {
+	configs, err := tlstest.WriteFixtures(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := configs["client"]
+	if !good.Enabled() || (common.TLSConfig{ServerName: "w0"}).Enabled() {
+		t.Fatal("want TLS enabled by the files alone")
+	}
+
+	tests := []struct {
+		name string
+		cfg  common.TLSConfig
+		want string
+	}{
+		{"no key", common.TLSConfig{CertFile: good.CertFile, CAFile: good.CAFile}, "must all be set"},
+		{"missing cert", common.TLSConfig{CertFile: good.CertFile + ".missing", KeyFile: good.KeyFile, CAFile: good.CAFile}, "unable to load key pair"},
+		{"missing CA", common.TLSConfig{CertFile: good.CertFile, KeyFile: good.KeyFile, CAFile: good.CAFile + ".missing"}, "unable to read CA file"},
+		{"CA without certificates", common.TLSConfig{CertFile: good.CertFile, KeyFile: good.KeyFile, CAFile: good.KeyFile}, "no certificates found"},
+	}
+	for _, tt := range tests {
+		_, err := tt.cfg.ServerConfig()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: want an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	t.Setenv(common.EnvTLSCert, good.CertFile)
+	t.Setenv(common.EnvTLSKey, good.KeyFile)
+	t.Setenv(common.EnvTLSCA, good.CAFile)
+	t.Setenv(common.EnvTLSServerName, "w0")
+	if env := common.TLSConfigFromEnv(); env != (common.TLSConfig{CertFile: good.CertFile, KeyFile: good.KeyFile, CAFile: good.CAFile, ServerName: "w0"}) {
+		t.Fatalf("unexpected config from the environment: %+v", env)
+	}
+}
@@ -0,0 +1,251 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+/*
+	Wire formats for messages. Every codec knows how to encode a message,
+	decode one, and find frame boundaries in a byte stream.
+
+	json:    plain json objects written back to back, the original format
+	msgpack: a 4 byte big endian length followed by a msgpack map using the
+	         same keys as the json format
+
+	Connections always start in json. A client that wants something else
+	sends a hello listing the codecs it supports in order of preference,
+	and the worker answers with the one it picked (see Conn.Negotiate).
+*/
+
+// Codec names used during negotiation
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+)
+
+// Codec encodes and decodes messages for a single wire format
+type Codec interface {
+	// Name is the identifier used in hello messages
+	Name() string
+	// Encode validates m and returns a complete frame ready to write
+	Encode(m Message) ([]byte, error)
+	// Decode turns one frame, as returned by Split, into a message
+	Decode(frame []byte) (Message, error)
+	// Split looks for a complete frame at the start of data. It returns the
+	// number of bytes consumed and the frame, or 0 and nil if more data is needed.
+	Split(data []byte) (advance int, frame []byte, err error)
+}
+
+// The built in codecs
+var (
+	JSON    Codec = jsonCodec{}
+	Msgpack Codec = msgpackCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(JSON)
+	RegisterCodec(Msgpack)
+}
+
+// RegisterCodec makes a codec available for negotiation
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// LookupCodec finds a registered codec by name
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// CodecNames lists all registered codecs in alphabetical order
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	var names []string
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// json codec, frames are bare objects
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return CodecJSON }
+
+func (jsonCodec) Encode(m Message) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (jsonCodec) Decode(data []byte) (Message, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, &DecodeError{Err: ErrMalformed, Msg: err.Error()}
+	}
+
+	rawType, ok := fields["type"]
+	if !ok {
+		return nil, &DecodeError{Field: "type", Err: ErrMissingField}
+	}
+	var msgType string
+	if err := json.Unmarshal(rawType, &msgType); err != nil {
+		return nil, &DecodeError{Field: "type", Err: ErrInvalidField, Msg: "must be a string"}
+	}
+
+	reg, err := lookup(msgType, func(key string) bool {
+		_, ok := fields[key]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := reg.factory()
+	if err := json.Unmarshal(data, m); err != nil {
+		de := &DecodeError{Type: msgType, Err: ErrInvalidField, Msg: err.Error()}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			de.Field = typeErr.Field
+			de.Msg = fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type)
+		}
+		return nil, de
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// finds the end of the first json object by tracking braces outside of strings
+func (jsonCodec) Split(data []byte) (int, []byte, error) {
+	start := bytes.IndexFunc(data, func(r rune) bool { return !unicode.IsSpace(r) })
+	if start < 0 {
+		return 0, nil, nil
+	}
+	if data[start] != '{' {
+		return 0, nil, &DecodeError{Err: ErrMalformed, Msg: fmt.Sprintf("frame starts with %q", data[start])}
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && b == '\\':
+			escaped = true
+		case b == '"':
+			inString = !inString
+		case inString:
+		case b == '{':
+			depth++
+		case b == '}':
+			depth--
+			if depth == 0 {
+				return i + 1, data[start : i+1], nil
+			}
+		}
+	}
+	if len(data)-start > MaxFrameSize {
+		return 0, nil, &DecodeError{Err: ErrMalformed, Msg: "frame exceeds MaxFrameSize"}
+	}
+	return 0, nil, nil
+}
+
+// msgpack codec, frames are length prefixed
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+
+func (msgpackCodec) Encode(m Message) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+
+	frame := buf.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	return frame, nil
+}
+
+func (msgpackCodec) Decode(data []byte) (Message, error) {
+	var fields map[string]msgpack.RawMessage
+	if err := msgpack.Unmarshal(data, &fields); err != nil {
+		return nil, &DecodeError{Err: ErrMalformed, Msg: err.Error()}
+	}
+
+	rawType, ok := fields["type"]
+	if !ok {
+		return nil, &DecodeError{Field: "type", Err: ErrMissingField}
+	}
+	var msgType string
+	if err := msgpack.Unmarshal(rawType, &msgType); err != nil {
+		return nil, &DecodeError{Field: "type", Err: ErrInvalidField, Msg: "must be a string"}
+	}
+
+	reg, err := lookup(msgType, func(key string) bool {
+		_, ok := fields[key]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := reg.factory()
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(m); err != nil {
+		return nil, &DecodeError{Type: msgType, Err: ErrInvalidField, Msg: err.Error()}
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (msgpackCodec) Split(data []byte) (int, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, nil
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size > MaxFrameSize {
+		return 0, nil, &DecodeError{Err: ErrMalformed, Msg: fmt.Sprintf("frame of %d bytes exceeds MaxFrameSize", size)}
+	}
+	if len(data) < 4+size {
+		return 0, nil, nil
+	}
+	return 4 + size, data[4 : 4+size], nil
+}
@@ -0,0 +1,473 @@
+// Package config loads the settings shared by the worker, client and test
+// harness. Values start from Default, then a JSON file named by -config or
+// CHESS_CONFIG, then CHESS_* environment variables, then command line
+// flags, each overriding the last.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/faultnet"
+)
+
+// environment variable naming the config file when -config isn't given
+const EnvConfigFile = "CHESS_CONFIG"
+
+// Config holds every setting, the json tags are the file's field names
+type Config struct {
+	Catalog Catalog `json:"catalog"`
+	Workers Workers `json:"workers"`
+	Worker  Worker  `json:"worker"`
+	Client  Client  `json:"client"`
+	Test    Test    `json:"test"`
+	Network Network `json:"network"`
+	Log     Log     `json:"log"`
+	TLS     TLS     `json:"tls"`
+
+	// file the config was read from, empty when none was used
+	Path string `json:"-"`
+}
+
+// Catalog is the discovery backend workers advertise to and clients query
+type Catalog struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Owner   string `json:"owner"` // owner field on worker advertisements
+}
+
+// Workers picks the workers a client uses. Base and count name them
+// <base>-00 to <base>-<count-1>, list names them (or gives host:port
+// addresses) outright, otherwise catalog workers are filtered on what they
+// advertise and ordered by rank before max is applied.
+type Workers struct {
+	Base        string            `json:"base"`
+	Count       int               `json:"count"`
+	List        []string          `json:"list"`
+	Prefix      string            `json:"prefix"`
+	Labels      map[string]string `json:"labels"`
+	MinCapacity int               `json:"min_capacity"`
+	Engine      string            `json:"engine"` // substring of the engine name
+	Net         string            `json:"net"`    // substring of the NNUE file name
+	MinThreads  int               `json:"min_threads"`
+	MinHash     int               `json:"min_hash"`
+	Protocol    int               `json:"protocol"` // 0 for any
+	MaxLoad     float64           `json:"max_load"` // 0 for no limit
+	Rank        string            `json:"rank"`
+	Max         int               `json:"max"`     // 0 for no limit
+	Refresh     Duration          `json:"refresh"` // catalog poll interval for workers joining and leaving, 0 disables
+}
+
+// Worker holds the settings for cmd/server
+type Worker struct {
+	Name          string            `json:"name"`
+	EnginePath    string            `json:"engine_path"`
+	AdminToken    string            `json:"admin_token"`
+	EngineOptions []string          `json:"engine_options"` // "name value" pinned for every game
+	StatusAddr    string            `json:"status_addr"`
+	Labels        map[string]string `json:"labels"`         // advertised for client selection
+	Capacity      int               `json:"capacity"`       // threads advertised, 0 for the CPU count
+	ShutdownGrace Duration          `json:"shutdown_grace"` // time the current job gets on SIGTERM or exit
+	RecordFile    string            `json:"record_file"`    // every message with clients, for pkg/recording
+}
+
+// Client holds the settings for cmd/client and the client side of cmd/test
+type Client struct {
+	TurnTime      Duration `json:"turn_time"`
+	LatencyBuffer Duration `json:"latency_buffer"`
+	EngineOptions []string `json:"engine_options"` // "name value" sent with every new_game
+	Codecs        []string `json:"codecs"`
+	TelemetryFile string   `json:"telemetry_file"`
+	TraceFile     string   `json:"trace_file"`
+	RecordFile    string   `json:"record_file"` // every message with workers, for pkg/recording
+	PGNFile       string   `json:"pgn_file"`    // cmd/client: finished and abandoned games are appended here
+	Resume        string   `json:"resume"`      // cmd/client: PGN, EPD or FEN file whose last game or position is played on from
+	Color         string   `json:"color"`       // cmd/client: the player's, white, black or random
+	Flip          bool     `json:"flip"`        // cmd/client: draw the board with the cluster's side at the bottom
+}
+
+// Test holds the settings for the cmd/test harness
+type Test struct {
+	Games      int    `json:"games"`
+	Threads    int    `json:"threads"`
+	EnginePath string `json:"engine_path"`  // local engine the cluster plays against
+	Tournament string `json:"tournament"`   // tournament spec, see pkg/tournament, replaces the settings above and workers
+	PGNFile    string `json:"pgn_file"`     // every finished game, overrides the tournament's
+	Openings   string `json:"openings"`     // EPD or PGN opening suite, overrides the tournament's
+	Order      string `json:"order"`        // of the openings, sequential or random
+	Seed       int64  `json:"seed"`         // for random order, 0 picks one
+	Summary    string `json:"summary_file"` // JSON standings and match statistics, overrides the tournament's
+	SPRT       SPRT   `json:"sprt"`         // overrides the tournament's when elo0 and elo1 are set
+
+	Adjudication Adjudication `json:"adjudication"` // rules set here override the tournament's
+}
+
+// Adjudication ends games early, see pkg/tournament, zero leaves a rule off
+type Adjudication struct {
+	MaxMoves        int    `json:"max_moves"`
+	ResignScore     int    `json:"resign_score"` // centipawns
+	ResignMoves     int    `json:"resign_moves"`
+	DrawScore       int    `json:"draw_score"` // centipawns
+	DrawMoves       int    `json:"draw_moves"`
+	DrawAfter       int    `json:"draw_after"`
+	TablebasePieces int    `json:"tablebase_pieces"`
+	TablebaseURL    string `json:"tablebase_url"`
+}
+
+// SPRT bounds a match between two engines, see pkg/tournament
+type SPRT struct {
+	Elo0  float64 `json:"elo0"`
+	Elo1  float64 `json:"elo1"`
+	Alpha float64 `json:"alpha"` // 0 for 0.05
+	Beta  float64 `json:"beta"`  // 0 for 0.05
+}
+
+// Enabled reports whether a test was asked for
+func (s SPRT) Enabled() bool {
+	return s.Elo0 != 0 || s.Elo1 != 0
+}
+
+// Network holds connection timeouts and buffer sizes
+type Network struct {
+	Wait         Duration `json:"wait"` // retry delay and handshake timeout
+	BufSize      int      `json:"buf_size"`
+	MaxFrameSize int      `json:"max_frame_size"`
+	Faults       string   `json:"faults"` // fault scenario for resilience testing, a JSON file or the JSON itself
+}
+
+// Log configures the stderr logger
+type Log struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// TLS holds the mutual TLS files, all empty disables TLS
+type TLS struct {
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	CAFile     string `json:"ca_file"`
+	ServerName string `json:"server_name"`
+}
+
+// Duration is a time.Duration written as a string such as "250ms" in files
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("expected a duration string such as \"250ms\", got %s", data)
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Catalog: Catalog{Address: common.CatalogAddr, Port: common.CatalogPort, Owner: "rnahm"},
+		Worker:  Worker{EnginePath: "bin/stockfish", ShutdownGrace: Duration{10 * time.Second}},
+		Client: Client{
+			TurnTime:      Duration{time.Second},
+			LatencyBuffer: Duration{50 * time.Millisecond},
+			Codecs:        common.Codecs,
+			Color:         "white",
+		},
+		Test:    Test{Games: 10, Threads: 1, EnginePath: "bin/stockfish"},
+		Network: Network{Wait: Duration{common.Wait}, BufSize: common.BufSize, MaxFrameSize: common.MaxFrameSize},
+	}
+}
+
+// LoadFile overlays the JSON file at path, unknown fields are errors so typos surface
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(c)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("%s:%d: %w", path, line(data, syntaxErr.Offset), err)
+		} else if errors.As(err, &typeErr) {
+			return fmt.Errorf("%s:%d: %s must be %s", path, line(data, typeErr.Offset), typeErr.Field, typeErr.Type)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	c.Path = path
+	return nil
+}
+
+// line number of a byte offset, for pointing at the bad spot in a file
+func line(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return strings.Count(string(data[:offset]), "\n") + 1
+}
+
+// Validate checks the settings the role uses and reports every problem at once
+func (c *Config) Validate(role Role) error {
+	var errs []error
+	bad := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Catalog.Address == "" {
+		bad("catalog.address", "must not be empty")
+	}
+	if c.Catalog.Port <= 0 || c.Catalog.Port > 65535 {
+		bad("catalog.port", "must be between 1 and 65535, got %d", c.Catalog.Port)
+	}
+	if c.Network.Wait.Duration <= 0 {
+		bad("network.wait", "must be positive, got %s", c.Network.Wait)
+	}
+	if c.Network.BufSize <= 0 {
+		bad("network.buf_size", "must be positive, got %d", c.Network.BufSize)
+	}
+	if c.Network.MaxFrameSize < c.Network.BufSize {
+		bad("network.max_frame_size", "must be at least network.buf_size (%d), got %d", c.Network.BufSize, c.Network.MaxFrameSize)
+	}
+	if _, err := c.Faults(); err != nil {
+		bad("network.faults", "%s", err)
+	}
+	if _, err := common.NewLogger(os.Stderr, c.Log.Level, c.Log.Format); err != nil {
+		bad("log", "%s", err)
+	}
+	tls := c.TLSConfig()
+	if tls.Enabled() && (tls.CertFile == "" || tls.KeyFile == "" || tls.CAFile == "") {
+		bad("tls", "cert_file, key_file and ca_file must all be set to enable TLS")
+	}
+
+	if role&RoleWorker != 0 {
+		if c.Worker.Name == "" {
+			bad("worker.name", "must not be empty")
+		}
+		if c.Worker.EnginePath == "" {
+			bad("worker.engine_path", "must not be empty")
+		}
+		if c.Catalog.Owner == "" {
+			bad("catalog.owner", "must not be empty")
+		}
+		checkOptions("worker.engine_options", c.Worker.EngineOptions, bad)
+		if c.Worker.Capacity < 0 {
+			bad("worker.capacity", "must not be negative, got %d", c.Worker.Capacity)
+		}
+		if c.Worker.ShutdownGrace.Duration <= 0 {
+			bad("worker.shutdown_grace", "must be positive, got %s", c.Worker.ShutdownGrace)
+		}
+	}
+
+	if role&(RoleClient|RoleTest) != 0 {
+		w := c.Workers
+		// a tournament names the workers of each of its clusters
+		if !w.Picked() && (role&RoleClient != 0 || c.Test.Tournament == "") {
+			bad("workers", "set base and count, list, prefix or labels to pick workers")
+		}
+		if w.Base != "" && w.Count <= 0 {
+			bad("workers.count", "must be at least 1 with workers.base, got %d", w.Count)
+		}
+		if w.Base != "" && len(w.List) > 0 {
+			bad("workers", "base and list can't both be set")
+		}
+		if w.MinCapacity < 0 {
+			bad("workers.min_capacity", "must not be negative, got %d", w.MinCapacity)
+		}
+		if w.Max < 0 {
+			bad("workers.max", "must not be negative, got %d", w.Max)
+		}
+		if w.MinThreads < 0 || w.MinHash < 0 || w.Protocol < 0 || w.MaxLoad < 0 {
+			bad("workers", "min_threads, min_hash, protocol and max_load must not be negative")
+		}
+		if w.Rank != "" && !contains(client.Ranks(), w.Rank) {
+			bad("workers.rank", "unknown rank %q, expected one of %s", w.Rank, strings.Join(client.Ranks(), ", "))
+		}
+		if w.Refresh.Duration < 0 {
+			bad("workers.refresh", "must not be negative, got %s", w.Refresh)
+		}
+		if c.Client.LatencyBuffer.Duration < 0 {
+			bad("client.latency_buffer", "must not be negative, got %s", c.Client.LatencyBuffer)
+		}
+		if c.Client.TurnTime.Duration <= c.Client.LatencyBuffer.Duration {
+			bad("client.turn_time", "must be longer than client.latency_buffer (%s), got %s", c.Client.LatencyBuffer, c.Client.TurnTime)
+		}
+		if len(c.Client.Codecs) == 0 {
+			bad("client.codecs", "must name at least one codec, known: %s", strings.Join(common.CodecNames(), ", "))
+		}
+		for _, name := range c.Client.Codecs {
+			if _, ok := common.LookupCodec(name); !ok {
+				bad("client.codecs", "unknown codec %q, known: %s", name, strings.Join(common.CodecNames(), ", "))
+			}
+		}
+		checkOptions("client.engine_options", c.Client.EngineOptions, bad)
+	}
+	if role&RoleClient != 0 && !contains([]string{"white", "black", "random"}, c.Client.Color) {
+		bad("client.color", "must be white, black or random, got %q", c.Client.Color)
+	}
+
+	if role&RoleTest != 0 {
+		if c.Test.Games <= 0 {
+			bad("test.games", "must be at least 1, got %d", c.Test.Games)
+		}
+		if c.Test.Threads <= 0 {
+			bad("test.threads", "must be at least 1, got %d", c.Test.Threads)
+		}
+		if c.Test.EnginePath == "" {
+			bad("test.engine_path", "must not be empty")
+		}
+		if c.Test.Order != "" && c.Test.Order != "sequential" && c.Test.Order != "random" {
+			bad("test.order", "must be sequential or random, got %q", c.Test.Order)
+		}
+		if t := c.Test.SPRT; t.Enabled() && t.Elo0 >= t.Elo1 {
+			bad("test.sprt", "elo0 must be below elo1, got %v and %v", t.Elo0, t.Elo1)
+		}
+		a := c.Test.Adjudication
+		for _, f := range []struct {
+			name  string
+			value int
+		}{
+			{"max_moves", a.MaxMoves}, {"resign_score", a.ResignScore}, {"resign_moves", a.ResignMoves},
+			{"draw_score", a.DrawScore}, {"draw_moves", a.DrawMoves}, {"draw_after", a.DrawAfter},
+			{"tablebase_pieces", a.TablebasePieces},
+		} {
+			if f.value < 0 {
+				bad("test.adjudication."+f.name, "must not be negative, got %d", f.value)
+			}
+		}
+		if t := c.Test.SPRT; t.Alpha < 0 || t.Alpha >= 0.5 || t.Beta < 0 || t.Beta >= 0.5 {
+			bad("test.sprt", "alpha and beta must be between 0 and 0.5, got %v and %v", t.Alpha, t.Beta)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if c.Path != "" {
+		return fmt.Errorf("invalid config (%s):\n%w", c.Path, errors.Join(errs...))
+	}
+	return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+}
+
+// engine options are "name value" or a bare "name" for buttons
+func checkOptions(field string, options []string, bad func(string, string, ...interface{})) {
+	for _, option := range options {
+		n := len(strings.Fields(option))
+		if n == 0 || n > 2 {
+			bad(field, "%q must be \"name value\" or \"name\"", option)
+		}
+	}
+}
+
+// Apply copies the network and catalog settings into the common package
+func (c *Config) Apply() {
+	common.CatalogAddr = c.Catalog.Address
+	common.CatalogPort = c.Catalog.Port
+	common.Wait = c.Network.Wait.Duration
+	common.BufSize = c.Network.BufSize
+	common.MaxFrameSize = c.Network.MaxFrameSize
+	common.Codecs = c.Client.Codecs
+}
+
+// Logger builds the stderr logger described by the log section
+func (c *Config) Logger() (*slog.Logger, error) {
+	return common.NewLogger(os.Stderr, c.Log.Level, c.Log.Format)
+}
+
+// Faults loads network.faults, nil when no scenario is set
+func (c *Config) Faults() (*faultnet.Scenario, error) {
+	if c.Network.Faults == "" {
+		return nil, nil
+	}
+	return faultnet.LoadScenario(c.Network.Faults)
+}
+
+// TLSConfig converts the tls section for UseTLS
+func (c *Config) TLSConfig() common.TLSConfig {
+	return common.TLSConfig{
+		CertFile:   c.TLS.CertFile,
+		KeyFile:    c.TLS.KeyFile,
+		CAFile:     c.TLS.CAFile,
+		ServerName: c.TLS.ServerName,
+	}
+}
+
+// UCIOptions converts client.engine_options for client.NewGame
+func (c *Config) UCIOptions() []uci.CmdSetOption {
+	return ParseOptions(c.Client.EngineOptions)
+}
+
+// ParseOptions converts "name value" engine options, a bare "name" is a button
+func ParseOptions(options []string) []uci.CmdSetOption {
+	var parsed []uci.CmdSetOption
+	for _, option := range options {
+		f := strings.Fields(option)
+		if len(f) == 0 {
+			continue
+		}
+		o := uci.CmdSetOption{Name: f[0]}
+		if len(f) == 2 {
+			o.Value = f[1]
+		}
+		parsed = append(parsed, o)
+	}
+	return parsed
+}
+
+// Selector converts the workers section for client.New
+func (c *Config) Selector() client.Selector {
+	return c.Workers.Selector()
+}
+
+// Selector converts the section for client.New
+func (w Workers) Selector() client.Selector {
+	if w.Base != "" {
+		sel := client.NamedWorkers(w.Base, w.Count)
+		sel.Max = w.Max
+		return sel
+	}
+	return client.Selector{
+		Workers:     w.List,
+		Prefix:      w.Prefix,
+		Labels:      w.Labels,
+		MinCapacity: w.MinCapacity,
+		Engine:      w.Engine,
+		Net:         w.Net,
+		MinThreads:  w.MinThreads,
+		MinHash:     w.MinHash,
+		Protocol:    w.Protocol,
+		MaxLoad:     w.MaxLoad,
+		Rank:        w.Rank,
+		Max:         w.Max,
+	}
+}
+
+// Picked reports whether the section picks any workers at all
+func (w Workers) Picked() bool {
+	return w.Base != "" || len(w.List) > 0 || w.Prefix != "" || len(w.Labels) > 0
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
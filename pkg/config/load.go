@@ -0,0 +1,232 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Role selects which settings a command uses, for flags and validation
+type Role int
+
+const (
+	RoleWorker Role = 1 << iota
+	RoleClient
+	RoleTest
+)
+
+const roleAll = RoleWorker | RoleClient | RoleTest
+
+// a setting that can come from a flag and/or an environment variable
+type field struct {
+	flag  string // empty for settings that shouldn't appear on the command line
+	env   string
+	usage string
+	roles Role
+	ptr   func(c *Config) interface{}
+}
+
+var fields = []field{
+	{"catalog-addr", "CHESS_CATALOG_ADDR", "catalog server host", roleAll, func(c *Config) interface{} { return &c.Catalog.Address }},
+	{"catalog-port", "CHESS_CATALOG_PORT", "catalog server port", roleAll, func(c *Config) interface{} { return &c.Catalog.Port }},
+	{"catalog-owner", "CHESS_CATALOG_OWNER", "owner advertised to the catalog", RoleWorker, func(c *Config) interface{} { return &c.Catalog.Owner }},
+
+	{"name", "CHESS_WORKER_NAME", "worker name advertised to the catalog", RoleWorker, func(c *Config) interface{} { return &c.Worker.Name }},
+	{"engine", "CHESS_ENGINE", "path to the UCI engine", RoleWorker, func(c *Config) interface{} { return &c.Worker.EnginePath }},
+	{"engine-options", "CHESS_ENGINE_OPTIONS", "comma separated \"name value\" engine options pinned for every game", RoleWorker, func(c *Config) interface{} { return &c.Worker.EngineOptions }},
+	{"", common.EnvAdminToken, "", RoleWorker, func(c *Config) interface{} { return &c.Worker.AdminToken }},
+	{"labels", "CHESS_WORKER_LABELS", "comma separated key=value labels advertised to the catalog", RoleWorker, func(c *Config) interface{} { return &c.Worker.Labels }},
+	{"capacity", "CHESS_WORKER_CAPACITY", "search threads advertised to the catalog, 0 for the CPU count", RoleWorker, func(c *Config) interface{} { return &c.Worker.Capacity }},
+	{"shutdown-grace", "CHESS_SHUTDOWN_GRACE", "time the current job gets to finish on SIGTERM, SIGINT or exit", RoleWorker, func(c *Config) interface{} { return &c.Worker.ShutdownGrace }},
+	{"record", "CHESS_RECORD_FILE", "file to record every message with clients to, for cmd/replay", RoleWorker, func(c *Config) interface{} { return &c.Worker.RecordFile }},
+	{"status-addr", common.EnvStatusAddr, "address for the /healthz, /status and /metrics listener", RoleWorker, func(c *Config) interface{} { return &c.Worker.StatusAddr }},
+
+	{"workers-base", "CHESS_WORKERS_BASE", "base worker name, workers are <base>-NN", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Base }},
+	{"workers-count", "CHESS_WORKERS_COUNT", "number of workers", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Count }},
+	{"workers", "CHESS_WORKERS", "comma separated worker names or host:port addresses", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.List }},
+	{"workers-prefix", "CHESS_WORKERS_PREFIX", "use catalog workers whose name starts with this", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Prefix }},
+	{"workers-labels", "CHESS_WORKERS_LABELS", "comma separated key=value labels catalog workers must carry", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Labels }},
+	{"workers-min-capacity", "CHESS_WORKERS_MIN_CAPACITY", "minimum advertised threads for catalog workers", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.MinCapacity }},
+	{"workers-engine", "CHESS_WORKERS_ENGINE", "catalog workers whose engine name contains this", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Engine }},
+	{"workers-net", "CHESS_WORKERS_NET", "catalog workers whose NNUE file name contains this", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Net }},
+	{"workers-min-threads", "CHESS_WORKERS_MIN_THREADS", "minimum engine threads for catalog workers", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.MinThreads }},
+	{"workers-min-hash", "CHESS_WORKERS_MIN_HASH", "minimum hash size in MB for catalog workers", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.MinHash }},
+	{"workers-protocol", "CHESS_WORKERS_PROTOCOL", "protocol version catalog workers must speak, 0 for any", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Protocol }},
+	{"workers-max-load", "CHESS_WORKERS_MAX_LOAD", "highest load per CPU for catalog workers, 0 for no limit", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.MaxLoad }},
+	{"workers-rank", "CHESS_WORKERS_RANK", "order catalog workers by name, load, capacity, threads or hash", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Rank }},
+	{"workers-max", "CHESS_WORKERS_MAX", "use at most this many workers, 0 for no limit", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Max }},
+	{"workers-refresh", "CHESS_WORKERS_REFRESH", "how often to poll the catalog for workers joining and leaving, 0 disables", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Workers.Refresh }},
+	{"turn-time", "CHESS_TURN_TIME", "time per turn", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.TurnTime }},
+	{"latency-buffer", "CHESS_LATENCY_BUFFER", "time reserved for results to travel back", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.LatencyBuffer }},
+	{"uci-options", "CHESS_UCI_OPTIONS", "comma separated \"name value\" options sent with every new game", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.EngineOptions }},
+	{"codecs", "CHESS_CODECS", "comma separated wire formats to offer, in order of preference", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.Codecs }},
+	{"telemetry", common.EnvTelemetryFile, "JSON Lines file for per-turn telemetry", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.TelemetryFile }},
+	{"record", "CHESS_RECORD_FILE", "file to record every message with workers to, for cmd/replay", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.RecordFile }},
+	{"pgn", "CHESS_PGN_FILE", "file to append the game to as PGN, even when it's left unfinished", RoleClient, func(c *Config) interface{} { return &c.Client.PGNFile }},
+	{"resume", "CHESS_RESUME", "PGN, EPD or FEN file to play on from, the last game or position in it", RoleClient, func(c *Config) interface{} { return &c.Client.Resume }},
+	{"color", "CHESS_COLOR", "the color you play, white, black or random", RoleClient, func(c *Config) interface{} { return &c.Client.Color }},
+	{"flip", "CHESS_FLIP", "draw the board with the cluster's side at the bottom", RoleClient, func(c *Config) interface{} { return &c.Client.Flip }},
+	{"trace", common.EnvTraceFile, "file for OTLP JSON trace spans", RoleClient | RoleTest, func(c *Config) interface{} { return &c.Client.TraceFile }},
+
+	{"games", "CHESS_GAMES", "number of games to play", RoleTest, func(c *Config) interface{} { return &c.Test.Games }},
+	{"threads", "CHESS_THREADS", "engine threads on each side", RoleTest, func(c *Config) interface{} { return &c.Test.Threads }},
+	{"local-engine", "CHESS_LOCAL_ENGINE", "path to the local engine the cluster plays", RoleTest, func(c *Config) interface{} { return &c.Test.EnginePath }},
+	{"tournament", "CHESS_TOURNAMENT", "tournament spec file, replaces -games, -threads, -local-engine and the workers flags", RoleTest, func(c *Config) interface{} { return &c.Test.Tournament }},
+	{"pgn", "CHESS_PGN_FILE", "file to write every finished game to as PGN", RoleTest, func(c *Config) interface{} { return &c.Test.PGNFile }},
+	{"openings", "CHESS_OPENINGS", "EPD or PGN opening suite, each opening is played with both colors", RoleTest, func(c *Config) interface{} { return &c.Test.Openings }},
+	{"order", "CHESS_OPENINGS_ORDER", "order of the openings, sequential or random", RoleTest, func(c *Config) interface{} { return &c.Test.Order }},
+	{"seed", "CHESS_OPENINGS_SEED", "seed for a random order of openings, 0 picks one", RoleTest, func(c *Config) interface{} { return &c.Test.Seed }},
+	{"summary", "CHESS_SUMMARY_FILE", "file to write the standings and match statistics to as JSON", RoleTest, func(c *Config) interface{} { return &c.Test.Summary }},
+	{"max-moves", "CHESS_MAX_MOVES", "full moves before a game is drawn, 0 for no limit", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.MaxMoves }},
+	{"resign-score", "CHESS_RESIGN_SCORE", "centipawns both engines must agree one side is ahead by to adjudicate a win", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.ResignScore }},
+	{"resign-moves", "CHESS_RESIGN_MOVES", "moves in a row both engines must agree on -resign-score for, 0 disables", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.ResignMoves }},
+	{"draw-score", "CHESS_DRAW_SCORE", "centipawns either side of zero both engines must stay within to adjudicate a draw", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.DrawScore }},
+	{"draw-moves", "CHESS_DRAW_MOVES", "moves in a row both engines must stay within -draw-score for, 0 disables", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.DrawMoves }},
+	{"draw-after", "CHESS_DRAW_AFTER", "full moves played before draws are adjudicated", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.DrawAfter }},
+	{"tablebase-pieces", "CHESS_TABLEBASE_PIECES", "look up positions with this many pieces or fewer in the tablebase, 0 disables", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.TablebasePieces }},
+	{"tablebase-url", "CHESS_TABLEBASE_URL", "lichess tablebase API to look positions up with", RoleTest, func(c *Config) interface{} { return &c.Test.Adjudication.TablebaseURL }},
+	{"sprt-elo0", "CHESS_SPRT_ELO0", "SPRT null hypothesis, the Elo difference the match stops at when it's accepted", RoleTest, func(c *Config) interface{} { return &c.Test.SPRT.Elo0 }},
+	{"sprt-elo1", "CHESS_SPRT_ELO1", "SPRT alternative hypothesis, above -sprt-elo0", RoleTest, func(c *Config) interface{} { return &c.Test.SPRT.Elo1 }},
+	{"sprt-alpha", "CHESS_SPRT_ALPHA", "SPRT false positive rate, 0 for 0.05", RoleTest, func(c *Config) interface{} { return &c.Test.SPRT.Alpha }},
+	{"sprt-beta", "CHESS_SPRT_BETA", "SPRT false negative rate, 0 for 0.05", RoleTest, func(c *Config) interface{} { return &c.Test.SPRT.Beta }},
+
+	{"wait", "CHESS_WAIT", "retry delay and handshake timeout", roleAll, func(c *Config) interface{} { return &c.Network.Wait }},
+	{"faults", "CHESS_FAULTS", "JSON fault scenario, or a file holding one, injected into worker connections", roleAll, func(c *Config) interface{} { return &c.Network.Faults }},
+	{"log-level", common.EnvLogLevel, "debug, info, warn or error", roleAll, func(c *Config) interface{} { return &c.Log.Level }},
+	{"log-format", common.EnvLogFormat, "text or json", roleAll, func(c *Config) interface{} { return &c.Log.Format }},
+	{"tls-cert", common.EnvTLSCert, "PEM certificate for mutual TLS", roleAll, func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{"tls-key", common.EnvTLSKey, "PEM key for -tls-cert", roleAll, func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{"tls-ca", common.EnvTLSCA, "PEM CA bundle trusted for peers", roleAll, func(c *Config) interface{} { return &c.TLS.CAFile }},
+	{"tls-server-name", common.EnvTLSServerName, "name expected in worker certificates", RoleClient | RoleTest, func(c *Config) interface{} { return &c.TLS.ServerName }},
+}
+
+// Load parses flags from args for the role, then builds the config from the
+// defaults, the config file, the environment and the flags that were set.
+// Positional arguments left after the flags are returned for the caller.
+// The result isn't validated so callers can fold in positional arguments first.
+func Load(role Role, fs *flag.FlagSet, args []string) (Config, []string, error) {
+	path := fs.String("config", os.Getenv(EnvConfigFile), "JSON config file (env "+EnvConfigFile+")")
+
+	// flags are kept in order and applied last so they win over the file and env
+	type setting struct {
+		f     field
+		value string
+	}
+	var set []setting
+	for _, f := range fields {
+		if f.flag == "" || f.roles&role == 0 {
+			continue
+		}
+		f := f
+		parse := func(value string) error {
+			var scratch Config
+			err := assign(f.ptr(&scratch), value)
+			if err != nil {
+				return err
+			}
+			set = append(set, setting{f, value})
+			return nil
+		}
+		usage := fmt.Sprintf("%s (env %s)", f.usage, f.env)
+		// switches work without a value, -flip as well as -flip=false
+		if _, ok := f.ptr(&Config{}).(*bool); ok {
+			fs.BoolFunc(f.flag, usage, parse)
+		} else {
+			fs.Func(f.flag, usage, parse)
+		}
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		err = cfg.LoadFile(*path)
+		if err != nil {
+			return Config{}, nil, err
+		}
+	}
+	for _, f := range fields {
+		if f.roles&role == 0 {
+			continue
+		}
+		value, ok := os.LookupEnv(f.env)
+		if !ok || value == "" {
+			continue
+		}
+		err = assign(f.ptr(&cfg), value)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("%s: %w", f.env, err)
+		}
+	}
+	for _, s := range set {
+		assign(s.f.ptr(&cfg), s.value)
+	}
+	return cfg, fs.Args(), nil
+}
+
+// sets a field from its string form
+func assign(ptr interface{}, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		*p = b
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		*p = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		*p = f
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as \"250ms\", got %q", value)
+		}
+		p.Duration = d
+	case *[]string:
+		*p = nil
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+	case *map[string]string:
+		*p = map[string]string{}
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(s, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", s)
+			}
+			(*p)[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", ptr))
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package config_test
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/config"
+)
+
+// loads args for role with a fresh flag set
+func load(t *testing.T, role config.Role, args ...string) (config.Config, []string, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return config.Load(role, fs, args)
+}
+
+// writes a config file and returns its path
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"workers": {"base": "file", "count": 2},
+		"client": {"turn_time": "2s", "latency_buffer": "100ms", "color": "black"}
+	}`)
+	t.Setenv(config.EnvConfigFile, path)
+	t.Setenv("CHESS_TURN_TIME", "3s")
+	t.Setenv("CHESS_WORKERS_COUNT", "4")
+	t.Setenv("CHESS_COLOR", "")
+
+	cfg, rest, err := load(t, config.RoleClient, "-turn-time", "4s", "-workers-max=3", "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"default", cfg.Catalog.Port, config.Default().Catalog.Port},
+		{"file", cfg.Workers.Base, "file"},
+		{"file under env", cfg.Client.LatencyBuffer.Duration, 100 * time.Millisecond},
+		{"empty env ignored", cfg.Client.Color, "black"},
+		{"env over file", cfg.Workers.Count, 4},
+		{"flag over env", cfg.Client.TurnTime.Duration, 4 * time.Second},
+		{"flag alone", cfg.Workers.Max, 3},
+		{"path", cfg.Path, path},
+		{"positional", rest, []string{"extra"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, tt.got)
+		}
+	}
+
+	// -config wins over CHESS_CONFIG
+	other := writeConfig(t, `{"workers": {"base": "flag"}}`)
+	cfg, _, err = load(t, config.RoleClient, "-config", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers.Base != "flag" || cfg.Path != other {
+		t.Fatalf("want the -config file, got base %q from %s", cfg.Workers.Base, cfg.Path)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  [2]string
+		args []string
+		want string
+	}{
+		{"bad duration flag", "", [2]string{}, []string{"-turn-time", "5"}, `expected a duration such as "250ms", got "5"`},
+		{"bad int flag", "", [2]string{}, []string{"-workers-count=many"}, `expected an integer, got "many"`},
+		{"bad labels flag", "", [2]string{}, []string{"-workers-labels", "gpu"}, `expected key=value, got "gpu"`},
+		{"flag for another role", "", [2]string{}, []string{"-shutdown-grace", "1s"}, "flag provided but not defined"},
+		{"bad env", "", [2]string{"CHESS_WORKERS_COUNT", "x"}, nil, `CHESS_WORKERS_COUNT: expected an integer, got "x"`},
+		{"unknown field", `{"client": {"turn": "1s"}}`, [2]string{}, nil, `unknown field "turn"`},
+		{"wrong type", "{\n\"workers\": {\n\"count\": \"two\"}}", [2]string{}, nil, "config.json:3: workers.count must be int"},
+		{"syntax", "{\n\n\"workers\": }", [2]string{}, nil, "config.json:3: "},
+		{"duration as a number", `{"client": {"turn_time": 250}}`, [2]string{}, nil, `expected a duration string such as "250ms", got 250`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+			if tt.env[0] != "" {
+				t.Setenv(tt.env[0], tt.env[1])
+			}
+			_, _, err := load(t, config.RoleClient, args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("want an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	var d config.Duration
+	err := json.Unmarshal([]byte(`"1m30s"`), &d)
+	if err != nil || d.Duration != 90*time.Second {
+		t.Fatalf("want 1m30s, got %s: %v", d, err)
+	}
+	data, err := json.Marshal(config.Duration{Duration: 250 * time.Millisecond})
+	if err != nil || string(data) != `"250ms"` {
+		t.Fatalf(`want "250ms", got %s: %v`, data, err)
+	}
+	if err := json.Unmarshal([]byte(`"fast"`), &d); err == nil {
+		t.Fatal("parsed a duration from fast")
+	}
+}
+
+func TestFlip(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want bool
+		rest []string
+	}{
+		{"default", "", nil, false, nil},
+		{"switch", "", []string{"-flip"}, true, nil},
+		{"switch takes no value", "", []string{"-flip", "false"}, true, []string{"false"}},
+		{"false", "", []string{"-flip=false"}, false, nil},
+		{"env", "true", nil, true, nil},
+		{"flag over env", "true", []string{"-flip=false"}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHESS_FLIP", tt.env)
+			cfg, rest, err := load(t, config.RoleClient, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Client.Flip != tt.want || strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+				t.Fatalf("want flip %v and %q left, got %v and %q", tt.want, tt.rest, cfg.Client.Flip, rest)
+			}
+		})
+	}
+
+	if _, _, err := load(t, config.RoleClient, "-flip=maybe"); err == nil {
+		t.Fatal("-flip=maybe was accepted")
+	}
+	t.Setenv("CHESS_FLIP", "maybe")
+	if _, _, err := load(t, config.RoleClient); err == nil || !strings.Contains(err.Error(), "CHESS_FLIP: expected true or false") {
+		t.Fatalf("want CHESS_FLIP=maybe refused, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() config.Config {
+		c := config.Default()
+		c.Worker.Name = "w"
+		c.Workers.Base, c.Workers.Count = "w", 2
+		return c
+	}
+	for _, role := range []config.Role{config.RoleWorker, config.RoleClient, config.RoleTest} {
+		c := valid()
+		if err := c.Validate(role); err != nil {
+			t.Fatalf("role %d: %v", role, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		role   config.Role
+		change func(*config.Config)
+		want   string
+	}{
+		{"port", config.RoleWorker, func(c *config.Config) { c.Catalog.Port = 70000 }, "catalog.port: must be between 1 and 65535, got 70000"},
+		{"worker name", config.RoleWorker, func(c *config.Config) { c.Worker.Name = "" }, "worker.name: must not be empty"},
+		{"engine option", config.RoleWorker, func(c *config.Config) { c.Worker.EngineOptions = []string{"a b c"} }, `worker.engine_options: "a b c" must be "name value" or "name"`},
+		{"grace", config.RoleWorker, func(c *config.Config) { c.Worker.ShutdownGrace.Duration = 0 }, "worker.shutdown_grace: must be positive, got 0s"},
+		{"no workers", config.RoleClient, func(c *config.Config) { c.Workers = config.Workers{} }, "workers: set base and count, list, prefix or labels to pick workers"},
+		{"base and list", config.RoleClient, func(c *config.Config) { c.Workers.List = []string{"x"} }, "workers: base and list can't both be set"},
+		{"rank", config.RoleClient, func(c *config.Config) { c.Workers.Rank = "speed" }, `workers.rank: unknown rank "speed"`},
+		{"turn time", config.RoleClient, func(c *config.Config) { c.Client.TurnTime.Duration = 10 * time.Millisecond }, "client.turn_time: must be longer than client.latency_buffer (50ms), got 10ms"},
+		{"codec", config.RoleClient, func(c *config.Config) { c.Client.Codecs = []string{"xml"} }, `client.codecs: unknown codec "xml"`},
+		{"color", config.RoleClient, func(c *config.Config) { c.Client.Color = "red" }, `client.color: must be white, black or random, got "red"`},
+		{"games", config.RoleTest, func(c *config.Config) { c.Test.Games = 0 }, "test.games: must be at least 1, got 0"},
+		{"adjudication", config.RoleTest, func(c *config.Config) { c.Test.Adjudication.DrawMoves = -1 }, "test.adjudication.draw_moves: must not be negative, got -1"},
+		{"sprt", config.RoleTest, func(c *config.Config) { c.Test.SPRT.Elo0, c.Test.SPRT.Elo1 = 5, 0 }, "test.sprt: elo0 must be below elo1, got 5 and 0"},
+		{"tls", config.RoleTest, func(c *config.Config) { c.TLS.CertFile = "cert.pem" }, "tls: cert_file, key_file and ca_file must all be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(&c)
+			err := c.Validate(tt.role)
+			if err == nil || !strings.Contains(err.Error(), "\n"+tt.want) {
+				t.Fatalf("want %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// settings of other roles aren't checked
+	c := valid()
+	c.Worker.Name = ""
+	if err := c.Validate(config.RoleClient); err != nil {
+		t.Fatalf("a client checked worker settings: %v", err)
+	}
+
+	// every problem is reported at once, naming the file
+	c = valid()
+	c.Path = "cluster.json"
+	c.Test.Games, c.Test.Threads = 0, 0
+	err := c.Validate(config.RoleTest)
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid config (cluster.json):\n") || strings.Count(err.Error(), "\n") != 2 {
+		t.Fatalf("want both problems under the file name, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package fakeengine
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/notnil/chess/uci"
+)
+
+// Engine runs a script inside the calling process. It has the same methods
+// as *uci.Engine, so it can stand in wherever the worker expects one.
+// Like uci.Engine every command but stop waits for the one before it.
+type Engine struct {
+	state *state
+
+	run    sync.Mutex // held while a command other than stop runs
+	mu     sync.Mutex // guards the fields below
+	stop   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+
+	results uci.SearchResults
+}
+
+// errClosed is returned by commands sent after Close
+var errClosed = errors.New("fake engine closed")
+
+// New returns an engine playing script
+func New(script Script) *Engine {
+	return &Engine{state: newState(script), closed: make(chan struct{})}
+}
+
+// Run runs the commands in order, stopping at the first error
+func (e *Engine) Run(cmds ...uci.Cmd) error {
+	for _, cmd := range cmds {
+		if cmd.String() == uci.CmdStop.String() {
+			e.interrupt()
+			continue
+		}
+		err := e.runLocked(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) runLocked(cmd uci.Cmd) error {
+	e.run.Lock()
+	defer e.run.Unlock()
+
+	select {
+	case <-e.closed:
+		return errClosed
+	default:
+	}
+	e.state.mu.Lock()
+	crashed, hung := e.state.crashed, e.state.hung
+	e.state.mu.Unlock()
+	if crashed {
+		return ErrCrashed
+	} else if hung {
+		// nothing is answered again, the caller is stuck until Close
+		<-e.closed
+		return errClosed
+	}
+
+	switch c := cmd.(type) {
+	case uci.CmdSetOption:
+		e.state.setOption(c.Name, c.Value)
+	case uci.CmdPosition:
+		var moves []string
+		for _, m := range c.Moves {
+			moves = append(moves, m.String())
+		}
+		return e.state.setPosition(c.Position, moves)
+	case uci.CmdGo:
+		return e.search(c)
+	default:
+		if cmd.String() == uci.CmdUCINewGame.String() {
+			return e.state.setPosition(nil, nil)
+		}
+		// uci, isready, ponderhit and quit need no answer here
+	}
+	return nil
+}
+
+func (e *Engine) search(cmd uci.CmdGo) error {
+	switch e.state.next() {
+	case fateCrash:
+		return ErrCrashed
+	case fateHang:
+		<-e.closed
+		return errClosed
+	}
+
+	var candidates []string
+	for _, m := range cmd.SearchMoves {
+		candidates = append(candidates, m.String())
+	}
+	stop := make(chan struct{})
+	e.mu.Lock()
+	e.stop = stop
+	e.mu.Unlock()
+	results, err := e.state.play(candidates, stop)
+	e.mu.Lock()
+	e.stop = nil
+	if err == nil {
+		e.results = results
+	}
+	e.mu.Unlock()
+	return err
+}
+
+// ends the search in progress, if there is one
+func (e *Engine) interrupt() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+}
+
+// SearchResults returns the results of the most recent search
+func (e *Engine) SearchResults() uci.SearchResults {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.results
+}
+
+// ID returns what the engine reports after uci
+func (e *Engine) ID() map[string]string {
+	return map[string]string{"name": e.state.script.name(), "author": "fakeengine"}
+}
+
+// Options returns the options offered, with the script's defaults
+func (e *Engine) Options() map[string]uci.Option {
+	options := map[string]uci.Option{}
+	for name, value := range e.state.script.defaults() {
+		options[name] = option(name, value)
+	}
+	return options
+}
+
+// Values returns the current value of every option, for tests to check
+// what they were set to
+func (e *Engine) Values() map[string]string {
+	return e.state.values()
+}
+
+// Close releases any command stuck in a hang, later commands fail
+func (e *Engine) Close() error {
+	e.once.Do(func() {
+		close(e.closed)
+		e.interrupt()
+	})
+	return nil
+}
+
+// Threads and Hash are spins like Stockfish's, anything else a string
+func option(name string, value string) uci.Option {
+	if name == "Threads" || name == "Hash" {
+		return uci.Option{Name: name, Type: uci.OptionSpin, Default: value, Min: "1", Max: "1024"}
+	}
+	return uci.Option{Name: name, Type: uci.OptionString, Default: value}
+}
@@ -0,0 +1,90 @@
+package fakeengine
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+)
+
+func TestServe(t *testing.T) {
+	script := Script{Name: "Scripted", Moves: []string{"e7e5"}, Score: -20, Depth: 7, Nodes: 99}
+	in := strings.Join([]string{
+		"uci",
+		"isready",
+		"setoption name Hash value 64",
+		"position startpos moves e2e4",
+		"go movetime 10 searchmoves e7e5 c7c5",
+		"quit",
+	}, "\n")
+	var out strings.Builder
+	err := Serve(script, strings.NewReader(in), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"id name Scripted",
+		"option name Hash type spin default 16 min 1 max 1024",
+		"uciok",
+		"readyok",
+		"info depth 7 score cp -20 nodes 99 pv e7e5",
+		"bestmove e7e5",
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out.String())
+		}
+	}
+}
+
+func TestServeMate(t *testing.T) {
+	in := "uci\nposition startpos\ngo depth 5\nquit\n"
+	var out strings.Builder
+	err := Serve(Script{Moves: []string{"e2e4"}, Mate: 3, Depth: 5}, strings.NewReader(in), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "info depth 5 score mate 3 nodes 0 pv e2e4\nbestmove e2e4\n") {
+		t.Fatalf("want a mate score, got\n%s", out.String())
+	}
+}
+
+func TestServeCrash(t *testing.T) {
+	in := "uci\nposition startpos\ngo\ngo\n"
+	err := Serve(Script{CrashOn: 2}, strings.NewReader(in), io.Discard)
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("want ErrCrashed, got %v", err)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	e := New(Script{Moves: []string{"g1f3"}, Delay: time.Minute, HangOn: 2})
+	defer e.Close()
+
+	pos := uci.CmdPosition{Position: chess.StartingPosition()}
+	// stop ends the delay early without taking the command lock
+	time.AfterFunc(20*time.Millisecond, func() { e.Run(uci.CmdStop) })
+	err := e.Run(uci.CmdUCINewGame, pos, uci.CmdGo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if move := e.SearchResults().BestMove.String(); move != "g1f3" {
+		t.Fatalf("want g1f3, got %s", move)
+	}
+
+	// the second search hangs until the engine is closed
+	done := make(chan error)
+	go func() { done <- e.Run(uci.CmdGo{}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("hung search returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	e.Close()
+	if err := <-done; err == nil {
+		t.Fatal("want an error from a search cut off by Close")
+	}
+}
@@ -0,0 +1,161 @@
+// Package fakeengine is a scripted UCI engine for tests. It answers the
+// commands a worker sends with configured moves and scores, and can be told
+// to stall, hang or crash, so workers and clients can be exercised quickly
+// and reproducibly without building Stockfish.
+//
+// The same Script drives both forms: Serve speaks UCI over a pipe, which is
+// what cmd/fakeengine runs, and Engine is driven directly by the worker
+// inside the test binary.
+package fakeengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// EnvScript names a JSON script file, or holds the JSON itself, for cmd/fakeengine.
+// The worker starts engines without arguments so the environment is the only way in.
+const EnvScript = "FAKEENGINE_SCRIPT"
+
+// DefaultName is reported as "id name" when the script doesn't set one
+const DefaultName = "FakeEngine"
+
+// Script decides how the engine behaves. The zero value plays the first
+// legal move straight away.
+type Script struct {
+	Name string `json:"name"` // reported as "id name", DefaultName when empty
+
+	// best moves in order of preference, the first one that is legal and may
+	// be searched is played, otherwise the first move that may be searched
+	Moves  []string       `json:"moves"`
+	Score  int            `json:"score"`  // centipawns, unless Scores has the move
+	Scores map[string]int `json:"scores"` // centipawns per best move
+	Mate   int            `json:"mate"`   // reported instead of the score when set
+	Depth  int            `json:"depth"`
+	Nodes  int            `json:"nodes"`
+
+	Delay   time.Duration `json:"delay"`    // how long each search takes, stop ends it early
+	CrashOn int           `json:"crash_on"` // the search, counting from 1, that kills the engine, 0 never
+	HangOn  int           `json:"hang_on"`  // the search, counting from 1, that never finishes and silences the engine, 0 never
+
+	// extra options and their defaults, Threads and Hash are always offered
+	Options map[string]string `json:"options"`
+}
+
+// in JSON durations are strings such as "250ms"
+func (s Script) MarshalJSON() ([]byte, error) {
+	type plain Script
+	return json.Marshal(struct {
+		plain
+		Delay string `json:"delay,omitempty"`
+	}{plain(s), durationString(s.Delay)})
+}
+
+func (s *Script) UnmarshalJSON(data []byte) error {
+	type plain Script
+	var raw struct {
+		plain
+		Delay string `json:"delay"`
+	}
+	// a typo in a test's script should fail loudly rather than be ignored
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	*s = Script(raw.plain)
+	if raw.Delay != "" {
+		s.Delay, err = time.ParseDuration(raw.Delay)
+		if err != nil {
+			return fmt.Errorf("delay: expected a duration such as \"250ms\", got %q", raw.Delay)
+		}
+	}
+	return nil
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// LoadScript reads a script from a JSON file, or from value itself when it
+// starts with "{"
+func LoadScript(value string) (Script, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		data, err = os.ReadFile(value)
+		if err != nil {
+			return Script{}, err
+		}
+	}
+	var s Script
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return Script{}, fmt.Errorf("fake engine script: %w", err)
+	}
+	return s, nil
+}
+
+// name reported for "id name"
+func (s Script) name() string {
+	if s.Name == "" {
+		return DefaultName
+	}
+	return s.Name
+}
+
+// option defaults, Threads and Hash match Stockfish's
+func (s Script) defaults() map[string]string {
+	defaults := map[string]string{"Threads": "1", "Hash": "16"}
+	for name, value := range s.Options {
+		defaults[name] = value
+	}
+	return defaults
+}
+
+// picks the move to play among candidates, the position's legal moves when nil
+func (s Script) bestMove(pos *chess.Position, candidates []string) (*chess.Move, error) {
+	legal := pos.ValidMoves()
+	allowed := func(m *chess.Move) bool {
+		if candidates == nil {
+			return true
+		}
+		for _, c := range candidates {
+			if c == m.String() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, want := range s.Moves {
+		for _, m := range legal {
+			if m.String() == want && allowed(m) {
+				return m, nil
+			}
+		}
+	}
+	for _, m := range legal {
+		if allowed(m) {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no legal move to play in %s", pos)
+}
+
+// score reported for the move
+func (s Script) score(move *chess.Move) int {
+	if score, ok := s.Scores[move.String()]; ok {
+		return score
+	}
+	return s.Score
+}
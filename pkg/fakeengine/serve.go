@@ -0,0 +1,158 @@
+package fakeengine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+)
+
+// Serve speaks UCI, reading commands from in and answering on out, until
+// quit or the end of input. A crash in the script returns ErrCrashed, a
+// hang keeps reading without answering anything.
+func Serve(script Script, in io.Reader, out io.Writer) error {
+	st := newState(script)
+	var mu sync.Mutex // out is shared with the search goroutine
+	say := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(out, format+"\n", args...)
+	}
+
+	var searching sync.WaitGroup
+	var stop chan struct{}
+	halt := func() {
+		if stop != nil {
+			close(stop)
+			stop = nil
+		}
+		searching.Wait()
+	}
+	defer halt()
+
+	hung := false
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || hung {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			say("id name %s", script.name())
+			say("id author fakeengine")
+			defaults := script.defaults()
+			var names []string
+			for name := range defaults {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				o := option(name, defaults[name])
+				if o.Type == uci.OptionSpin {
+					say("option name %s type spin default %s min %s max %s", name, o.Default, o.Min, o.Max)
+				} else {
+					say("option name %s type string default %s", name, o.Default)
+				}
+			}
+			say("uciok")
+		case "isready":
+			say("readyok")
+		case "setoption":
+			name, value := parseSetOption(fields[1:])
+			st.setOption(name, value)
+		case "ucinewgame":
+			halt()
+			st.setPosition(nil, nil)
+		case "position":
+			halt()
+			pos, moves, err := parsePosition(fields[1:])
+			if err == nil {
+				err = st.setPosition(pos, moves)
+			}
+			if err != nil {
+				say("info string %s", err)
+			}
+		case "go":
+			halt()
+			switch st.next() {
+			case fateCrash:
+				return ErrCrashed
+			case fateHang:
+				hung = true
+				continue
+			}
+			stop = make(chan struct{})
+			searching.Add(1)
+			go func(candidates []string, stop chan struct{}) {
+				defer searching.Done()
+				results, err := st.play(candidates, stop)
+				if err != nil {
+					say("info string %s", err)
+					say("bestmove 0000")
+					return
+				}
+				score := fmt.Sprintf("cp %d", results.Info.Score.CP)
+				if results.Info.Score.Mate != 0 {
+					score = fmt.Sprintf("mate %d", results.Info.Score.Mate)
+				}
+				say("info depth %d score %s nodes %d pv %s", results.Info.Depth, score, results.Info.Nodes, results.BestMove)
+				say("bestmove %s", results.BestMove)
+			}(searchMoves(fields[1:]), stop)
+		case "stop":
+			halt()
+		case "quit":
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// name and value of "setoption name <name> [value <value>]", names may have spaces
+func parseSetOption(args []string) (string, string) {
+	text := strings.Join(args, " ")
+	text = strings.TrimPrefix(text, "name ")
+	name, value, _ := strings.Cut(text, " value ")
+	return strings.TrimSpace(name), strings.TrimSpace(value)
+}
+
+// position and moves of "position (startpos | fen <fen>) [moves <move>...]"
+func parsePosition(args []string) (*chess.Position, []string, error) {
+	var fen []string
+	var moves []string
+	inMoves := false
+	for i, arg := range args {
+		switch {
+		case arg == "moves":
+			inMoves = true
+		case inMoves:
+			moves = append(moves, arg)
+		case i == 0 && (arg == "startpos" || arg == "fen"):
+		default:
+			fen = append(fen, arg)
+		}
+	}
+	if len(fen) == 0 {
+		return nil, moves, nil
+	}
+	opt, err := chess.FEN(strings.Join(fen, " "))
+	if err != nil {
+		return nil, nil, err
+	}
+	return chess.NewGame(opt).Position(), moves, nil
+}
+
+// the moves after "searchmoves" in a go command, nil for every move
+func searchMoves(args []string) []string {
+	for i, arg := range args {
+		if arg == "searchmoves" {
+			return append([]string{}, args[i+1:]...)
+		}
+	}
+	return nil
+}
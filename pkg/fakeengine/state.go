@@ -0,0 +1,117 @@
+package fakeengine
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+)
+
+// ErrCrashed is returned once the script has crashed the engine
+var ErrCrashed = errors.New("fake engine crashed")
+
+// what happens to a search, decided by the script before it starts
+type fate int
+
+const (
+	fateSearch fate = iota
+	fateCrash
+	fateHang
+)
+
+// engine state shared by the piped and in-process forms
+type state struct {
+	script Script
+
+	mu       sync.Mutex
+	position *chess.Position
+	options  map[string]string
+	searches int
+	crashed  bool
+	hung     bool
+}
+
+func newState(script Script) *state {
+	return &state{script: script, position: chess.StartingPosition(), options: script.defaults()}
+}
+
+func (s *state) setOption(name string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.options[name] = value
+}
+
+// the current value of every option
+func (s *state) values() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := map[string]string{}
+	for name, value := range s.options {
+		values[name] = value
+	}
+	return values
+}
+
+// sets up pos, or the starting position when nil, and plays moves on it
+func (s *state) setPosition(pos *chess.Position, moves []string) error {
+	if pos == nil {
+		pos = chess.StartingPosition()
+	}
+	for _, text := range moves {
+		move, err := chess.UCINotation{}.Decode(pos, text)
+		if err != nil {
+			return err
+		}
+		pos = pos.Update(move)
+	}
+	s.mu.Lock()
+	s.position = pos
+	s.mu.Unlock()
+	return nil
+}
+
+// counts a search and decides its fate, a crashed or hung engine stays that way
+func (s *state) next() fate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searches++
+	switch {
+	case s.crashed || s.script.CrashOn == s.searches:
+		s.crashed = true
+		return fateCrash
+	case s.hung || s.script.HangOn == s.searches:
+		s.hung = true
+		return fateHang
+	}
+	return fateSearch
+}
+
+// plays out a search among candidates, returning early when stop is closed
+func (s *state) play(candidates []string, stop <-chan struct{}) (uci.SearchResults, error) {
+	s.mu.Lock()
+	pos := s.position
+	s.mu.Unlock()
+
+	move, err := s.script.bestMove(pos, candidates)
+	if err != nil {
+		return uci.SearchResults{}, err
+	}
+	if s.script.Delay > 0 {
+		timer := time.NewTimer(s.script.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-stop:
+		}
+	}
+
+	info := uci.Info{Depth: s.script.Depth, Nodes: s.script.Nodes, PV: []*chess.Move{move}}
+	if s.script.Mate != 0 {
+		info.Score.Mate = s.script.Mate
+	} else {
+		info.Score.CP = s.script.score(move)
+	}
+	return uci.SearchResults{BestMove: move, Info: info}, nil
+}
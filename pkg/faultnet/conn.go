@@ -0,0 +1,195 @@
+package faultnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrTruncated is returned by a write the scenario cut short
+var ErrTruncated = errors.New("faultnet: write truncated")
+
+// Conn applies a scenario's faults to the connection it wraps. Deadlines
+// are honoured while a read or write is held back, so callers time out as
+// they would on a slow link. Like a net.Conn, reads and writes may run
+// concurrently but not with themselves.
+type Conn struct {
+	net.Conn
+	s      *Scenario
+	worker string
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	changed       chan struct{} // closed and replaced whenever a deadline moves
+	timer         *time.Timer   // scheduled disconnect
+	closed        chan struct{}
+	closeOnce     sync.Once
+
+	// read from the network but held back until due, only touched by Read
+	buf     []byte
+	pending []byte
+	due     time.Time
+	readErr error
+}
+
+// Wrap applies the scenario to conn, a connection to or from the named worker
+func (s *Scenario) Wrap(conn net.Conn, worker string) *Conn {
+	c := &Conn{
+		Conn:    conn,
+		s:       s,
+		worker:  worker,
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+		buf:     make([]byte, 32*1024),
+	}
+	if after := s.closeAfter(worker); after > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.timer = time.AfterFunc(after, func() {
+			s.count(func(st *Stats) { st.Disconnected++ })
+			c.Close()
+		})
+	}
+	return c
+}
+
+// Read delivers data once its latency has passed
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			err := c.readErr
+			c.readErr = nil
+			return 0, err
+		}
+		n, err := c.Conn.Read(c.buf)
+		if n > 0 {
+			f := c.s.faults(c.worker, Recv)
+			if f.partition {
+				c.s.count(func(st *Stats) { st.Dropped++ })
+			} else {
+				c.pending = append(c.pending[:0], c.buf[:n]...)
+				c.due = time.Now().Add(f.wait(n))
+				if c.due.After(time.Now()) {
+					c.s.count(func(st *Stats) { st.Delayed++ })
+				}
+			}
+		}
+		if err != nil {
+			if len(c.pending) == 0 {
+				return 0, err
+			}
+			// a timeout doesn't outlive the data read with it
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				c.readErr = err
+			}
+		}
+	}
+	err := c.wait(c.due, func() time.Time { return c.readDeadline })
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write holds p back for its latency and bandwidth, then sends, drops or truncates it
+func (c *Conn) Write(p []byte) (int, error) {
+	f := c.s.faults(c.worker, Send)
+	if f.partition || c.s.chance(f.drop) {
+		c.s.count(func(st *Stats) { st.Dropped++ })
+		return len(p), nil
+	}
+	if wait := f.wait(len(p)); wait > 0 {
+		c.s.count(func(st *Stats) { st.Delayed++ })
+		err := c.wait(time.Now().Add(wait), func() time.Time { return c.writeDeadline })
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > 0 && c.s.chance(f.truncate) {
+		c.s.count(func(st *Stats) { st.Truncated++ })
+		n, _ := c.Conn.Write(p[:c.s.cut(len(p))])
+		c.Close()
+		return n, ErrTruncated
+	}
+	return c.Conn.Write(p)
+}
+
+// blocks until t, failing early when the deadline passes or the connection closes
+func (c *Conn) wait(t time.Time, deadline func() time.Time) error {
+	for {
+		c.mu.Lock()
+		d := deadline()
+		changed := c.changed
+		c.mu.Unlock()
+
+		until := t
+		if !d.IsZero() && d.Before(t) {
+			until = d
+		}
+		wait := time.Until(until)
+		if wait <= 0 {
+			if until.Equal(t) {
+				return nil
+			}
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-changed:
+		case <-c.closed:
+			timer.Stop()
+			return net.ErrClosed
+		}
+		timer.Stop()
+	}
+}
+
+// records deadlines and wakes anything waiting on the old ones
+func (c *Conn) setDeadlines(read bool, write bool, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if read {
+		c.readDeadline = t
+	}
+	if write {
+		c.writeDeadline = t
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.setDeadlines(true, true, t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.setDeadlines(true, false, t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.setDeadlines(false, true, t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Close closes the connection and cancels its scheduled disconnect
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.mu.Unlock()
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,58 @@
+package faultnet
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ErrPartitioned is returned for dials a partition refuses
+var ErrPartitioned = errors.New("faultnet: network partitioned")
+
+// Dialer opens connections to one worker through a scenario
+type Dialer struct {
+	Scenario *Scenario
+	Worker   string     // name the scenario's rules are matched against
+	Dialer   net.Dialer // the real dialer
+}
+
+// DialContext dials address unless a partition cuts the worker off, and
+// wraps the connection
+func (d *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if d.Scenario.partitioned(d.Worker) {
+		d.Scenario.count(func(st *Stats) { st.Refused++ })
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ErrPartitioned}
+	}
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return d.Scenario.Wrap(conn, d.Worker), nil
+}
+
+// Listen wraps every connection ln accepts as a connection of the named
+// worker. Connections accepted during a partition are closed straight away.
+func (s *Scenario) Listen(ln net.Listener, worker string) net.Listener {
+	return &listener{Listener: ln, s: s, worker: worker}
+}
+
+type listener struct {
+	net.Listener
+	s      *Scenario
+	worker string
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.s.partitioned(l.worker) {
+			l.s.count(func(st *Stats) { st.Refused++ })
+			conn.Close()
+			continue
+		}
+		return l.s.Wrap(conn, l.worker), nil
+	}
+}
@@ -0,0 +1,217 @@
+package faultnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// a loopback connection wrapped for "w", and the plain other end
+func pair(t *testing.T, s *Scenario) (*Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	d := &Dialer{Scenario: s, Worker: "w"}
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-accepted
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return conn.(*Conn), peer
+}
+
+func TestLatency(t *testing.T) {
+	c, peer := pair(t, &Scenario{Rules: []Rule{{Direction: Recv, Latency: 80 * time.Millisecond}}})
+
+	begin := time.Now()
+	peer.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(begin); took < 80*time.Millisecond {
+		t.Fatalf("read arrived after %s, before the latency", took)
+	}
+
+	// writes aren't covered by a recv rule
+	begin = time.Now()
+	c.Write([]byte("hi"))
+	if took := time.Since(begin); took > 40*time.Millisecond {
+		t.Fatalf("write held back for %s", took)
+	}
+}
+
+func TestLatencyHonoursDeadlines(t *testing.T) {
+	c, peer := pair(t, &Scenario{Rules: []Rule{{Direction: Recv, Latency: 200 * time.Millisecond}}})
+
+	peer.Write([]byte("late"))
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 4)
+	_, err := c.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("want a timeout, got %v", err)
+	}
+
+	// the data wasn't lost, it arrives once the latency has passed
+	c.SetReadDeadline(time.Time{})
+	_, err = io.ReadFull(c, buf)
+	if err != nil || string(buf) != "late" {
+		t.Fatalf("want the held back data, got %q, %v", buf, err)
+	}
+}
+
+func TestDeadlineInterruptsWait(t *testing.T) {
+	c, peer := pair(t, &Scenario{Rules: []Rule{{Latency: time.Minute}}})
+
+	peer.Write([]byte("x"))
+	time.AfterFunc(30*time.Millisecond, func() { c.SetReadDeadline(time.Now()) })
+	_, err := c.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("want a timeout once the deadline moved, got %v", err)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	c, peer := pair(t, &Scenario{Rules: []Rule{{Direction: Send, Bandwidth: 1000}}})
+	go io.Copy(io.Discard, peer)
+
+	begin := time.Now()
+	c.Write(make([]byte, 100))
+	if took := time.Since(begin); took < 100*time.Millisecond {
+		t.Fatalf("100 bytes at 1000 B/s took %s", took)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	s := &Scenario{Rules: []Rule{{Drop: 1}}}
+	c, peer := pair(t, s)
+
+	n, err := c.Write([]byte("lost"))
+	if n != 4 || err != nil {
+		t.Fatalf("a dropped write should look like it worked, got %d, %v", n, err)
+	}
+	peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _ := peer.Read(make([]byte, 4)); n != 0 {
+		t.Fatal("dropped data arrived")
+	}
+	if s.Stats().Dropped != 1 {
+		t.Fatalf("want one drop counted, got %+v", s.Stats())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	c, peer := pair(t, &Scenario{Seed: 3, Rules: []Rule{{Truncate: 1}}})
+
+	n, err := c.Write([]byte("0123456789"))
+	if !errors.Is(err, ErrTruncated) || n >= 10 {
+		t.Fatalf("want a truncated write, got %d, %v", n, err)
+	}
+	got, _ := io.ReadAll(peer)
+	if len(got) != n {
+		t.Fatalf("peer got %d bytes, %d were written", len(got), n)
+	}
+	if _, err := c.Write([]byte("more")); err == nil {
+		t.Fatal("connection still open after truncation")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	s := &Scenario{Rules: []Rule{{Worker: "w", Until: 100 * time.Millisecond, Partition: true}}}
+	s.Start()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	d := &Dialer{Scenario: s, Worker: "w"}
+	_, err = d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if !errors.Is(err, ErrPartitioned) {
+		t.Fatalf("want the dial refused, got %v", err)
+	}
+	// other workers aren't cut off
+	other := &Dialer{Scenario: s, Worker: "v"}
+	if conn, err := other.DialContext(context.Background(), "tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	} else {
+		conn.Close()
+	}
+
+	// the partition heals
+	time.Sleep(120 * time.Millisecond)
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestScheduledDisconnects(t *testing.T) {
+	s := &Scenario{Rules: []Rule{
+		{Worker: "w", From: 60 * time.Millisecond, Disconnect: true},
+		{Worker: "other", Lifetime: 30 * time.Millisecond},
+	}}
+	s.Start()
+	c, _ := pair(t, s)
+
+	_, err := c.Read(make([]byte, 1))
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("want the connection closed, got %v", err)
+	}
+	if s.Stats().Disconnected != 1 {
+		t.Fatalf("want one disconnect counted, got %+v", s.Stats())
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	s, err := LoadScenario(`{"seed": 7, "rules": [{"worker": "w-*", "direction": "recv", "latency": "25ms", "from": "1s", "until": "2s"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.Rules[0]
+	if s.Seed != 7 || r.Direction != Recv || r.Latency != 25*time.Millisecond || r.From != time.Second || r.Until != 2*time.Second {
+		t.Fatalf("scenario read wrong: %+v", s)
+	}
+	if !r.applies("w-01", 1500*time.Millisecond) || r.applies("w-01", 2*time.Second) || r.applies("x-01", 1500*time.Millisecond) {
+		t.Fatal("rule window or worker match is wrong")
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"latncy": "25ms"}]}`,
+		`{"rules": [{"latency": "soon"}]}`,
+		`{"rules": [{"drop": 1.5}]}`,
+		`{"rules": [{"direction": "up"}]}`,
+		`{"rules": [{"from": "2s", "until": "1s"}]}`,
+		`{"rule": []}`,
+	} {
+		if _, err := LoadScenario(bad); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package faultnet injects network faults for resilience testing: latency
+// and jitter, bandwidth limits, dropped and truncated writes, partitions and
+// scheduled disconnects. A Scenario lists the faults, each limited to some
+// workers and a window of time, and wraps connections, dialers and
+// listeners so the client and worker use it without knowing it's there.
+//
+// Faults act on the side that wraps the connection. Delays hold the caller
+// up the way a full link would, drops lose whole writes, which with one
+// write per message means whole messages, and truncation cuts a write short
+// and closes the connection. Dropping and truncating only apply to writes,
+// partial reads can't be lost without breaking the framing.
+package faultnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction limits a rule to one side of the connection
+type Direction string
+
+const (
+	Both Direction = ""
+	Send Direction = "send"
+	Recv Direction = "recv"
+)
+
+// Rule is a set of faults on the connections of some workers
+type Rule struct {
+	Worker    string        `json:"worker"`    // glob matched against the worker name, empty for every worker
+	Direction Direction     `json:"direction"` // "send", "recv" or empty for both
+	From      time.Duration `json:"from"`      // when the rule starts, from the scenario's start
+	Until     time.Duration `json:"until"`     // when it ends, 0 for never
+
+	Latency    time.Duration `json:"latency"`    // added to every read and write
+	Jitter     time.Duration `json:"jitter"`     // up to this much more, picked at random each time
+	Bandwidth  int           `json:"bandwidth"`  // bytes per second, 0 for no limit
+	Drop       float64       `json:"drop"`       // chance a write is lost
+	Truncate   float64       `json:"truncate"`   // chance a write is cut short and the connection closed
+	Partition  bool          `json:"partition"`  // nothing gets through, dials and accepts fail
+	Disconnect bool          `json:"disconnect"` // connections open when the rule starts are closed
+	Lifetime   time.Duration `json:"lifetime"`   // connections opened while it applies are closed this long after, 0 for never
+}
+
+// in JSON durations are strings such as "250ms"
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	type plain Rule
+	var raw struct {
+		plain
+		From     string `json:"from"`
+		Until    string `json:"until"`
+		Latency  string `json:"latency"`
+		Jitter   string `json:"jitter"`
+		Lifetime string `json:"lifetime"`
+	}
+	// a typo in a scenario should fail loudly rather than test nothing
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	*r = Rule(raw.plain)
+	durations := []struct {
+		name  string
+		value string
+		ptr   *time.Duration
+	}{
+		{"from", raw.From, &r.From},
+		{"until", raw.Until, &r.Until},
+		{"latency", raw.Latency, &r.Latency},
+		{"jitter", raw.Jitter, &r.Jitter},
+		{"lifetime", raw.Lifetime, &r.Lifetime},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		*d.ptr, err = time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("%s: expected a duration such as \"250ms\", got %q", d.name, d.value)
+		}
+	}
+	return r.validate()
+}
+
+func (r *Rule) validate() error {
+	switch r.Direction {
+	case Both, Send, Recv:
+	default:
+		return fmt.Errorf("direction: expected \"send\", \"recv\" or nothing, got %q", r.Direction)
+	}
+	if _, err := path.Match(r.Worker, ""); err != nil {
+		return fmt.Errorf("worker: %w", err)
+	}
+	if r.From < 0 || r.Until < 0 || r.Latency < 0 || r.Jitter < 0 || r.Lifetime < 0 || r.Bandwidth < 0 {
+		return fmt.Errorf("durations and bandwidth must not be negative")
+	}
+	if r.Until != 0 && r.Until <= r.From {
+		return fmt.Errorf("until (%s) must come after from (%s)", r.Until, r.From)
+	}
+	if r.Drop < 0 || r.Drop > 1 || r.Truncate < 0 || r.Truncate > 1 {
+		return fmt.Errorf("drop and truncate are chances between 0 and 1")
+	}
+	return nil
+}
+
+// the rule covers worker at elapsed into the scenario
+func (r *Rule) applies(worker string, elapsed time.Duration) bool {
+	if elapsed < r.From || (r.Until != 0 && elapsed >= r.Until) {
+		return false
+	}
+	if r.Worker == "" {
+		return true
+	}
+	ok, _ := path.Match(r.Worker, worker)
+	return ok
+}
+
+// Scenario is a list of rules played against a clock that starts with the
+// first connection, or with Start. Rules that overlap add their latencies,
+// take the lowest bandwidth and the highest chances.
+type Scenario struct {
+	Seed  int64  `json:"seed"` // for jitter, drops and truncation, 0 picks one from the clock
+	Rules []Rule `json:"rules"`
+
+	mu    sync.Mutex
+	start time.Time
+	rng   *rand.Rand
+	stats Stats
+}
+
+// Stats counts the faults a scenario has injected
+type Stats struct {
+	Delayed      int // reads and writes held back
+	Dropped      int // writes lost, or reads lost to a partition
+	Truncated    int
+	Disconnected int
+	Refused      int // dials and accepts failed by a partition
+}
+
+// LoadScenario reads a scenario from a JSON file, or from value itself when
+// it starts with "{"
+func LoadScenario(value string) (*Scenario, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		data, err = os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var s Scenario
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&s)
+	if err != nil {
+		return nil, fmt.Errorf("fault scenario: %w", err)
+	}
+	return &s, nil
+}
+
+// Start restarts the scenario's clock, rule windows count from now
+func (s *Scenario) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = time.Now()
+}
+
+// time since the scenario started, starting it if it hasn't. Called with mu held.
+func (s *Scenario) elapsed() time.Duration {
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	return time.Since(s.start)
+}
+
+// the scenario's random source, seeded on first use. Called with mu held.
+func (s *Scenario) random() *rand.Rand {
+	if s.rng == nil {
+		seed := s.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		s.rng = rand.New(rand.NewSource(seed))
+	}
+	return s.rng
+}
+
+// Stats returns the faults injected so far
+func (s *Scenario) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *Scenario) count(f func(*Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.stats)
+}
+
+// faults on worker's connections in one direction right now
+type faults struct {
+	delay     time.Duration
+	bandwidth int
+	drop      float64
+	truncate  float64
+	partition bool
+}
+
+// combines the rules that apply now
+func (s *Scenario) faults(worker string, dir Direction) faults {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := s.elapsed()
+	var f faults
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		if !r.applies(worker, elapsed) || (r.Direction != Both && r.Direction != dir) {
+			continue
+		}
+		f.delay += r.Latency
+		if r.Jitter > 0 {
+			f.delay += time.Duration(s.random().Int63n(int64(r.Jitter)))
+		}
+		if r.Bandwidth > 0 && (f.bandwidth == 0 || r.Bandwidth < f.bandwidth) {
+			f.bandwidth = r.Bandwidth
+		}
+		f.drop = max(f.drop, r.Drop)
+		f.truncate = max(f.truncate, r.Truncate)
+		f.partition = f.partition || r.Partition
+	}
+	return f
+}
+
+// how long n bytes are held back
+func (f faults) wait(n int) time.Duration {
+	d := f.delay
+	if f.bandwidth > 0 {
+		d += time.Duration(int64(n) * int64(time.Second) / int64(f.bandwidth))
+	}
+	return d
+}
+
+// true with probability p
+func (s *Scenario) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.random().Float64() < p
+}
+
+// picks a length to cut a write of n bytes down to, shorter than n
+func (s *Scenario) cut(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.random().Intn(n)
+}
+
+// worker is cut off in both directions right now
+func (s *Scenario) partitioned(worker string) bool {
+	return s.faults(worker, Send).partition || s.faults(worker, Recv).partition
+}
+
+// when a connection to worker opened now gets closed, zero for never
+func (s *Scenario) closeAfter(worker string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := s.elapsed()
+	var after time.Duration
+	sooner := func(d time.Duration) {
+		if d > 0 && (after == 0 || d < after) {
+			after = d
+		}
+	}
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		if r.Disconnect && elapsed < r.From && r.applies(worker, r.From) {
+			sooner(r.From - elapsed)
+		}
+		if r.Lifetime > 0 && r.applies(worker, elapsed) {
+			sooner(r.Lifetime)
+		}
+	}
+	return after
+}
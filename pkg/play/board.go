@@ -0,0 +1,119 @@
+package play
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+)
+
+// clears the screen and moves the cursor home
+const clearScreen = "\033[H\033[2J"
+
+// Terminal reports whether f is a terminal that understands ANSI escapes,
+// so the screen can be cleared between moves
+func Terminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	term := os.Getenv("TERM")
+	return info.Mode()&os.ModeCharDevice != 0 && term != "" && term != "dumb"
+}
+
+// ParseColor reads white, black or random, picking one for random
+func ParseColor(name string) (chess.Color, error) {
+	switch strings.ToLower(name) {
+	case "white", "":
+		return chess.White, nil
+	case "black":
+		return chess.Black, nil
+	case "random":
+		return []chess.Color{chess.White, chess.Black}[rand.Intn(2)], nil
+	}
+	return chess.NoColor, fmt.Errorf("expected white, black or random, got %q", name)
+}
+
+// draws board with white at the bottom, or black when flipped
+func drawBoard(w io.Writer, board *chess.Board, flipped bool) {
+	var files strings.Builder
+	files.WriteString("  ")
+	for i := 0; i < 8; i++ {
+		f := chess.File(i)
+		if flipped {
+			f = chess.File(7 - i)
+		}
+		files.WriteString(" " + f.String())
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, files.String())
+	for i := 0; i < 8; i++ {
+		r := chess.Rank(7 - i)
+		if flipped {
+			r = chess.Rank(i)
+		}
+		var row strings.Builder
+		for j := 0; j < 8; j++ {
+			f := chess.File(j)
+			if flipped {
+				f = chess.File(7 - j)
+			}
+			p := board.Piece(chess.NewSquare(f, r))
+			if p == chess.NoPiece {
+				row.WriteString(" -")
+			} else {
+				row.WriteString(" " + p.String())
+			}
+		}
+		fmt.Fprintf(w, "%s %s  %s\n", r, row.String(), r)
+	}
+	fmt.Fprintln(w, files.String())
+	fmt.Fprintln(w)
+}
+
+// moves played out from pos in SAN, separated by spaces
+func san(pos *chess.Position, moves ...*chess.Move) string {
+	var parts []string
+	for _, m := range moves {
+		parts = append(parts, chess.AlgebraicNotation{}.Encode(pos, m))
+		pos = pos.Update(m)
+	}
+	return strings.Join(parts, " ")
+}
+
+// the legal move s names in SAN, UCI or long algebraic notation, nil when
+// there isn't one
+func parseMove(pos *chess.Position, s string) *chess.Move {
+	// castling is often written with zeros
+	s = strings.ReplaceAll(s, "0", "O")
+	for _, m := range pos.ValidMoves() {
+		if strings.EqualFold(m.String(), s) {
+			return m
+		}
+	}
+	// long algebraic is read with or without the dash, Ng1-f3 or Ng1f3
+	for _, text := range []string{s, strings.ReplaceAll(s, "-", "")} {
+		for _, n := range []chess.Decoder{chess.AlgebraicNotation{}, chess.LongAlgebraicNotation{}} {
+			m, err := n.Decode(pos, text)
+			if err == nil {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// a search's score from the searching side, like +0.35/12 or -M3/20
+func score(r client.SearchResult) string {
+	switch {
+	case r.Mate > 0:
+		return fmt.Sprintf("+M%d/%d", r.Mate, r.Depth)
+	case r.Mate < 0:
+		return fmt.Sprintf("-M%d/%d", -r.Mate, r.Depth)
+	}
+	return fmt.Sprintf("%+.2f/%d", float64(r.Score)/100, r.Depth)
+}
@@ -0,0 +1,395 @@
+// Package play runs a game between a person at a terminal and the cluster.
+// Moves are typed in SAN (Nf3), UCI (g1f3) or long algebraic notation
+// (Ng1-f3), and commands take moves back, ask for hints and end the game:
+//
+//	session := play.New(c, os.Stdin, os.Stdout)
+//	session.SetColor(chess.Black)
+//	err := session.Play(ctx)
+//	record := session.Record() // for tournament.WritePGN
+package play
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+)
+
+// Searcher picks the cluster's moves, *client.Client is one
+type Searcher interface {
+	Search(ctx context.Context, position *chess.Position, history []*chess.Position, limits client.Limits) (client.SearchResult, error)
+}
+
+// DrawScore is the most the cluster can think it's ahead by, in
+// centipawns, and still accept a draw offer
+var DrawScore = 25
+
+// the commands, for help
+const usage = `Type a move in SAN (Nf3), UCI (g1f3) or long algebraic notation (Ng1-f3), or:
+  moves   list the legal moves
+  hint    ask the cluster for a move
+  undo    take back your last move and the reply
+  redo    play a taken back move again
+  flip    turn the board around
+  draw    offer a draw, or claim one by repetition or the fifty move rule
+  resign  give up the game
+  quit    leave the game unfinished`
+
+// Session is one game against the cluster
+type Session struct {
+	searcher Searcher
+	in       *bufio.Scanner
+	out      io.Writer
+	color    chess.Color // the player's
+	flipped  bool        // the cluster's side at the bottom
+	clear    bool        // clear the screen before drawing the board
+	started  time.Time
+	game     *chess.Game
+	moves    []tournament.MoveRecord
+	book     int                     // moves of a resumed game, which can't be taken back
+	undone   []tournament.MoveRecord // taken back, the next to redo last
+	cluster  *client.SearchResult    // the cluster's last move, nil when there's none to go by
+	status   string                  // shown under the board
+
+	result      string // empty while the game goes on
+	termination string
+	reason      string
+}
+
+// New returns a session reading the player's input from in and drawing the
+// game on out. The player has white by default.
+func New(searcher Searcher, in io.Reader, out io.Writer) *Session {
+	return &Session{
+		searcher: searcher,
+		in:       bufio.NewScanner(in),
+		out:      out,
+		color:    chess.White,
+		started:  time.Now(),
+		game:     chess.NewGame(),
+	}
+}
+
+// SetColor sets the player's color, the cluster plays the other
+func (s *Session) SetColor(color chess.Color) {
+	s.color = color
+}
+
+// SetFlipped draws the board with the cluster's side at the bottom
+func (s *Session) SetFlipped(flipped bool) {
+	s.flipped = flipped
+}
+
+// SetClear clears the screen before the board is drawn, for terminals
+func (s *Session) SetClear(clear bool) {
+	s.clear = clear
+}
+
+// Resume plays on from game, the moves it has count as book moves and
+// can't be taken back
+func (s *Session) Resume(game *chess.Game) {
+	s.game = game
+	s.moves = nil
+	for _, m := range game.Moves() {
+		s.moves = append(s.moves, tournament.MoveRecord{Move: tournament.Move{Move: m}, Book: true})
+	}
+	s.book = len(s.moves)
+}
+
+// Play runs the game until it's over, the player resigns or quits, or the
+// input ends. A failed search ends it early with the error.
+func (s *Session) Play(ctx context.Context) error {
+	s.show()
+	begin := time.Now()
+	for s.result == "" {
+		if outcome := s.game.Outcome(); outcome != chess.NoOutcome {
+			s.end(outcome.String(), tournament.Describe(s.game.Method()))
+			break
+		}
+		if s.game.Position().Turn() != s.color {
+			err := s.reply(ctx)
+			if err != nil {
+				s.reason = err.Error()
+				return err
+			}
+			s.show()
+			begin = time.Now()
+			continue
+		}
+
+		fmt.Fprint(s.out, "Your move: ")
+		if !s.in.Scan() {
+			s.quit()
+			break
+		}
+		input := strings.TrimSpace(s.in.Text())
+		played, err := s.command(ctx, input, begin)
+		if err != nil {
+			s.reason = err.Error()
+			return err
+		}
+		if played {
+			begin = time.Now()
+		}
+	}
+	// the final board was drawn after the last move
+	fmt.Fprintln(s.out, s.verdict())
+	return nil
+}
+
+// runs one line of input, reporting whether the board changed
+func (s *Session) command(ctx context.Context, input string, begin time.Time) (bool, error) {
+	switch strings.ToLower(input) {
+	case "":
+		return false, nil
+	case "help", "?":
+		fmt.Fprintln(s.out, usage)
+		return false, nil
+	case "moves":
+		fmt.Fprintln(s.out, s.legal())
+		return false, nil
+	case "hint":
+		return false, s.hint(ctx)
+	case "undo":
+		return s.undo(), nil
+	case "redo":
+		return s.redo(), nil
+	case "flip":
+		s.flipped = !s.flipped
+		s.show()
+		return false, nil
+	case "draw":
+		return false, s.offerDraw(ctx)
+	case "resign":
+		winner := tournament.WhiteWins
+		if s.color == chess.White {
+			winner = tournament.BlackWins
+		}
+		s.end(winner, "resignation")
+		return false, nil
+	case "quit", "exit":
+		s.quit()
+		return false, nil
+	}
+
+	pos := s.game.Position()
+	move := parseMove(pos, input)
+	if move == nil {
+		fmt.Fprintf(s.out, "%q isn't a legal move here, type moves to list them or help for commands\n", input)
+		return false, nil
+	}
+	s.status = ""
+	s.play(tournament.MoveRecord{Move: tournament.Move{Move: move}, Time: time.Since(begin)})
+	s.undone = nil
+	s.show()
+	return true, nil
+}
+
+// plays m, which is legal in the current position
+func (s *Session) play(m tournament.MoveRecord) {
+	s.game.Move(m.Move.Move)
+	s.moves = append(s.moves, m)
+}
+
+// the cluster's move
+func (s *Session) reply(ctx context.Context) error {
+	fmt.Fprintln(s.out, "The cluster is thinking...")
+	pos := s.game.Position()
+	begin := time.Now()
+	result, err := s.search(ctx)
+	if err != nil {
+		return err
+	}
+	s.play(tournament.MoveRecord{
+		Move: tournament.Move{Move: result.Move, Score: result.Score, Mate: result.Mate, Depth: result.Depth, Nodes: result.Nodes},
+		Time: time.Since(begin),
+	})
+	s.cluster = &result
+	s.status = "The cluster played " + san(pos, result.Move) + describe(pos, result)
+	return nil
+}
+
+// asks the cluster for the best move in the current position
+func (s *Session) search(ctx context.Context) (client.SearchResult, error) {
+	positions := s.game.Positions()
+	return s.searcher.Search(ctx, s.game.Position(), positions[:len(positions)-1], client.Limits{})
+}
+
+// suggests a move for the player
+func (s *Session) hint(ctx context.Context) error {
+	fmt.Fprintln(s.out, "Asking the cluster...")
+	pos := s.game.Position()
+	result, err := s.search(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "Try "+san(pos, result.Move)+describe(pos, result))
+	return nil
+}
+
+// takes back the player's last move and everything after it
+func (s *Session) undo() bool {
+	positions := s.game.Positions()
+	keep := -1
+	for i := len(s.moves) - 1; i >= s.book; i-- {
+		if positions[i].Turn() == s.color {
+			keep = i
+			break
+		}
+	}
+	if keep < 0 {
+		fmt.Fprintln(s.out, "There's no move of yours to take back")
+		return false
+	}
+	for i := len(s.moves) - 1; i >= keep; i-- {
+		s.undone = append(s.undone, s.moves[i])
+	}
+	fen, _ := chess.FEN(positions[0].String()) // a position's own FEN always reads
+	moves := s.moves[:keep]
+	s.game = chess.NewGame(fen)
+	s.moves = nil
+	for _, m := range moves {
+		s.play(m)
+	}
+	s.cluster = nil
+	s.status = "Took back " + san(positions[keep], s.undone[len(s.undone)-1].Move.Move)
+	s.show()
+	return true
+}
+
+// plays taken back moves until it's the player's turn again
+func (s *Session) redo() bool {
+	if len(s.undone) == 0 {
+		fmt.Fprintln(s.out, "There's no move to redo")
+		return false
+	}
+	var played []string
+	for len(s.undone) > 0 {
+		m := s.undone[len(s.undone)-1]
+		s.undone = s.undone[:len(s.undone)-1]
+		played = append(played, san(s.game.Position(), m.Move.Move))
+		s.play(m)
+		if s.game.Position().Turn() == s.color {
+			break
+		}
+	}
+	s.cluster = nil
+	s.status = "Replayed " + strings.Join(played, " ")
+	s.show()
+	return true
+}
+
+// the player offers or claims a draw
+func (s *Session) offerDraw(ctx context.Context) error {
+	// claims don't need the cluster's agreement
+	for _, method := range s.game.EligibleDraws() {
+		if method != chess.DrawOffer {
+			s.end(tournament.Draw, tournament.Describe(method))
+			return nil
+		}
+	}
+
+	// the cluster goes by its own score
+	var ahead int
+	if s.cluster != nil {
+		ahead = s.cluster.Centipawns()
+	} else {
+		fmt.Fprintln(s.out, "The cluster is considering...")
+		result, err := s.search(ctx)
+		if err != nil {
+			return err
+		}
+		ahead = -result.Centipawns()
+	}
+	if ahead > DrawScore {
+		fmt.Fprintln(s.out, "The cluster declines the draw")
+		return nil
+	}
+	s.end(tournament.Draw, "draw agreed")
+	return nil
+}
+
+func (s *Session) quit() {
+	s.result, s.termination, s.reason = tournament.Ongoing, tournament.TerminationUnterminated, ""
+}
+
+func (s *Session) end(result string, reason string) {
+	s.result, s.termination, s.reason = result, tournament.TerminationNormal, reason
+}
+
+// draws the board with the status under it
+func (s *Session) show() {
+	if s.clear {
+		fmt.Fprint(s.out, clearScreen)
+	}
+	// the player's side at the bottom unless flipped
+	drawBoard(s.out, s.game.Position().Board(), (s.color == chess.Black) != s.flipped)
+	if s.status != "" {
+		fmt.Fprintln(s.out, s.status)
+	}
+}
+
+// the legal moves in SAN
+func (s *Session) legal() string {
+	pos := s.game.Position()
+	var moves []string
+	for _, m := range pos.ValidMoves() {
+		moves = append(moves, san(pos, m))
+	}
+	return strings.Join(moves, " ")
+}
+
+// how the game ended, from the player's side
+func (s *Session) verdict() string {
+	won := tournament.WhiteWins
+	if s.color == chess.Black {
+		won = tournament.BlackWins
+	}
+	switch s.result {
+	case tournament.Ongoing:
+		return "Game left unfinished"
+	case tournament.Draw:
+		return "Drawn by " + s.reason
+	case won:
+		return "You won by " + s.reason
+	}
+	return "The cluster won by " + s.reason
+}
+
+// Record returns the game so far with the player as engine 0 and the
+// cluster as engine 1, names and time controls are left to the caller
+func (s *Session) Record() tournament.GameResult {
+	g := tournament.GameResult{
+		Pairing:     tournament.Pairing{Number: 1, Round: 1, White: 0, Black: 1, Opening: -1},
+		Result:      s.result,
+		Termination: s.termination,
+		Reason:      s.reason,
+		Date:        s.started,
+		Start:       s.game.Positions()[0],
+		Moves:       append([]tournament.MoveRecord(nil), s.moves...),
+	}
+	if s.color == chess.Black {
+		g.White, g.Black = 1, 0
+	}
+	if g.Result == "" {
+		g.Result, g.Termination = tournament.Ongoing, tournament.TerminationUnterminated
+	}
+	return g
+}
+
+// what a search from pos found, scored from the side to move there
+func describe(pos *chess.Position, r client.SearchResult) string {
+	if r.Source == client.SourceRandom {
+		return " (no search finished in time)"
+	}
+	text := " (" + score(r)
+	if len(r.PV) > 0 {
+		text += ", line " + san(pos, r.PV...)
+	}
+	return text + ")"
+}
@@ -0,0 +1,242 @@
+package play_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/play"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+)
+
+// plays the first legal move of moves, otherwise the first valid move
+type searcher struct {
+	moves    []string
+	score    int
+	searches int
+	fail     bool
+}
+
+func (s *searcher) Search(ctx context.Context, position *chess.Position, history []*chess.Position, limits client.Limits) (client.SearchResult, error) {
+	s.searches++
+	if s.fail {
+		return client.SearchResult{}, errors.New("no workers")
+	}
+	valid := position.ValidMoves()
+	move := valid[0]
+scripted:
+	for _, want := range s.moves {
+		for _, m := range valid {
+			if m.String() == want {
+				move = m
+				break scripted
+			}
+		}
+	}
+	return client.SearchResult{Move: move, Source: client.SourceWorker, Score: s.score, Depth: 10, PV: []*chess.Move{move}}, nil
+}
+
+// plays a session with input typed in, returning the record and the output
+func session(t *testing.T, s *searcher, input string, setup func(*play.Session)) (tournament.GameResult, string) {
+	t.Helper()
+	var out bytes.Buffer
+	p := play.New(s, strings.NewReader(input), &out)
+	if setup != nil {
+		setup(p)
+	}
+	err := p.Play(context.Background())
+	if err != nil {
+		t.Fatalf("%v, output:\n%s", err, out.String())
+	}
+	return p.Record(), out.String()
+}
+
+// the record's moves in UCI notation
+func uci(g tournament.GameResult) string {
+	var moves []string
+	for _, m := range g.Moves {
+		moves = append(moves, m.Move.Move.String())
+	}
+	return strings.Join(moves, " ")
+}
+
+func TestMoveNotations(t *testing.T) {
+	s := &searcher{moves: []string{"e7e5", "b8c6", "g8f6"}, score: 30}
+	g, out := session(t, s, "e4\ng1f3\nBf1-c4\nquit\n", nil)
+	if uci(g) != "e2e4 e7e5 g1f3 b8c6 f1c4 g8f6" {
+		t.Fatalf("unexpected moves: %s\n%s", uci(g), out)
+	}
+	if g.Result != tournament.Ongoing || g.Termination != tournament.TerminationUnterminated {
+		t.Fatalf("want the quit game unterminated, got %s by %s", g.Result, g.Termination)
+	}
+	if g.Moves[1].Score != 30 || g.Moves[1].Depth != 10 {
+		t.Fatalf("the cluster's search wasn't recorded: %+v", g.Moves[1])
+	}
+	if !strings.Contains(out, "The cluster played Nc6 (+0.30/10, line Nc6)") {
+		t.Fatalf("no score and PV for the cluster's move:\n%s", out)
+	}
+	if strings.Contains(out, "\033[") {
+		t.Fatalf("cleared the screen without being asked to:\n%q", out)
+	}
+}
+
+func TestBadMove(t *testing.T) {
+	g, out := session(t, &searcher{}, "e5\nmoves\nquit\n", nil)
+	if len(g.Moves) != 0 {
+		t.Fatalf("played %s", uci(g))
+	}
+	if !strings.Contains(out, `"e5" isn't a legal move`) {
+		t.Fatalf("no complaint about e5:\n%s", out)
+	}
+	// the moves are only listed when asked for, on one line
+	if strings.Count(out, "Nf3") != 1 || !strings.Contains(out, "Nc3") {
+		t.Fatalf("want the legal moves listed once:\n%s", out)
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	s := &searcher{moves: []string{"d7d5"}}
+	g, out := session(t, s, "undo\ne4\nundo\nd4\nundo\nredo\nredo\nundo\nNf3\nredo\nquit\n", nil)
+	if uci(g) != "g1f3 d7d5" {
+		t.Fatalf("unexpected moves: %s\n%s", uci(g), out)
+	}
+	// redo replays the cluster's reply without searching
+	if s.searches != 3 {
+		t.Fatalf("want 3 searches, got %d", s.searches)
+	}
+	for _, want := range []string{"no move of yours to take back", "Took back e4", "Took back d4", "Replayed d4 d5"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in:\n%s", want, out)
+		}
+	}
+	// once after replaying everything, once after a new move
+	if strings.Count(out, "no move to redo") != 2 {
+		t.Errorf("want a new move to clear the moves to redo:\n%s", out)
+	}
+}
+
+func TestResumeCantUndoBook(t *testing.T) {
+	game := chess.NewGame()
+	game.MoveStr("e4")
+	game.MoveStr("e5")
+	g, out := session(t, &searcher{}, "undo\nquit\n", func(p *play.Session) { p.Resume(game) })
+	if len(g.Moves) != 2 || !g.Moves[0].Book {
+		t.Fatalf("want the book moves kept, got %s", uci(g))
+	}
+	if !strings.Contains(out, "no move of yours to take back") {
+		t.Fatalf("took back a book move:\n%s", out)
+	}
+}
+
+func TestPlayBlack(t *testing.T) {
+	s := &searcher{moves: []string{"e2e4"}}
+	g, out := session(t, s, "c5\nresign\n", func(p *play.Session) { p.SetColor(chess.Black) })
+	if len(g.Moves) != 3 || !strings.HasPrefix(uci(g), "e2e4 c7c5 ") {
+		t.Fatalf("unexpected moves: %s", uci(g))
+	}
+	if g.White != 1 || g.Black != 0 {
+		t.Fatalf("want the cluster as white, got white %d and black %d", g.White, g.Black)
+	}
+	if g.Result != tournament.WhiteWins || g.Reason != "resignation" || g.Termination != tournament.TerminationNormal {
+		t.Fatalf("want white to win by resignation, got %s by %s", g.Result, g.Reason)
+	}
+	if !strings.HasSuffix(out, "The cluster won by resignation\n") {
+		t.Fatalf("unexpected verdict:\n%s", out)
+	}
+}
+
+func TestCheckmate(t *testing.T) {
+	s := &searcher{moves: []string{"e7e5", "d8h4"}}
+	g, out := session(t, s, "f3\ng4\n", nil)
+	if g.Result != tournament.BlackWins || g.Reason != "checkmate" {
+		t.Fatalf("want black to win by checkmate, got %s by %s", g.Result, g.Reason)
+	}
+	if !strings.HasSuffix(out, "The cluster won by checkmate\n") {
+		t.Fatalf("unexpected verdict:\n%s", out)
+	}
+}
+
+func TestDrawOffer(t *testing.T) {
+	// ahead by more than DrawScore after its move
+	s := &searcher{score: play.DrawScore + 1}
+	g, out := session(t, s, "e4\ndraw\nquit\n", nil)
+	if g.Result != tournament.Ongoing || !strings.Contains(out, "declines the draw") {
+		t.Fatalf("want the draw declined, got %s:\n%s", g.Result, out)
+	}
+
+	s = &searcher{score: play.DrawScore}
+	g, out = session(t, s, "e4\ndraw\n", nil)
+	if g.Result != tournament.Draw || g.Reason != "draw agreed" {
+		t.Fatalf("want a draw agreed, got %s by %s:\n%s", g.Result, g.Reason, out)
+	}
+
+	// before the cluster has moved it searches, a good score for the
+	// player is a bad one for the cluster
+	s = &searcher{score: -100}
+	g, _ = session(t, s, "draw\nquit\n", nil)
+	if g.Result != tournament.Ongoing || s.searches != 1 {
+		t.Fatalf("want the draw declined after a search, got %s after %d", g.Result, s.searches)
+	}
+
+	// repetition is claimed without asking
+	s = &searcher{moves: []string{"g8f6", "f6g8"}}
+	g, _ = session(t, s, "Nf3\nNg1\nNf3\nNg1\ndraw\n", nil)
+	if g.Result != tournament.Draw || g.Reason != "repetition" {
+		t.Fatalf("want a draw by repetition, got %s by %s", g.Result, g.Reason)
+	}
+}
+
+func TestHint(t *testing.T) {
+	s := &searcher{moves: []string{"d2d4"}, score: 20}
+	g, out := session(t, s, "hint\nquit\n", nil)
+	if len(g.Moves) != 0 || s.searches != 1 {
+		t.Fatalf("a hint played %s after %d searches", uci(g), s.searches)
+	}
+	if !strings.Contains(out, "Try d4 (+0.20/10, line d4)") {
+		t.Fatalf("no hint in:\n%s", out)
+	}
+}
+
+func TestSearchFailure(t *testing.T) {
+	var out bytes.Buffer
+	p := play.New(&searcher{fail: true}, strings.NewReader("e4\n"), &out)
+	err := p.Play(context.Background())
+	if err == nil {
+		t.Fatal("a failed search didn't end the game")
+	}
+	if g := p.Record(); g.Result != tournament.Ongoing || g.Reason != "no workers" || len(g.Moves) != 1 {
+		t.Fatalf("want the game so far with the error, got %+v", g)
+	}
+}
+
+func TestBoard(t *testing.T) {
+	// the first rank drawn, skipping the blank line and file letters
+	top := func(out string) string {
+		return strings.Fields(strings.Split(out, "\n")[2])[0]
+	}
+	_, out := session(t, &searcher{}, "quit\n", nil)
+	if top(out) != "8" {
+		t.Fatalf("want white at the bottom:\n%s", out)
+	}
+	_, out = session(t, &searcher{}, "quit\n", func(p *play.Session) { p.SetFlipped(true) })
+	if top(out) != "1" || !strings.Contains(out, "   h g f e d c b a") {
+		t.Fatalf("want black at the bottom:\n%s", out)
+	}
+
+	// the player's side is at the bottom, flip turns it round
+	_, out = session(t, &searcher{}, "flip\nquit\n", func(p *play.Session) {
+		p.SetColor(chess.Black)
+		p.SetClear(true)
+	})
+	boards := strings.Split(out, "\033[H\033[2J")
+	if len(boards) != 4 {
+		t.Fatalf("want the screen cleared for each of 3 boards:\n%q", out)
+	}
+	if top(boards[1]) != "1" || top(boards[3]) != "8" {
+		t.Fatalf("want black at the bottom, then white:\n%s", out)
+	}
+}
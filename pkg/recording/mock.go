@@ -0,0 +1,249 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Mock stands in for the workers of a client recording, one loopback
+// listener per worker. Each connection made to a worker is served the next
+// recorded connection to it: every recorded reply goes out once the request
+// it followed has arrived, after the same delay, and requests that differ
+// from the recording are reported.
+type Mock struct {
+	r     *Recording
+	opts  Options
+	peers []*mockPeer
+	ctx   context.Context
+	stop  context.CancelFunc
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	report Report
+}
+
+type mockPeer struct {
+	name  string
+	ln    net.Listener
+	conns []Conn
+	next  int // guarded by Mock.mu
+}
+
+// NewMock starts serving a client recording
+func NewMock(r *Recording, opts Options) (*Mock, error) {
+	if r.Role != RoleClient {
+		return nil, errors.New("mock workers need a recording made by a client")
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	m := &Mock{r: r, opts: opts, ctx: ctx, stop: stop, conns: map[net.Conn]bool{}}
+
+	byName := map[string]*mockPeer{}
+	for _, c := range r.Conns() {
+		p, ok := byName[c.Peer]
+		if !ok {
+			p = &mockPeer{name: c.Peer}
+			byName[c.Peer] = p
+			m.peers = append(m.peers, p)
+		}
+		p.conns = append(p.conns, c)
+	}
+	for _, p := range m.peers {
+		var err error
+		p.ln, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	for _, p := range m.peers {
+		m.wg.Add(1)
+		go m.serve(p)
+	}
+	return m, nil
+}
+
+// Workers names the recorded workers in the order they were first contacted
+func (m *Mock) Workers() []string {
+	var names []string
+	for _, p := range m.peers {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+// Addr is where the named worker is being mocked, empty if it isn't
+func (m *Mock) Addr(worker string) string {
+	for _, p := range m.peers {
+		if p.name == worker && p.ln != nil {
+			return p.ln.Addr().String()
+		}
+	}
+	return ""
+}
+
+// Report sums up the playback so far
+func (m *Mock) Report() Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := m.report
+	report.Mismatches = append([]Mismatch{}, m.report.Mismatches...)
+	return report
+}
+
+// Close stops every listener and connection
+func (m *Mock) Close() {
+	m.stop()
+	for _, p := range m.peers {
+		if p.ln != nil {
+			p.ln.Close()
+		}
+	}
+	m.mu.Lock()
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+func (m *Mock) serve(p *mockPeer) {
+	defer m.wg.Done()
+	for {
+		raw, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		if m.ctx.Err() != nil {
+			// accepted just as the mock closed
+			m.mu.Unlock()
+			raw.Close()
+			return
+		}
+		if p.next >= len(p.conns) {
+			// more connections than were recorded
+			m.mu.Unlock()
+			raw.Close()
+			continue
+		}
+		c := p.conns[p.next]
+		p.next++
+		m.conns[raw] = true
+		m.report.Conns++
+		m.mu.Unlock()
+
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.play(raw, c)
+			m.mu.Lock()
+			delete(m.conns, raw)
+			m.mu.Unlock()
+			raw.Close()
+		}()
+	}
+}
+
+// plays the worker's side of one recorded connection
+func (m *Mock) play(raw net.Conn, c Conn) {
+	conn := common.NewConn(raw)
+	events := c.Events
+	// replies are timed from the request they followed
+	anchor, anchored := time.Now(), time.Time{}
+	if len(events) > 0 {
+		anchored = events[0].At
+	}
+	requests := 0
+	mismatch := func(want string, got string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.report.Mismatches = append(m.report.Mismatches, Mismatch{Conn: c.Id, Peer: c.Peer, Index: requests, Want: want, Got: got})
+	}
+
+	i := 0
+	for i < len(events) {
+		e := events[i]
+		if !m.r.FromClient(e) {
+			err := sleepUntil(m.ctx, anchor.Add(m.opts.scale(e.At.Sub(anchored))))
+			if err != nil {
+				return
+			}
+			if e.Message == nil {
+				_, err = conn.Write(e.Raw)
+			} else {
+				var reply common.Message
+				reply, err = e.Decode()
+				if err == nil {
+					err = conn.Send(reply)
+				}
+			}
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			m.report.Sent++
+			m.mu.Unlock()
+			i++
+			continue
+		}
+
+		request, err := conn.Recv()
+		var decodeErr *common.DecodeError
+		if err != nil && !errors.As(err, &decodeErr) {
+			return
+		}
+		m.mu.Lock()
+		m.report.Received++
+		m.mu.Unlock()
+
+		// hellos are negotiated live, the recorded one and its reply are skipped
+		if e.Type() == common.TypeHello {
+			i++
+			if i < len(events) && !m.r.FromClient(events[i]) && events[i].Type() == common.TypeHello {
+				i++
+			}
+		}
+		if hello, ok := request.(*common.Hello); ok {
+			conn.Accept(hello)
+			continue
+		}
+		got := "(undecodable frame)"
+		if request != nil {
+			got = summaryOf(request, m.opts.Strict)
+		}
+		if i >= len(events) {
+			mismatch("", got)
+			requests++
+			break
+		}
+
+		want := summary(events[i], m.opts.Strict)
+		if want != got {
+			mismatch(want, got)
+		}
+		anchor, anchored = time.Now(), events[i].At
+		requests++
+		i++
+	}
+
+	// past the end of the recording, anything more the client sends is extra
+	for {
+		request, err := conn.Recv()
+		var decodeErr *common.DecodeError
+		if err != nil && !errors.As(err, &decodeErr) {
+			return
+		}
+		got := "(undecodable frame)"
+		if request != nil {
+			got = summaryOf(request, m.opts.Strict)
+		}
+		mismatch("", got)
+		requests++
+	}
+}
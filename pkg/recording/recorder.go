@@ -0,0 +1,107 @@
+package recording
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Recorder writes the messages of every connection attached to it. Each
+// event is written as soon as it happens, so a crash loses at most a line.
+type Recorder struct {
+	mu     sync.Mutex
+	out    io.Writer
+	enc    *json.Encoder
+	conns  int
+	err    error
+	closed bool
+}
+
+// Create records to a new file at path, role and name say who is recording
+func Create(path string, role string, name string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(f, role, name)
+	if r.err != nil {
+		f.Close()
+		return nil, r.err
+	}
+	return r, nil
+}
+
+// NewRecorder records to out, closing the recorder closes out if it can be
+func NewRecorder(out io.Writer, role string, name string) *Recorder {
+	r := &Recorder{out: out, enc: json.NewEncoder(out)}
+	r.write(Event{Kind: KindHeader, At: time.Now(), Role: role, Name: name, Protocol: common.ProtocolVersion})
+	return r
+}
+
+// Attach records every message conn sends and receives from now on, peer
+// names the other end. Call it before conn is shared.
+func (r *Recorder) Attach(conn *common.Conn, peer string) {
+	r.mu.Lock()
+	r.conns++
+	id := r.conns
+	r.mu.Unlock()
+	r.write(Event{Kind: KindOpen, At: time.Now(), Conn: id, Peer: peer})
+
+	conn.SetTap(func(sent bool, m common.Message, frame []byte) {
+		e := Event{Kind: KindRecv, At: time.Now(), Conn: id}
+		if sent {
+			e.Kind = KindSend
+		}
+		if m == nil {
+			e.Raw = append([]byte{}, frame...)
+		} else {
+			e.Message = encode(m)
+		}
+		r.write(e)
+	})
+}
+
+// messages as JSON whatever the codec, without admin tokens
+func encode(m common.Message) json.RawMessage {
+	if admin, ok := m.(*common.Admin); ok && admin.Token != "" {
+		redacted := *admin
+		redacted.Token = "redacted"
+		m = &redacted
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (r *Recorder) write(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.closed {
+		return
+	}
+	r.err = r.enc.Encode(e)
+}
+
+// Err returns the first write error, recording stops after one
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close stops recording and closes the output
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if c, ok := r.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
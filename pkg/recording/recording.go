@@ -0,0 +1,174 @@
+// Package recording captures the protocol traffic of a client or worker to
+// a file and plays it back, so a game that went wrong can be reproduced
+// offline. A Recorder taps connections and writes every message with the
+// time it was sent or received. Replay re-drives a worker with the client's
+// side of a recording, and Mock stands in for the workers of a client
+// recording so a client can be run against the same replies.
+//
+// Recordings are JSON Lines: a header naming who recorded it, then one
+// event per connection opened and per message. Messages are stored as
+// JSON whatever codec was in use, and admin tokens are redacted.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Roles that make recordings
+const (
+	RoleClient = "client"
+	RoleWorker = "worker"
+)
+
+// Event kinds
+const (
+	KindHeader = "recording" // first line, who recorded it
+	KindOpen   = "open"      // a connection to or from Peer
+	KindSend   = "send"      // a message the recorder sent
+	KindRecv   = "recv"      // a message the recorder received
+)
+
+// Event is one line of a recording
+type Event struct {
+	Kind string    `json:"kind"`
+	At   time.Time `json:"at"`
+	Conn int       `json:"conn,omitempty"`
+
+	// header
+	Role     string `json:"role,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Protocol int    `json:"protocol,omitempty"`
+
+	// open
+	Peer string `json:"peer,omitempty"`
+
+	// send and recv, Raw holds frames that couldn't be decoded
+	Message json.RawMessage `json:"message,omitempty"`
+	Raw     []byte          `json:"raw,omitempty"`
+}
+
+// Decode returns the event's message, nil for undecodable frames
+func (e Event) Decode() (common.Message, error) {
+	if e.Message == nil {
+		return nil, nil
+	}
+	return common.Decode(e.Message)
+}
+
+// Type is the message's type, empty for undecodable frames
+func (e Event) Type() string {
+	var m struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(e.Message, &m)
+	return m.Type
+}
+
+// Recording is a loaded recording
+type Recording struct {
+	Role   string
+	Name   string
+	Start  time.Time
+	Events []Event // everything after the header, in order
+}
+
+// Conn is the events of one connection
+type Conn struct {
+	Id     int
+	Peer   string
+	Events []Event // messages only, in order
+}
+
+// Load reads the recording at path
+func Load(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Read parses a recording. A recorder that died mid-line leaves a partial
+// last line, which is ignored.
+func Read(in io.Reader) (*Recording, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var r Recording
+	line := 0
+	var partial error
+	for scanner.Scan() {
+		line++
+		if partial != nil {
+			return nil, partial
+		}
+		var e Event
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			partial = fmt.Errorf("line %d: %w", line, err)
+			continue
+		}
+		if line == 1 {
+			if e.Kind != KindHeader {
+				return nil, errors.New("not a recording, the first line isn't a header")
+			}
+			r.Role, r.Name, r.Start = e.Role, e.Name, e.At
+			continue
+		}
+		r.Events = append(r.Events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, errors.New("empty recording")
+	}
+	return &r, nil
+}
+
+// Conns groups the messages by connection, in the order they were opened
+func (r *Recording) Conns() []Conn {
+	byId := map[int]*Conn{}
+	var ids []int
+	for _, e := range r.Events {
+		c, ok := byId[e.Conn]
+		if !ok {
+			c = &Conn{Id: e.Conn}
+			byId[e.Conn] = c
+			ids = append(ids, e.Conn)
+		}
+		switch e.Kind {
+		case KindOpen:
+			c.Peer = e.Peer
+		case KindSend, KindRecv:
+			c.Events = append(c.Events, e)
+		}
+	}
+	sort.Ints(ids)
+	conns := make([]Conn, len(ids))
+	for i, id := range ids {
+		conns[i] = *byId[id]
+	}
+	return conns
+}
+
+// FromClient reports whether the client sent the event's message
+func (r *Recording) FromClient(e Event) bool {
+	if r.Role == RoleClient {
+		return e.Kind == KindSend
+	}
+	return e.Kind == KindRecv
+}
@@ -0,0 +1,190 @@
+package recording_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+)
+
+var script = fakeengine.Script{Moves: []string{"e2e4", "e7e5", "g1f3", "b8c6"}, Score: 20, Nodes: 100}
+
+// plays plies moves with cl, returning them
+func play(t *testing.T, cl *client.Client, plies int) []string {
+	t.Helper()
+	ctx := context.Background()
+	err := cl.NewGame(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var moves []string
+	game := chess.NewGame()
+	for i := 0; i < plies; i++ {
+		positions := game.Positions()
+		result, err := cl.Search(ctx, game.Position(), positions[:len(positions)-1], client.Limits{})
+		if err != nil {
+			t.Fatalf("ply %d: %v", i+1, err)
+		}
+		err = game.Move(result.Move)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moves = append(moves, result.Move.String())
+	}
+	return moves
+}
+
+// records a short game between a client and one worker, from both sides
+func record(t *testing.T, c *clustertest.Cluster) (clientSide *recording.Recording, workerSide *recording.Recording, moves []string) {
+	t.Helper()
+	var clientBuf, workerBuf bytes.Buffer
+	workerRec := recording.NewRecorder(&workerBuf, recording.RoleWorker, "clustertest-00")
+	c.Worker("clustertest-00").SetRecorder(workerRec)
+
+	cl := c.NewClient(client.NamedWorkers("clustertest", 1))
+	clientRec := recording.NewRecorder(&clientBuf, recording.RoleClient, "recording-test")
+	cl.SetRecorder(clientRec)
+	err := cl.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves = play(t, cl, 4)
+	cl.Shutdown()
+	// the worker records the client's stop before the session ends
+	deadline := time.Now().Add(common.Wait)
+	for c.Worker("clustertest-00").Status().SessionActive {
+		if time.Now().After(deadline) {
+			t.Fatal("the session never ended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clientRec.Close()
+	workerRec.Close()
+
+	clientSide, err = recording.Read(&clientBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workerSide, err = recording.Read(&workerBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientSide, workerSide, moves
+}
+
+func TestRecord(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, Script: script, NoClient: true})
+	clientSide, workerSide, moves := record(t, c)
+
+	if clientSide.Role != recording.RoleClient || workerSide.Role != recording.RoleWorker {
+		t.Fatalf("wrong roles %q and %q", clientSide.Role, workerSide.Role)
+	}
+	conns := clientSide.Conns()
+	if len(conns) != 1 || conns[0].Peer != "clustertest-00" {
+		t.Fatalf("want one connection to clustertest-00, got %+v", conns)
+	}
+	// both sides saw the same messages, mirrored
+	workerConns := workerSide.Conns()
+	if len(workerConns) != 1 || len(workerConns[0].Events) != len(conns[0].Events) {
+		t.Fatalf("sides differ: %+v and %+v", conns, workerConns)
+	}
+	for i, e := range conns[0].Events {
+		w := workerConns[0].Events[i]
+		if clientSide.FromClient(e) != workerSide.FromClient(w) || e.Type() != w.Type() {
+			t.Fatalf("message %d: client saw %s %s, worker saw %s %s", i, e.Kind, e.Type(), w.Kind, w.Type())
+		}
+	}
+	if len(moves) != 4 || moves[0] != "e2e4" {
+		t.Fatalf("unexpected game %v", moves)
+	}
+}
+
+func TestReadPartialLine(t *testing.T) {
+	var buf bytes.Buffer
+	rec := recording.NewRecorder(&buf, recording.RoleClient, "partial")
+	rec.Close()
+	buf.WriteString(`{"kind":"open","at":"2024-`)
+
+	r, err := recording.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "partial" || len(r.Events) != 0 {
+		t.Fatalf("unexpected recording %+v", r)
+	}
+
+	_, err = recording.Read(bytes.NewBufferString(`{"kind":"open"}` + "\n"))
+	if err == nil {
+		t.Fatal("read a recording without a header")
+	}
+}
+
+func TestReplayWorker(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, Script: script, NoClient: true})
+	clientSide, workerSide, _ := record(t, c)
+
+	c.AddWorker("clustertest-01", script)
+	opts := recording.Options{Speed: 2, Strict: true}
+	for _, r := range []*recording.Recording{clientSide, workerSide} {
+		report, err := recording.Replay(context.Background(), r, c.Addr("clustertest-01"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Mismatches) > 0 || report.Sent == 0 || report.Received == 0 {
+			t.Fatalf("%s recording: %+v", r.Role, report)
+		}
+	}
+
+	// a worker playing differently is caught
+	c.AddWorker("clustertest-02", fakeengine.Script{Moves: []string{"d2d4", "d7d5"}})
+	report, err := recording.Replay(context.Background(), clientSide, c.Addr("clustertest-02"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) == 0 {
+		t.Fatal("replies from a different engine matched")
+	}
+}
+
+func TestMockDrivesClient(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, Script: script, NoClient: true})
+	clientSide, _, moves := record(t, c)
+
+	mock, err := recording.NewMock(clientSide, recording.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	if names := mock.Workers(); len(names) != 1 || names[0] != "clustertest-00" {
+		t.Fatalf("unexpected workers %v", names)
+	}
+
+	cl := c.NewClient(client.Selector{Workers: []string{mock.Addr("clustertest-00")}})
+	err = cl.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := play(t, cl, 4)
+	cl.Shutdown()
+	for i := range moves {
+		if again[i] != moves[i] {
+			t.Fatalf("ply %d: recorded %s, mocked %s", i+1, moves[i], again[i])
+		}
+	}
+	report := mock.Report()
+	if report.Conns != 1 || len(report.Mismatches) > 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	_, err = recording.NewMock(&recording.Recording{Role: recording.RoleWorker}, recording.Options{})
+	if err == nil {
+		t.Fatal("mocked workers from a worker's recording")
+	}
+}
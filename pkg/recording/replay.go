@@ -0,0 +1,322 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Options control playback
+type Options struct {
+	Speed  float64 // 2 plays twice as fast as recorded, 0 for the recorded pace
+	Strict bool    // compare every field of messages, not just their type and ids
+	Peer   string  // Replay only: the connections with this peer, empty for all
+}
+
+// scales a recorded gap to the playback speed
+func (o Options) scale(d time.Duration) time.Duration {
+	if o.Speed <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) / o.Speed)
+}
+
+// Mismatch is a message that differs from the recording
+type Mismatch struct {
+	Conn  int    `json:"conn"`
+	Peer  string `json:"peer"`
+	Index int    `json:"index"` // among the messages from the side being checked
+	Want  string `json:"want"`  // as recorded, empty for a message that wasn't
+	Got   string `json:"got"`   // as played, empty for a message that never came
+}
+
+func (m Mismatch) String() string {
+	switch {
+	case m.Got == "":
+		return fmt.Sprintf("conn %d (%s) message %d: missing %s", m.Conn, m.Peer, m.Index, m.Want)
+	case m.Want == "":
+		return fmt.Sprintf("conn %d (%s) message %d: unexpected %s", m.Conn, m.Peer, m.Index, m.Got)
+	}
+	return fmt.Sprintf("conn %d (%s) message %d: want %s, got %s", m.Conn, m.Peer, m.Index, m.Want, m.Got)
+}
+
+// Report sums up a playback
+type Report struct {
+	Conns      int
+	Sent       int
+	Received   int
+	Mismatches []Mismatch
+}
+
+func (r *Report) add(o Report) {
+	r.Conns += o.Conns
+	r.Sent += o.Sent
+	r.Received += o.Received
+	r.Mismatches = append(r.Mismatches, o.Mismatches...)
+}
+
+// Replay re-drives the worker at addr with the client's side of a
+// recording, keeping the recorded timing, and reports where its replies
+// differ. Due times are moved along by the time since they were recorded.
+// A client recording usually talks to several workers, pick one with
+// Options.Peer.
+func Replay(ctx context.Context, r *Recording, addr string, opts Options) (Report, error) {
+	var conns []Conn
+	peers := map[string]bool{}
+	for _, c := range r.Conns() {
+		if opts.Peer == "" || c.Peer == opts.Peer {
+			conns = append(conns, c)
+			peers[c.Peer] = true
+		}
+	}
+	if len(conns) == 0 {
+		return Report{}, errors.New("no connections to replay")
+	}
+	if r.Role == RoleClient && len(peers) > 1 {
+		return Report{}, fmt.Errorf("the recording talks to %d workers, pick one to replay", len(peers))
+	}
+
+	begin := time.Now()
+	var mu sync.Mutex
+	var report Report
+	var errs []error
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c Conn) {
+			defer wg.Done()
+			p := player{r: r, opts: opts, begin: begin}
+			result, err := p.replay(ctx, c, addr)
+			mu.Lock()
+			defer mu.Unlock()
+			report.add(result)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("conn %d: %w", c.Id, err))
+			}
+		}(c)
+	}
+	wg.Wait()
+	sort.SliceStable(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].Conn < report.Mismatches[j].Conn
+	})
+	return report, errors.Join(errs...)
+}
+
+// replays connections against a shared clock
+type player struct {
+	r     *Recording
+	opts  Options
+	begin time.Time
+}
+
+// when a recorded event is due in playback
+func (p player) at(t time.Time) time.Time {
+	return p.begin.Add(p.opts.scale(t.Sub(p.r.Start)))
+}
+
+func (p player) replay(ctx context.Context, c Conn, addr string) (Report, error) {
+	report := Report{Conns: 1}
+	var requests, replies []Event
+	for _, e := range c.Events {
+		if p.r.FromClient(e) {
+			requests = append(requests, e)
+		} else {
+			replies = append(replies, e)
+		}
+	}
+	if len(requests) == 0 {
+		return report, nil
+	}
+
+	err := sleepUntil(ctx, p.at(c.Events[0].At))
+	if err != nil {
+		return report, err
+	}
+	dialer := net.Dialer{Timeout: common.Wait}
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return report, err
+	}
+	conn := common.NewConn(raw)
+	defer conn.Close()
+
+	// a leading hello is negotiated afresh rather than replayed, with its reply
+	if requests[0].Type() == common.TypeHello {
+		m, err := requests[0].Decode()
+		if err != nil {
+			return report, err
+		}
+		conn.SetDeadline(time.Now().Add(common.Wait))
+		err = conn.Negotiate(m.(*common.Hello).Codecs)
+		if err != nil {
+			return report, err
+		}
+		conn.SetDeadline(time.Time{})
+		requests = requests[1:]
+		if len(replies) > 0 && replies[0].Type() == common.TypeHello {
+			replies = replies[1:]
+		}
+	}
+
+	// replies are collected while the requests go out
+	var mu sync.Mutex
+	var got []common.Message
+	arrived := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			m, err := conn.Recv()
+			var decodeErr *common.DecodeError
+			if errors.As(err, &decodeErr) {
+				continue
+			} else if err != nil {
+				return
+			}
+			mu.Lock()
+			got = append(got, m)
+			mu.Unlock()
+			select {
+			case arrived <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	for _, e := range requests {
+		err := sleepUntil(ctx, p.at(e.At))
+		if err != nil {
+			return report, err
+		}
+		conn.SetWriteDeadline(time.Now().Add(common.Wait))
+		if e.Message == nil {
+			_, err = conn.Write(e.Raw)
+		} else {
+			var m common.Message
+			m, err = e.Decode()
+			if err != nil {
+				return report, err
+			}
+			shiftDue(m, e.At)
+			err = conn.Send(m)
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Sent++
+	}
+
+	// give the replies as long as they took, and then some
+	until := p.at(c.Events[len(c.Events)-1].At).Add(common.Wait)
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+wait:
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n >= len(replies) {
+			break
+		}
+		select {
+		case <-arrived:
+		case <-done:
+			break wait
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	conn.Close()
+	<-done
+
+	report.Received = len(got)
+	for i := 0; i < len(replies) || i < len(got); i++ {
+		var want, have string
+		if i < len(replies) {
+			want = summary(replies[i], p.opts.Strict)
+		}
+		if i < len(got) {
+			have = summaryOf(got[i], p.opts.Strict)
+		}
+		if want != have {
+			report.Mismatches = append(report.Mismatches, Mismatch{Conn: c.Id, Peer: c.Peer, Index: i, Want: want, Got: have})
+		}
+	}
+	return report, ctx.Err()
+}
+
+// due times are absolute, they move along with the replay so the worker
+// gets as long as it did
+func shiftDue(m common.Message, recorded time.Time) {
+	if pm, ok := m.(*common.ParseMoves); ok {
+		pm.DueTime = time.Now().Add(pm.DueTime.Sub(recorded))
+	}
+}
+
+// fields that change from run to run, skipped even when strict
+var volatile = []string{"due_time", "trace_id", "span_id", "spans"}
+
+// a recorded message reduced to what's compared
+func summary(e Event, strict bool) string {
+	if e.Message == nil {
+		return "(undecodable frame)"
+	}
+	return reduce(e.Message, strict)
+}
+
+func summaryOf(m common.Message, strict bool) string {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "(unencodable message)"
+	}
+	return reduce(data, strict)
+}
+
+// the type and ids, or everything but the volatile fields when strict,
+// as JSON with sorted keys
+func reduce(data []byte, strict bool) string {
+	var fields map[string]interface{}
+	if json.Unmarshal(data, &fields) != nil {
+		return string(data)
+	}
+	if strict {
+		for _, name := range volatile {
+			delete(fields, name)
+		}
+	} else {
+		kept := map[string]interface{}{}
+		for _, name := range []string{"type", "pos_id", "job_id"} {
+			if v, ok := fields[name]; ok {
+				kept[name] = v
+			}
+		}
+		fields = kept
+	}
+	out, _ := json.Marshal(fields)
+	return string(out)
+}
+
+// waits until t, or returns early with ctx's error
+func sleepUntil(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Optional worker config file, re-read by the reload-config admin command
+type Config struct {
+	AdminToken    string   `json:"admin_token"`
+	EngineOptions []string `json:"engine_options"` // "name value" strings pinned for every game
+}
+
+// Set the shared secret admin commands must carry, empty disables them
+func (w *Worker) SetAdminToken(token string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.adminToken = token
+}
+
+// Load a config file and remember its path for reload-config.
+// Either a file holding just this Config or a shared config file with it
+// under "worker" is accepted.
+func (w *Worker) LoadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Config
+		Worker *Config `json:"worker"`
+	}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	cfg := file.Config
+	if file.Worker != nil {
+		cfg = *file.Worker
+	}
+
+	err = w.SetEngineOptions(cfg.EngineOptions)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.configPath = path
+	if cfg.AdminToken != "" {
+		w.adminToken = cfg.AdminToken
+	}
+	return nil
+}
+
+// Set the file reload-config re-reads, without loading it now
+func (w *Worker) SetConfigPath(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.configPath = path
+}
+
+// Pin "name value" engine options for every game and apply them to the running engine
+func (w *Worker) SetEngineOptions(engineOptions []string) error {
+	var options []uci.CmdSetOption
+	for _, option := range engineOptions {
+		o, err := parseOption(option)
+		if err != nil {
+			return err
+		}
+		options = append(options, o)
+	}
+
+	// apply to the running engine as well as future games
+	eng := w.engine()
+	for _, o := range options {
+		err := eng.Run(o)
+		if err != nil {
+			return fmt.Errorf("unable to set %s on engine: %w", o.Name, err)
+		}
+		w.mu.Lock()
+		w.setValue(o)
+		w.mu.Unlock()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.engineOptions = options
+	return nil
+}
+
+// Runs an admin command and replies on conn.
+// Returns true when the connection should be closed.
+func (w *Worker) admin(conn *common.Conn, m *common.Admin) bool {
+	err := w.authorize(m.Token)
+	if err != nil {
+		w.log().Warn("rejected admin command", "command", m.Command, "remote", conn.RemoteAddr().String(), common.LogErr, err)
+		w.sendError(conn, errAdmin, err.Error())
+		return false
+	}
+
+	reply := common.AdminReply{Type: common.TypeAdminReply, Command: m.Command}
+	switch m.Command {
+	case common.AdminStatus:
+		status := w.Status()
+		reply.Status = &status
+	case common.AdminDrain:
+		w.Drain()
+		reply.Message = "draining"
+	case common.AdminReloadConfig:
+		w.mu.Lock()
+		path := w.configPath
+		w.mu.Unlock()
+		if path == "" {
+			w.sendError(conn, errAdmin, "worker was started without a config file")
+			return false
+		}
+		err = w.LoadConfig(path)
+		if err != nil {
+			w.sendError(conn, errAdmin, fmt.Sprint("Unable to reload config: ", err))
+			return false
+		}
+		reply.Message = "reloaded " + path
+	case common.AdminSetEngineOption:
+		option, err := parseOption(strings.Join(m.Args, " "))
+		if err != nil {
+			w.sendError(conn, errOption, err.Error())
+			return false
+		}
+		err = w.setEngineOption(option)
+		if err != nil {
+			w.sendError(conn, errEngine, fmt.Sprint("Unable to set engine option: ", err))
+			return false
+		}
+		reply.Message = fmt.Sprintf("%s set to %q", option.Name, option.Value)
+	case common.AdminRestart:
+		err = w.restartEngine()
+		if err != nil {
+			w.sendError(conn, errEngine, fmt.Sprint("Unable to restart engine: ", err))
+			return false
+		}
+		reply.Message = "engine restarted"
+	case common.AdminExit:
+		reply.Message = "exiting"
+		w.send(conn, &reply)
+		go w.Shutdown(w.shutdownGrace())
+		return true
+	default:
+		w.sendError(conn, errAdmin, fmt.Sprint("Unknown admin command: ", m.Command))
+		return false
+	}
+
+	err = w.send(conn, &reply)
+	if err != nil {
+		w.log().Warn("unable to send admin reply", "command", m.Command, common.LogErr, err)
+	}
+	return false
+}
+
+// Checks the token in constant time
+func (w *Worker) authorize(token string) error {
+	w.mu.Lock()
+	expected := w.adminToken
+	w.mu.Unlock()
+
+	if expected == "" {
+		return errors.New("admin commands are disabled on this worker")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return errors.New("admin token rejected")
+	}
+	return nil
+}
+
+// Runs the option now and pins it for future games
+func (w *Worker) setEngineOption(option uci.CmdSetOption) error {
+	err := w.engine().Run(option, uci.CmdIsReady)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.setValue(option)
+	for i, existing := range w.engineOptions {
+		if strings.EqualFold(existing.Name, option.Name) {
+			w.engineOptions[i] = option
+			return nil
+		}
+	}
+	w.engineOptions = append(w.engineOptions, option)
+	return nil
+}
+
+// Replaces the engine process, only allowed while no client holds the session
+func (w *Worker) restartEngine() error {
+	if !w.session.TryLock() {
+		return errors.New("a client session is active, drain the worker first")
+	}
+	defer w.session.Unlock()
+
+	e, err := startEngine(w.newEngine)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	for _, option := range w.engineOptions {
+		err = e.Run(option)
+		if err != nil {
+			w.mu.Unlock()
+			e.Close()
+			return err
+		}
+	}
+	old := w.eng
+	w.eng = e
+	w.engineName = e.ID()["name"]
+	w.engineDefaults = optionDefaults(e)
+	// the new engine only has the pinned options
+	w.engineValues = nil
+	for _, option := range w.engineOptions {
+		w.setValue(option)
+	}
+	w.mu.Unlock()
+
+	old.Close()
+	return nil
+}
+
+// The current engine, admin commands must not read w.eng directly
+// because restart can swap it
+func (w *Worker) engine() Engine {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.eng
+}
+
+// Status returns a snapshot of what the worker is doing
+func (w *Worker) Status() common.WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := common.WorkerStatus{
+		Name:          w.name,
+		UptimeSeconds: time.Since(w.started).Seconds(),
+		Draining:      w.draining,
+		SessionActive: w.conn != nil,
+		Searching:     w.searching,
+		PosId:         w.posId,
+		JobId:         w.jobId,
+		Sessions:      w.sessions,
+		Jobs:          w.jobs,
+		Engine:        w.engineName,
+		EngineState:   "idle",
+	}
+	if w.conn != nil {
+		status.Client = w.conn.RemoteAddr().String()
+	}
+	if w.searching {
+		due := w.jobDue
+		status.JobDueTime = &due
+		status.EngineState = "searching"
+	}
+	if w.stopped {
+		status.EngineState = "stopped"
+	}
+	for _, option := range w.engineOptions {
+		status.EngineOptions = append(status.EngineOptions, strings.TrimSpace(option.Name+" "+option.Value))
+	}
+	return status
+}
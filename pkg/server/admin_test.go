@@ -0,0 +1,211 @@
+package server_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+const token = "secret"
+
+// a single worker taking admin commands with token
+func startAdmin(t *testing.T) (*clustertest.Cluster, *clustertest.Conn) {
+	t.Helper()
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, NoClient: true})
+	c.Worker(worker).SetAdminToken(token)
+	return c, c.Dial(worker)
+}
+
+// runs command with the right token, the reply must be an admin_reply
+func admin(t *testing.T, conn *clustertest.Conn, command string, args ...string) *common.AdminReply {
+	t.Helper()
+	reply := conn.Request(&common.Admin{Type: common.TypeAdmin, Token: token, Command: command, Args: args})
+	r, ok := reply.(*common.AdminReply)
+	if !ok || r.Command != command {
+		t.Fatalf("%s: want an admin_reply, got %#v", command, reply)
+	}
+	return r
+}
+
+func TestAdminWrongToken(t *testing.T) {
+	c, conn := startAdmin(t)
+	for _, wrong := range []string{"", "secreT", "secret2"} {
+		reply := conn.Request(&common.Admin{Type: common.TypeAdmin, Token: wrong, Command: common.AdminDrain})
+		wantError(t, reply, "admin token rejected")
+	}
+	// none of them drained the worker
+	if status := admin(t, conn, common.AdminStatus).Status; status == nil || status.Draining {
+		t.Fatalf("want a status that isn't draining, got %#v", status)
+	}
+
+	// without a token admin commands are off
+	c.Worker(worker).SetAdminToken("")
+	reply := conn.Request(&common.Admin{Type: common.TypeAdmin, Command: common.AdminStatus})
+	wantError(t, reply, "disabled")
+}
+
+func TestAdminStatus(t *testing.T) {
+	c, conn := startAdmin(t)
+	session := c.Dial(worker)
+	newGame(t, session, 4)
+
+	status := admin(t, conn, common.AdminStatus).Status
+	if status == nil {
+		t.Fatal("status reply without a status")
+	}
+	if status.Name != worker || !status.SessionActive || status.PosId != 4 || status.Sessions != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status.Engine != fakeengine.DefaultName || status.EngineState != "idle" {
+		t.Fatalf("want an idle fakeengine, got %q %s", status.Engine, status.EngineState)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	c, conn := startAdmin(t)
+	session := c.Dial(worker)
+	newGame(t, session, 0)
+
+	if r := admin(t, conn, common.AdminDrain); r.Message != "draining" {
+		t.Fatalf("unexpected drain reply %q", r.Message)
+	}
+	if _, ok := session.Recv().(*common.Leaving); !ok {
+		t.Fatal("the client wasn't told the worker is leaving")
+	}
+	err := c.Catalog.WaitGone(3*time.Second, worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// admin still works, new sessions don't
+	if status := admin(t, conn, common.AdminStatus).Status; !status.Draining {
+		t.Fatalf("want a draining status, got %+v", status)
+	}
+	reply := c.Dial(worker).Request(&common.NewGame{Type: common.TypeNewGame, Position: start})
+	if _, ok := reply.(*common.Leaving); !ok {
+		t.Fatalf("want a new session sent away, got %#v", reply)
+	}
+}
+
+func TestAdminSetEngineOption(t *testing.T) {
+	c, conn := startAdmin(t)
+	r := admin(t, conn, common.AdminSetEngineOption, "Hash", "64")
+	if r.Message != `Hash set to "64"` {
+		t.Fatalf("unexpected reply %q", r.Message)
+	}
+	if hash := c.Engine(worker).Values()["Hash"]; hash != "64" {
+		t.Fatalf("want Hash 64 on the engine, got %q", hash)
+	}
+	status := admin(t, conn, common.AdminStatus).Status
+	if len(status.EngineOptions) != 1 || status.EngineOptions[0] != "Hash 64" {
+		t.Fatalf("want Hash 64 pinned, got %q", status.EngineOptions)
+	}
+
+	// setting it again replaces the pinned value
+	admin(t, conn, common.AdminSetEngineOption, "Hash", "128")
+	status = admin(t, conn, common.AdminStatus).Status
+	if len(status.EngineOptions) != 1 || status.EngineOptions[0] != "Hash 128" {
+		t.Fatalf("want Hash 128 pinned, got %q", status.EngineOptions)
+	}
+
+	reply := conn.Request(&common.Admin{Type: common.TypeAdmin, Token: token, Command: common.AdminSetEngineOption, Args: []string{"Too", "many", "words"}})
+	wantError(t, reply, "option")
+}
+
+func TestAdminErrors(t *testing.T) {
+	_, conn := startAdmin(t)
+	tests := []struct {
+		command string
+		args    []string
+		want    string
+	}{
+		{"dance", nil, "Unknown admin command: dance"},
+		{common.AdminSetEngineOption, nil, "Unable to decode option"},
+		{common.AdminReloadConfig, nil, "started without a config file"},
+	}
+	for _, tt := range tests {
+		reply := conn.Request(&common.Admin{Type: common.TypeAdmin, Token: token, Command: tt.command, Args: tt.args})
+		wantError(t, reply, tt.want)
+	}
+
+	// exit only comes as an admin command
+	wantError(t, conn.Request(&common.Exit{Type: common.TypeExit}), "authorized admin command")
+}
+
+func TestAdminReloadConfig(t *testing.T) {
+	c, conn := startAdmin(t)
+	path := filepath.Join(t.TempDir(), "worker.json")
+	err := os.WriteFile(path, []byte(`{"admin_token": "`+token+`"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Worker(worker).LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new token applies from the next command, options reach the engine
+	err = os.WriteFile(path, []byte(`{"admin_token": "rotated", "engine_options": ["Threads 2"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := admin(t, conn, common.AdminReloadConfig); r.Message != "reloaded "+path {
+		t.Fatalf("unexpected reload reply %q", r.Message)
+	}
+	if threads := c.Engine(worker).Values()["Threads"]; threads != "2" {
+		t.Fatalf("want Threads 2 on the engine, got %q", threads)
+	}
+	wantError(t, conn.Request(&common.Admin{Type: common.TypeAdmin, Token: token, Command: common.AdminStatus}), "admin token rejected")
+	reply := conn.Request(&common.Admin{Type: common.TypeAdmin, Token: "rotated", Command: common.AdminStatus})
+	if r, ok := reply.(*common.AdminReply); !ok || len(r.Status.EngineOptions) != 1 || r.Status.EngineOptions[0] != "Threads 2" {
+		t.Fatalf("want the rotated token accepted and Threads 2 pinned, got %#v", reply)
+	}
+
+	// a broken file is reported
+	err = os.WriteFile(path, []byte(`{"engine_options": ["a b c"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply = conn.Request(&common.Admin{Type: common.TypeAdmin, Token: "rotated", Command: common.AdminReloadConfig})
+	wantError(t, reply, "Unable to reload config")
+}
+
+func TestAdminExit(t *testing.T) {
+	c, conn := startAdmin(t)
+	addr := c.Addr(worker)
+	if r := admin(t, conn, common.AdminExit); r.Message != "exiting" {
+		t.Fatalf("unexpected exit reply %q", r.Message)
+	}
+	// the worker hangs up on the admin connection
+	conn.SetReadDeadline(time.Now().Add(common.Wait))
+	if m, err := conn.Conn.Recv(); err == nil {
+		t.Fatalf("want the connection closed, got %#v", m)
+	}
+
+	err := c.Catalog.WaitGone(3*time.Second, worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and stops listening once it's shut down
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		raw, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			break
+		}
+		raw.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the worker still accepts connections after exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !c.Worker(worker).Status().Draining {
+		t.Fatal("the worker didn't shut down")
+	}
+}
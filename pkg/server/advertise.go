@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/catalog"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// struct for json messages to catalog server
+type message struct {
+	Type    string `json:"type"`
+	Owner   string `json:"owner"`
+	Port    int    `json:"port"`
+	Project string `json:"project"`
+
+	Labels   map[string]string `json:"labels,omitempty"`
+	Capacity int               `json:"capacity"`
+
+	// engine and host details clients filter and rank on
+	Engine   string  `json:"engine,omitempty"`
+	Net      string  `json:"net,omitempty"` // NNUE file, its name carries the net's hash
+	Threads  int     `json:"threads,omitempty"`
+	Hash     int     `json:"hash,omitempty"` // MB
+	CPU      string  `json:"cpu,omitempty"`
+	Protocol int     `json:"protocol"`
+	Load     float64 `json:"load"` // 1 minute load average per CPU
+	Busy     bool    `json:"busy"` // a client session is active
+	Draining bool    `json:"draining"`
+
+	// seconds the catalog keeps the entry without another update
+	Lifetime int `json:"lifetime"`
+}
+
+// how often the worker re-advertises, entries outlive a few missed updates
+const (
+	advertiseEvery    = time.Minute
+	advertiseLifetime = 3 * 60
+)
+
+// builds the catalog advertisement from the worker's current state
+func (w *Worker) advertisement(owner string) message {
+	port, _ := strconv.Atoi(w.port)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := message{
+		Type:     catalog.WorkerType,
+		Owner:    owner,
+		Project:  w.name,
+		Port:     port,
+		Labels:   w.labels,
+		Capacity: w.capacity,
+		Engine:   w.engineName,
+		Net:      w.optionValue("EvalFile"),
+		CPU:      cpuModel,
+		Protocol: common.ProtocolVersion,
+		Load:     loadAverage(),
+		Busy:     w.conn != nil,
+		Draining: w.draining,
+		Lifetime: advertiseLifetime,
+	}
+	m.Threads, _ = strconv.Atoi(w.optionValue("Threads"))
+	m.Hash, _ = strconv.Atoi(w.optionValue("Hash"))
+	return m
+}
+
+// value of an engine option in effect on the running engine: the last value
+// a client's new_game, an admin command or the config set, otherwise the
+// engine's default. Called with mu held.
+func (w *Worker) optionValue(name string) string {
+	value, ok := w.engineValues[strings.ToLower(name)]
+	if ok {
+		return value
+	}
+	return w.engineDefaults[name]
+}
+
+// remembers an option the running engine was set to. Called with mu held.
+func (w *Worker) setValue(option uci.CmdSetOption) {
+	if w.engineValues == nil {
+		w.engineValues = map[string]string{}
+	}
+	w.engineValues[strings.ToLower(option.Name)] = option.Value
+}
+
+// defaults of every option the engine reported after uci, read once because
+// Options blocks while the engine searches
+func optionDefaults(e Engine) map[string]string {
+	defaults := map[string]string{}
+	for name, option := range e.Options() {
+		defaults[name] = option.Default
+	}
+	return defaults
+}
+
+// the CPU model doesn't change, read it once
+var cpuModel = readCPUModel()
+
+// model name from /proc/cpuinfo, the architecture elsewhere
+func readCPUModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.GOARCH
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return runtime.GOARCH
+}
+
+// 1 minute load average divided by the CPU count, 0 where /proc/loadavg is missing
+func loadAverage() float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return load / float64(runtime.NumCPU())
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+func TestAdvertisedOptions(t *testing.T) {
+	w, err := StartupWith(func() (Engine, error) { return fakeengine.New(fakeengine.Script{}), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Run()
+	defer w.Shutdown(time.Millisecond)
+
+	want := func(threads int, hash int) {
+		t.Helper()
+		m := w.advertisement("test")
+		if m.Threads != threads || m.Hash != hash {
+			t.Fatalf("want %d threads and %d MB hash advertised, got %d and %d", threads, hash, m.Threads, m.Hash)
+		}
+	}
+	// the engine's defaults
+	want(1, 16)
+
+	// a client's new_game sets what the engine runs with
+	raw, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", w.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	conn := common.NewConn(raw)
+	err = conn.Send(&common.NewGame{Type: common.TypeNewGame, Position: chess.StartingPosition().String(), Options: []string{"Threads 3", "hash 64"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.SetReadDeadline(time.Now().Add(common.Wait))
+	if m, err := conn.Recv(); err != nil || m.MessageType() != common.TypeReadyOk {
+		t.Fatalf("want ready_ok, got %v: %v", m, err)
+	}
+	want(3, 64)
+
+	// pinned options win over the client's
+	err = w.SetEngineOptions([]string{"Threads 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want(2, 64)
+
+	// a restarted engine only has the pinned options
+	conn.Send(&common.Stop{Type: common.TypeStop})
+	deadline := time.Now().Add(common.Wait)
+	for {
+		err = w.restartEngine()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want(2, 16)
+}
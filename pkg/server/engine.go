@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/notnil/chess/uci"
+)
+
+// Engine is what the worker needs from a UCI engine. *uci.Engine drives a
+// real executable, tests can hand the worker a fakeengine.Engine instead.
+type Engine interface {
+	Run(cmds ...uci.Cmd) error
+	SearchResults() uci.SearchResults
+	ID() map[string]string
+	Options() map[string]uci.Option
+	Close() error
+}
+
+// EngineFactory starts a fresh engine, it's called again when the engine is restarted
+type EngineFactory func() (Engine, error)
+
+// ExecEngine starts the UCI executable at path
+func ExecEngine(path string) EngineFactory {
+	return func() (Engine, error) {
+		e, err := uci.New(path)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// StatusHandler serves /healthz, /status and /metrics for the worker
+func (w *Worker) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// healthy while the worker is taking new work
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		status := w.Status()
+		if status.Draining {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(rw, "draining")
+			return
+		}
+		fmt.Fprintln(rw, "ok")
+	})
+
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		enc.Encode(w.Status())
+	})
+
+	mux.HandleFunc("/metrics", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.metrics.write(rw, w)
+	})
+	return mux
+}
+
+// Serve the status endpoints on addr in the background, returns the bound address
+func (w *Worker) ListenHTTP(addr string) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := http.Serve(ln, w.StatusHandler())
+		if err != nil {
+			w.log().Error("status endpoint stopped", common.LogErr, err)
+		}
+	}()
+	return ln.Addr(), nil
+}
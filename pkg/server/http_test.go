@@ -0,0 +1,165 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+// fetches path from the handler, returning the status code and body
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, NoClient: true, Script: fakeengine.Script{Nodes: 500}})
+	conn := c.Dial(worker)
+	newGame(t, conn, 3)
+	if _, ok := conn.Request(parseMoves(start, 3, 1, "e2e4")).(*common.Results); !ok {
+		t.Fatal("want results")
+	}
+	wantError(t, conn.Request(parseMoves(start, 1, 2, "e2e4")), "pos_id")
+	h := c.Worker(worker).StatusHandler()
+
+	code, body := get(t, h, "/healthz")
+	if code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("want ok, got %d %q", code, body)
+	}
+
+	code, body = get(t, h, "/status")
+	var status common.WorkerStatus
+	err := json.Unmarshal([]byte(body), &status)
+	if code != http.StatusOK || err != nil {
+		t.Fatalf("want a status, got %d %q: %v", code, body, err)
+	}
+	if status.Name != worker || status.Jobs != 1 || !status.SessionActive {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	code, body = get(t, h, "/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("want metrics, got %d", code)
+	}
+	samples := checkExposition(t, body)
+	for name, want := range map[string]float64{
+		"chess_worker_jobs_total":                              1,
+		"chess_worker_nodes_searched_total":                    500,
+		`chess_worker_requests_total{type="new_game"}`:         1,
+		`chess_worker_requests_total{type="parse_moves"}`:      2,
+		`chess_worker_errors_total{kind="pos_id"}`:             1,
+		"chess_worker_session_active":                          1,
+		"chess_worker_draining":                                0,
+		"chess_worker_search_seconds_count":                    1,
+		`chess_worker_search_seconds_bucket{le="+Inf"}`:        1,
+		"chess_worker_deadline_misses_total":                   0,
+		`chess_worker_deadline_miss_seconds_bucket{le="+Inf"}`: 0,
+	} {
+		got, ok := samples[name]
+		if !ok || got != want {
+			t.Errorf("want %s %g, got %g (present %v)", name, want, got, ok)
+		}
+	}
+
+	// unhealthy once draining
+	c.Worker(worker).Drain()
+	code, body = get(t, h, "/healthz")
+	if code != http.StatusServiceUnavailable || body != "draining\n" {
+		t.Fatalf("want draining, got %d %q", code, body)
+	}
+}
+
+var sampleLine = regexp.MustCompile(`^([a-z_]+)(\{[a-z]+="[^"]*"\})? (\S+)$`)
+
+// checks body is in the Prometheus text format: every sample belongs to a
+// family with HELP and TYPE lines, counters end in _total and histogram
+// buckets only grow. Returns the samples by name and labels.
+func checkExposition(t *testing.T, body string) map[string]float64 {
+	t.Helper()
+	samples := map[string]float64{}
+	help := map[string]bool{}
+	types := map[string]string{}
+	var family string
+	var bucket float64
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "#" {
+			switch fields[1] {
+			case "HELP":
+				family = fields[2]
+				help[family] = true
+				bucket = 0
+			case "TYPE":
+				if fields[2] != family || len(fields) != 4 {
+					t.Fatalf("TYPE without its HELP: %q", line)
+				}
+				types[family] = fields[3]
+			}
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("malformed sample line %q", line)
+		}
+		name := m[1]
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			t.Fatalf("bad value in %q: %v", line, err)
+		}
+		if _, dup := samples[name+m[2]]; dup {
+			t.Fatalf("duplicate sample %q", line)
+		}
+		samples[name+m[2]] = value
+
+		switch types[family] {
+		case "counter":
+			if name != family || !strings.HasSuffix(name, "_total") {
+				t.Fatalf("counter %q isn't named family_total", line)
+			}
+		case "gauge":
+			if name != family {
+				t.Fatalf("gauge %q outside its family %s", line, family)
+			}
+		case "histogram":
+			if !strings.HasPrefix(name, family+"_") {
+				t.Fatalf("histogram sample %q outside its family %s", line, family)
+			}
+			if name == family+"_bucket" {
+				if value < bucket {
+					t.Fatalf("bucket %q smaller than the one before", line)
+				}
+				bucket = value
+			}
+		default:
+			t.Fatalf("sample %q without a known TYPE", line)
+		}
+	}
+	for name := range help {
+		if types[name] == "" {
+			t.Errorf("%s has no TYPE", name)
+		}
+	}
+	return samples
+}
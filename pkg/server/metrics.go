@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess/uci"
+)
+
+// error kinds reported to clients, used as the metrics label
+const (
+	errDecode   = "decode"
+	errOption   = "option"
+	errPosition = "position"
+	errNoGame   = "no_game"
+	errPosId    = "pos_id"
+	errDeadline = "deadline"
+	errEngine   = "engine"
+	errSend     = "send"
+	errDraining = "draining"
+	errAdmin    = "admin"
+)
+
+// upper bounds in seconds, chosen around typical turn times
+var searchBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+var deadlineBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Cumulative histogram in the Prometheus style
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(out io.Writer, name string) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(out, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(out, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(out, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(out, "%s_count %d\n", name, h.count)
+}
+
+// Counters and histograms exposed on /metrics
+type metrics struct {
+	mu             sync.Mutex
+	requests       map[string]uint64 // by message type
+	errors         map[string]uint64 // by error kind
+	jobs           uint64
+	nodes          uint64
+	nps            int
+	deadlineMisses uint64
+	searchTime     histogram
+	deadlineMiss   histogram // seconds late, only misses are observed
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:     map[string]uint64{},
+		errors:       map[string]uint64{},
+		searchTime:   newHistogram(searchBuckets),
+		deadlineMiss: newHistogram(deadlineBuckets),
+	}
+}
+
+func (m *metrics) countRequest(msgType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[msgType]++
+}
+
+func (m *metrics) countError(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[kind]++
+}
+
+// records a finished search
+func (m *metrics) observeSearch(elapsed time.Duration, info uci.Info) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs++
+	m.nodes += uint64(info.Nodes)
+	m.nps = info.NPS
+	m.searchTime.observe(elapsed.Seconds())
+}
+
+// records how long after the due time a result went out, early results are ignored
+func (m *metrics) observeDeadline(late time.Duration) {
+	if late <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadlineMisses++
+	m.deadlineMiss.observe(late.Seconds())
+}
+
+// writes everything in the Prometheus text exposition format
+func (m *metrics) write(out io.Writer, w *Worker) {
+	status := w.Status()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(out, "# HELP chess_worker_up_seconds Time since the worker started.")
+	fmt.Fprintln(out, "# TYPE chess_worker_up_seconds gauge")
+	fmt.Fprintf(out, "chess_worker_up_seconds %g\n", status.UptimeSeconds)
+
+	fmt.Fprintln(out, "# HELP chess_worker_draining Whether the worker refuses new work.")
+	fmt.Fprintln(out, "# TYPE chess_worker_draining gauge")
+	fmt.Fprintf(out, "chess_worker_draining %d\n", boolGauge(status.Draining))
+
+	fmt.Fprintln(out, "# HELP chess_worker_session_active Whether a client holds the session.")
+	fmt.Fprintln(out, "# TYPE chess_worker_session_active gauge")
+	fmt.Fprintf(out, "chess_worker_session_active %d\n", boolGauge(status.SessionActive))
+
+	fmt.Fprintln(out, "# HELP chess_worker_requests_total Requests handled by message type.")
+	fmt.Fprintln(out, "# TYPE chess_worker_requests_total counter")
+	for _, key := range sortedKeys(m.requests) {
+		fmt.Fprintf(out, "chess_worker_requests_total{type=%q} %d\n", key, m.requests[key])
+	}
+
+	fmt.Fprintln(out, "# HELP chess_worker_errors_total Errors reported to clients by kind.")
+	fmt.Fprintln(out, "# TYPE chess_worker_errors_total counter")
+	for _, key := range sortedKeys(m.errors) {
+		fmt.Fprintf(out, "chess_worker_errors_total{kind=%q} %d\n", key, m.errors[key])
+	}
+
+	fmt.Fprintln(out, "# HELP chess_worker_jobs_total Searches completed.")
+	fmt.Fprintln(out, "# TYPE chess_worker_jobs_total counter")
+	fmt.Fprintf(out, "chess_worker_jobs_total %d\n", m.jobs)
+
+	fmt.Fprintln(out, "# HELP chess_worker_nodes_searched_total Nodes searched by the engine.")
+	fmt.Fprintln(out, "# TYPE chess_worker_nodes_searched_total counter")
+	fmt.Fprintf(out, "chess_worker_nodes_searched_total %d\n", m.nodes)
+
+	fmt.Fprintln(out, "# HELP chess_worker_nps Nodes per second of the last search.")
+	fmt.Fprintln(out, "# TYPE chess_worker_nps gauge")
+	fmt.Fprintf(out, "chess_worker_nps %d\n", m.nps)
+
+	fmt.Fprintln(out, "# HELP chess_worker_search_seconds Wall time of each search.")
+	fmt.Fprintln(out, "# TYPE chess_worker_search_seconds histogram")
+	m.searchTime.write(out, "chess_worker_search_seconds")
+
+	fmt.Fprintln(out, "# HELP chess_worker_deadline_misses_total Results sent after their due time.")
+	fmt.Fprintln(out, "# TYPE chess_worker_deadline_misses_total counter")
+	fmt.Fprintf(out, "chess_worker_deadline_misses_total %d\n", m.deadlineMisses)
+
+	fmt.Fprintln(out, "# HELP chess_worker_deadline_miss_seconds How late missed results were.")
+	fmt.Fprintln(out, "# TYPE chess_worker_deadline_miss_seconds histogram")
+	m.deadlineMiss.write(out, "chess_worker_deadline_miss_seconds")
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
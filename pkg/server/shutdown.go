@@ -0,0 +1,113 @@
+package server
+
+import (
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Set how long Shutdown lets the current job run before cancelling it
+func (w *Worker) SetShutdownGrace(grace time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.grace = grace
+}
+
+func (w *Worker) shutdownGrace() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.grace
+}
+
+// Drain takes the worker out of rotation. New sessions and jobs are refused,
+// the catalog entry is withdrawn and, once its current job is done, the
+// client is told the worker is leaving. Admin and status requests still work.
+func (w *Worker) Drain() {
+	w.mu.Lock()
+	already := w.draining
+	w.draining = true
+	w.mu.Unlock()
+	if already {
+		return
+	}
+	w.log().Info("draining")
+	w.deregister()
+	go w.release(0, "worker is draining")
+}
+
+// Shutdown drains the worker, gives the current job up to grace to finish
+// before cancelling it, then stops Run, which quits the engine.
+// Later calls do nothing.
+func (w *Worker) Shutdown(grace time.Duration) {
+	w.shutdownOnce.Do(func() {
+		w.log().Info("shutting down server", "grace", grace)
+		w.mu.Lock()
+		w.draining = true
+		w.mu.Unlock()
+		w.deregister()
+		w.release(grace, "worker is shutting down")
+
+		w.mu.Lock()
+		w.stopped = true
+		w.mu.Unlock()
+		w.listener.Close()
+	})
+}
+
+// stops CatalogMessage and waits briefly for its final update to go out
+func (w *Worker) deregister() {
+	w.stopAdvert.Do(func() { close(w.advertStop) })
+	w.mu.Lock()
+	advertising := w.advertising
+	w.mu.Unlock()
+	if !advertising {
+		return
+	}
+	select {
+	case <-w.advertDone:
+	case <-time.After(common.Wait):
+		w.log().Warn("timed out deregistering from catalog")
+	}
+}
+
+// waits for the current job (a grace of 0 waits for it to finish), cancels it
+// if it runs over, then sends leaving to the client and closes its connection
+func (w *Worker) release(grace time.Duration, reason string) {
+	if !w.waitIdle(grace) {
+		w.log().Warn("cancelling search for shutdown", common.LogJobId, w.jobId)
+		w.Stop()
+		w.waitIdle(common.Wait)
+	}
+
+	w.mu.Lock()
+	conn := w.conn
+	w.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	err := w.send(conn, &common.Leaving{Type: common.TypeLeaving, Reason: reason})
+	if err != nil {
+		w.log().Warn("unable to tell client the worker is leaving", common.LogErr, err)
+	}
+	conn.Close()
+}
+
+// reports whether no job is running, waiting up to timeout for the current one (0 for no limit)
+func (w *Worker) waitIdle(timeout time.Duration) bool {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		w.mu.Lock()
+		active := w.jobActive
+		w.mu.Unlock()
+		if !active {
+			return true
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
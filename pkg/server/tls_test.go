@@ -0,0 +1,137 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/server"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tlstest"
+)
+
+// a worker requiring mutual TLS with cfg, returning its address
+func startTLSWorker(t *testing.T, cfg common.TLSConfig) string {
+	t.Helper()
+	w, err := server.StartupWith(func() (server.Engine, error) { return fakeengine.New(fakeengine.Script{}), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.UseTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Run()
+	t.Cleanup(func() { w.Shutdown(time.Millisecond) })
+	return net.JoinHostPort("127.0.0.1", w.Port())
+}
+
+// dials addr with cfg and starts a game, the error is whatever stopped it
+func tlsNewGame(t *testing.T, addr string, cfg *tls.Config) error {
+	t.Helper()
+	raw, err := tls.DialWithDialer(&net.Dialer{Timeout: common.Wait}, "tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(common.Wait))
+	conn := common.NewConn(raw)
+	err = conn.Send(&common.NewGame{Type: common.TypeNewGame, Position: start})
+	if err != nil {
+		return err
+	}
+	m, err := conn.Recv()
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			t.Fatalf("the worker neither answered nor hung up: %v", err)
+		}
+		return err
+	}
+	if _, ok := m.(*common.ReadyOk); !ok {
+		t.Fatalf("want ready_ok, got %#v", m)
+	}
+	return nil
+}
+
+// a dialing config presenting cert's certificate, none when nil, and trusting caFile
+func dialConfig(t *testing.T, cert *common.TLSConfig, caFile string) *tls.Config {
+	t.Helper()
+	caData, err := os.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caData)
+	cfg := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12}
+	if cert != nil {
+		pair, err := tls.LoadX509KeyPair(cert.CertFile, cert.KeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg
+}
+
+func TestMutualTLS(t *testing.T) {
+	configs, err := tlstest.WriteFixtures(filepath.Join(t.TempDir(), "good"), worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same names signed by an unrelated CA
+	others, err := tlstest.WriteFixtures(filepath.Join(t.TempDir(), "other"), worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSWorker(t, configs[worker])
+	good := configs["client"]
+	other := others["client"]
+
+	err = tlsNewGame(t, addr, dialConfig(t, &good, good.CAFile))
+	if err != nil {
+		t.Fatalf("a client with a good certificate was refused: %v", err)
+	}
+	err = tlsNewGame(t, addr, dialConfig(t, nil, good.CAFile))
+	if err == nil {
+		t.Fatal("a client without a certificate got a session")
+	}
+	err = tlsNewGame(t, addr, dialConfig(t, &other, good.CAFile))
+	if err == nil {
+		t.Fatal("a client with a certificate from another CA got a session")
+	}
+	// clients check the worker too
+	err = tlsNewGame(t, addr, dialConfig(t, &other, other.CAFile))
+	if err == nil {
+		t.Fatal("a client trusted a worker from another CA")
+	}
+
+	// and a client searches over it
+	cl := client.New(client.Selector{Workers: []string{addr}}, 300*time.Millisecond, 50*time.Millisecond)
+	defer cl.Shutdown()
+	err = cl.UseTLS(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.NewGame(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := cl.Search(context.Background(), chess.StartingPosition(), nil, client.Limits{})
+	if err != nil || result.Source != client.SourceWorker {
+		t.Fatalf("want a move from the worker over TLS, got %+v: %v", result, err)
+	}
+}
@@ -1,66 +1,117 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
-	"strconv"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/notnil/chess"
 	"github.com/notnil/chess/uci"
 	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/faultnet"
+	"github.com/rpnahm/distsys-chess-engine/pkg/recording"
+	"github.com/rpnahm/distsys-chess-engine/pkg/trace"
 )
 
 type Worker struct {
-	name     string
-	listener net.Listener
-	address  string
-	port     string
-	eng      *uci.Engine
-	game     *chess.Game
-	conn     net.Conn
-	posId    int
-	jobId    int
+	name      string
+	listener  net.Listener
+	address   string
+	port      string
+	eng       Engine
+	newEngine EngineFactory
+	game      *chess.Game
+	conn      *common.Conn
+	posId     int
+	jobId     int
+
+	// only one client session runs at a time, others wait their turn
+	session sync.Mutex
+	// held for every write, release sends leaving from outside the session
+	sendMu sync.Mutex
+
+	// guards the fields below, which admin connections read and write
+	mu             sync.Mutex
+	started        time.Time
+	draining       bool
+	stopped        bool
+	searching      bool
+	jobDue         time.Time
+	sessions       int
+	jobs           int
+	engineName     string
+	engineDefaults map[string]string
+	engineValues   map[string]string // options the running engine was set to, by lower case name
+	adminToken     string
+	configPath     string
+	engineOptions  []uci.CmdSetOption
+	labels         map[string]string
+	capacity       int
+	jobActive      bool // a parse_moves request is being handled
+	grace          time.Duration
+	advertising    bool
+	recorder       *recording.Recorder
+
+	// closed to stop CatalogMessage, which closes advertDone once deregistered
+	advertStop   chan struct{}
+	advertDone   chan struct{}
+	stopAdvert   sync.Once
+	shutdownOnce sync.Once
+
+	metrics *metrics
+	logger  *slog.Logger
 }
 
-// struct for json messages to catalog server
-type message struct {
-	Type    string `json:"type"`
-	Owner   string `json:"owner"`
-	Port    int    `json:"port"`
-	Project string `json:"project"`
+// Create a worker instance and start listening and such
+func Startup() (*Worker, error) {
+	return StartupEngine("bin/stockfish")
 }
 
-// Create a worker instance and start listening and such
-func Startup() *Worker {
+// Same as Startup with the engine executable at enginePath
+func StartupEngine(enginePath string) (*Worker, error) {
+	return StartupWith(ExecEngine(enginePath))
+}
+
+// Same as Startup with engines made by newEngine
+func StartupWith(newEngine EngineFactory) (*Worker, error) {
 
 	// startup server
-	w := &Worker{}
+	w := &Worker{started: time.Now(), newEngine: newEngine, capacity: runtime.NumCPU(), grace: 10 * time.Second,
+		advertStop: make(chan struct{}), advertDone: make(chan struct{}), metrics: newMetrics(), logger: slog.Default()}
 
-	e, err := uci.New("bin/stockfish")
+	e, err := startEngine(newEngine)
 	if err != nil {
-		log.Fatal("Unable to start server", err)
+		return nil, err
 	}
 	w.eng = e
+	w.engineName = w.eng.ID()["name"]
+	w.engineDefaults = optionDefaults(w.eng)
 
 	// start listening on any address and any port
 	ln, err := net.Listen("tcp", "0.0.0.0:0")
 	if err != nil {
-		log.Fatal("Error opening listener", err)
+		e.Close()
+		return nil, fmt.Errorf("error opening listener: %w", err)
 	}
 
 	w.address = ln.Addr().String()
 	_, port, err := net.SplitHostPort(w.address)
 	if err != nil {
-		log.Fatal("Error splitting port from address")
+		e.Close()
+		ln.Close()
+		return nil, fmt.Errorf("error splitting port from address: %w", err)
 	}
 	w.port = port
 	w.listener = ln
 
-	return w
+	return w, nil
 }
 
 // Set the name of the server
@@ -68,129 +119,254 @@ func (w *Worker) SetName(name string) {
 	w.name = name
 }
 
-// Run the worker, handles the main for loop
-func (w *Worker) Run() {
-	defer w.listener.Close()
-	defer w.eng.Close()
+// Port the worker listens on, on every interface
+func (w *Worker) Port() string {
+	return w.port
+}
+
+// Set the labels advertised to the catalog, clients can select workers by them
+func (w *Worker) SetLabels(labels map[string]string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.labels = labels
+}
 
-	// Start UCI on engine
-	err := w.eng.Run(uci.CmdUCI, uci.CmdIsReady)
+// Set the search threads advertised to the catalog, defaults to the CPU count
+func (w *Worker) SetCapacity(capacity int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.capacity = capacity
+}
+
+// Set the logger, every line is tagged with the worker's name
+func (w *Worker) SetLogger(logger *slog.Logger) {
+	w.logger = logger
+}
+
+// logger with the worker name attached
+func (w *Worker) log() *slog.Logger {
+	return w.logger.With(common.LogWorker, w.name)
+}
+
+// Require mutual TLS on the listener, clients must present a certificate signed by cfg.CAFile
+func (w *Worker) UseTLS(cfg common.TLSConfig) error {
+	tlsConfig, err := cfg.ServerConfig()
 	if err != nil {
-		log.Fatal("Unable to start UCI on engine:", err)
+		return err
 	}
+	w.listener = tls.NewListener(w.listener, tlsConfig)
+	return nil
+}
+
+// Inject the scenario's faults into every connection the worker accepts, for
+// resilience testing. Call after SetName, rules match the worker's name, and
+// before UseTLS so faults sit below TLS.
+func (w *Worker) InjectFaults(s *faultnet.Scenario) {
+	w.listener = s.Listen(w.listener, w.name)
+}
+
+// Record every message on connections accepted after this call
+func (w *Worker) SetRecorder(r *recording.Recorder) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recorder = r
+}
+
+// Run the worker, handles the main for loop
+func (w *Worker) Run() {
+	defer w.listener.Close()
+	defer func() { w.engine().Close() }()
 
 	for {
-		w.conn, err = w.listener.Accept()
-		if err != nil {
-			log.Println("Error accepting connection", err)
+		conn, err := w.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			// closed by Shutdown
+			return
+		} else if err != nil {
+			w.log().Error("error accepting connection", common.LogErr, err)
+			continue
 		}
-		w.handle()
+		go w.handle(conn)
 	}
 }
 
-// Handles the operations of a single client connection
-func (w *Worker) handle() {
-	defer w.conn.Close()
+// Handles the operations of a single connection. Admin requests are
+// answered right away, anything else claims the client session first.
+func (w *Worker) handle(raw net.Conn) {
+	defer raw.Close()
 
-	buf := make([]byte, common.BufSize)
+	// finish the handshake up front so a silent peer can't hold the worker
+	if tlsConn, ok := raw.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(common.Wait))
+		err := tlsConn.Handshake()
+		if err != nil {
+			w.log().Warn("TLS handshake failed", "remote", raw.RemoteAddr().String(), common.LogErr, err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+	conn := common.NewConn(raw)
+	w.mu.Lock()
+	recorder := w.recorder
+	w.mu.Unlock()
+	if recorder != nil {
+		recorder.Attach(conn, raw.RemoteAddr().String())
+	}
+
+	inSession := false
+	defer func() {
+		if inSession {
+			w.endSession()
+		}
+	}()
 
 	// loop forever for each client
 	for {
-		n, err := w.conn.Read(buf)
-		if err != nil {
-			log.Println("Unable to read connection: ", err)
-			break
+		// *** FROM HERE ON WE HAVE TO REPORT ERRORS TO THE CLIENT ***
+		// read and decode the next frame into its concrete message
+		request, err := conn.Recv()
+		received := time.Now()
+		var decodeErr *common.DecodeError
+		if errors.As(err, &decodeErr) {
+			w.sendError(conn, errDecode, fmt.Sprint("Unable to decode request: ", err))
+			continue
+		} else if err != nil {
+			w.log().Info("connection closed", "remote", raw.RemoteAddr().String(), common.LogErr, err)
+			return
 		}
 
-		// *** FROM HERE ON WE HAVE TO REPORT ERRORS TO THE CLIENT ***
-		// decode the json
-		var request map[string]interface{}
-		err = json.Unmarshal(buf[:n], &request)
-		if err != nil {
-			w.reportError(fmt.Sprint("Error unmarshalling json data: ", buf[:n], err))
+		// requests that don't need the session
+		opType := request.MessageType()
+		switch m := request.(type) {
+		case *common.Admin:
+			if w.admin(conn, m) {
+				return
+			}
+			w.metrics.countRequest(opType)
+			w.log().Debug("handled request", common.LogType, opType)
+			continue
+		case *common.Hello:
+			// Switch wire format, errors are reported in json
+			err = conn.Accept(m)
+			if err != nil {
+				w.sendError(conn, errDecode, fmt.Sprint("Unable to negotiate codec: ", err))
+			}
+			continue
+		case *common.Exit:
+			w.sendError(conn, errAdmin, "exit must be sent as an authorized admin command")
 			continue
+		case *common.Stop:
+			// Handle stop request
+			w.log().Info("client stopped session", "remote", raw.RemoteAddr().String())
+			return
+		}
+
+		// a draining worker finishes the current job and refuses anything new
+		if w.isDraining() {
+			w.refuse(conn)
+			return
+		}
+		if !inSession {
+			w.startSession(conn)
+			inSession = true
+			// draining may have started while waiting for the session
+			if w.isDraining() {
+				w.refuse(conn)
+				return
+			}
 		}
 
 		// Switch to run the correct operation
-		opType := request["type"]
-		switch opType {
-		case "new_game":
+		switch m := request.(type) {
+		case *common.NewGame:
 			// Handle newgame request
-			w.newGame(buf[:n])
-			break
-		case "parse_moves":
+			w.newGame(m)
+		case *common.ParseMoves:
 			// Handle parsemoves request
-			w.parseMoves(buf[:n])
-			break
-		case "new_pos":
+			w.parseMoves(m, received)
+		case *common.NewPos:
 			// Handle newpos request
-			w.newPos(buf[:n])
-			break
-		case "stop":
-			// Handle stop request
-			fmt.Println("stopping")
-			return
-		case "exit":
-			// Shut down server
-			log.Fatal("Shutting Down Server")
+			w.newPos(m)
 		default:
-			w.reportError(fmt.Sprint("Unknown message type: ", opType))
+			w.reportError(errDecode, fmt.Sprint("Unexpected message type: ", opType))
 		}
-		fmt.Println("Handled ", opType, " request")
+		w.metrics.countRequest(opType)
+		w.log().Debug("handled request", common.LogType, opType, common.LogPosId, w.posId, common.LogJobId, w.jobId)
 	}
 }
 
-// Handle a newgame request
-func (w *Worker) newGame(data []byte) {
-	// unmarshall the data
-	var info common.NewGame
-	err := json.Unmarshal(data, &info)
-	if err != nil {
-		w.reportError(fmt.Sprint("Unable to decode new_game JSON, ", data, err))
-		return
-	}
+// Waits for the session lock and makes conn the active client
+func (w *Worker) startSession(conn *common.Conn) {
+	w.session.Lock()
+	w.mu.Lock()
+	w.conn = conn
+	w.sessions++
+	w.mu.Unlock()
+	// every session starts with new_game
+	w.game = nil
+}
 
-	// Reset the posid (only matters within each game)
-	w.posId = info.PosId
+// Releases the session for the next client
+func (w *Worker) endSession() {
+	w.mu.Lock()
+	w.conn = nil
+	w.mu.Unlock()
+	w.session.Unlock()
+}
 
-	// set the options or the instance
+func (w *Worker) isDraining() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.draining
+}
+
+// Handle a newgame request
+func (w *Worker) newGame(info *common.NewGame) {
 	// First interpret each option as a CmdSetOption
 	var options []uci.Cmd
 	for _, option_string := range info.Options {
-		// split the string by whitespace
-		temp := strings.Fields(option_string)
-		// test for whitespace
-		if len(temp) > 2 {
-			w.reportError(fmt.Sprint("Unable to decode option: ", option_string))
+		option, err := parseOption(option_string)
+		if err != nil {
+			w.reportError(errOption, err.Error())
 			return
 		}
-		if len(temp) == 2 {
-			options = append(options, uci.CmdSetOption{Name: temp[0], Value: temp[1]})
-		} else {
-			options = append(options, uci.CmdSetOption{Name: temp[0], Value: ""})
-		}
+		options = append(options, option)
 	}
-
-	//now run the options on the engine
-	err = w.eng.Run(options...)
+	// and the starting position, nothing changes until the whole request is good
+	fen, err := chess.FEN(info.Position)
 	if err != nil {
-		w.reportError(fmt.Sprint("Unable to run options", err))
+		w.reportError(errPosition, fmt.Sprint("Unable to decode FEN string: ", info.Position, err))
 		return
 	}
 
-	// Set a new game board
-	fen, err := chess.FEN(info.Position)
+	// Reset the posid (only matters within each game)
+	w.mu.Lock()
+	w.posId = info.PosId
+	// options pinned by the operator always win over the client's
+	for _, option := range w.engineOptions {
+		options = append(options, option)
+	}
+	w.mu.Unlock()
+
+	//now run the options on the engine
+	err = w.eng.Run(options...)
 	if err != nil {
-		w.reportError(fmt.Sprint("Unable to decode FEN string: ", info.Position, err))
+		w.reportError(errEngine, fmt.Sprint("Unable to run options", err))
 		return
 	}
-	// interpret the starting position
+	w.mu.Lock()
+	for _, option := range options {
+		w.setValue(option.(uci.CmdSetOption))
+	}
+	w.mu.Unlock()
+
+	// Set a new game board from the starting position
 	w.game = chess.NewGame(fen)
 	pos := uci.CmdPosition{Position: w.game.Position()}
 	// reset the engine game and set the position
 	err = w.eng.Run(uci.CmdUCINewGame, pos, uci.CmdIsReady)
 	if err != nil {
-		w.reportError(fmt.Sprint("Unable to run ucinewgame, position command on engine", err))
+		w.reportError(errEngine, fmt.Sprint("Unable to run ucinewgame, position command on engine", err))
 		return
 	}
 
@@ -198,28 +374,55 @@ func (w *Worker) newGame(data []byte) {
 }
 
 // Handles parse_moves request in order to run the request on go
-func (w *Worker) parseMoves(data []byte) {
-	fmt.Println("Beginning to parse moves")
-	// decode json data
-	var input common.ParseMoves
-	err := json.Unmarshal(data, &input)
-	if err != nil {
-		w.reportError(fmt.Sprint("Unable to decode parse_moves json: ", err))
-		return
+func (w *Worker) parseMoves(input *common.ParseMoves, received time.Time) {
+	// shutdown waits for the results to go out before telling the client it's leaving
+	w.mu.Lock()
+	w.jobActive = true
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.jobActive = false
+		w.mu.Unlock()
+	}()
+	w.log().Debug("beginning to parse moves", common.LogPosId, input.PosId, common.LogJobId, input.JobId, "moves", len(input.Moves))
+
+	// spans only exist when the client is tracing, they go back in the results
+	var tracer *trace.Tracer
+	if input.TraceId != "" {
+		tracer = trace.NewTracer(w.name, nil)
 	}
+	job := tracer.StartAt("worker.job", trace.Context{TraceId: input.TraceId, SpanId: input.SpanId}, received)
+	job.SetAttr("job_id", input.JobId)
+	job.SetAttr("moves", len(input.Moves))
+	wait := tracer.StartAt("worker.queue_wait", job.Context(), received)
 
+	if w.game == nil {
+		w.reportError(errNoGame, "No game, send new_game first")
+		return
+	}
 	// check pos_id (must be greater than or equal to existing pos_id)
 	if w.posId > input.PosId {
-		w.reportError(fmt.Sprint("Bad pos_id: ", input.PosId))
+		w.reportError(errPosId, fmt.Sprint("Bad pos_id: ", input.PosId))
 		return
 	}
-	w.posId = input.PosId
-	w.jobId = input.JobId
-
-	// create a new game with the current position
+	// create a new game with the current position, the codec only checked its shape
 	cmdPos, err := w.updatePos(input.Position)
 	if err != nil {
-		log.Println("Error parsing Fen:", err)
+		w.log().Warn("error parsing FEN", common.LogPosId, input.PosId, common.LogJobId, input.JobId, common.LogErr, err)
+		return
+	}
+	w.mu.Lock()
+	w.posId = input.PosId
+	w.jobId = input.JobId
+	w.mu.Unlock()
+	// with the game so far the engine can see repetitions, a bad history only costs that
+	if len(input.History) > 0 {
+		withHistory, err := historyPos(input.Start, input.History)
+		if err != nil {
+			w.log().Warn("ignoring game history", common.LogPosId, input.PosId, common.LogJobId, input.JobId, common.LogErr, err)
+		} else {
+			cmdPos = withHistory
+		}
 	}
 
 	// make an array of moves to process
@@ -235,7 +438,8 @@ func (w *Worker) parseMoves(data []byte) {
 	// calculate time
 	processTime := time.Until(input.DueTime)
 	if processTime < 0 {
-		w.reportError(fmt.Sprintf("Process time was negative: %s - %s = %s", input.DueTime, time.Now(), processTime))
+		w.metrics.observeDeadline(-processTime)
+		w.reportError(errDeadline, fmt.Sprintf("Process time was negative: %s - %s = %s", input.DueTime, time.Now(), processTime))
 		return
 	}
 	cmdGo := uci.CmdGo{MoveTime: processTime, SearchMoves: movesToProcess}
@@ -246,39 +450,89 @@ func (w *Worker) parseMoves(data []byte) {
 		wData, _ := json.Marshal(wMessage)
 		_, err = w.conn.Write(wData)
 		if err != nil {
-			log.Println("Unable to send working message", err)
+			w.log().Warn("unable to send working message", common.LogErr, err)
 		}
 	*/
 	// run the commands
+	w.mu.Lock()
+	w.searching = true
+	w.jobDue = input.DueTime
+	w.jobs++
+	w.mu.Unlock()
+	waited, _ := wait.Finish()
+	search := tracer.Start("engine.search", job.Context())
+	start := time.Now()
 	err = w.eng.Run(cmdPos, cmdGo)
+	w.mu.Lock()
+	w.searching = false
+	w.mu.Unlock()
 	if err != nil {
-		w.reportError(fmt.Sprint("Unable to run new job", err))
+		w.reportError(errEngine, fmt.Sprint("Unable to run new job", err))
 		return
 	}
+	w.metrics.observeSearch(time.Since(start), w.eng.SearchResults().Info)
 
 	// Now return the results
 	var rMessage common.Results
-	rMessage.Type = "results"
+	rMessage.Type = common.TypeResults
 	rMessage.JobId = w.jobId
 	rMessage.BestMove = w.eng.SearchResults().BestMove.String()
 	rMessage.Score = w.eng.SearchResults().Info.Score.CP
 	rMessage.Mate = w.eng.SearchResults().Info.Score.Mate
+	rMessage.Depth = w.eng.SearchResults().Info.Depth
 	rMessage.Nodes = w.eng.SearchResults().Info.Nodes
+	for _, m := range w.eng.SearchResults().Info.PV {
+		rMessage.PV = append(rMessage.PV, m.String())
+	}
+
+	search.SetAttr("depth", rMessage.Depth)
+	search.SetAttr("nodes", rMessage.Nodes)
+	searched, _ := search.Finish()
+	if done, ok := job.Finish(); ok {
+		rMessage.Spans = []common.Span{done, waited, searched}
+	}
 
 	// encode and send
-	rData, _ := json.Marshal(rMessage)
-	_, err = w.conn.Write(rData)
+	err = w.send(w.conn, &rMessage)
 	if err != nil {
-		log.Println("Unable to send results", err)
+		w.log().Warn("unable to send results", common.LogJobId, rMessage.JobId, common.LogErr, err)
 		return
 	}
+	w.metrics.observeDeadline(time.Since(input.DueTime))
+}
+
+// starts an engine and runs uci on it
+func startEngine(newEngine EngineFactory) (Engine, error) {
+	e, err := newEngine()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start engine: %w", err)
+	}
+	err = e.Run(uci.CmdUCI, uci.CmdIsReady)
+	if err != nil {
+		e.Close()
+		return nil, fmt.Errorf("unable to start UCI on engine: %w", err)
+	}
+	return e, nil
+}
 
+// Interprets a "name value" string as a setoption command
+func parseOption(option string) (uci.CmdSetOption, error) {
+	// split the string by whitespace
+	temp := strings.Fields(option)
+	// test for whitespace
+	if len(temp) > 2 || len(temp) == 0 {
+		return uci.CmdSetOption{}, errors.New(fmt.Sprint("Unable to decode option: ", option))
+	}
+	if len(temp) == 2 {
+		return uci.CmdSetOption{Name: temp[0], Value: temp[1]}, nil
+	}
+	return uci.CmdSetOption{Name: temp[0], Value: ""}, nil
 }
 
 func (w *Worker) updatePos(fenStr string) (uci.CmdPosition, error) {
 	fen, err := chess.FEN(fenStr)
 	if err != nil {
-		w.reportError(fmt.Sprint("Error parsing Fen String:", err))
+		w.reportError(errPosition, fmt.Sprint("Error parsing Fen String:", err))
 		return uci.CmdPosition{}, err
 	}
 	w.game = chess.NewGame(fen)
@@ -286,21 +540,42 @@ func (w *Worker) updatePos(fenStr string) (uci.CmdPosition, error) {
 	return cmdPos, nil
 }
 
-// Set a new position of the game
-func (w *Worker) newPos(data []byte) {
-	// must unmarshall data first
-	var input common.NewPos
-	err := json.Unmarshal(data, &input)
+// position command for the start position followed by the history moves
+func historyPos(start string, history []string) (uci.CmdPosition, error) {
+	fen, err := chess.FEN(start)
 	if err != nil {
-		w.reportError(fmt.Sprint("Unable to parse new_pos json:", err))
+		return uci.CmdPosition{}, err
+	}
+	game := chess.NewGame(fen, chess.UseNotation(chess.UCINotation{}))
+	cmdPos := uci.CmdPosition{Position: game.Position()}
+	for _, move := range history {
+		err = game.MoveStr(move)
+		if err != nil {
+			return uci.CmdPosition{}, err
+		}
+		cmdPos.Moves = append(cmdPos.Moves, game.Moves()[len(game.Moves())-1])
+	}
+	return cmdPos, nil
+}
+
+// Set a new position of the game
+func (w *Worker) newPos(input *common.NewPos) {
+	if w.game == nil {
+		w.reportError(errNoGame, "No game, send new_game first")
 		return
 	}
 	if input.PosId < w.posId {
-		w.reportError("Old pos_id")
+		w.reportError(errPosId, "Old pos_id")
 		return
 	} else if input.PosId > w.posId {
+		// updatePos replies with the error
+		_, err := w.updatePos(input.Position)
+		if err != nil {
+			return
+		}
+		w.mu.Lock()
 		w.posId = input.PosId
-		w.updatePos(input.Position)
+		w.mu.Unlock()
 	}
 	w.readyOk()
 
@@ -308,82 +583,113 @@ func (w *Worker) newPos(data []byte) {
 
 // Returns readyok message
 func (w *Worker) readyOk() {
-	o := common.ReadyOk{Type: "ready_ok", PosId: w.posId}
-	oData, _ := json.Marshal(o)
-	_, err := w.conn.Write(oData)
+	o := common.ReadyOk{Type: common.TypeReadyOk, PosId: w.posId}
+	err := w.send(w.conn, &o)
 	if err != nil {
-		w.reportError(fmt.Sprint("Error sending ready_ok", err))
+		w.reportError(errSend, fmt.Sprint("Error sending ready_ok", err))
 	}
 }
 
 // Function to stop the worker from considering the current case
 func (w *Worker) Stop() {
-	err := w.eng.Run(uci.CmdStop)
+	err := w.engine().Run(uci.CmdStop)
 	if err != nil {
-		w.reportError(fmt.Sprint("Error stopping engine: ", err))
+		w.reportError(errEngine, fmt.Sprint("Error stopping engine: ", err))
 	}
 }
 
+// Writes a message to conn, one writer at a time
+func (w *Worker) send(conn *common.Conn, m common.Message) error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	return conn.Send(m)
+}
+
 // Send an error message back to the client
-func (w *Worker) reportError(errString string) {
+// kind groups errors for the metrics endpoint
+func (w *Worker) reportError(kind string, errString string) {
+	w.sendError(w.conn, kind, errString)
+}
+
+// Send an error message on any connection
+func (w *Worker) sendError(conn *common.Conn, kind string, errString string) {
+	w.metrics.countError(kind)
+
 	output := common.Error{
-		Type:   "error",
+		Type:   common.TypeError,
 		Reason: errString,
 	}
 
-	data, err := json.Marshal(output)
+	err := w.send(conn, &output)
 	if err != nil {
-		log.Println("Unable to marshall error data ", output, err)
-	}
-
-	_, err = w.conn.Write(data)
-	if err != nil {
-		log.Println("Unable to send errror data ", output, err)
+		w.log().Warn("unable to send error", "kind", kind, "reason", errString, common.LogErr, err)
 	} else {
-		log.Println("Successfully sent error ", output)
+		w.log().Info("sent error", "kind", kind, "reason", errString)
 	}
 }
 
-// Send the server info to the catalog once per minute
-func (w *Worker) CatalogMessage(owner string) {
-	port, _ := strconv.Atoi(w.port)
-	m := message{
-		Type:    "chess-worker",
-		Owner:   owner,
-		Project: w.name,
-		Port:    port,
+// Tells a client asking for work that the worker is leaving, it should be dropped
+func (w *Worker) refuse(conn *common.Conn) {
+	w.metrics.countError(errDraining)
+	err := w.send(conn, &common.Leaving{Type: common.TypeLeaving, Reason: "worker is draining, no new work accepted"})
+	if err != nil {
+		w.log().Warn("unable to send leaving", common.LogErr, err)
 	}
+}
 
-	// encode the json data
-	jsonData, err := json.Marshal(m)
+// Send the server info to the catalog once per minute until Drain or Shutdown,
+// which send a final short lived update so the catalog drops the worker.
+// Only returns early if the catalog can't be set up, send failures are logged and retried
+func (w *Worker) CatalogMessage(owner string) error {
+	// check the advertisement encodes before looping
+	_, err := json.Marshal(w.advertisement(owner))
 	if err != nil {
-		log.Fatal("Error Marshalling Name-Server json", err)
+		return fmt.Errorf("error marshalling catalog json: %w", err)
 	}
 
-	nsAddressString := fmt.Sprintf("%s:%d", common.CatalogAddr, common.CatalogPort)
+	nsAddressString := net.JoinHostPort(common.CatalogAddr, fmt.Sprint(common.CatalogPort))
 	nsAddress, err := net.ResolveUDPAddr("udp", nsAddressString)
 	if err != nil {
-		log.Fatal("Error resolving Name Server Address", err)
+		return fmt.Errorf("error resolving catalog address: %w", err)
 	}
 
+	w.mu.Lock()
+	w.advertising = true
+	w.mu.Unlock()
+	defer close(w.advertDone)
+
 	// connect to nameserver and update every 60 seconds
+	w.log().Info("advertising to catalog", "catalog", nsAddressString, "owner", owner, "port", w.port)
+	for {
+		// rebuilt every time so load and engine settings stay current
+		w.sendCatalog(nsAddress, w.advertisement(owner))
+		select {
+		case <-w.advertStop:
+			final := w.advertisement(owner)
+			final.Lifetime = 1
+			w.sendCatalog(nsAddress, final)
+			w.log().Info("deregistered from catalog", "catalog", nsAddressString)
+			return nil
+		case <-time.After(advertiseEvery):
+		}
+	}
+}
+
+// sends one advertisement, failures are only logged
+func (w *Worker) sendCatalog(nsAddress *net.UDPAddr, m message) {
+	jsonData, err := json.Marshal(m)
+	if err != nil {
+		w.log().Warn("error marshalling catalog json", common.LogErr, err)
+		return
+	}
 	conn, err := net.Dial("udp", nsAddress.String())
 	if err != nil {
-		log.Fatal("Error connecting to Nameserver for posting:", err)
+		w.log().Warn("error connecting to catalog", common.LogErr, err)
+		return
 	}
 	defer conn.Close()
-	fmt.Println(m)
-	for {
-		conn, err := net.Dial("udp", nsAddress.String())
-		if err != nil {
-			log.Fatal("Error connecting to Nameserver for posting:", err)
-		}
-		_, err = conn.Write(jsonData)
-		if err != nil {
-			log.Fatal("Error sending message to Nameserver", err)
-		}
-		conn.Close()
-		time.Sleep(1 * time.Minute)
+	_, err = conn.Write(jsonData)
+	if err != nil {
+		w.log().Warn("error sending message to catalog", common.LogErr, err)
 	}
-
 }
@@ -0,0 +1,320 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+)
+
+const worker = "clustertest-00"
+
+var start = chess.StartingPosition().String()
+
+// a single worker with no client, tests talk to it directly
+func startWorker(t *testing.T, script fakeengine.Script) *clustertest.Conn {
+	t.Helper()
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, Script: script, NoClient: true})
+	return c.Dial(worker)
+}
+
+func newGame(t *testing.T, conn *clustertest.Conn, posId int) {
+	t.Helper()
+	reply := conn.Request(&common.NewGame{Type: common.TypeNewGame, Position: start, PosId: posId})
+	ready, ok := reply.(*common.ReadyOk)
+	if !ok || ready.PosId != posId {
+		t.Fatalf("new_game: want ready_ok for pos_id %d, got %#v", posId, reply)
+	}
+}
+
+func parseMoves(position string, posId int, jobId int, moves ...string) *common.ParseMoves {
+	return &common.ParseMoves{
+		Type:     common.TypeParseMoves,
+		Position: position,
+		PosId:    posId,
+		JobId:    jobId,
+		Moves:    moves,
+		DueTime:  time.Now().Add(200 * time.Millisecond),
+	}
+}
+
+// the reply must be an error whose reason contains want
+func wantError(t *testing.T, reply common.Message, want string) {
+	t.Helper()
+	e, ok := reply.(*common.Error)
+	if !ok {
+		t.Fatalf("want an error containing %q, got %#v", want, reply)
+	}
+	if !strings.Contains(e.Reason, want) {
+		t.Fatalf("want an error containing %q, got %q", want, e.Reason)
+	}
+}
+
+func TestNewGame(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{})
+	newGame(t, conn, 0)
+	// a new game may start over at any pos_id
+	newGame(t, conn, 5)
+	newGame(t, conn, 0)
+}
+
+func TestNewGameOptions(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, NoClient: true})
+	conn := c.Dial(worker)
+	reply := conn.Request(&common.NewGame{Type: common.TypeNewGame, Position: start, Options: []string{"Threads 4"}})
+	if _, ok := reply.(*common.ReadyOk); !ok {
+		t.Fatalf("want ready_ok, got %#v", reply)
+	}
+	if threads := c.Engine(worker).Values()["Threads"]; threads != "4" {
+		t.Fatalf("want Threads set to 4, engine has %q", threads)
+	}
+
+	// a new_game with a bad option changes neither the engine nor the pos_id
+	reply = conn.Request(&common.NewGame{Type: common.TypeNewGame, Position: start, PosId: 0, Options: []string{"Threads 2", "Too many words here"}})
+	wantError(t, reply, "Unable to decode option")
+	if threads := c.Engine(worker).Values()["Threads"]; threads != "4" {
+		t.Fatalf("want Threads left at 4, engine has %q", threads)
+	}
+	newGame(t, conn, 5)
+	wantError(t, conn.Request(&common.NewGame{Type: common.TypeNewGame, Position: start, PosId: 0, Options: []string{"Too many words here"}}), "Unable to decode option")
+	wantError(t, conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 3}), "Old pos_id")
+}
+
+func TestNewPos(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{})
+	newGame(t, conn, 0)
+
+	after := chess.NewGame()
+	after.MoveStr("e4")
+	for posId := 1; posId <= 3; posId++ {
+		reply := conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: after.Position().String(), PosId: posId})
+		ready, ok := reply.(*common.ReadyOk)
+		if !ok || ready.PosId != posId {
+			t.Fatalf("new_pos %d: want ready_ok, got %#v", posId, reply)
+		}
+	}
+	// the same pos_id again is accepted without changing anything
+	reply := conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 3})
+	if ready, ok := reply.(*common.ReadyOk); !ok || ready.PosId != 3 {
+		t.Fatalf("repeated new_pos: want ready_ok for 3, got %#v", reply)
+	}
+}
+
+func TestParseMoves(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{Moves: []string{"d2d4"}, Score: 25, Depth: 9, Nodes: 1234})
+	newGame(t, conn, 0)
+
+	reply := conn.Request(parseMoves(start, 0, 7, "e2e4", "d2d4", "g1f3"))
+	results, ok := reply.(*common.Results)
+	if !ok {
+		t.Fatalf("want results, got %#v", reply)
+	}
+	if results.JobId != 7 || results.BestMove != "d2d4" || results.Score != 25 || results.Depth != 9 || results.Nodes != 1234 {
+		t.Fatalf("unexpected results %#v", results)
+	}
+}
+
+func TestParseMovesOnlySearchesAssignedMoves(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{Moves: []string{"d2d4"}})
+	newGame(t, conn, 0)
+
+	reply := conn.Request(parseMoves(start, 0, 1, "e2e4", "g1f3"))
+	results, ok := reply.(*common.Results)
+	if !ok {
+		t.Fatalf("want results, got %#v", reply)
+	}
+	if results.BestMove != "e2e4" && results.BestMove != "g1f3" {
+		t.Fatalf("worker played %s, which it wasn't given", results.BestMove)
+	}
+}
+
+func TestParseMovesMate(t *testing.T) {
+	// fool's mate, black to play Qh4#
+	game := chess.NewGame()
+	for _, m := range []string{"f3", "e5", "g4"} {
+		game.MoveStr(m)
+	}
+	conn := startWorker(t, fakeengine.Script{Moves: []string{"d8h4"}, Mate: 1})
+	newGame(t, conn, 0)
+
+	reply := conn.Request(parseMoves(game.Position().String(), 1, 1, "d8h4", "a7a6"))
+	results, ok := reply.(*common.Results)
+	if !ok || results.BestMove != "d8h4" || results.Mate != 1 {
+		t.Fatalf("want d8h4 mate 1, got %#v", reply)
+	}
+}
+
+func TestParseMovesWithHistory(t *testing.T) {
+	game := chess.NewGame(chess.UseNotation(chess.UCINotation{}))
+	var history []string
+	for _, m := range []string{"g1f3", "g8f6", "f3g1", "f6g8"} {
+		game.MoveStr(m)
+		history = append(history, m)
+	}
+	conn := startWorker(t, fakeengine.Script{})
+	newGame(t, conn, 0)
+
+	m := parseMoves(game.Position().String(), 1, 1, "g1f3", "e2e4")
+	m.Start = start
+	m.History = history
+	if _, ok := conn.Request(m).(*common.Results); !ok {
+		t.Fatal("want results for a search with history")
+	}
+
+	// history without a start position is rejected before it reaches the worker
+	m.Start = ""
+	conn.SendUnchecked(m)
+	wantError(t, conn.Recv(), "start")
+}
+
+func TestPosIdOrdering(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{})
+	newGame(t, conn, 0)
+
+	reply := conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 4})
+	if _, ok := reply.(*common.ReadyOk); !ok {
+		t.Fatalf("want ready_ok, got %#v", reply)
+	}
+
+	// going back is refused for both new_pos and parse_moves
+	wantError(t, conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 3}), "Old pos_id")
+	wantError(t, conn.Request(parseMoves(start, 2, 1, "e2e4")), "Bad pos_id")
+
+	// a later pos_id moves the worker forward
+	reply = conn.Request(parseMoves(start, 6, 2, "e2e4"))
+	if _, ok := reply.(*common.Results); !ok {
+		t.Fatalf("want results, got %#v", reply)
+	}
+	wantError(t, conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 5}), "Old pos_id")
+}
+
+func TestErrorReplies(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{})
+	newGame(t, conn, 0)
+
+	tests := []struct {
+		name string
+		send common.Message
+		want string
+		bad  bool // fails validation, so it is rejected as undecodable
+	}{
+		{"bad option", &common.NewGame{Type: common.TypeNewGame, Position: start, Options: []string{"Too many words here"}}, "Unable to decode option", false},
+		{"bad fen", &common.NewGame{Type: common.TypeNewGame, Position: "not a fen"}, "position", true},
+		{"empty position", &common.NewPos{Type: common.TypeNewPos, PosId: 1}, "position", true},
+		{"no moves", &common.ParseMoves{Type: common.TypeParseMoves, Position: start, PosId: 1, DueTime: time.Now()}, "moves", true},
+		{"negative job_id", &common.ParseMoves{Type: common.TypeParseMoves, Position: start, PosId: 1, JobId: -1, Moves: []string{"e2e4"}, DueTime: time.Now()}, "job_id", true},
+		{"past due time", &common.ParseMoves{Type: common.TypeParseMoves, Position: start, PosId: 1, Moves: []string{"e2e4"}, DueTime: time.Now().Add(-time.Second)}, "negative", false},
+		{"exit without admin", &common.Exit{Type: common.TypeExit}, "admin", false},
+		{"admin while disabled", &common.Admin{Type: common.TypeAdmin, Command: common.AdminStatus}, "disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.bad {
+				conn.SendUnchecked(tt.send)
+				wantError(t, conn.Recv(), tt.want)
+				return
+			}
+			wantError(t, conn.Request(tt.send), tt.want)
+		})
+	}
+
+	// the session survives every error
+	reply := conn.Request(parseMoves(start, 1, 1, "e2e4"))
+	if _, ok := reply.(*common.Results); !ok {
+		t.Fatalf("want results after errors, got %#v", reply)
+	}
+}
+
+func TestBadPosition(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{})
+	bad := parseMoves("not a fen", 0, 1, "e2e4")
+
+	// nothing to search before new_game
+	wantError(t, conn.Request(parseMoves(start, 0, 1, "e2e4")), "new_game")
+	wantError(t, conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 1}), "new_game")
+	conn.SendUnchecked(bad)
+	wantError(t, conn.Recv(), "position")
+	conn.SendUnchecked(&common.NewPos{Type: common.TypeNewPos, Position: "not a fen", PosId: 1})
+	wantError(t, conn.Recv(), "position")
+
+	// one error for each, then the next request gets its own reply
+	newGame(t, conn, 0)
+	conn.SendUnchecked(bad)
+	wantError(t, conn.Recv(), "position")
+	conn.SendUnchecked(&common.NewPos{Type: common.TypeNewPos, Position: "not a fen", PosId: 1})
+	wantError(t, conn.Recv(), "position")
+	reply := conn.Request(parseMoves(start, 0, 2, "e2e4"))
+	if results, ok := reply.(*common.Results); !ok || results.JobId != 2 {
+		t.Fatalf("want results for job 2, got %#v", reply)
+	}
+
+	// FENs only have their shape checked when encoded, the worker refuses the rest
+	unknownPiece := "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNX w KQkq - 0 1"
+	wantError(t, conn.Request(&common.NewGame{Type: common.TypeNewGame, Position: unknownPiece, PosId: 7}), "Unable to decode FEN")
+	wantError(t, conn.Request(parseMoves(unknownPiece, 3, 3, "e2e4")), "Error parsing Fen")
+	wantError(t, conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: unknownPiece, PosId: 4}), "Error parsing Fen")
+	// and none of them moved the pos_id on
+	reply = conn.Request(&common.NewPos{Type: common.TypeNewPos, Position: start, PosId: 1})
+	if ready, ok := reply.(*common.ReadyOk); !ok || ready.PosId != 1 {
+		t.Fatalf("want ready_ok for pos_id 1, got %#v", reply)
+	}
+}
+
+func TestUndecodableFrame(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{})
+	_, err := conn.Write([]byte(`{"type":"new_game","position":42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, conn.Recv(), "Unable to decode request")
+	newGame(t, conn, 0)
+}
+
+func TestEngineCrash(t *testing.T) {
+	conn := startWorker(t, fakeengine.Script{CrashOn: 2})
+	newGame(t, conn, 0)
+	if _, ok := conn.Request(parseMoves(start, 0, 1, "e2e4")).(*common.Results); !ok {
+		t.Fatal("first search should succeed")
+	}
+	wantError(t, conn.Request(parseMoves(start, 0, 2, "e2e4")), "Unable to run new job")
+}
+
+func TestOneSessionAtATime(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, NoClient: true})
+	first := c.Dial(worker)
+	newGame(t, first, 0)
+
+	// the second client waits until the first one stops
+	second := c.Dial(worker)
+	second.Send(&common.NewGame{Type: common.TypeNewGame, Position: start, PosId: 3})
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if m, err := second.Conn.Recv(); err == nil {
+		t.Fatalf("second session answered while the first was active: %#v", m)
+	}
+
+	first.Send(&common.Stop{Type: common.TypeStop})
+	reply := second.Recv()
+	if ready, ok := reply.(*common.ReadyOk); !ok || ready.PosId != 3 {
+		t.Fatalf("want ready_ok for the second session, got %#v", reply)
+	}
+}
+
+func TestDrainSendsLeaving(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 1, NoClient: true})
+	conn := c.Dial(worker)
+	newGame(t, conn, 0)
+
+	c.Worker(worker).Drain()
+	reply := conn.Recv()
+	if _, ok := reply.(*common.Leaving); !ok {
+		t.Fatalf("want leaving, got %#v", reply)
+	}
+	err := c.Catalog.WaitGone(3*time.Second, worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
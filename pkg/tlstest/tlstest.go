@@ -0,0 +1,143 @@
+// Package tlstest generates throwaway certificate authorities and
+// certificates so TLS between clients and workers can be exercised
+// locally without a real PKI. Nothing here is suitable for production keys.
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// how long generated certificates stay valid
+var Lifetime = 24 * time.Hour
+
+// CA is a self signed certificate authority held in memory
+type CA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	CertPEM []byte
+}
+
+// Pair is a PEM encoded certificate and its private key
+type Pair struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// NewCA creates a fresh self signed CA
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial(),
+		Subject:               pkix.Name{CommonName: "distsys-chess test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(Lifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key, CertPEM: encode("CERTIFICATE", der)}, nil
+}
+
+// Issue signs a certificate usable by both workers and clients.
+// Names become DNS SANs, or IP SANs when they parse as addresses.
+func (ca *CA) Issue(commonName string, names ...string) (Pair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Pair{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(Lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return Pair{}, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return Pair{}, err
+	}
+	return Pair{CertPEM: encode("CERTIFICATE", der), KeyPEM: encode("EC PRIVATE KEY", keyDer)}, nil
+}
+
+// WriteFixtures creates a CA in dir plus one certificate for every worker
+// name and one for the client, returning a config for each keyed by name
+// ("client" for the client).
+func WriteFixtures(dir string, workers ...string) (map[string]common.TLSConfig, error) {
+	ca, err := NewCA()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, ca.CertPEM, 0600); err != nil {
+		return nil, err
+	}
+
+	configs := map[string]common.TLSConfig{}
+	for _, name := range append([]string{"client"}, workers...) {
+		pair, err := ca.Issue(name, name, "localhost", "127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		cfg := common.TLSConfig{
+			CertFile: filepath.Join(dir, name+".pem"),
+			KeyFile:  filepath.Join(dir, name+"-key.pem"),
+			CAFile:   caFile,
+		}
+		if err := os.WriteFile(cfg.CertFile, pair.CertPEM, 0600); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(cfg.KeyFile, pair.KeyPEM, 0600); err != nil {
+			return nil, err
+		}
+		configs[name] = cfg
+	}
+	return configs, nil
+}
+
+func serial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	return n
+}
+
+func encode(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
@@ -0,0 +1,131 @@
+package tournament
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+func (a *Adjudication) validate(bad func(string, string, ...interface{})) {
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"max_moves", a.MaxMoves}, {"resign_score", a.ResignScore}, {"resign_moves", a.ResignMoves},
+		{"draw_score", a.DrawScore}, {"draw_moves", a.DrawMoves}, {"draw_after", a.DrawAfter},
+		{"tablebase_pieces", a.TablebasePieces},
+	} {
+		if f.value < 0 {
+			bad("adjudication."+f.name, "must not be negative, got %d", f.value)
+		}
+	}
+	if a.ResignMoves > 0 && a.ResignScore == 0 {
+		bad("adjudication.resign_score", "must be set with resign_moves")
+	}
+	if a.TablebasePieces > 7 {
+		bad("adjudication.tablebase_pieces", "tablebases go up to 7 pieces, got %d", a.TablebasePieces)
+	}
+	if a.TablebasePieces > 0 && a.TablebaseURL == "" {
+		a.TablebaseURL = DefaultTablebaseURL
+	}
+}
+
+// stands in for mate scores, beyond any centipawn score
+const mateScore = 100000
+
+// centipawns is the move's score, nil when there was no search
+func (m Move) centipawns() *int {
+	score := m.Score
+	switch {
+	case m.Mate > 0:
+		score = mateScore
+	case m.Mate < 0:
+		score = -mateScore
+	case m.Depth == 0:
+		return nil
+	}
+	return &score
+}
+
+// adjudicator follows one game for the rules
+type adjudicator struct {
+	rules     Adjudication
+	tablebase Tablebase // nil when off
+	logger    *slog.Logger
+	winning   int // plies in a row the scores agreed on a winner, positive for white
+	drawn     int // plies in a row the scores were near zero
+}
+
+// adjudicate looks at the game after m, the move just played. It returns
+// the result and the reason when the game is over.
+func (a *adjudicator) adjudicate(ctx context.Context, game *chess.Game, m Move) (string, string) {
+	pos := game.Position()
+	// the side to move is the one that didn't play m
+	white := 1
+	if pos.Turn() == chess.White {
+		white = -1
+	}
+	score := m.centipawns()
+
+	if r := a.rules; r.ResignMoves > 0 {
+		switch {
+		case score == nil:
+			a.winning = 0
+		case *score*white >= r.ResignScore:
+			a.winning = max(a.winning, 0) + 1
+		case *score*white <= -r.ResignScore:
+			a.winning = min(a.winning, 0) - 1
+		default:
+			a.winning = 0
+		}
+		if a.winning >= 2*r.ResignMoves || -a.winning >= 2*r.ResignMoves {
+			result := WhiteWins
+			if a.winning < 0 {
+				result = BlackWins
+			}
+			return result, fmt.Sprintf("adjudicated, both engines scored it %d cp or more for %d moves", r.ResignScore, r.ResignMoves)
+		}
+	}
+
+	if r := a.rules; r.DrawMoves > 0 {
+		positions := game.Positions()
+		number := moveNumber(positions[len(positions)-2]) // of m
+		if score != nil && *score <= r.DrawScore && *score >= -r.DrawScore && number > r.DrawAfter {
+			a.drawn++
+		} else {
+			a.drawn = 0
+		}
+		if a.drawn >= 2*r.DrawMoves {
+			return Draw, fmt.Sprintf("adjudicated, both engines scored it within %d cp for %d moves", r.DrawScore, r.DrawMoves)
+		}
+	}
+
+	if a.tablebase != nil && pieceCount(pos) <= a.rules.TablebasePieces {
+		wdl, err := a.tablebase.Probe(ctx, pos)
+		if err != nil {
+			// the game goes on without it
+			a.logger.Warn("unable to probe tablebase", common.LogErr, err)
+			a.tablebase = nil
+			return "", ""
+		}
+		moverWins := WhiteWins
+		if pos.Turn() == chess.Black {
+			moverWins = BlackWins
+		}
+		switch wdl {
+		case TablebaseWin:
+			return moverWins, "tablebase win"
+		case TablebaseLoss:
+			if moverWins == WhiteWins {
+				return BlackWins, "tablebase win"
+			}
+			return WhiteWins, "tablebase win"
+		case TablebaseDraw:
+			return Draw, "tablebase draw"
+		}
+	}
+	return "", ""
+}
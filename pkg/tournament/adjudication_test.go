@@ -0,0 +1,195 @@
+package tournament_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+)
+
+// plays one game of a against b under rules
+func adjudicated(t *testing.T, rules tournament.Adjudication, a fakeengine.Script, b fakeengine.Script) tournament.GameResult {
+	t.Helper()
+	spec := &tournament.Spec{
+		Games:        1,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: rules,
+		Engines:      []tournament.Engine{uciEngine("a"), uciEngine("b")},
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{"a": a, "b": b}))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results.Games[0]
+}
+
+func TestResignAdjudication(t *testing.T) {
+	rules := tournament.Adjudication{MaxMoves: 20, ResignScore: 500, ResignMoves: 3}
+	confident := fakeengine.Script{Score: 800, Depth: 10}
+	hopeless := fakeengine.Script{Score: -600, Depth: 10}
+
+	g := adjudicated(t, rules, confident, hopeless)
+	if g.Result != tournament.WhiteWins || g.Termination != tournament.TerminationAdjudication || len(g.Moves) != 6 {
+		t.Fatalf("want white to win by adjudication after 6 plies, got %s by %s after %d", g.Result, g.Reason, len(g.Moves))
+	}
+	// the other way round
+	g = adjudicated(t, rules, hopeless, confident)
+	if g.Result != tournament.BlackWins || len(g.Moves) != 6 {
+		t.Fatalf("want black to win by adjudication after 6 plies, got %s by %s after %d", g.Result, g.Reason, len(g.Moves))
+	}
+
+	// both engines have to agree
+	g = adjudicated(t, rules, confident, fakeengine.Script{Score: -100, Depth: 10})
+	if strings.HasPrefix(g.Reason, "adjudicated") {
+		t.Fatalf("adjudicated without agreement: %s by %s", g.Result, g.Reason)
+	}
+	// and have searched
+	g = adjudicated(t, rules, fakeengine.Script{Score: 800}, fakeengine.Script{Score: -800})
+	if strings.HasPrefix(g.Reason, "adjudicated") {
+		t.Fatalf("adjudicated without a search: %s by %s", g.Result, g.Reason)
+	}
+}
+
+func TestDrawAdjudication(t *testing.T) {
+	rules := tournament.Adjudication{MaxMoves: 20, DrawScore: 10, DrawMoves: 2, DrawAfter: 2}
+	level := fakeengine.Script{Score: 5, Depth: 10}
+
+	// two moves, then two more each within 10 cp
+	g := adjudicated(t, rules, level, fakeengine.Script{Score: -10, Depth: 10})
+	if g.Result != tournament.Draw || g.Termination != tournament.TerminationAdjudication || len(g.Moves) != 8 {
+		t.Fatalf("want a draw by adjudication after 8 plies, got %s by %s after %d", g.Result, g.Reason, len(g.Moves))
+	}
+
+	g = adjudicated(t, rules, level, fakeengine.Script{Score: 50, Depth: 10})
+	if strings.HasPrefix(g.Reason, "adjudicated") {
+		t.Fatalf("adjudicated a draw one engine doesn't see: %s by %s", g.Result, g.Reason)
+	}
+}
+
+func TestTablebaseAdjudication(t *testing.T) {
+	// the side to move always loses
+	var probed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = append(probed, r.URL.Query().Get("fen"))
+		w.Write([]byte(`{"category": "loss", "dtz": -10}`))
+	}))
+	defer server.Close()
+
+	pgn := filepath.Join(t.TempDir(), "games.pgn")
+	suite := writeFile(t, "suite.epd", "8/8/8/4k3/8/8/8/4K2Q w - - id \"KQK\";\n8/8/8/4k3/8/8/1PPPPPP1/4K3 w - - id \"pawns\";\n")
+	spec := &tournament.Spec{
+		Games:        4,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 50, TablebasePieces: 5, TablebaseURL: server.URL},
+		Openings:     tournament.Openings{File: suite},
+		Engines:      []tournament.Engine{uciEngine("a"), uciEngine("b")},
+		PGN:          pgn,
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{}))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// white moves first, then black is to move and lost
+	for _, g := range results.Games[:2] {
+		if g.Result != tournament.WhiteWins || g.Reason != "tablebase win" || len(g.Moves) != 1 {
+			t.Fatalf("want white to win by the tablebase after a move, got %s by %s after %d", g.Result, g.Reason, len(g.Moves))
+		}
+	}
+	if len(probed) != 2 || !strings.Contains(probed[0], " b ") {
+		t.Fatalf("want one probe per game with black to move, got %q", probed)
+	}
+	// too many pieces to look up
+	for _, g := range results.Games[2:] {
+		if g.Reason == "tablebase win" {
+			t.Fatalf("looked up a position with 8 pieces")
+		}
+	}
+
+	data, err := os.ReadFile(pgn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `[Termination "adjudication"]`) || !strings.Contains(string(data), "{tablebase win} 1-0") {
+		t.Fatalf("the PGN doesn't say how the game was adjudicated:\n%s", data)
+	}
+}
+
+func TestTablebaseFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	suite := writeFile(t, "suite.epd", "8/8/8/4k3/8/8/8/4K2Q w - -\n")
+	spec := &tournament.Spec{
+		Games:        1,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 5, TablebasePieces: 3, TablebaseURL: server.URL},
+		Openings:     tournament.Openings{File: suite},
+		Engines:      []tournament.Engine{uciEngine("a"), uciEngine("b")},
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{}))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("a tablebase error stopped the game: %v", err)
+	}
+	if g := results.Games[0]; g.Termination == tournament.TerminationAdjudication && g.Reason != "max moves" {
+		t.Fatalf("adjudicated without the tablebase: %s", g.Reason)
+	}
+
+	// whatever a failed probe returns is ignored
+	runner = newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{}))
+	runner.SetTablebase(failingTablebase{})
+	results, err = runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("a tablebase error stopped the game: %v", err)
+	}
+	if g := results.Games[0]; strings.HasPrefix(g.Reason, "tablebase") {
+		t.Fatalf("adjudicated from a failed probe: %s", g.Reason)
+	}
+}
+
+// answers a win along with an error
+type failingTablebase struct{}
+
+func (failingTablebase) Probe(ctx context.Context, pos *chess.Position) (int, error) {
+	return tournament.TablebaseWin, errors.New("tablebase unavailable")
+}
+
+func TestValidateAdjudication(t *testing.T) {
+	spec := &tournament.Spec{
+		Games:        1,
+		TimeControl:  moveTime(time.Second),
+		Adjudication: tournament.Adjudication{ResignMoves: 3, DrawScore: -1, TablebasePieces: 8},
+		Engines:      []tournament.Engine{uciEngine("a"), uciEngine("b")},
+	}
+	err := spec.Validate()
+	if err == nil {
+		t.Fatal("bad adjudication rules validated")
+	}
+	for _, want := range []string{"resign_score", "draw_score", "tablebase_pieces"} {
+		if !strings.Contains(err.Error(), "adjudication."+want+":") {
+			t.Errorf("no complaint about %s in:\n%v", want, err)
+		}
+	}
+
+	spec.Adjudication = tournament.Adjudication{TablebasePieces: 6}
+	err = spec.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Adjudication.TablebaseURL != tournament.DefaultTablebaseURL {
+		t.Fatalf("want the lichess tablebase by default, got %q", spec.Adjudication.TablebaseURL)
+	}
+}
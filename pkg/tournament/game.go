@@ -0,0 +1,208 @@
+package tournament
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// Results as written in PGN
+const (
+	WhiteWins = "1-0"
+	BlackWins = "0-1"
+	Draw      = "1/2-1/2"
+	Ongoing   = "*" // stopped before the end
+)
+
+// PGN Termination tag values
+const (
+	TerminationNormal       = "normal"
+	TerminationAdjudication = "adjudication"
+	TerminationTimeForfeit  = "time forfeit"
+	TerminationIllegalMove  = "rules infraction"
+	TerminationUnterminated = "unterminated"
+)
+
+// GameResult is a finished game
+type GameResult struct {
+	Pairing
+	WhiteName   string
+	BlackName   string
+	Result      string // WhiteWins, BlackWins or Draw
+	Termination string // one of the Termination constants
+	Reason      string // what ended the game, such as "checkmate" or "max moves"
+	Date        time.Time
+	TimeControl [2]TimeControl // white's and black's
+	Workers     [2]int         // white's and black's, 0 for engines that aren't clusters
+	Opening     string         // name of the opening, empty for the initial position
+	Start       *chess.Position
+	Moves       []MoveRecord // the opening's moves first
+}
+
+// MoveRecord is a played move with the search behind it
+type MoveRecord struct {
+	Move
+	Time time.Duration // taken by the player
+	Book bool          // part of the opening rather than played
+}
+
+// Score is the points the engine at index i got from the game
+func (g GameResult) Score(i int) float64 {
+	switch {
+	case g.Result == Draw:
+		return 0.5
+	case g.Result == WhiteWins && i == g.White, g.Result == BlackWins && i == g.Black:
+		return 1
+	}
+	return 0
+}
+
+// plays one game between the pairing's players
+func (r *Runner) play(ctx context.Context, p Pairing, players [2]Player) (GameResult, error) {
+	s := r.spec
+	result := GameResult{
+		Pairing:     p,
+		WhiteName:   s.Engines[p.White].Name,
+		BlackName:   s.Engines[p.Black].Name,
+		Date:        time.Now(),
+		TimeControl: [2]TimeControl{s.timeControl(p.White), s.timeControl(p.Black)},
+	}
+	names := [2]string{result.WhiteName, result.BlackName}
+	for i, player := range players {
+		err := player.NewGame(ctx)
+		if err != nil {
+			return result, fmt.Errorf("%s: new game: %w", names[i], err)
+		}
+		if cluster, ok := player.(interface{ Workers() int }); ok {
+			result.Workers[i] = cluster.Workers()
+		}
+	}
+
+	game := chess.NewGame()
+	if p.Opening >= 0 {
+		opening := s.openings[p.Opening]
+		var err error
+		game, err = opening.Game()
+		if err != nil {
+			return result, err
+		}
+		result.Opening = opening.Name
+		for _, m := range game.Moves() {
+			result.Moves = append(result.Moves, MoveRecord{Move: Move{Move: m}, Book: true})
+		}
+	}
+	result.Start = game.Positions()[0]
+	clocks := [2]time.Duration{result.TimeControl[0].Base.Duration, result.TimeControl[1].Base.Duration}
+	adjudicator := &adjudicator{rules: s.Adjudication, logger: r.logger.With("game", p.Number)}
+	if s.Adjudication.TablebasePieces > 0 {
+		adjudicator.tablebase = r.tablebase
+	}
+	// ends the game with the side to move winning or losing
+	end := func(moverWins bool, termination string, reason string) {
+		white := game.Position().Turn() == chess.White
+		if white == moverWins {
+			result.Result = WhiteWins
+		} else {
+			result.Result = BlackWins
+		}
+		result.Termination, result.Reason = termination, reason
+	}
+
+	for game.Outcome() == chess.NoOutcome {
+		if max := s.Adjudication.MaxMoves; max > 0 && len(game.Moves()) >= 2*max {
+			result.Result, result.Termination, result.Reason = Draw, TerminationAdjudication, "max moves"
+			break
+		}
+
+		side := 0
+		if game.Position().Turn() == chess.Black {
+			side = 1
+		}
+		tc := result.TimeControl[side]
+		begin := time.Now()
+		m, err := players[side].Move(ctx, game, tc.budget(clocks[side]))
+		took := time.Since(begin)
+		if err != nil {
+			return result, fmt.Errorf("%s: move %d: %w", names[side], len(game.Moves())/2+1, err)
+		}
+		// fixed move times are the engine's to keep, only clocks run out
+		if tc.MoveTime.Duration == 0 {
+			clocks[side] -= took
+			if clocks[side] < 0 {
+				end(false, TerminationTimeForfeit, "lost on time")
+				break
+			}
+			clocks[side] += tc.Increment.Duration
+		}
+
+		legal := find(game.ValidMoves(), m.Move)
+		if legal == nil {
+			end(false, TerminationIllegalMove, fmt.Sprintf("illegal move %s", m.Move))
+			break
+		}
+		m.Move = legal
+		game.Move(legal)
+		result.Moves = append(result.Moves, MoveRecord{Move: m, Time: took})
+		claimDraw(game)
+		if game.Outcome() != chess.NoOutcome {
+			break
+		}
+		if outcome, reason := adjudicator.adjudicate(ctx, game, m); outcome != "" {
+			result.Result, result.Termination, result.Reason = outcome, TerminationAdjudication, reason
+			break
+		}
+	}
+
+	if result.Result == "" {
+		result.Result = game.Outcome().String()
+		result.Termination = TerminationNormal
+		result.Reason = Describe(game.Method())
+	}
+	return result, nil
+}
+
+// the legal move matching m, nil if there's none
+func find(legal []*chess.Move, m *chess.Move) *chess.Move {
+	if m == nil {
+		return nil
+	}
+	for _, l := range legal {
+		if l.String() == m.String() {
+			return l
+		}
+	}
+	return nil
+}
+
+// threefold repetition and the fifty move rule end the game as soon as
+// they can be claimed, the way an arbiter would for engines
+func claimDraw(game *chess.Game) {
+	if game.Outcome() != chess.NoOutcome {
+		return
+	}
+	for _, method := range game.EligibleDraws() {
+		if method == chess.ThreefoldRepetition || method == chess.FiftyMoveRule {
+			game.Draw(method)
+			return
+		}
+	}
+}
+
+// Describe is the reason for a game the rules ended
+func Describe(method chess.Method) string {
+	switch method {
+	case chess.Checkmate:
+		return "checkmate"
+	case chess.Stalemate:
+		return "stalemate"
+	case chess.ThreefoldRepetition, chess.FivefoldRepetition:
+		return "repetition"
+	case chess.FiftyMoveRule, chess.SeventyFiveMoveRule:
+		return "fifty move rule"
+	case chess.InsufficientMaterial:
+		return "insufficient material"
+	}
+	return method.String()
+}
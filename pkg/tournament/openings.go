@@ -0,0 +1,205 @@
+package tournament
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// Opening orders
+const (
+	OrderSequential = "sequential" // as they appear in the file
+	OrderRandom     = "random"     // shuffled with Openings.Seed
+)
+
+// Openings is the suite games start from. Each opening is played twice in
+// a row by every pairing, once with each color.
+type Openings struct {
+	File  string `json:"file"`  // EPD or PGN suite, empty starts every game from the initial position
+	Order string `json:"order"` // OrderSequential when empty
+	Seed  int64  `json:"seed"`  // shuffles OrderRandom, 0 picks one which Validate fills in
+	Plies int    `json:"plies"` // PGN: moves played from each game, 0 for all of them
+}
+
+// Opening is a position to start games from, Moves are played from FEN
+// before the engines take over
+type Opening struct {
+	Name  string
+	FEN   string
+	Moves []string // UCI notation
+}
+
+// Game returns the opening played out
+func (o Opening) Game() (*chess.Game, error) {
+	fen, err := chess.FEN(o.FEN)
+	if err != nil {
+		return nil, err
+	}
+	game := chess.NewGame(fen)
+	for _, s := range o.Moves {
+		m, err := chess.UCINotation{}.Decode(game.Position(), s)
+		if err == nil {
+			err = game.Move(m)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: move %s: %w", o.Name, s, err)
+		}
+	}
+	return game, nil
+}
+
+// LoadOpenings reads an EPD or PGN suite, telling them apart by extension.
+// Files of FEN lines are read as EPD. plies limits how much of each PGN
+// game is used, 0 for all of it.
+func LoadOpenings(path string, plies int) ([]Opening, error) {
+	var openings []Opening
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".epd", ".fen":
+		openings, err = loadEPD(path)
+	case ".pgn":
+		openings, err = loadPGN(path, plies)
+	default:
+		return nil, fmt.Errorf("%s: expected a .epd, .fen or .pgn suite", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(openings) == 0 {
+		return nil, fmt.Errorf("%s: no openings", path)
+	}
+	// a bad position should fail now rather than games into the tournament
+	for _, o := range openings {
+		_, err := o.Game()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return openings, nil
+}
+
+// EPD lines are the first four FEN fields then operations such as
+// id "name"; and maybe the move counters
+func loadEPD(path string) ([]Opening, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var openings []Opening
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%s:%d: expected a position", path, line)
+		}
+		fen := strings.Join(fields[:4], " ")
+		rest := strings.Join(fields[4:], " ")
+		if len(fields) >= 6 && isNumber(fields[4]) && isNumber(fields[5]) {
+			fen += " " + fields[4] + " " + fields[5]
+			rest = strings.Join(fields[6:], " ")
+		} else {
+			fen += " 0 1"
+		}
+		name := operand(rest, "id")
+		if name == "" {
+			name = operand(rest, "c0")
+		}
+		if name == "" {
+			name = fmt.Sprintf("%s:%d", filepath.Base(path), line)
+		}
+		openings = append(openings, Opening{Name: name, FEN: fen})
+	}
+	return openings, scanner.Err()
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// the operand of an EPD operation such as id "name";
+func operand(operations string, opcode string) string {
+	for _, op := range strings.Split(operations, ";") {
+		op = strings.TrimSpace(op)
+		if value, ok := strings.CutPrefix(op, opcode+" "); ok {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+func loadPGN(path string, plies int) ([]Opening, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var openings []Opening
+	scanner := chess.NewScanner(f)
+	for scanner.Scan() {
+		g := scanner.Next()
+		// the scanner makes an empty game of a blank line ending the file
+		if len(g.TagPairs()) == 0 && len(g.Moves()) == 0 {
+			continue
+		}
+		moves := g.Moves()
+		if plies > 0 && len(moves) > plies {
+			moves = moves[:plies]
+		}
+		o := Opening{Name: pgnName(g, len(openings)+1, path), FEN: g.Positions()[0].String()}
+		for _, m := range moves {
+			o.Moves = append(o.Moves, m.String())
+		}
+		openings = append(openings, o)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return openings, nil
+}
+
+// names a PGN opening from its tags, or its place in the file
+func pgnName(g *chess.Game, n int, path string) string {
+	var parts []string
+	for _, key := range []string{"ECO", "Opening", "Variation"} {
+		if tag := g.GetTagPair(key); tag != nil && tag.Value != "" && tag.Value != "?" {
+			parts = append(parts, tag.Value)
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("%s #%d", filepath.Base(path), n)
+	}
+	return strings.Join(parts, " ")
+}
+
+// order puts the openings in the suite's order, filling in a random seed
+func (o *Openings) order(openings []Opening) {
+	if o.Order != OrderRandom {
+		return
+	}
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(o.Seed))
+	rng.Shuffle(len(openings), func(i, j int) {
+		openings[i], openings[j] = openings[j], openings[i]
+	})
+}
@@ -0,0 +1,211 @@
+package tournament_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+)
+
+const sicilian = "rnbqkbnr/pp1ppppp/8/2p5/4P3/8/PPPP1PPP/RNBQKBNR w KQkq -"
+const english = "rnbqkbnr/pppppppp/8/8/2P5/8/PP1PPPPP/RNBQKBNR b KQkq -"
+
+func writeFile(t *testing.T, name string, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(text), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOpenings(t *testing.T) {
+	epd := writeFile(t, "suite.epd", "# comment\n\n"+
+		sicilian+` id "sicilian";`+"\n"+
+		english+` 0 1 c0 "english";`+"\n"+
+		sicilian+"\n")
+	openings, err := tournament.LoadOpenings(epd, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openings) != 3 {
+		t.Fatalf("want 3 openings, got %d", len(openings))
+	}
+	for i, want := range []string{"sicilian", "english", "suite.epd:5"} {
+		if openings[i].Name != want {
+			t.Errorf("opening %d: want name %q, got %q", i, want, openings[i].Name)
+		}
+	}
+	if openings[1].FEN != english+" 0 1" {
+		t.Errorf("want the counters kept, got %q", openings[1].FEN)
+	}
+
+	pgn := writeFile(t, "suite.pgn", `[Event "?"]
+[ECO "C50"]
+[Opening "Italian Game"]
+
+1. e4 e5 2. Nf3 Nc6 3. Bc4 *
+
+[Event "?"]
+
+1. d4 d5 *
+
+`)
+	openings, err = tournament.LoadOpenings(pgn, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openings) != 2 {
+		t.Fatalf("want 2 openings, got %d", len(openings))
+	}
+	if o := openings[0]; o.Name != "C50 Italian Game" || strings.Join(o.Moves, " ") != "e2e4 e7e5 g1f3 b8c6" {
+		t.Fatalf("want 4 plies of the italian, got %q: %v", o.Name, o.Moves)
+	}
+	if o := openings[1]; o.Name != "suite.pgn #2" || len(o.Moves) != 2 {
+		t.Fatalf("want both plies of the second game, got %q: %v", o.Name, o.Moves)
+	}
+
+	fen := writeFile(t, "game.fen", english+" 0 1\n")
+	openings, err = tournament.LoadOpenings(fen, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openings) != 1 || openings[0].FEN != english+" 0 1" {
+		t.Fatalf("want the FEN as it is, got %+v", openings)
+	}
+
+	for name, text := range map[string]string{
+		"empty.epd":   "# nothing\n",
+		"short.epd":   "rnbqkbnr/pppppppp w KQkq\n",
+		"board.epd":   "rnbqkbnr/pppppppp/8/8 w KQkq -\n",
+		"suite.txt":   sicilian + "\n",
+		"missing.pgn": "",
+	} {
+		path := writeFile(t, name, text)
+		if name == "missing.pgn" {
+			os.Remove(path)
+		}
+		_, err := tournament.LoadOpenings(path, 0)
+		if err == nil {
+			t.Errorf("%s: loaded a bad suite", name)
+		}
+	}
+}
+
+func TestOpeningsPlayedWithBothColors(t *testing.T) {
+	pgn := filepath.Join(t.TempDir(), "games.pgn")
+	suite := writeFile(t, "suite.epd", sicilian+` id "sicilian";`+"\n"+english+` id "english";`+"\n")
+	spec := &tournament.Spec{
+		Games:        4,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 6},
+		Openings:     tournament.Openings{File: suite},
+		Engines:      []tournament.Engine{uciEngine("a"), uciEngine("b")},
+		PGN:          pgn,
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{}))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Games) != 4 {
+		t.Fatalf("want 4 games, got %d", len(results.Games))
+	}
+	for i, g := range results.Games {
+		want := []string{"sicilian", "english"}[i/2]
+		if g.Opening != want {
+			t.Fatalf("game %d: want the %s, got %q", i+1, want, g.Opening)
+		}
+		if !strings.HasPrefix(g.Start.String(), []string{sicilian, english}[i/2]) {
+			t.Fatalf("game %d started from %s", i+1, g.Start)
+		}
+	}
+	if results.Games[0].WhiteName != results.Games[1].BlackName {
+		t.Fatalf("the opening wasn't replayed with colors swapped: %s then %s", results.Games[0].WhiteName, results.Games[1].WhiteName)
+	}
+
+	data, err := os.ReadFile(pgn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`[SetUp "1"]`, `[FEN "` + english + ` 0 1"]`, `[Opening "english"]`, "1... "} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("no %s in the PGN:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "OpeningSeed") {
+		t.Errorf("a sequential suite wrote its seed:\n%s", data)
+	}
+}
+
+func TestBookMoves(t *testing.T) {
+	suite := writeFile(t, "suite.pgn", "[Event \"?\"]\n\n1. e4 e5 2. Nf3 *\n\n")
+	spec := &tournament.Spec{
+		Games:        1,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 4},
+		Openings:     tournament.Openings{File: suite},
+		Engines:      []tournament.Engine{uciEngine("a"), uciEngine("b")},
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{}))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := results.Games[0]
+	if g.Start.String() != "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1" {
+		t.Fatalf("want the game to start from the initial position, got %s", g.Start)
+	}
+	for i, m := range g.Moves {
+		if m.Book != (i < 3) {
+			t.Fatalf("ply %d: want book %v", i+1, i < 3)
+		}
+	}
+}
+
+func TestRandomOrder(t *testing.T) {
+	var text strings.Builder
+	for i := 0; i < 20; i++ {
+		text.WriteString(sicilian + ` id "` + string(rune('a'+i)) + `";` + "\n")
+	}
+	suite := writeFile(t, "suite.epd", text.String())
+	order := func(seed int64) (string, int64) {
+		spec := &tournament.Spec{
+			Games:       1,
+			TimeControl: moveTime(time.Millisecond),
+			Openings:    tournament.Openings{File: suite, Order: tournament.OrderRandom, Seed: seed},
+			Engines:     []tournament.Engine{uciEngine("a"), uciEngine("b")},
+		}
+		err := spec.Validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, o := range spec.OpeningSuite() {
+			names = append(names, o.Name)
+		}
+		return strings.Join(names, ""), spec.Openings.Seed
+	}
+
+	first, _ := order(7)
+	again, _ := order(7)
+	if first != again {
+		t.Fatalf("the same seed shuffled differently: %s and %s", first, again)
+	}
+	if first == "abcdefghijklmnopqrst" {
+		t.Fatal("random order left the suite as it was")
+	}
+	shuffled, seed := order(0)
+	if seed == 0 {
+		t.Fatal("no seed was picked")
+	}
+	if replayed, _ := order(seed); replayed != shuffled {
+		t.Fatalf("the picked seed doesn't reproduce the order: %s and %s", shuffled, replayed)
+	}
+}
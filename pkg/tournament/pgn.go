@@ -0,0 +1,125 @@
+package tournament
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// movetext lines are wrapped to this width, as the PGN standard asks
+const pgnWidth = 79
+
+// WritePGN writes g as a PGN game of event, the reason the game ended is a
+// comment after the last move. Played moves have a comment with the score
+// from the mover's side, the depth, the time taken and the nodes searched,
+// such as {+0.35/18 0.98s 1520334}. A shuffled suite's seed is written with
+// the opening so the order can be reproduced.
+func WritePGN(w io.Writer, event string, openings Openings, g GameResult) error {
+	var b strings.Builder
+	tag := func(name string, value string) {
+		value = strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`)
+		fmt.Fprintf(&b, "[%s \"%s\"]\n", name, value)
+	}
+	tag("Event", event)
+	tag("Site", "?")
+	tag("Date", g.Date.Format("2006.01.02"))
+	tag("Round", fmt.Sprint(g.Number))
+	tag("White", g.WhiteName)
+	tag("Black", g.BlackName)
+	tag("Result", g.Result)
+	if g.Start != nil && g.Start.String() != chess.StartingPosition().String() {
+		tag("SetUp", "1")
+		tag("FEN", g.Start.String())
+	}
+	if g.TimeControl[0] == g.TimeControl[1] {
+		tag("TimeControl", g.TimeControl[0].String())
+	} else {
+		tag("WhiteTimeControl", g.TimeControl[0].String())
+		tag("BlackTimeControl", g.TimeControl[1].String())
+	}
+	for i, side := range []string{"White", "Black"} {
+		if g.Workers[i] > 0 {
+			tag(side+"Workers", fmt.Sprint(g.Workers[i]))
+		}
+	}
+	if g.Opening != "" {
+		tag("Opening", g.Opening)
+		if openings.Order == OrderRandom {
+			tag("OpeningSeed", fmt.Sprint(openings.Seed))
+		}
+	}
+	tag("PlyCount", fmt.Sprint(len(g.Moves)))
+	tag("Termination", g.Termination)
+	b.WriteString("\n")
+
+	var tokens []string
+	pos := g.Start
+	if pos == nil {
+		pos = chess.StartingPosition()
+	}
+	notation := chess.AlgebraicNotation{}
+	for i, m := range g.Moves {
+		if pos.Turn() == chess.White {
+			tokens = append(tokens, fmt.Sprintf("%d.", moveNumber(pos)))
+		} else if i == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d...", moveNumber(pos)))
+		}
+		tokens = append(tokens, notation.Encode(pos, m.Move.Move))
+		if !m.Book {
+			tokens = append(tokens, "{"+m.comment()+"}")
+		}
+		pos = pos.Update(m.Move.Move)
+	}
+	if g.Reason != "" {
+		tokens = append(tokens, "{"+strings.ReplaceAll(g.Reason, "}", ")")+"}")
+	}
+	tokens = append(tokens, g.Result)
+
+	line := 0
+	for i, token := range tokens {
+		if i > 0 && line+1+len(token) > pgnWidth {
+			b.WriteString("\n")
+			line = 0
+		} else if i > 0 {
+			b.WriteString(" ")
+			line++
+		}
+		b.WriteString(token)
+		line += len(token)
+	}
+	b.WriteString("\n\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// the search behind the move, score/depth time nodes
+func (m MoveRecord) comment() string {
+	var parts []string
+	switch {
+	case m.Mate > 0:
+		parts = append(parts, fmt.Sprintf("+M%d/%d", m.Mate, m.Depth))
+	case m.Mate < 0:
+		parts = append(parts, fmt.Sprintf("-M%d/%d", -m.Mate, m.Depth))
+	case m.Depth > 0:
+		parts = append(parts, fmt.Sprintf("%+.2f/%d", float64(m.Score)/100, m.Depth))
+	}
+	parts = append(parts, seconds(m.Time.Round(time.Millisecond))+"s")
+	if m.Nodes > 0 {
+		parts = append(parts, fmt.Sprint(m.Nodes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// the full move number of pos, from its FEN
+func moveNumber(pos *chess.Position) int {
+	fields := strings.Fields(pos.String())
+	n := 1
+	if len(fields) == 6 {
+		fmt.Sscan(fields[5], &n)
+	}
+	return n
+}
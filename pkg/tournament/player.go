@@ -0,0 +1,159 @@
+package tournament
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+)
+
+// Player plays one side of a game
+type Player interface {
+	// NewGame resets the player for a new game
+	NewGame(ctx context.Context) error
+	// Move picks a move in the game's current position within budget
+	Move(ctx context.Context, game *chess.Game, budget time.Duration) (Move, error)
+	Close() error
+}
+
+// Move is a player's move and what its search said about it
+type Move struct {
+	Move  *chess.Move
+	Score int // centipawns from the mover's side
+	Mate  int
+	Depth int
+	Nodes int
+}
+
+// Factory starts a player for one game of e
+type Factory func(ctx context.Context, e Engine) (Player, error)
+
+// DefaultFactory starts uci engines with uci.New, and cluster engines with
+// the client newClient builds, connecting it first. With a refresh interval
+// clusters poll the catalog for workers joining and leaving mid-game.
+func DefaultFactory(newClient func(e Engine) (*client.Client, error), refresh time.Duration) Factory {
+	return func(ctx context.Context, e Engine) (Player, error) {
+		switch e.Kind {
+		case KindUCI:
+			eng, err := uci.New(e.Path)
+			if err != nil {
+				return nil, err
+			}
+			return NewUCIPlayer(eng, e.UCIOptions()), nil
+		case KindCluster:
+			c, err := newClient(e)
+			if err != nil {
+				return nil, err
+			}
+			err = c.Connect(ctx)
+			if err != nil {
+				c.Shutdown()
+				return nil, err
+			}
+			p := NewClusterPlayer(c, e.UCIOptions())
+			if refresh > 0 {
+				p.stopWatch = c.WatchCatalog(refresh)
+			}
+			return p, nil
+		}
+		return nil, fmt.Errorf("unknown engine kind %q", e.Kind)
+	}
+}
+
+// UCIEngine is the part of *uci.Engine a UCIPlayer uses
+type UCIEngine interface {
+	Run(cmds ...uci.Cmd) error
+	SearchResults() uci.SearchResults
+	Close() error
+}
+
+// UCIPlayer plays with a local UCI engine
+type UCIPlayer struct {
+	engine  UCIEngine
+	options []uci.CmdSetOption
+}
+
+// NewUCIPlayer plays with engine, setting options for every game
+func NewUCIPlayer(engine UCIEngine, options []uci.CmdSetOption) *UCIPlayer {
+	return &UCIPlayer{engine: engine, options: options}
+}
+
+func (p *UCIPlayer) NewGame(ctx context.Context) error {
+	cmds := []uci.Cmd{uci.CmdUCI, uci.CmdIsReady}
+	for _, o := range p.options {
+		cmds = append(cmds, o)
+	}
+	cmds = append(cmds, uci.CmdUCINewGame, uci.CmdIsReady)
+	return p.engine.Run(cmds...)
+}
+
+// Move searches for budget. The whole game is sent so the engine sees
+// repetitions, and the search can't be cut short by ctx.
+func (p *UCIPlayer) Move(ctx context.Context, game *chess.Game, budget time.Duration) (Move, error) {
+	if ctx.Err() != nil {
+		return Move{}, ctx.Err()
+	}
+	cmdPos := uci.CmdPosition{Position: game.Positions()[0], Moves: game.Moves()}
+	err := p.engine.Run(cmdPos, uci.CmdGo{MoveTime: budget})
+	if err != nil {
+		return Move{}, err
+	}
+	results := p.engine.SearchResults()
+	if results.BestMove == nil {
+		return Move{}, errors.New("the engine returned no move")
+	}
+	return Move{
+		Move:  results.BestMove,
+		Score: results.Info.Score.CP,
+		Mate:  results.Info.Score.Mate,
+		Depth: results.Info.Depth,
+		Nodes: results.Info.Nodes,
+	}, nil
+}
+
+func (p *UCIPlayer) Close() error {
+	return p.engine.Close()
+}
+
+// ClusterPlayer plays with the workers of a connected client
+type ClusterPlayer struct {
+	client    *client.Client
+	options   []uci.CmdSetOption
+	stopWatch func() // stops WatchCatalog, nil when not watching
+}
+
+// NewClusterPlayer plays with c, sending options with every new game
+func NewClusterPlayer(c *client.Client, options []uci.CmdSetOption) *ClusterPlayer {
+	return &ClusterPlayer{client: c, options: options}
+}
+
+func (p *ClusterPlayer) NewGame(ctx context.Context) error {
+	return p.client.NewGame(ctx, p.options)
+}
+
+func (p *ClusterPlayer) Move(ctx context.Context, game *chess.Game, budget time.Duration) (Move, error) {
+	positions := game.Positions()
+	result, err := p.client.Search(ctx, game.Position(), positions[:len(positions)-1], client.Limits{MoveTime: budget})
+	if err != nil {
+		return Move{}, err
+	}
+	return Move{Move: result.Move, Score: result.Score, Mate: result.Mate, Depth: result.Depth, Nodes: result.Nodes}, nil
+}
+
+// Workers is the number of workers connected
+func (p *ClusterPlayer) Workers() int {
+	return len(p.client.Workers())
+}
+
+// Close disconnects from the workers
+func (p *ClusterPlayer) Close() error {
+	if p.stopWatch != nil {
+		p.stopWatch()
+	}
+	p.client.Shutdown()
+	return nil
+}
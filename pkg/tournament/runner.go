@@ -0,0 +1,260 @@
+package tournament
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Runner plays a tournament
+type Runner struct {
+	spec      *Spec
+	factory   Factory
+	logger    *slog.Logger
+	tablebase Tablebase
+	onGame    []func(GameResult)
+}
+
+// New validates spec and returns a runner starting its players with factory
+func New(spec *Spec, factory Factory) (*Runner, error) {
+	err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+	r := &Runner{spec: spec, factory: factory, logger: slog.Default()}
+	if spec.Adjudication.TablebasePieces > 0 {
+		r.tablebase = NewHTTPTablebase(spec.Adjudication.TablebaseURL)
+	}
+	return r, nil
+}
+
+// Set the logger used for game events
+func (r *Runner) SetLogger(logger *slog.Logger) {
+	r.logger = logger
+}
+
+// SetTablebase replaces the one Adjudication.TablebaseURL points to
+func (r *Runner) SetTablebase(tb Tablebase) {
+	r.tablebase = tb
+}
+
+// OnGame calls f with each game as it finishes, one call at a time
+func (r *Runner) OnGame(f func(GameResult)) {
+	r.onGame = append(r.onGame, f)
+}
+
+// Results are the games a tournament finished
+type Results struct {
+	Event     string
+	Format    string
+	Engines   []string
+	Scheduled int          // games in the schedule
+	Games     []GameResult // in schedule order
+	SPRT      *SPRT        // the spec's test, nil for none
+	Stopped   string       // why the rest of the schedule wasn't played, empty when it was
+}
+
+// Standing is an engine's record
+type Standing struct {
+	Engine string  `json:"engine"`
+	Games  int     `json:"games"`
+	Wins   int     `json:"wins"`
+	Draws  int     `json:"draws"`
+	Losses int     `json:"losses"`
+	Points float64 `json:"points"`
+}
+
+// Standings ranks the engines by points
+func (r *Results) Standings() []Standing {
+	standings := make([]Standing, len(r.Engines))
+	for i, name := range r.Engines {
+		standings[i].Engine = name
+	}
+	for _, g := range r.Games {
+		for _, i := range []int{g.White, g.Black} {
+			s := &standings[i]
+			s.Games++
+			s.Points += g.Score(i)
+			switch g.Score(i) {
+			case 1:
+				s.Wins++
+			case 0.5:
+				s.Draws++
+			default:
+				s.Losses++
+			}
+		}
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Points > standings[j].Points
+	})
+	return standings
+}
+
+// Run plays every scheduled game, Spec.Concurrency at a time. The first
+// game that can't be played stops the tournament, games already finished
+// are returned along with the error. Cancelling ctx stops it the same way.
+// Once the spec's SPRT is decided no more games are started, the ones
+// being played are finished.
+func (r *Runner) Run(ctx context.Context) (*Results, error) {
+	s := r.spec
+	schedule := s.Schedule()
+	results := &Results{Event: s.Event, Format: s.Format, Scheduled: len(schedule), SPRT: s.SPRT}
+	for _, e := range s.Engines {
+		results.Engines = append(results.Engines, e.Name)
+	}
+
+	var pgn io.Writer
+	if s.PGN != "" {
+		f, err := os.Create(s.PGN)
+		if err != nil {
+			return results, err
+		}
+		defer f.Close()
+		pgn = f
+	}
+
+	// engines play at most MaxGames at once
+	slots := make([]chan struct{}, len(s.Engines))
+	for i, e := range s.Engines {
+		slots[i] = make(chan struct{}, e.MaxGames)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		cancel()
+	}
+
+	// closed when the SPRT is decided
+	decided := make(chan struct{})
+
+	games := make(chan Pairing)
+	var wg sync.WaitGroup
+	for i := 0; i < s.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range games {
+				result, err := r.game(ctx, p, slots)
+				if err != nil {
+					fail(fmt.Errorf("game %d: %w", p.Number, err))
+					continue
+				}
+				r.logger.Info("game finished", "game", p.Number, "white", result.WhiteName, "black", result.BlackName,
+					"result", result.Result, "reason", result.Reason, "moves", len(result.Moves))
+
+				mu.Lock()
+				results.Games = append(results.Games, result)
+				if pgn != nil {
+					err = WritePGN(pgn, s.Event, s.Openings, result)
+				}
+				for _, f := range r.onGame {
+					f(result)
+				}
+				if s.SPRT != nil && results.Stopped == "" {
+					if test := results.Match(0, 1, s.SPRT).SPRT; test != nil && test.Result != SPRTContinue {
+						results.Stopped = fmt.Sprintf("SPRT accepted %s with LLR %.2f", test.Result, test.LLR)
+						r.logger.Info("stopping early", "reason", results.Stopped)
+						close(decided)
+					}
+				}
+				mu.Unlock()
+				if err != nil {
+					fail(fmt.Errorf("writing PGN: %w", err))
+				}
+			}
+		}()
+	}
+
+schedule:
+	for _, p := range schedule {
+		// select picks at random when a worker is waiting too, so look first
+		select {
+		case <-decided:
+			break schedule
+		default:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case games <- p:
+		case <-ctx.Done():
+			break schedule
+		case <-decided:
+			break schedule
+		}
+	}
+	close(games)
+	wg.Wait()
+
+	sort.Slice(results.Games, func(i, j int) bool {
+		return results.Games[i].Number < results.Games[j].Number
+	})
+	if s.Summary != "" {
+		err := writeSummary(s.Summary, results)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("writing summary: %w", err)
+		}
+	}
+	if firstErr != nil {
+		return results, firstErr
+	}
+	return results, ctx.Err()
+}
+
+func writeSummary(path string, results *Results) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = results.WriteSummary(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// starts the pairing's players once both engines are free and plays the game
+func (r *Runner) game(ctx context.Context, p Pairing, slots []chan struct{}) (GameResult, error) {
+	if ctx.Err() != nil {
+		return GameResult{}, ctx.Err()
+	}
+	// always taken lowest engine first, so two games can't wait on each other
+	order := []int{p.White, p.Black}
+	if p.Black < p.White {
+		order = []int{p.Black, p.White}
+	}
+	for _, i := range order {
+		select {
+		case slots[i] <- struct{}{}:
+			defer func(i int) { <-slots[i] }(i)
+		case <-ctx.Done():
+			return GameResult{}, ctx.Err()
+		}
+	}
+
+	var players [2]Player
+	for side, i := range []int{p.White, p.Black} {
+		player, err := r.factory(ctx, r.spec.Engines[i])
+		if err != nil {
+			return GameResult{}, fmt.Errorf("starting %s: %w", r.spec.Engines[i].Name, err)
+		}
+		defer player.Close()
+		players[side] = player
+	}
+	return r.play(ctx, p, players)
+}
@@ -0,0 +1,288 @@
+package tournament
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Confidence is the level of the Elo intervals
+const Confidence = 0.95
+
+// SPRT outcomes
+const (
+	SPRTContinue = ""   // not decided yet
+	SPRTAcceptH0 = "H0" // the engine isn't Elo1 stronger, it's at most Elo0
+	SPRTAcceptH1 = "H1" // the engine is at least Elo1 stronger
+)
+
+// SPRT is a sequential probability ratio test of the first engine against
+// the second: H0 is that it's Elo0 stronger, H1 that it's Elo1 stronger.
+// Alpha and Beta are the chances of accepting H1 when H0 holds and the
+// other way around.
+type SPRT struct {
+	Elo0  float64 `json:"elo0"`
+	Elo1  float64 `json:"elo1"`
+	Alpha float64 `json:"alpha"` // 0.05 when zero
+	Beta  float64 `json:"beta"`  // 0.05 when zero
+}
+
+func (t *SPRT) validate(bad func(string, string, ...interface{})) {
+	if t.Alpha == 0 {
+		t.Alpha = 0.05
+	}
+	if t.Beta == 0 {
+		t.Beta = 0.05
+	}
+	if t.Elo0 >= t.Elo1 {
+		bad("sprt", "elo0 must be below elo1, got %v and %v", t.Elo0, t.Elo1)
+	}
+	if t.Alpha <= 0 || t.Alpha >= 0.5 || t.Beta <= 0 || t.Beta >= 0.5 {
+		bad("sprt", "alpha and beta must be between 0 and 0.5, got %v and %v", t.Alpha, t.Beta)
+	}
+}
+
+// Bounds are the log likelihood ratios accepting H0 and H1
+func (t SPRT) Bounds() (lower float64, upper float64) {
+	return math.Log(t.Beta / (1 - t.Alpha)), math.Log((1 - t.Beta) / t.Alpha)
+}
+
+// SPRTState is where a match stands in the test
+type SPRTState struct {
+	SPRT
+	LLR    float64 `json:"llr"`
+	Lower  float64 `json:"lower"`
+	Upper  float64 `json:"upper"`
+	Result string  `json:"result"` // one of the SPRT outcomes
+}
+
+// Elo is a rating difference, infinite when every game went one way
+type Elo float64
+
+// MarshalJSON writes infinite differences as null, JSON has no infinity
+func (e Elo) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(e), 0) || math.IsNaN(float64(e)) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(math.Round(float64(e)*10) / 10)
+}
+
+func (e Elo) String() string {
+	switch {
+	case math.IsNaN(float64(e)):
+		return "?"
+	case math.IsInf(float64(e), 1):
+		return "+inf"
+	case math.IsInf(float64(e), -1):
+		return "-inf"
+	}
+	return fmt.Sprintf("%+.1f", float64(e))
+}
+
+// Interval is an Elo difference and its confidence interval
+type Interval struct {
+	Diff Elo `json:"diff"`
+	Low  Elo `json:"low"`
+	High Elo `json:"high"`
+}
+
+// Match is how one engine did against another, from Engine's side.
+// Games with the same opening and colors swapped are pairs. With any
+// complete pairs the Elo interval uses their pentanomial distribution,
+// which accounts for the opening's part in the result, and the SPRT only
+// ever does.
+type Match struct {
+	Engine      string     `json:"engine"`
+	Opponent    string     `json:"opponent"`
+	Games       int        `json:"games"`
+	Wins        int        `json:"wins"`
+	Draws       int        `json:"draws"`
+	Losses      int        `json:"losses"`
+	Score       float64    `json:"score"`       // share of the points, 0 to 1
+	Pentanomial [5]int     `json:"pentanomial"` // pairs scoring 0, 0.5, 1, 1.5 and 2 points
+	Elo         Interval   `json:"elo"`
+	LOS         float64    `json:"los"` // likelihood of superiority, 0 to 1
+	SPRT        *SPRTState `json:"sprt,omitempty"`
+}
+
+// Pairs is the number of complete game pairs
+func (m Match) Pairs() int {
+	n := 0
+	for _, count := range m.Pentanomial {
+		n += count
+	}
+	return n
+}
+
+// Match works out how engine i did against engine j. test is run on it
+// when it isn't nil.
+func (r *Results) Match(i int, j int, test *SPRT) Match {
+	m := Match{Engine: r.Engines[i], Opponent: r.Engines[j]}
+	pairs := map[int][]float64{}
+	for _, g := range r.Games {
+		if (g.White != i || g.Black != j) && (g.White != j || g.Black != i) {
+			continue
+		}
+		score := g.Score(i)
+		m.Games++
+		switch score {
+		case 1:
+			m.Wins++
+		case 0.5:
+			m.Draws++
+		default:
+			m.Losses++
+		}
+		// rounds 1 and 2 share an opening, then 3 and 4 and so on
+		pair := (g.Round - 1) / 2
+		pairs[pair] = append(pairs[pair], score)
+	}
+	if m.Games == 0 {
+		return m
+	}
+	m.Score = (float64(m.Wins) + float64(m.Draws)/2) / float64(m.Games)
+
+	for _, scores := range pairs {
+		if len(scores) == 2 {
+			m.Pentanomial[int((scores[0]+scores[1])*2)]++
+		}
+	}
+
+	// per pair scores when there are pairs, per game otherwise
+	samples := map[float64]float64{1: float64(m.Wins), 0.5: float64(m.Draws), 0: float64(m.Losses)}
+	if m.Pairs() > 0 {
+		samples = pairSamples(m.Pentanomial)
+	}
+	mean, variance, n := moments(samples)
+	z := math.Sqrt2 * math.Erfinv(Confidence)
+	margin := z * math.Sqrt(variance/n)
+	m.Elo = Interval{Diff: eloOf(mean), Low: eloOf(mean - margin), High: eloOf(mean + margin)}
+
+	m.LOS = 0.5
+	if decisive := m.Wins + m.Losses; decisive > 0 {
+		m.LOS = 0.5 * (1 + math.Erf(float64(m.Wins-m.Losses)/math.Sqrt(2*float64(decisive))))
+	}
+
+	if test != nil {
+		m.SPRT = test.state(m.Pentanomial)
+	}
+	return m
+}
+
+// Matches lists every pairing that played, from the lower indexed engine's
+// side, so a gauntlet's are all from the first engine's side
+func (r *Results) Matches() []Match {
+	var matches []Match
+	for i := range r.Engines {
+		for j := i + 1; j < len(r.Engines); j++ {
+			var test *SPRT
+			if i == 0 && j == 1 {
+				test = r.SPRT
+			}
+			if m := r.Match(i, j, test); m.Games > 0 {
+				matches = append(matches, m)
+			}
+		}
+	}
+	return matches
+}
+
+// pairs' scores out of 1 with how often they came up
+func pairSamples(pentanomial [5]int) map[float64]float64 {
+	samples := map[float64]float64{}
+	for k, count := range pentanomial {
+		samples[float64(k)/4] = float64(count)
+	}
+	return samples
+}
+
+// the mean and variance of scores seen as often as the values, and how
+// many there were
+func moments(samples map[float64]float64) (mean float64, variance float64, n float64) {
+	for _, count := range samples {
+		n += count
+	}
+	for score, count := range samples {
+		mean += score * count / n
+	}
+	for score, count := range samples {
+		variance += (score - mean) * (score - mean) * count / n
+	}
+	return mean, variance, n
+}
+
+// state is the test run on the complete pairs. Until they've gone more
+// than one way there's no spread to judge them by and nothing is decided.
+func (t SPRT) state(pentanomial [5]int) *SPRTState {
+	state := &SPRTState{SPRT: t}
+	state.Lower, state.Upper = t.Bounds()
+	outcomes := 0
+	for _, count := range pentanomial {
+		if count > 0 {
+			outcomes++
+		}
+	}
+	if outcomes < 2 {
+		return state
+	}
+	// the normal approximation to the generalized SPRT, with the bounds'
+	// Elo turned into expected scores
+	mean, variance, n := moments(pairSamples(pentanomial))
+	s0, s1 := scoreOf(t.Elo0), scoreOf(t.Elo1)
+	state.LLR = n * (s1 - s0) * (2*mean - s0 - s1) / (2 * variance)
+	switch {
+	case state.LLR >= state.Upper:
+		state.Result = SPRTAcceptH1
+	case state.LLR <= state.Lower:
+		state.Result = SPRTAcceptH0
+	}
+	return state
+}
+
+// the Elo difference expected to score s
+func eloOf(s float64) Elo {
+	switch {
+	case s <= 0:
+		return Elo(math.Inf(-1))
+	case s >= 1:
+		return Elo(math.Inf(1))
+	}
+	return Elo(400 * math.Log10(s/(1-s)))
+}
+
+// the score expected from an Elo difference
+func scoreOf(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Summary is the machine readable account of a tournament
+type Summary struct {
+	Event     string     `json:"event"`
+	Format    string     `json:"format"`
+	Scheduled int        `json:"scheduled"`
+	Played    int        `json:"played"`
+	Stopped   string     `json:"stopped,omitempty"` // why games were left unplayed
+	Standings []Standing `json:"standings"`
+	Matches   []Match    `json:"matches"`
+}
+
+// Summary puts the standings and every match's statistics together
+func (r *Results) Summary() Summary {
+	return Summary{
+		Event:     r.Event,
+		Format:    r.Format,
+		Scheduled: r.Scheduled,
+		Played:    len(r.Games),
+		Stopped:   r.Stopped,
+		Standings: r.Standings(),
+		Matches:   r.Matches(),
+	}
+}
+
+// WriteSummary writes Summary as indented JSON
+func (r *Results) WriteSummary(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.Summary())
+}
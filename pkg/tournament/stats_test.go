@@ -0,0 +1,195 @@
+package tournament_test
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+)
+
+// a game of round between engines 0 and 1 with a's result
+func played(round int, aWhite bool, aScore float64) tournament.GameResult {
+	g := tournament.GameResult{Pairing: tournament.Pairing{Round: round, White: 0, Black: 1}}
+	if !aWhite {
+		g.White, g.Black = 1, 0
+	}
+	switch {
+	case aScore == 0.5:
+		g.Result = tournament.Draw
+	case (aScore == 1) == aWhite:
+		g.Result = tournament.WhiteWins
+	default:
+		g.Result = tournament.BlackWins
+	}
+	return g
+}
+
+func TestMatchStatistics(t *testing.T) {
+	results := &tournament.Results{
+		Engines: []string{"a", "b"},
+		Games: []tournament.GameResult{
+			played(1, true, 1), played(2, false, 1), // 2 points
+			played(3, true, 1), played(4, false, 0.5), // 1.5
+			played(5, true, 1), played(6, false, 0), // 1
+			played(7, true, 0.5), played(8, false, 0.5), // 1
+			played(9, true, 0), // unpaired
+		},
+	}
+	m := results.Match(0, 1, nil)
+	if m.Games != 9 || m.Wins != 4 || m.Draws != 3 || m.Losses != 2 {
+		t.Fatalf("want 4-3-2 over 9 games, got %d-%d-%d over %d", m.Wins, m.Draws, m.Losses, m.Games)
+	}
+	if m.Pentanomial != [5]int{0, 0, 2, 1, 1} || m.Pairs() != 4 {
+		t.Fatalf("want pentanomial [0 0 2 1 1], got %v", m.Pentanomial)
+	}
+	// pairs score 0.6875 on average
+	want := -400 * math.Log10(1/0.6875-1)
+	if math.Abs(float64(m.Elo.Diff)-want) > 0.01 {
+		t.Fatalf("want Elo %.2f, got %v", want, m.Elo.Diff)
+	}
+	if !(m.Elo.Low < m.Elo.Diff && m.Elo.Diff < m.Elo.High) {
+		t.Fatalf("Elo isn't inside its interval: %+v", m.Elo)
+	}
+	if m.LOS <= 0.5 || m.LOS >= 1 {
+		t.Fatalf("want LOS above a half, got %v", m.LOS)
+	}
+
+	// the other side of the same match
+	other := results.Match(1, 0, nil)
+	if other.Wins != m.Losses || math.Abs(float64(other.Elo.Diff+m.Elo.Diff)) > 1e-9 || math.Abs(other.LOS+m.LOS-1) > 1e-9 {
+		t.Fatalf("the match looks different from b's side: %+v", other)
+	}
+
+	// with every game won the difference can't be measured
+	results.Games = results.Games[:2]
+	m = results.Match(0, 1, nil)
+	if !math.IsInf(float64(m.Elo.Diff), 1) {
+		t.Fatalf("want an infinite difference, got %v", m.Elo.Diff)
+	}
+	data, err := json.Marshal(m.Elo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"diff":null,"low":null,"high":null}` {
+		t.Fatalf("want infinities as null, got %s", data)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	test := &tournament.SPRT{Elo0: 0, Elo1: 10, Alpha: 0.05, Beta: 0.05}
+	lower, upper := test.Bounds()
+	if math.Abs(lower+2.944) > 0.001 || math.Abs(upper-2.944) > 0.001 {
+		t.Fatalf("want bounds of -2.94 and 2.94, got %v and %v", lower, upper)
+	}
+
+	var games []tournament.GameResult
+	pair := func(first float64, second float64) {
+		round := len(games) + 1
+		games = append(games, played(round, true, first), played(round+1, false, second))
+	}
+	results := &tournament.Results{Engines: []string{"a", "b"}}
+	state := func() *tournament.SPRTState {
+		results.Games = games
+		return results.Match(0, 1, test).SPRT
+	}
+
+	// a single game isn't a pair yet
+	games = append(games, played(1, true, 1))
+	if s := state(); s.LLR != 0 || s.Result != tournament.SPRTContinue {
+		t.Fatalf("decided on half a pair: %+v", s)
+	}
+
+	// evenly matched, so heading for H0, slowly with 10 Elo to tell apart
+	games = nil
+	even := func(n int) {
+		for i := 0; i < n; i++ {
+			pair(1, 1)
+			pair(0, 0)
+		}
+	}
+	even(10)
+	if s := state(); s.LLR >= 0 || s.Result != tournament.SPRTContinue {
+		t.Fatalf("want a negative LLR without a decision after 20 pairs, got %+v", s)
+	}
+	even(4000)
+	if s := state(); s.Result != tournament.SPRTAcceptH0 {
+		t.Fatalf("want H0 accepted after 8000 even pairs, got %+v", s)
+	}
+
+	// pairs that all went the same way say nothing about the spread
+	games = nil
+	for i := 0; i < 5; i++ {
+		pair(1, 1)
+	}
+	if s := state(); s.Result != tournament.SPRTContinue {
+		t.Fatalf("decided without any spread: %+v", s)
+	}
+	// winning nearly everything decides for H1
+	for i := 0; i < 5; i++ {
+		pair(1, 1)
+		pair(1, 0.5)
+	}
+	if s := state(); s.Result != tournament.SPRTAcceptH1 {
+		t.Fatalf("want H1 accepted, got %+v", s)
+	}
+}
+
+func TestSPRTStopsEarly(t *testing.T) {
+	// mates fool's or scholar's, whichever color it has
+	mater := fakeengine.Script{Moves: []string{"e2e4", "f1c4", "d1h5", "h5f7", "e7e5", "d8h4"}}
+	fool := fakeengine.Script{Moves: []string{"f2f3", "g2g4", "a7a6", "a6a5", "a5a4"}}
+	summary := filepath.Join(t.TempDir(), "summary.json")
+	spec := &tournament.Spec{
+		Games:        100,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 20},
+		Engines:      []tournament.Engine{uciEngine("mater"), uciEngine("fool")},
+		SPRT:         &tournament.SPRT{Elo0: 0, Elo1: 100},
+		Summary:      summary,
+	}
+	// every other pair fool plays as well as mater, so the pairs differ
+	var fools int
+	factory := func(ctx context.Context, e tournament.Engine) (tournament.Player, error) {
+		script := mater
+		if e.Name == "fool" {
+			if fools/2%2 == 0 {
+				script = fool
+			}
+			fools++
+		}
+		return tournament.NewUCIPlayer(fakeengine.New(script), nil), nil
+	}
+	runner := newRunner(t, spec, factory)
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Games) == spec.Games || results.Stopped == "" {
+		t.Fatalf("want the match stopped early, played %d games, stopped %q", len(results.Games), results.Stopped)
+	}
+
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read tournament.Summary
+	err = json.Unmarshal(data, &read)
+	if err != nil {
+		t.Fatalf("%v in:\n%s", err, data)
+	}
+	if read.Scheduled != 100 || read.Played != len(results.Games) || read.Stopped != results.Stopped {
+		t.Fatalf("unexpected summary:\n%s", data)
+	}
+	if len(read.Standings) != 2 || read.Standings[0].Engine != "mater" || read.Standings[0].Games != read.Played {
+		t.Fatalf("unexpected standings:\n%s", data)
+	}
+	if len(read.Matches) != 1 || read.Matches[0].SPRT == nil || read.Matches[0].SPRT.Result != tournament.SPRTAcceptH1 {
+		t.Fatalf("want the match to accept H1:\n%s", data)
+	}
+}
@@ -0,0 +1,88 @@
+package tournament
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// DefaultTablebaseURL is the public lichess tablebase, it has every
+// position with up to 7 pieces
+const DefaultTablebaseURL = "https://tablebase.lichess.ovh/standard"
+
+// Tablebase results, from the side to move
+const (
+	TablebaseUnknown = iota // not in the tablebase, or not sure under the fifty move rule
+	TablebaseWin
+	TablebaseDraw
+	TablebaseLoss
+)
+
+// Tablebase knows the result of positions with few pieces
+type Tablebase interface {
+	// Probe returns one of the Tablebase results
+	Probe(ctx context.Context, pos *chess.Position) (int, error)
+}
+
+// HTTPTablebase probes a server speaking the lichess tablebase API
+type HTTPTablebase struct {
+	url    string
+	client http.Client
+}
+
+// how long to wait on the tablebase server before giving up
+var TablebaseTimeout = 5 * time.Second
+
+// NewHTTPTablebase returns a tablebase asking the server at url,
+// DefaultTablebaseURL for instance
+func NewHTTPTablebase(url string) *HTTPTablebase {
+	return &HTTPTablebase{url: url, client: http.Client{Timeout: TablebaseTimeout}}
+}
+
+func (t *HTTPTablebase) Probe(ctx context.Context, pos *chess.Position) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url+"?fen="+url.QueryEscape(pos.String()), nil)
+	if err != nil {
+		return TablebaseUnknown, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return TablebaseUnknown, fmt.Errorf("unable to contact tablebase: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return TablebaseUnknown, fmt.Errorf("tablebase returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return TablebaseUnknown, fmt.Errorf("unable to read tablebase response: %w", err)
+	}
+	var probe struct {
+		Category string `json:"category"`
+	}
+	err = json.Unmarshal(body, &probe)
+	if err != nil {
+		return TablebaseUnknown, fmt.Errorf("unable to decode tablebase response: %w", err)
+	}
+	// cursed wins and blessed losses are draws under the fifty move rule,
+	// maybe-win and maybe-loss depend on it so they stay unknown
+	switch probe.Category {
+	case "win":
+		return TablebaseWin, nil
+	case "loss":
+		return TablebaseLoss, nil
+	case "draw", "cursed-win", "blessed-loss":
+		return TablebaseDraw, nil
+	}
+	return TablebaseUnknown, nil
+}
+
+// the number of pieces on the board, kings included
+func pieceCount(pos *chess.Position) int {
+	return len(pos.Board().SquareMap())
+}
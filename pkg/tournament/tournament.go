@@ -0,0 +1,312 @@
+// Package tournament plays matches between engine configurations: clusters
+// of workers driven through pkg/client, and local UCI engines. A Spec lists
+// the engines, the format and the time controls, and a Runner plays the
+// games it schedules, several at once if asked, writing each finished game
+// to PGN.
+//
+// Specs are JSON files, durations are strings such as "250ms":
+//
+//	{
+//	  "format": "gauntlet",
+//	  "games": 10,
+//	  "time_control": {"move_time": "1s"},
+//	  "openings": {"file": "openings.epd", "order": "random"},
+//	  "engines": [
+//	    {"name": "cluster", "kind": "cluster", "workers": {"base": "test-rnahm", "count": 2}},
+//	    {"name": "stockfish", "kind": "uci", "path": "bin/stockfish", "options": ["Threads 1"]}
+//	  ],
+//	  "pgn": "games.pgn"
+//	}
+package tournament
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess/uci"
+	"github.com/rpnahm/distsys-chess-engine/pkg/config"
+)
+
+// Formats
+const (
+	FormatRoundRobin = "round-robin" // every engine plays every other
+	FormatGauntlet   = "gauntlet"    // the first engine plays every other
+)
+
+// Engine kinds
+const (
+	KindUCI     = "uci"     // a local UCI executable
+	KindCluster = "cluster" // workers driven through pkg/client
+)
+
+// Spec describes a tournament, the json tags are the file's field names
+type Spec struct {
+	Event        string       `json:"event"`  // PGN Event tag, DefaultEvent when empty
+	Format       string       `json:"format"` // FormatRoundRobin when empty
+	Engines      []Engine     `json:"engines"`
+	Games        int          `json:"games"`       // per pairing, colors alternate
+	Concurrency  int          `json:"concurrency"` // games played at once, 1 when zero
+	TimeControl  TimeControl  `json:"time_control"`
+	Adjudication Adjudication `json:"adjudication"`
+	Openings     Openings     `json:"openings"`
+	SPRT         *SPRT        `json:"sprt"`    // stops a match between two engines once it's decided, nil to play every game
+	PGN          string       `json:"pgn"`     // file every finished game is written to, empty for none
+	Summary      string       `json:"summary"` // file the JSON summary is written to when the tournament ends, empty for none
+
+	openings []Opening // loaded by Validate, in the order they're played
+}
+
+// DefaultEvent names tournaments that don't name themselves
+const DefaultEvent = "distsys-chess tournament"
+
+// Engine is one of the configurations playing
+type Engine struct {
+	Name        string         `json:"name"`
+	Kind        string         `json:"kind"`
+	Path        string         `json:"path"`         // uci: the executable
+	Workers     config.Workers `json:"workers"`      // cluster: which workers, as in the config file
+	Options     []string       `json:"options"`      // "name value" engine options set for every game
+	TimeControl *TimeControl   `json:"time_control"` // overrides the tournament's
+	MaxGames    int            `json:"max_games"`    // games it plays at once, 0 for 1 with clusters and no limit otherwise
+}
+
+// UCIOptions converts the engine's options for uci and client.NewGame
+func (e Engine) UCIOptions() []uci.CmdSetOption {
+	return config.ParseOptions(e.Options)
+}
+
+// TimeControl is either a fixed time per move or a clock with an increment
+type TimeControl struct {
+	MoveTime  config.Duration `json:"move_time"` // fixed time per move, wins over base
+	Base      config.Duration `json:"base"`      // clock at the start of the game
+	Increment config.Duration `json:"increment"` // added to the clock after every move
+}
+
+// moves a clock is assumed to last for, when sharing it out
+const movesToGo = 30
+
+// budget is the time to allow for a move with clock left on the clock
+func (tc TimeControl) budget(clock time.Duration) time.Duration {
+	if tc.MoveTime.Duration > 0 {
+		return tc.MoveTime.Duration
+	}
+	budget := clock/movesToGo + tc.Increment.Duration*3/4
+	return min(budget, clock*3/4)
+}
+
+// String is the PGN TimeControl tag, seconds+increment for clocks, a one
+// move period for fixed move times and - for none at all
+func (tc TimeControl) String() string {
+	if tc.MoveTime.Duration == 0 && tc.Base.Duration == 0 {
+		return "-"
+	}
+	if tc.MoveTime.Duration > 0 {
+		return "1/" + seconds(tc.MoveTime.Duration)
+	}
+	if tc.Increment.Duration > 0 {
+		return seconds(tc.Base.Duration) + "+" + seconds(tc.Increment.Duration)
+	}
+	return seconds(tc.Base.Duration)
+}
+
+func seconds(d time.Duration) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", d.Seconds()), "0"), ".")
+}
+
+// Adjudication ends games whose result is no longer in doubt. Scores are
+// what the engines reported for their own moves, moves without a search
+// behind them don't count.
+type Adjudication struct {
+	MaxMoves        int    `json:"max_moves"`        // full moves before the game is drawn, 0 for no limit
+	ResignScore     int    `json:"resign_score"`     // centipawns both engines must agree one side is ahead by
+	ResignMoves     int    `json:"resign_moves"`     // moves in a row each engine must agree for, 0 never resigns
+	DrawScore       int    `json:"draw_score"`       // centipawns either side of zero both engines must stay within
+	DrawMoves       int    `json:"draw_moves"`       // moves in a row each engine must stay there for, 0 never draws
+	DrawAfter       int    `json:"draw_after"`       // full moves played before draws are adjudicated
+	TablebasePieces int    `json:"tablebase_pieces"` // positions with this many pieces or fewer, kings included, are looked up, 0 for none
+	TablebaseURL    string `json:"tablebase_url"`    // lichess tablebase API, DefaultTablebaseURL when empty
+}
+
+// Load reads the spec at path, unknown fields are errors so typos surface.
+// A relative openings file is looked for next to the spec.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	var s Spec
+	err = dec.Decode(&s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Openings.File != "" && !filepath.IsAbs(s.Openings.File) {
+		s.Openings.File = filepath.Join(filepath.Dir(path), s.Openings.File)
+	}
+	return &s, nil
+}
+
+// Validate fills in the defaults and reports every problem at once
+func (s *Spec) Validate() error {
+	var errs []error
+	bad := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Event == "" {
+		s.Event = DefaultEvent
+	}
+	if s.Format == "" {
+		s.Format = FormatRoundRobin
+	}
+	if s.Format != FormatRoundRobin && s.Format != FormatGauntlet {
+		bad("format", "unknown format %q, expected %s or %s", s.Format, FormatRoundRobin, FormatGauntlet)
+	}
+	if s.Games <= 0 {
+		bad("games", "must be at least 1, got %d", s.Games)
+	}
+	if s.Concurrency < 0 {
+		bad("concurrency", "must not be negative, got %d", s.Concurrency)
+	} else if s.Concurrency == 0 {
+		s.Concurrency = 1
+	}
+	s.Adjudication.validate(bad)
+	checkTimeControl("time_control", s.TimeControl, bad)
+	if o := s.Openings; o.Order != "" && o.Order != OrderSequential && o.Order != OrderRandom {
+		bad("openings.order", "unknown order %q, expected %s or %s", o.Order, OrderSequential, OrderRandom)
+	}
+	if s.Openings.Plies < 0 {
+		bad("openings.plies", "must not be negative, got %d", s.Openings.Plies)
+	}
+	if s.Openings.File != "" {
+		openings, err := LoadOpenings(s.Openings.File, s.Openings.Plies)
+		if err != nil {
+			bad("openings.file", "%s", err)
+		}
+		s.Openings.order(openings)
+		s.openings = openings
+	}
+
+	if len(s.Engines) < 2 {
+		bad("engines", "need at least 2, got %d", len(s.Engines))
+	}
+	if s.SPRT != nil {
+		if len(s.Engines) != 2 {
+			bad("sprt", "needs a match between 2 engines, got %d", len(s.Engines))
+		}
+		s.SPRT.validate(bad)
+	}
+	names := map[string]bool{}
+	for i := range s.Engines {
+		e := &s.Engines[i]
+		field := fmt.Sprintf("engines[%d]", i)
+		if e.Name == "" {
+			bad(field+".name", "must not be empty")
+		} else if names[e.Name] {
+			bad(field+".name", "%q is used twice", e.Name)
+		}
+		names[e.Name] = true
+
+		switch e.Kind {
+		case KindUCI:
+			if e.Path == "" {
+				bad(field+".path", "must be set for a uci engine")
+			}
+		case KindCluster:
+			if !e.Workers.Picked() {
+				bad(field+".workers", "set base and count, list, prefix or labels to pick workers")
+			}
+		default:
+			bad(field+".kind", "unknown kind %q, expected %s or %s", e.Kind, KindUCI, KindCluster)
+		}
+		for _, option := range e.Options {
+			if n := len(strings.Fields(option)); n == 0 || n > 2 {
+				bad(field+".options", "%q must be \"name value\" or \"name\"", option)
+			}
+		}
+		if e.TimeControl != nil {
+			checkTimeControl(field+".time_control", *e.TimeControl, bad)
+		}
+		if e.MaxGames < 0 {
+			bad(field+".max_games", "must not be negative, got %d", e.MaxGames)
+		} else if e.MaxGames == 0 {
+			// workers hold one client session at a time
+			e.MaxGames = s.Concurrency
+			if e.Kind == KindCluster {
+				e.MaxGames = 1
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid tournament:\n%w", errors.Join(errs...))
+}
+
+func checkTimeControl(field string, tc TimeControl, bad func(string, string, ...interface{})) {
+	if tc.MoveTime.Duration < 0 || tc.Base.Duration < 0 || tc.Increment.Duration < 0 {
+		bad(field, "durations must not be negative")
+	}
+	if tc.MoveTime.Duration == 0 && tc.Base.Duration == 0 {
+		bad(field, "set move_time or base")
+	}
+}
+
+// timeControl is the one engine i plays with
+func (s *Spec) timeControl(i int) TimeControl {
+	if tc := s.Engines[i].TimeControl; tc != nil {
+		return *tc
+	}
+	return s.TimeControl
+}
+
+// Pairing is a scheduled game, White and Black index Spec.Engines
+type Pairing struct {
+	Number  int // from 1, in schedule order
+	Round   int // from 1, every pairing plays once a round
+	White   int
+	Black   int
+	Opening int // index into OpeningSuite, -1 for the initial position
+}
+
+// OpeningSuite is the openings Validate loaded, in the order they're played
+func (s *Spec) OpeningSuite() []Opening {
+	return s.openings
+}
+
+// Schedule lists the games in the order they're started. Each round plays
+// every pairing once, colors swap from one round to the next, and the
+// suite moves on to the next opening every other round so each is played
+// with both colors. The suite starts over when it runs out.
+func (s *Spec) Schedule() []Pairing {
+	var pairs [][2]int
+	for i := range s.Engines {
+		for j := i + 1; j < len(s.Engines); j++ {
+			if s.Format == FormatGauntlet && i != 0 {
+				break
+			}
+			pairs = append(pairs, [2]int{i, j})
+		}
+	}
+	var games []Pairing
+	for round := 0; round < s.Games; round++ {
+		for _, pair := range pairs {
+			p := Pairing{Number: len(games) + 1, Round: round + 1, White: pair[0], Black: pair[1], Opening: -1}
+			if len(s.openings) > 0 {
+				p.Opening = (round / 2) % len(s.openings)
+			}
+			if round%2 == 1 {
+				p.White, p.Black = p.Black, p.White
+			}
+			games = append(games, p)
+		}
+	}
+	return games
+}
@@ -0,0 +1,357 @@
+package tournament_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/config"
+	"github.com/rpnahm/distsys-chess-engine/pkg/fakeengine"
+	"github.com/rpnahm/distsys-chess-engine/pkg/tournament"
+)
+
+// mates in four as white, anything legal otherwise
+var scholar = fakeengine.Script{Moves: []string{"e2e4", "f1c4", "d1h5", "h5f7"}}
+
+// gets in the way of nothing
+var passive = fakeengine.Script{Moves: []string{"a7a6", "a6a5", "a5a4", "h2h3", "h3h4"}}
+
+// uci engines are fake engines playing the script named by their path
+func fakeFactory(scripts map[string]fakeengine.Script) tournament.Factory {
+	return func(ctx context.Context, e tournament.Engine) (tournament.Player, error) {
+		return tournament.NewUCIPlayer(fakeengine.New(scripts[e.Path]), e.UCIOptions()), nil
+	}
+}
+
+// runs spec quietly
+func newRunner(t *testing.T, spec *tournament.Spec, factory tournament.Factory) *tournament.Runner {
+	t.Helper()
+	runner, err := tournament.New(spec, factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return runner
+}
+
+func moveTime(d time.Duration) tournament.TimeControl {
+	return tournament.TimeControl{MoveTime: config.Duration{Duration: d}}
+}
+
+func uciEngine(name string) tournament.Engine {
+	return tournament.Engine{Name: name, Kind: tournament.KindUCI, Path: name}
+}
+
+func TestSchedule(t *testing.T) {
+	spec := &tournament.Spec{
+		Games:       2,
+		TimeControl: moveTime(time.Second),
+		Engines:     []tournament.Engine{uciEngine("a"), uciEngine("b"), uciEngine("c")},
+	}
+	err := spec.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	games := spec.Schedule()
+	if len(games) != 6 {
+		t.Fatalf("round robin of 3 engines over 2 rounds: want 6 games, got %d", len(games))
+	}
+	// each pairing is played once with each color
+	colors := map[[2]int]int{}
+	for i, g := range games {
+		if g.Number != i+1 {
+			t.Fatalf("game %d numbered %d", i+1, g.Number)
+		}
+		colors[[2]int{g.White, g.Black}]++
+	}
+	for pair, n := range colors {
+		if n != 1 || colors[[2]int{pair[1], pair[0]}] != 1 {
+			t.Fatalf("colors aren't balanced: %v", colors)
+		}
+	}
+
+	spec.Format = tournament.FormatGauntlet
+	for _, g := range spec.Schedule() {
+		if g.White != 0 && g.Black != 0 {
+			t.Fatalf("gauntlet game without the first engine: %+v", g)
+		}
+	}
+	if n := len(spec.Schedule()); n != 4 {
+		t.Fatalf("gauntlet of 3 engines over 2 rounds: want 4 games, got %d", n)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	spec := &tournament.Spec{
+		Format: "swiss",
+		SPRT:   &tournament.SPRT{Elo0: 5, Elo1: 0},
+		Engines: []tournament.Engine{
+			{Name: "a", Kind: tournament.KindUCI},
+			{Name: "a", Kind: "remote", Options: []string{"too many words"}},
+			{Name: "c", Kind: tournament.KindCluster},
+		},
+	}
+	err := spec.Validate()
+	if err == nil {
+		t.Fatal("a broken spec validated")
+	}
+	for _, want := range []string{"format", "games", "time_control", "engines[0].path", "engines[1].name", "engines[1].kind", "engines[1].options", "engines[2].workers", "sprt"} {
+		if !strings.Contains(err.Error(), want+":") {
+			t.Errorf("no complaint about %s in:\n%v", want, err)
+		}
+	}
+
+	spec = &tournament.Spec{
+		Games:       1,
+		Concurrency: 4,
+		TimeControl: moveTime(time.Second),
+		Engines: []tournament.Engine{
+			uciEngine("a"),
+			{Name: "b", Kind: tournament.KindCluster, Workers: config.Workers{Prefix: "test"}},
+		},
+	}
+	err = spec.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// clusters play one game at a time unless told otherwise
+	if spec.Engines[0].MaxGames != 4 || spec.Engines[1].MaxGames != 1 {
+		t.Fatalf("want max games 4 and 1, got %d and %d", spec.Engines[0].MaxGames, spec.Engines[1].MaxGames)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	spec, err := tournament.Load(filepath.Join("..", "..", "tournament.example.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = spec.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "typo.json")
+	os.WriteFile(path, []byte(`{"gmaes": 2}`), 0644)
+	_, err = tournament.Load(path)
+	if err == nil {
+		t.Fatal("loaded a spec with an unknown field")
+	}
+}
+
+func TestRunWritesPGN(t *testing.T) {
+	pgn := filepath.Join(t.TempDir(), "games.pgn")
+	spec := &tournament.Spec{
+		Event:        "scholar",
+		Games:        2,
+		TimeControl:  moveTime(10 * time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 20},
+		Engines:      []tournament.Engine{uciEngine("scholar"), uciEngine("passive")},
+		PGN:          pgn,
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{"scholar": scholar, "passive": passive}))
+	var finished int
+	runner.OnGame(func(tournament.GameResult) { finished++ })
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Games) != 2 || finished != 2 {
+		t.Fatalf("want 2 games reported, got %d and %d", len(results.Games), finished)
+	}
+
+	first := results.Games[0]
+	if first.Result != tournament.WhiteWins || first.Reason != "checkmate" || len(first.Moves) != 7 {
+		t.Fatalf("want scholar's mate, got %s by %s in %d plies", first.Result, first.Reason, len(first.Moves))
+	}
+	second := results.Games[1]
+	if second.Termination != tournament.TerminationNormal && second.Reason != "max moves" {
+		t.Fatalf("unexpected end to the second game: %s, %s", second.Termination, second.Reason)
+	}
+
+	// the PGN reads back move for move
+	f, err := os.Open(pgn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := chess.NewScanner(f)
+	var read []*chess.Game
+	for scanner.Scan() {
+		// the scanner makes an empty game of the blank line ending the file
+		if g := scanner.Next(); len(g.TagPairs()) > 0 {
+			read = append(read, g)
+		}
+	}
+	if len(read) != 2 {
+		t.Fatalf("want 2 games in the PGN, got %d: %v", len(read), scanner.Err())
+	}
+	for i, g := range read {
+		if len(g.Moves()) != len(results.Games[i].Moves) {
+			t.Fatalf("game %d: %d moves in the PGN, %d played", i+1, len(g.Moves()), len(results.Games[i].Moves))
+		}
+		for _, tag := range []struct{ key, value string }{
+			{"Event", "scholar"},
+			{"Round", []string{"1", "2"}[i]},
+			{"Result", results.Games[i].Result},
+			{"TimeControl", "1/0.01"},
+			{"Termination", results.Games[i].Termination},
+		} {
+			if got := g.GetTagPair(tag.key); got == nil || got.Value != tag.value {
+				t.Fatalf("game %d: want %s %q, got %v", i+1, tag.key, tag.value, got)
+			}
+		}
+	}
+	if read[0].Outcome() != chess.WhiteWon || read[0].Method() != chess.Checkmate {
+		t.Fatalf("the PGN doesn't end in mate: %s", read[0])
+	}
+}
+
+func TestClockRunsOut(t *testing.T) {
+	slow := fakeengine.Script{Delay: 40 * time.Millisecond}
+	clock := tournament.TimeControl{Base: config.Duration{Duration: 100 * time.Millisecond}}
+	spec := &tournament.Spec{
+		Games:       1,
+		TimeControl: moveTime(time.Millisecond),
+		Engines: []tournament.Engine{
+			{Name: "slow", Kind: tournament.KindUCI, Path: "slow", TimeControl: &clock},
+			uciEngine("passive"),
+		},
+	}
+	runner := newRunner(t, spec, fakeFactory(map[string]fakeengine.Script{"slow": slow, "passive": passive}))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := results.Games[0]
+	if g.Result != tournament.BlackWins || g.Termination != tournament.TerminationTimeForfeit {
+		t.Fatalf("want the slow engine to lose on time, got %s by %s", g.Result, g.Termination)
+	}
+	// two moves fit in the clock and the third doesn't
+	if len(g.Moves) != 4 {
+		t.Fatalf("want 4 plies before the flag fell, got %d", len(g.Moves))
+	}
+}
+
+func TestRoundRobinConcurrently(t *testing.T) {
+	spec := &tournament.Spec{
+		Games:        2,
+		Concurrency:  3,
+		TimeControl:  moveTime(time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 10},
+		Engines:      []tournament.Engine{uciEngine("scholar"), uciEngine("passive"), uciEngine("other")},
+	}
+	scripts := map[string]fakeengine.Script{"scholar": scholar, "passive": passive, "other": {}}
+	runner := newRunner(t, spec, fakeFactory(scripts))
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Games) != 6 {
+		t.Fatalf("want 6 games, got %d", len(results.Games))
+	}
+	var points float64
+	for _, s := range results.Standings() {
+		if s.Games != 4 || s.Wins+s.Draws+s.Losses != 4 {
+			t.Fatalf("unexpected standing %+v", s)
+		}
+		points += s.Points
+	}
+	if points != 6 {
+		t.Fatalf("want 6 points shared out, got %v", points)
+	}
+	if top := results.Standings()[0]; top.Engine != "scholar" {
+		t.Fatalf("want scholar on top, got %+v", results.Standings())
+	}
+}
+
+func TestClusterEngine(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 2, Script: scholar, NoClient: true})
+	spec := &tournament.Spec{
+		Games:        2,
+		Concurrency:  2,
+		TimeControl:  moveTime(100 * time.Millisecond),
+		Adjudication: tournament.Adjudication{MaxMoves: 10},
+		Engines: []tournament.Engine{
+			{Name: "cluster", Kind: tournament.KindCluster, Workers: config.Workers{List: c.Names}},
+			uciEngine("passive"),
+		},
+	}
+	newClient := func(e tournament.Engine) (*client.Client, error) {
+		return c.NewClient(e.Workers.Selector()), nil
+	}
+	clusters := tournament.DefaultFactory(newClient, 0)
+	factory := func(ctx context.Context, e tournament.Engine) (tournament.Player, error) {
+		if e.Kind == tournament.KindCluster {
+			return clusters(ctx, e)
+		}
+		return tournament.NewUCIPlayer(fakeengine.New(passive), nil), nil
+	}
+	runner := newRunner(t, spec, factory)
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Games) != 2 {
+		t.Fatalf("want 2 games, got %d", len(results.Games))
+	}
+	if g := results.Games[0]; g.WhiteName != "cluster" || g.Result != tournament.WhiteWins {
+		t.Fatalf("want the cluster to mate as white, got %s %s by %s", g.WhiteName, g.Result, g.Reason)
+	}
+	if g := results.Games[0]; g.Workers != [2]int{2, 0} {
+		t.Fatalf("want 2 workers on white's side, got %v", g.Workers)
+	}
+}
+
+func TestPGNComments(t *testing.T) {
+	game := chess.NewGame()
+	var moves []tournament.MoveRecord
+	for i, m := range []tournament.Move{
+		{Score: 35, Depth: 12, Nodes: 40000},
+		{Score: -120, Depth: 11},
+		{Mate: 3, Depth: 20, Nodes: 9},
+		{Mate: -2, Depth: 18},
+	} {
+		m.Move = game.ValidMoves()[0]
+		game.Move(m.Move)
+		moves = append(moves, tournament.MoveRecord{Move: m, Time: time.Duration(i+1) * 250 * time.Millisecond, Book: i == 0})
+	}
+	var b strings.Builder
+	err := tournament.WritePGN(&b, "comments", tournament.Openings{}, tournament.GameResult{
+		Pairing:     tournament.Pairing{Number: 1, Round: 1, Opening: -1},
+		WhiteName:   "player",
+		BlackName:   "cluster",
+		Result:      tournament.Ongoing,
+		Termination: tournament.TerminationUnterminated,
+		TimeControl: [2]tournament.TimeControl{{}, moveTime(time.Second)},
+		Workers:     [2]int{0, 3},
+		Moves:       moves,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`[WhiteTimeControl "-"]`, `[BlackTimeControl "1/1"]`, `[BlackWorkers "3"]`, `[Result "*"]`,
+		"{-1.20/11 0.5s}", "{+M3/20 0.75s 9}", "{-M2/18 1s} *",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("no %s in:\n%s", want, b.String())
+		}
+	}
+	// book moves go without
+	if strings.Count(b.String(), "{") != 3 || strings.Contains(b.String(), "WhiteWorkers") {
+		t.Errorf("unexpected comments or tags:\n%s", b.String())
+	}
+
+	scanner := chess.NewScanner(strings.NewReader(b.String()))
+	if !scanner.Scan() || len(scanner.Next().Moves()) != 4 {
+		t.Fatalf("the PGN doesn't read back: %v", scanner.Err())
+	}
+}
@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// OTLP JSON shapes, only the fields we fill in
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// span kind internal, from the OTLP enum
+const spanKindInternal = 1
+
+// FileExporter appends OTLP JSON export requests to a writer, one per line
+type FileExporter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+// NewFileExporter writes to out
+func NewFileExporter(out io.Writer) *FileExporter {
+	return &FileExporter{out: out}
+}
+
+// CreateFileExporter truncates and writes to the file at path
+func CreateFileExporter(path string) (*FileExporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{out: f, closer: f}, nil
+}
+
+// Export writes the spans as a single line, grouped by service
+func (e *FileExporter) Export(spans []common.Span) error {
+	byService := map[string][]otlpSpan{}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceId:           s.TraceId,
+			SpanId:            s.SpanId,
+			ParentSpanId:      s.ParentId,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		var keys []string
+		for key := range s.Attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: key, Value: otlpValue{StringValue: s.Attrs[key]}})
+		}
+		byService[s.Service] = append(byService[s.Service], span)
+	}
+
+	var services []string
+	for service := range byService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var req otlpRequest
+	for _, service := range services {
+		req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+			Resource: otlpResource{Attributes: []otlpKeyValue{
+				{Key: "service.name", Value: otlpValue{StringValue: service}},
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/rpnahm/distsys-chess-engine"},
+				Spans: byService[service],
+			}},
+		})
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.out.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the underlying file when the exporter opened it
+func (e *FileExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
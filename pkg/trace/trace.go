@@ -0,0 +1,171 @@
+// Package trace records spans for a turn across the client and workers.
+//
+// The client starts a span per turn and passes its trace and span ids to
+// workers in parse_moves. Workers time their side of the job and return
+// those spans inside results, so the client's export holds the whole
+// timeline. Spans are written as OpenTelemetry (OTLP) JSON, one export
+// request per line, which Jaeger, Perfetto converters and the OTel
+// collector's file receiver all read.
+//
+// A nil *Tracer is valid and records nothing, as are the spans it returns.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+)
+
+// Context identifies a span so children can be attached to it
+type Context struct {
+	TraceId string
+	SpanId  string
+}
+
+// Valid reports whether the context refers to a span
+func (c Context) Valid() bool {
+	return c.TraceId != "" && c.SpanId != ""
+}
+
+// Exporter receives finished spans
+type Exporter interface {
+	Export(spans []common.Span) error
+}
+
+// Tracer creates spans for one service
+type Tracer struct {
+	service string
+	exp     Exporter
+	onError func(error)
+}
+
+// NewTracer creates a tracer whose spans are tagged with service, a nil
+// exporter drops ended spans but still lets spans be Finished and sent on
+func NewTracer(service string, exp Exporter) *Tracer {
+	return &Tracer{service: service, exp: exp, onError: func(error) {}}
+}
+
+// OnError sets a callback for export failures, which are otherwise dropped
+func (t *Tracer) OnError(f func(error)) {
+	if t != nil {
+		t.onError = f
+	}
+}
+
+// Start begins a span under parent, or a new trace when parent is not valid
+func (t *Tracer) Start(name string, parent Context) *Span {
+	return t.StartAt(name, parent, time.Now())
+}
+
+// StartAt begins a span that started at an earlier time
+func (t *Tracer) StartAt(name string, parent Context, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, data: common.Span{
+		TraceId:  parent.TraceId,
+		ParentId: parent.SpanId,
+		SpanId:   newId(8),
+		Name:     name,
+		Service:  t.service,
+		Start:    start,
+	}}
+	if !parent.Valid() {
+		s.data.TraceId = newId(16)
+		s.data.ParentId = ""
+	}
+	return s
+}
+
+// Import exports spans recorded by another process, such as a worker
+func (t *Tracer) Import(spans []common.Span) {
+	if t == nil || len(spans) == 0 {
+		return
+	}
+	t.export(spans)
+}
+
+func (t *Tracer) export(spans []common.Span) {
+	if t.exp == nil {
+		return
+	}
+	err := t.exp.Export(spans)
+	if err != nil {
+		t.onError(err)
+	}
+}
+
+// Span is an operation in progress
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   common.Span
+	ended  bool
+}
+
+// Context returns the ids children and remote processes should use
+func (s *Span) Context() Context {
+	if s == nil {
+		return Context{}
+	}
+	return Context{TraceId: s.data.TraceId, SpanId: s.data.SpanId}
+}
+
+// SetAttr attaches a key value pair, values are formatted with %v
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attrs == nil {
+		s.data.Attrs = map[string]string{}
+	}
+	s.data.Attrs[key] = fmt.Sprint(value)
+}
+
+// End finishes the span and exports it, later calls do nothing
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at a given time
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	data, ok := s.finish(end)
+	if ok {
+		s.tracer.export([]common.Span{data})
+	}
+}
+
+// Finish ends the span without exporting it and returns it for sending to
+// another process, which is how workers hand their spans to the client
+func (s *Span) Finish() (common.Span, bool) {
+	if s == nil {
+		return common.Span{}, false
+	}
+	return s.finish(time.Now())
+}
+
+func (s *Span) finish(end time.Time) (common.Span, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return common.Span{}, false
+	}
+	s.ended = true
+	s.data.End = end
+	return s.data, true
+}
+
+func newId(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,300 @@
+package trace_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/rpnahm/distsys-chess-engine/pkg/client"
+	"github.com/rpnahm/distsys-chess-engine/pkg/clustertest"
+	"github.com/rpnahm/distsys-chess-engine/pkg/common"
+	"github.com/rpnahm/distsys-chess-engine/pkg/trace"
+)
+
+// exported spans as they appear in the file, with the service they came under
+type exportedSpan struct {
+	Service           string
+	TraceId           string `json:"traceId"`
+	SpanId            string `json:"spanId"`
+	ParentSpanId      string `json:"parentSpanId"`
+	Name              string `json:"name"`
+	Kind              int    `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+	Attributes        []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+}
+
+var (
+	traceId = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	spanId  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// decodes every export request in out, checking the OTLP JSON shape
+func decode(t *testing.T, out []byte) []exportedSpan {
+	t.Helper()
+	var spans []exportedSpan
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []struct {
+						Key   string `json:"key"`
+						Value struct {
+							StringValue string `json:"stringValue"`
+						} `json:"value"`
+					} `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Scope struct {
+						Name string `json:"name"`
+					} `json:"scope"`
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			t.Fatalf("line isn't an export request: %v: %s", err, scanner.Text())
+		}
+		for _, rs := range req.ResourceSpans {
+			attrs := rs.Resource.Attributes
+			if len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue == "" {
+				t.Fatalf("want a service.name resource attribute, got %+v", attrs)
+			}
+			for _, ss := range rs.ScopeSpans {
+				if ss.Scope.Name == "" {
+					t.Fatal("scope without a name")
+				}
+				for _, s := range ss.Spans {
+					s.Service = attrs[0].Value.StringValue
+					checkSpan(t, s)
+					spans = append(spans, s)
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func checkSpan(t *testing.T, s exportedSpan) {
+	t.Helper()
+	if !traceId.MatchString(s.TraceId) || !spanId.MatchString(s.SpanId) {
+		t.Fatalf("%s: want hex ids, got trace %q span %q", s.Name, s.TraceId, s.SpanId)
+	}
+	if s.ParentSpanId != "" && !spanId.MatchString(s.ParentSpanId) {
+		t.Fatalf("%s: want a hex parent id, got %q", s.Name, s.ParentSpanId)
+	}
+	if s.Kind != 1 {
+		t.Fatalf("%s: want kind internal, got %d", s.Name, s.Kind)
+	}
+	start, err := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+	if err != nil {
+		t.Fatalf("%s: start isn't a nanosecond string: %v", s.Name, err)
+	}
+	end, err := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+	if err != nil || end < start {
+		t.Fatalf("%s: want an end after %d, got %q: %v", s.Name, start, s.EndTimeUnixNano, err)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	var out bytes.Buffer
+	exp := trace.NewFileExporter(&out)
+	start := time.Unix(1700000000, 123456789)
+	err := exp.Export([]common.Span{
+		{TraceId: "0af7651916cd43dd8448eb211c80319c", SpanId: "b7ad6b7169203331", Name: "turn", Service: "client", Start: start, End: start.Add(time.Second)},
+		{TraceId: "0af7651916cd43dd8448eb211c80319c", SpanId: "00f067aa0ba902b7", ParentId: "b7ad6b7169203331", Name: "worker.job", Service: "w0", Start: start, End: start.Add(time.Millisecond), Attrs: map[string]string{"moves": "3", "job_id": "1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(out.Bytes(), []byte("\n")) != 1 {
+		t.Fatalf("want one line per export, got %q", out.String())
+	}
+
+	spans := decode(t, out.Bytes())
+	if len(spans) != 2 || spans[0].Service != "client" || spans[1].Service != "w0" {
+		t.Fatalf("want a resource per service in order, got %+v", spans)
+	}
+	turn, job := spans[0], spans[1]
+	if turn.StartTimeUnixNano != "1700000000123456789" || turn.EndTimeUnixNano != "1700000001123456789" {
+		t.Fatalf("unexpected times %s to %s", turn.StartTimeUnixNano, turn.EndTimeUnixNano)
+	}
+	if turn.ParentSpanId != "" || job.ParentSpanId != turn.SpanId || job.TraceId != turn.TraceId {
+		t.Fatalf("want worker.job under turn, got %+v", job)
+	}
+	// attributes sorted by key
+	if len(job.Attributes) != 2 || job.Attributes[0].Key != "job_id" || job.Attributes[1].Value.StringValue != "3" {
+		t.Fatalf("unexpected attributes %+v", job.Attributes)
+	}
+	// the raw JSON leaves out an empty parent
+	if bytes.Count(out.Bytes(), []byte(`"parentSpanId"`)) != 1 {
+		t.Fatalf("want parentSpanId only on the child: %s", out.String())
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var out bytes.Buffer
+	tracer := trace.NewTracer("client", trace.NewFileExporter(&out))
+
+	turn := tracer.Start("turn", trace.Context{})
+	turn.SetAttr("ply", 3)
+	child := tracer.Start("partition", turn.Context())
+	child.End()
+	child.End()
+
+	// a worker finishes its span and hands it over
+	worker := trace.NewTracer("w0", nil)
+	job := worker.Start("worker.job", turn.Context())
+	data, ok := job.Finish()
+	if !ok {
+		t.Fatal("the job didn't finish")
+	}
+	if _, ok := job.Finish(); ok {
+		t.Fatal("a span finished twice")
+	}
+	tracer.Import([]common.Span{data})
+	turn.End()
+
+	spans := decode(t, out.Bytes())
+	if len(spans) != 3 {
+		t.Fatalf("want partition, worker.job and turn exported once each, got %+v", spans)
+	}
+	partition, imported, root := spans[0], spans[1], spans[2]
+	if root.Name != "turn" || root.ParentSpanId != "" || len(root.Attributes) != 1 || root.Attributes[0].Value.StringValue != "3" {
+		t.Fatalf("unexpected root %+v", root)
+	}
+	for _, s := range []exportedSpan{partition, imported} {
+		if s.TraceId != root.TraceId || s.ParentSpanId != root.SpanId {
+			t.Fatalf("want %s under turn, got %+v", s.Name, s)
+		}
+	}
+	if imported.Service != "w0" {
+		t.Fatalf("want the worker's service kept, got %s", imported.Service)
+	}
+
+	// separate turns are separate traces
+	if other := tracer.Start("turn", trace.Context{}); other.Context().TraceId == root.TraceId {
+		t.Fatal("a new turn reused the trace id")
+	}
+}
+
+type failingExporter struct{}
+
+func (failingExporter) Export([]common.Span) error { return errors.New("disk full") }
+
+func TestTracerErrors(t *testing.T) {
+	tracer := trace.NewTracer("client", failingExporter{})
+	var got error
+	tracer.OnError(func(err error) { got = err })
+	tracer.Start("turn", trace.Context{}).End()
+	if got == nil || got.Error() != "disk full" {
+		t.Fatalf("want the export error reported, got %v", got)
+	}
+
+	// a nil tracer and its spans do nothing
+	var none *trace.Tracer
+	span := none.Start("turn", trace.Context{})
+	span.SetAttr("ply", 1)
+	span.End()
+	if span.Context().Valid() {
+		t.Fatal("a nil tracer made a valid context")
+	}
+	if _, ok := span.Finish(); ok {
+		t.Fatal("a nil span finished")
+	}
+	none.Import([]common.Span{{Name: "worker.job"}})
+}
+
+// the value of an attribute, empty when the span doesn't have it
+func (s exportedSpan) attr(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue
+		}
+	}
+	return ""
+}
+
+func TestTracedSearch(t *testing.T) {
+	c := clustertest.Start(t, clustertest.Options{Workers: 2})
+	var out bytes.Buffer
+	c.Client.SetTracer(trace.NewTracer("client", trace.NewFileExporter(&out)))
+	ctx := context.Background()
+	err := c.Client.NewGame(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Client.Search(ctx, chess.StartingPosition(), nil, client.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string][]exportedSpan{}
+	byId := map[string]exportedSpan{}
+	for _, s := range decode(t, out.Bytes()) {
+		byName[s.Name] = append(byName[s.Name], s)
+		byId[s.SpanId] = s
+	}
+	turns := byName["turn"]
+	if len(turns) != 1 || turns[0].ParentSpanId != "" {
+		t.Fatalf("want one root turn, got %+v", turns)
+	}
+	turn := turns[0]
+	parent := func(s exportedSpan) exportedSpan {
+		t.Helper()
+		p, ok := byId[s.ParentSpanId]
+		if !ok || s.TraceId != turn.TraceId {
+			t.Fatalf("%s from %s isn't in the turn's trace", s.Name, s.Service)
+		}
+		return p
+	}
+
+	// client spans, one send and one result_return per worker
+	want := map[string]int{"partition": 1, "send": 2, "result_return": 2, "aggregate": 1}
+	for name, n := range want {
+		if len(byName[name]) != n {
+			t.Fatalf("want %d %s spans, got %d", n, name, len(byName[name]))
+		}
+		for _, s := range byName[name] {
+			if s.Service != "client" || parent(s).Name != "turn" {
+				t.Fatalf("want %s under turn on the client, got %+v", name, s)
+			}
+		}
+	}
+
+	// each worker's job hangs off the send to that worker, the rest off the job
+	jobs := byName["worker.job"]
+	if len(jobs) != 2 {
+		t.Fatalf("want a worker.job per worker, got %d", len(jobs))
+	}
+	for _, job := range jobs {
+		send := parent(job)
+		if send.Name != "send" || send.attr("worker") != job.Service || send.attr("job_id") != job.attr("job_id") {
+			t.Fatalf("want %s's worker.job under its send, got it under %+v", job.Service, send)
+		}
+	}
+	for _, name := range []string{"worker.queue_wait", "engine.search"} {
+		if len(byName[name]) != 2 {
+			t.Fatalf("want a %s per worker, got %d", name, len(byName[name]))
+		}
+		for _, s := range byName[name] {
+			if job := parent(s); job.Name != "worker.job" || job.Service != s.Service {
+				t.Fatalf("want %s under its own worker.job, got it under %+v", s.Name, job)
+			}
+		}
+	}
+}